@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// alarmLimitRow is one register's alarm limits from an imported file, with
+// the outcome of checking it
+type alarmLimitRow struct {
+	Line     int      `json:"line"`
+	Register string   `json:"register"` // name or address, as given
+	Address  uint16   `json:"address,omitempty"`
+	Low      *float64 `json:"low"`
+	High     *float64 `json:"high"`
+	Status   string   `json:"status"` // "valid", "invalid" or "ok"
+	Error    string   `json:"error,omitempty"`
+
+	low, high string // limits as given, empty to remove
+}
+
+// parseAlarmLimits reads alarm limits from a JSON array of {"register",
+// "low", "high"} objects or CSV lines of "register,low,high" with an optional
+// header. The register is a name or an address; a missing or empty limit
+// removes it.
+func parseAlarmLimits(data []byte) ([]*alarmLimitRow, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		var items []struct {
+			Register interface{} `json:"register"`
+			Low      json.Number `json:"low"`
+			High     json.Number `json:"high"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		rows := make([]*alarmLimitRow, 0, len(items))
+		for i, item := range items {
+			register := ""
+			if item.Register != nil {
+				register = fmt.Sprint(item.Register)
+			}
+			rows = append(rows, &alarmLimitRow{Line: i + 1, Register: register, low: item.Low.String(), high: item.High.String()})
+		}
+		return rows, nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(trimmed))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var rows []*alarmLimitRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected register,low,high", line)
+		}
+		// Skip a header row
+		if len(rows) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "register") {
+			continue
+		}
+		rows = append(rows, &alarmLimitRow{
+			Line:     line,
+			Register: strings.TrimSpace(record[0]),
+			low:      strings.TrimSpace(record[1]),
+			high:     strings.TrimSpace(record[2]),
+		})
+	}
+	return rows, nil
+}
+
+// parseAlarmLimit parses a limit, nil if it is empty
+func parseAlarmLimit(text string) (*float64, error) {
+	if text == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("invalid limit %q", text)
+	}
+	return &f, nil
+}
+
+// checkAlarmLimits resolves the register of each row among the server's
+// registers and checks its limits, setting the row's status. It returns
+// whether all rows are valid. The caller must hold s.mu.
+func (s *ModbusServer) checkAlarmLimits(rows []*alarmLimitRow) bool {
+	byName := make(map[string][]uint16)
+	for _, reg := range s.registerMap {
+		if reg.Name != "" {
+			byName[reg.Name] = append(byName[reg.Name], reg.Address)
+		}
+	}
+
+	valid := true
+	seen := make(map[uint16]int)
+	for _, row := range rows {
+		err := func() error {
+			if row.Register == "" {
+				return errors.New("missing register")
+			}
+			if addr, err := strconv.ParseUint(row.Register, 10, 16); err == nil {
+				if _, ok := s.registerMap[uint16(addr)]; !ok {
+					return fmt.Errorf("address %d is not a configured register", addr)
+				}
+				row.Address = uint16(addr)
+			} else {
+				switch addresses := byName[row.Register]; len(addresses) {
+				case 0:
+					return fmt.Errorf("no register is named %q", row.Register)
+				case 1:
+					row.Address = addresses[0]
+				default:
+					return fmt.Errorf("%d registers are named %q; use the address", len(addresses), row.Register)
+				}
+			}
+			if line, ok := seen[row.Address]; ok {
+				return fmt.Errorf("register %d already has limits on line %d", row.Address, line)
+			}
+			seen[row.Address] = row.Line
+
+			var err error
+			if row.Low, err = parseAlarmLimit(row.low); err != nil {
+				return err
+			}
+			if row.High, err = parseAlarmLimit(row.high); err != nil {
+				return err
+			}
+			switch {
+			case (row.Low != nil || row.High != nil) && row.Address < 30000:
+				return errors.New("alarm limits need an input or holding register")
+			case row.Low != nil && row.High != nil && *row.Low > *row.High:
+				return errors.New("low limit must not be greater than high limit")
+			}
+			return nil
+		}()
+		if err != nil {
+			row.Status = "invalid"
+			row.Error = err.Error()
+			valid = false
+		} else {
+			row.Status = "valid"
+		}
+	}
+	return valid
+}
+
+// applyAlarmLimits sets the alarm limits of checked rows. The caller must
+// hold s.mu.
+func (s *ModbusServer) applyAlarmLimits(rows []*alarmLimitRow) {
+	blocks := copyBlocks(s.RegisterBlocks)
+	for _, row := range rows {
+		i, j, ok := findRegister(blocks, row.Address)
+		if !ok {
+			continue
+		}
+		blocks[i].Registers[j].AlarmLow = row.Low
+		blocks[i].Registers[j].AlarmHigh = row.High
+		row.Status = "ok"
+	}
+	s.RegisterBlocks = blocks
+	s.registerMap = buildRegisterMap(s.RegisterBlocks)
+}
+
+// handleAlarmLimits imports the alarm limits of a server's registers from a
+// CSV or JSON file on POST /api/servers/{id}/alarmlimits, and serves the
+// current limits as CSV in the same format on GET. With preview=true the
+// rows are only checked. Registers not in the file keep their limits.
+func handleAlarmLimits(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-alarmlimits.csv"`, unsafeFileChars.ReplaceAllString(id, "_")))
+		out := csv.NewWriter(w)
+		out.Write([]string{"register", "low", "high"})
+		server.mu.Lock()
+		for _, block := range server.RegisterBlocks {
+			for _, reg := range block.Registers {
+				if reg.AlarmLow == nil && reg.AlarmHigh == nil {
+					continue
+				}
+				limit := func(f *float64) string {
+					if f == nil {
+						return ""
+					}
+					return strconv.FormatFloat(*f, 'g', -1, 64)
+				}
+				out.Write([]string{strconv.Itoa(int(reg.Address)), limit(reg.AlarmLow), limit(reg.AlarmHigh)})
+			}
+		}
+		server.mu.Unlock()
+		out.Flush()
+
+	case http.MethodPost:
+		data, err := readUploadBody(r, "file")
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		rows, err := parseAlarmLimits(data)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid alarm limits file: %v", err))
+			return
+		}
+		if len(rows) == 0 {
+			handleError(w, r, "Alarm limits file contains no rows")
+			return
+		}
+
+		preview := r.FormValue("preview") == "true"
+		server.mu.Lock()
+		valid := server.checkAlarmLimits(rows)
+		if valid && !preview {
+			server.applyAlarmLimits(rows)
+		}
+		server.mu.Unlock()
+		if valid && !preview {
+			logMessage(InfoLevel, "Imported alarm limits of %d registers to server %s", len(rows), id)
+		}
+
+		response := map[string]interface{}{
+			"success": valid,
+			"preview": preview,
+			"rows":    rows,
+		}
+		if !valid {
+			response["error"] = "Some rows are invalid; no limits were changed"
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func alarmLimitServer(t *testing.T) *ModbusServer {
+	t.Helper()
+	high := 50.0
+	blocks := []RegisterBlock{{
+		StartAddress: 30001,
+		Length:       4,
+		Registers: []RegisterConfig{
+			{Address: 30001, Name: "Tank level"},
+			{Address: 30002, Name: "Pump speed", AlarmHigh: &high},
+			{Address: 30003, Name: "Flow"},
+			{Address: 30004, Name: "Flow"},
+		},
+	}}
+	server := &ModbusServer{ID: "tank", RegisterBlocks: blocks, registerMap: buildRegisterMap(blocks)}
+	mu.Lock()
+	servers[server.ID] = server
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(servers, server.ID)
+		mu.Unlock()
+	})
+	return server
+}
+
+func postAlarmLimits(t *testing.T, query, body string) map[string]interface{} {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/servers/tank/alarmlimits"+query, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleAlarmLimits(w, r, "tank")
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	return response
+}
+
+func TestImportAlarmLimits(t *testing.T) {
+	server := alarmLimitServer(t)
+
+	csv := "register,low,high\nTank level,0.5,9.5\n30002,,\n"
+	if response := postAlarmLimits(t, "?preview=true", csv); response["success"] != true {
+		t.Fatalf("preview failed: %v", response)
+	}
+	if server.registerMap[30001].AlarmLow != nil {
+		t.Fatal("preview changed the limits")
+	}
+
+	if response := postAlarmLimits(t, "", csv); response["success"] != true {
+		t.Fatalf("import failed: %v", response)
+	}
+	level := server.registerMap[30001]
+	if level.AlarmLow == nil || *level.AlarmLow != 0.5 || level.AlarmHigh == nil || *level.AlarmHigh != 9.5 {
+		t.Errorf("tank level limits = %v, %v", level.AlarmLow, level.AlarmHigh)
+	}
+	if speed := server.registerMap[30002]; speed.AlarmHigh != nil {
+		t.Error("empty high limit did not remove it")
+	}
+
+	body := `[{"register": 30003, "high": 12}]`
+	if response := postAlarmLimits(t, "", body); response["success"] != true {
+		t.Fatalf("JSON import failed: %v", response)
+	}
+	if flow := server.registerMap[30003]; flow.AlarmHigh == nil || *flow.AlarmHigh != 12 {
+		t.Error("JSON import did not set the high limit")
+	}
+	if server.registerMap[30001].AlarmLow == nil {
+		t.Error("register not in the file lost its limits")
+	}
+}
+
+func TestImportAlarmLimitsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown name", "Level,1,2", "no register is named"},
+		{"ambiguous name", "Flow,1,2", "use the address"},
+		{"unknown address", "30009,1,2", "not a configured register"},
+		{"low above high", "Tank level,5,2", "must not be greater"},
+		{"invalid limit", "Tank level,low,2", "invalid limit"},
+		{"twice", "30001,1,2\nTank level,1,3", "already has limits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := alarmLimitServer(t)
+			response := postAlarmLimits(t, "", tt.body)
+			if response["success"] != false {
+				t.Fatalf("invalid file was accepted: %v", response)
+			}
+			rows := response["rows"].([]interface{})
+			last := rows[len(rows)-1].(map[string]interface{})
+			if message, _ := last["error"].(string); !strings.Contains(message, tt.want) {
+				t.Errorf("error %q does not contain %q", message, tt.want)
+			}
+			if server.registerMap[30001].AlarmLow != nil {
+				t.Error("an invalid file changed the limits")
+			}
+		})
+	}
+}
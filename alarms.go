@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAlarmLog is the number of raised alarms kept for /api/alarms and reports
+const maxAlarmLog = 1000
+
+// Alarm is a register value beyond one of its alarm limits. Its Cleared time
+// is set once the value is back within the limits.
+type Alarm struct {
+	ServerID string     `json:"serverId"`
+	Address  uint16     `json:"address"`
+	Name     string     `json:"name"`
+	Limit    string     `json:"limit"` // "high" or "low"
+	Value    float64    `json:"value"` // when raised
+	Message  string     `json:"message"`
+	Raised   time.Time  `json:"raised"`
+	Cleared  *time.Time `json:"cleared,omitempty"`
+}
+
+var (
+	// alarmLogMu guards alarmLog and the Cleared time of every alarm
+	alarmLogMu sync.Mutex
+	alarmLog   []*Alarm // raised alarms, oldest first
+)
+
+// checkAlarms raises an alarm for each register whose value is beyond its
+// alarm limits, and clears it once the value is back within them. An event
+// is published for both. Only registers in blocks read successfully by the
+// last poll are checked. The caller must hold s.mu.
+func (s *ModbusServer) checkAlarms() {
+	now := time.Now()
+	checked := make(map[uint16]bool)
+	for _, block := range s.RegisterBlocks {
+		if status := s.blockStatus[block.StartAddress]; status == nil || status.Status != "ok" {
+			continue
+		}
+		for _, reg := range block.Registers {
+			if reg.AlarmLow == nil && reg.AlarmHigh == nil || reg.Address < 30000 {
+				continue
+			}
+			value, ok := toFloat(decodeRegister(reg, s.registerWords(block, reg.Address, max(registerWordCount(reg), 1))))
+			if filtered, isFiltered := s.filteredValue(reg); isFiltered {
+				value, ok = filtered, true
+			}
+			if !ok || math.IsNaN(value) {
+				continue
+			}
+			checked[reg.Address] = true
+
+			limit := ""
+			switch {
+			case reg.AlarmHigh != nil && value > *reg.AlarmHigh:
+				limit = "high"
+			case reg.AlarmLow != nil && value < *reg.AlarmLow:
+				limit = "low"
+			}
+			active := s.alarms[reg.Address]
+			if active != nil && active.Limit == limit {
+				continue
+			}
+
+			name := reg.Name
+			if name == "" {
+				name = fmt.Sprint(reg.Address)
+			}
+			if active != nil {
+				s.clearAlarm(active, now, fmt.Sprintf("Server %s: %s is back within its alarm limits at %g", s.ID, name, value))
+			}
+			if limit == "" {
+				continue
+			}
+			bound := reg.AlarmHigh
+			if limit == "low" {
+				bound = reg.AlarmLow
+			}
+			alarm := &Alarm{
+				ServerID: s.ID,
+				Address:  reg.Address,
+				Name:     name,
+				Limit:    limit,
+				Value:    value,
+				Message:  fmt.Sprintf("Server %s: %s is %g, beyond its %s alarm limit %g", s.ID, name, value, limit, *bound),
+				Raised:   now,
+			}
+			if s.alarms == nil {
+				s.alarms = make(map[uint16]*Alarm)
+			}
+			s.alarms[reg.Address] = alarm
+			alarmLogMu.Lock()
+			alarmLog = append(alarmLog, alarm)
+			if len(alarmLog) > maxAlarmLog {
+				alarmLog = append([]*Alarm(nil), alarmLog[len(alarmLog)-maxAlarmLog:]...)
+			}
+			alarmLogMu.Unlock()
+			logMessage(ErrorLevel, "%s", alarm.Message)
+			events.publish(Event{Type: "alarm", ServerID: s.ID, Address: &alarm.Address, Message: alarm.Message})
+		}
+	}
+
+	// Alarms of registers whose limits were removed are cleared quietly
+	for address, alarm := range s.alarms {
+		if !checked[address] && s.registerMap[address].AlarmLow == nil && s.registerMap[address].AlarmHigh == nil {
+			alarmLogMu.Lock()
+			alarm.Cleared = &now
+			alarmLogMu.Unlock()
+			delete(s.alarms, address)
+		}
+	}
+}
+
+// clearAlarm marks an active alarm as cleared and publishes the event. The
+// caller must hold s.mu.
+func (s *ModbusServer) clearAlarm(alarm *Alarm, now time.Time, message string) {
+	alarmLogMu.Lock()
+	alarm.Cleared = &now
+	alarmLogMu.Unlock()
+	delete(s.alarms, alarm.Address)
+	logMessage(InfoLevel, "%s", message)
+	events.publish(Event{Type: "alarm-cleared", ServerID: s.ID, Address: &alarm.Address, Message: message})
+}
+
+// alarmsSince returns copies of the alarms that were active at some time
+// after since: raised after it, or cleared after it or not yet cleared.
+// Alarms of removed servers are included, as they happened.
+func alarmsSince(since time.Time) []Alarm {
+	alarmLogMu.Lock()
+	defer alarmLogMu.Unlock()
+	var alarms []Alarm
+	for _, alarm := range alarmLog {
+		if alarm.Raised.After(since) || alarm.Cleared == nil || alarm.Cleared.After(since) {
+			alarms = append(alarms, *alarm)
+		}
+	}
+	return alarms
+}
+
+// activeAlarms returns copies of the active alarms of the running servers,
+// sorted by server and address
+func activeAlarms() []Alarm {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	alarms := []Alarm{}
+	for _, server := range serverList {
+		server.mu.Lock()
+		alarmLogMu.Lock()
+		for _, alarm := range server.alarms {
+			alarms = append(alarms, *alarm)
+		}
+		alarmLogMu.Unlock()
+		server.mu.Unlock()
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].ServerID != alarms[j].ServerID {
+			return alarms[i].ServerID < alarms[j].ServerID
+		}
+		return alarms[i].Address < alarms[j].Address
+	})
+	return alarms
+}
+
+// handleAlarms returns the active alarms and, with ?since=<duration> (24h by
+// default), the alarms raised or cleared within that time
+func handleAlarms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	period := 24 * time.Hour
+	if value := r.URL.Query().Get("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			handleError(w, r, fmt.Sprintf("Invalid since: %s", value))
+			return
+		}
+		period = d
+	}
+
+	recent := alarmsSince(time.Now().Add(-period))
+	if recent == nil {
+		recent = []Alarm{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active": activeAlarms(),
+		"recent": recent,
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// availabilityInterval is how often the connection status of the servers is sampled
+	availabilityInterval = time.Second
+	// availabilityDays is the number of days availability is kept for
+	availabilityDays = 92
+	// defaultAvailabilityDays is the number of days reported unless asked otherwise
+	defaultAvailabilityDays = 30
+)
+
+// availabilityTemplate renders the availability report
+const availabilityTemplate = `
+{{define "availability"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Modbus Browser Availability</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 0.9rem; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #dee2e6; padding: 2px 6px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background-color: #f8f9fa; }
+.error { color: #dc3545; }
+</style>
+</head>
+<body>
+<h1>Server Availability</h1>
+<p>Share of the time each server was connected, by day ({{.Zone}}), over the last {{len .Days}} days. Time polling was paused or the server was disconnected on purpose, and time this instance was not running, are not counted.</p>
+<table>
+<thead><tr><th>Server</th><th>Total</th>{{range .Days}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Servers}}<tr><td>{{.ID}}</td>{{template "availabilityCell" .}}{{range .Days}}{{template "availabilityCell" .}}{{end}}</tr>
+{{end}}
+</tbody>
+</table>
+</body>
+</html>
+{{end}}
+{{define "availabilityCell"}}<td{{if .Down}} class="error"{{end}}>{{.Percent}}</td>{{end}}`
+
+// dayAvailability is the time a server spent in each state on one day
+type dayAvailability struct {
+	connected    time.Duration
+	disconnected time.Duration
+	paused       time.Duration
+}
+
+// availabilityTracker accumulates the connection status of every server by day
+type availabilityTracker struct {
+	mu      sync.Mutex
+	last    time.Time
+	servers map[string]map[string]*dayAvailability // by server ID, then local date
+}
+
+// availability is the running tracker, started with the process
+var availability = &availabilityTracker{servers: make(map[string]map[string]*dayAvailability)}
+
+// sample adds the time since the last sample to the current state of each
+// server. Long gaps, e.g. while the machine was suspended, are not counted.
+func (a *availabilityTracker) sample() {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	elapsed := now.Sub(a.last)
+	a.last = now
+	if elapsed <= 0 || elapsed > 5*availabilityInterval {
+		return
+	}
+
+	date := now.Format(time.DateOnly)
+	for _, server := range serverList {
+		server.mu.Lock()
+		status, paused := server.ConnectionStatus, server.Paused
+		server.mu.Unlock()
+
+		days, ok := a.servers[server.ID]
+		if !ok {
+			days = make(map[string]*dayAvailability)
+			a.servers[server.ID] = days
+		}
+		day, ok := days[date]
+		if !ok {
+			day = &dayAvailability{}
+			days[date] = day
+		}
+		switch {
+		case paused || status == connectionDisconnected:
+			day.paused += elapsed
+		case status == "ok":
+			day.connected += elapsed
+		default:
+			day.disconnected += elapsed
+		}
+	}
+
+	// Drop days past the retention, and servers left without any
+	oldest := now.AddDate(0, 0, -availabilityDays).Format(time.DateOnly)
+	for id, days := range a.servers {
+		for date := range days {
+			if date <= oldest {
+				delete(days, date)
+			}
+		}
+		if len(days) == 0 {
+			delete(a.servers, id)
+		}
+	}
+}
+
+// runAvailability samples the connection status of the servers until the process exits
+func runAvailability() {
+	ticker := time.NewTicker(availabilityInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		availability.sample()
+	}
+}
+
+// DayAvailability is the availability of a server on one day
+type DayAvailability struct {
+	Date         string   `json:"date"`
+	Connected    float64  `json:"connectedSeconds"`
+	Disconnected float64  `json:"disconnectedSeconds"`
+	Paused       float64  `json:"pausedSeconds"`
+	Availability *float64 `json:"availability"` // percent of the connected and disconnected time, nil if neither
+}
+
+// ServerAvailability is the availability of a server over a number of days
+type ServerAvailability struct {
+	ID           string            `json:"id"`
+	Connected    float64           `json:"connectedSeconds"`
+	Disconnected float64           `json:"disconnectedSeconds"`
+	Paused       float64           `json:"pausedSeconds"`
+	Availability *float64          `json:"availability"`
+	Days         []DayAvailability `json:"days"` // most recent first
+}
+
+// percent returns connected as a percentage of connected and disconnected
+func percent(connected, disconnected float64) *float64 {
+	if connected+disconnected == 0 {
+		return nil
+	}
+	p := 100 * connected / (connected + disconnected)
+	return &p
+}
+
+// formatPercent formats an availability for the report, "-" if unknown
+func formatPercent(p *float64) string {
+	if p == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", *p)
+}
+
+// Percent formats the availability of the day for the report
+func (d DayAvailability) Percent() string {
+	return formatPercent(d.Availability)
+}
+
+// Down reports whether the server was disconnected at some point of the day
+func (d DayAvailability) Down() bool {
+	return d.Disconnected > 0
+}
+
+// Percent formats the availability of the server for the report
+func (s ServerAvailability) Percent() string {
+	return formatPercent(s.Availability)
+}
+
+// Down reports whether the server was disconnected at some point of the period
+func (s ServerAvailability) Down() bool {
+	return s.Disconnected > 0
+}
+
+// report returns the availability of the servers over the given number of
+// days up to today, most recent first, and the dates of those days
+func (a *availabilityTracker) report(days int, serverID string) ([]ServerAvailability, []string) {
+	now := time.Now()
+	dates := make([]string, days)
+	for i := range dates {
+		dates[i] = now.AddDate(0, 0, -i).Format(time.DateOnly)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]ServerAvailability, 0, len(a.servers))
+	for id, byDate := range a.servers {
+		if serverID != "" && id != serverID {
+			continue
+		}
+		entry := ServerAvailability{ID: id, Days: make([]DayAvailability, 0, days)}
+		for _, date := range dates {
+			day := DayAvailability{Date: date}
+			if d, ok := byDate[date]; ok {
+				day.Connected = d.connected.Seconds()
+				day.Disconnected = d.disconnected.Seconds()
+				day.Paused = d.paused.Seconds()
+			}
+			day.Availability = percent(day.Connected, day.Disconnected)
+			entry.Connected += day.Connected
+			entry.Disconnected += day.Disconnected
+			entry.Paused += day.Paused
+			entry.Days = append(entry.Days, day)
+		}
+		entry.Availability = percent(entry.Connected, entry.Disconnected)
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, dates
+}
+
+// handleAvailability serves the daily availability of the servers on GET
+// /api/availability?days=N&server=ID as JSON, and on GET
+// /api/availability/report as an HTML table
+func handleAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultAvailabilityDays
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > availabilityDays {
+			handleError(w, r, fmt.Sprintf("Invalid days %q (must be between 1 and %d)", s, availabilityDays))
+			return
+		}
+		days = n
+	}
+	list, dates := availability.report(days, r.URL.Query().Get("server"))
+
+	if r.URL.Path == "/api/availability/report" {
+		zone, _ := time.Now().Zone()
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, "availability", map[string]interface{}{
+			"Servers": list,
+			"Days":    dates,
+			"Zone":    zone,
+		}); err != nil {
+			handleError(w, r, fmt.Sprintf("Error rendering availability report: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"days":    days,
+		"servers": list,
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import "time"
+
+const (
+	// pollBackoffThreshold is the number of consecutive failed polls after
+	// which the poll rate of a server is backed off
+	pollBackoffThreshold = 3
+	// maxPollInterval is the longest interval a backed off server is polled at
+	maxPollInterval = time.Minute
+)
+
+// pollInterval returns the interval a server is currently polled at: its
+// configured poll rate, doubled for every failed poll from the
+// pollBackoffThreshold-th on, up to maxPollInterval. The caller must hold s.mu.
+func (s *ModbusServer) pollInterval() time.Duration {
+	base := time.Duration(s.PollRate) * time.Millisecond
+	interval := base
+	for i := pollBackoffThreshold; i <= s.failedPolls && interval < maxPollInterval; i++ {
+		interval *= 2
+	}
+	if interval > base && interval > maxPollInterval {
+		return maxPollInterval
+	}
+	return interval
+}
+
+// PollBackoff returns the backed off poll interval in milliseconds, or 0 if
+// the server is polled at its configured rate. The caller must hold s.mu.
+func (s *ModbusServer) PollBackoff() int {
+	interval := s.pollInterval()
+	if interval == time.Duration(s.PollRate)*time.Millisecond {
+		return 0
+	}
+	return int(interval / time.Millisecond)
+}
+
+// FailedPolls returns the number of consecutive polls in which no block could
+// be read. The caller must hold s.mu.
+func (s *ModbusServer) FailedPolls() int {
+	return s.failedPolls
+}
+
+// recordPollResult counts consecutive failed polls and logs when the poll
+// rate is backed off or restored. The caller must hold s.mu.
+func (s *ModbusServer) recordPollResult(failed bool) {
+	previous := s.pollInterval()
+	if failed {
+		s.failedPolls++
+	} else {
+		s.failedPolls = 0
+	}
+
+	switch interval := s.pollInterval(); {
+	case interval == previous:
+	case failed:
+		logMessage(InfoLevel, "Server %s failed %d polls in a row, polling every %s", s.ID, s.failedPolls, interval)
+	default:
+		logMessage(InfoLevel, "Server %s recovered, polling every %s again", s.ID, interval)
+	}
+}
+
+// recordCycle records the duration of a poll cycle and counts it as an
+// overrun if it took longer than the poll interval, logging when a server
+// starts overrunning. It reports whether the next tick should be skipped.
+// The caller must hold s.mu.
+func (s *ModbusServer) recordCycle(elapsed, interval time.Duration) bool {
+	overran := s.lastCycle > interval
+	s.lastCycle = elapsed
+	if s.cycles.add(elapsed, interval) && elapsed <= interval {
+		logMessage(InfoLevel, "Server %s poll cycle took %s, close to its %s poll interval", s.ID, elapsed.Round(time.Millisecond), interval)
+	}
+	if elapsed <= interval {
+		return false
+	}
+	s.overruns++
+	if !overran {
+		logMessage(InfoLevel, "Server %s poll cycle took %s, longer than its %s poll interval", s.ID, elapsed.Round(time.Millisecond), interval)
+	}
+	return s.SkipOverrun
+}
+
+// LastCycle returns the duration of the last poll cycle in milliseconds.
+// The caller must hold s.mu.
+func (s *ModbusServer) LastCycle() int64 {
+	return s.lastCycle.Milliseconds()
+}
+
+// Overruns returns the number of poll cycles that took longer than the poll
+// interval. The caller must hold s.mu.
+func (s *ModbusServer) Overruns() int {
+	return s.overruns
+}
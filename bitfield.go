@@ -0,0 +1,74 @@
+package main
+
+import "fmt"
+
+// BitConfig names a bit of a bitfield register, e.g. {"bit": 3, "name": "Overcurrent"}
+type BitConfig struct {
+	Bit  uint8  `json:"bit"` // 0 is the least significant bit
+	Name string `json:"name"`
+}
+
+// registerBit is a bit of a bitfield register in the register data
+type registerBit struct {
+	Bit  uint8  `json:"bit"`
+	Name string `json:"name"`
+	Set  bool   `json:"set"`
+}
+
+// checkBits returns an error if the bits of a register are invalid or it is
+// not a bitfield
+func checkBits(reg RegisterConfig) error {
+	if len(reg.Bits) == 0 {
+		return nil
+	}
+	if reg.Format != "bitfield" {
+		return fmt.Errorf("bits require the bitfield format, not %q", reg.Format)
+	}
+	seen := make(map[uint8]bool)
+	for i, bit := range reg.Bits {
+		switch {
+		case bit.Bit > 15:
+			return fmt.Errorf("bit %d: number %d is out of range (must be 0 to 15)", i+1, bit.Bit)
+		case seen[bit.Bit]:
+			return fmt.Errorf("bit %d: bit %d is named twice", i+1, bit.Bit)
+		case bit.Name == "":
+			return fmt.Errorf("bit %d: name is required", i+1)
+		}
+		seen[bit.Bit] = true
+	}
+	return nil
+}
+
+// registerBits expands the value of a bitfield register into its named bits,
+// and the bits that are set without a name as "Bit n", in bit order
+func registerBits(reg RegisterConfig, value uint16) []registerBit {
+	named := make(map[uint8]string, len(reg.Bits))
+	for _, bit := range reg.Bits {
+		named[bit.Bit] = bit.Name
+	}
+	var bits []registerBit
+	for n := uint8(0); n < 16; n++ {
+		set := value&(1<<n) != 0
+		name, ok := named[n]
+		if !ok && !set {
+			continue
+		}
+		if !ok {
+			name = fmt.Sprintf("Bit %d", n)
+		}
+		bits = append(bits, registerBit{Bit: n, Name: name, Set: set})
+	}
+	return bits
+}
+
+// setBits sets the Bits of a register data row from the value it holds, or
+// nil if the register is not a bitfield or has no value
+func setBits(row map[string]interface{}, reg RegisterConfig) {
+	row["Bits"] = nil
+	if reg.Format != "bitfield" || row["Quality"] == "missing" {
+		return
+	}
+	if value, ok := row["Value"].(uint16); ok {
+		row["Bits"] = registerBits(reg, value)
+	}
+}
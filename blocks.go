@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// reorderBlocks puts the register blocks of a server in the order of their
+// start addresses in order, which must list every block exactly once. Blocks
+// are polled and displayed in this order. The caller must hold s.mu.
+func (s *ModbusServer) reorderBlocks(order []uint16) error {
+	if len(order) != len(s.RegisterBlocks) {
+		return fmt.Errorf("order lists %d blocks, server %s has %d", len(order), s.ID, len(s.RegisterBlocks))
+	}
+	byStart := make(map[uint16]RegisterBlock, len(s.RegisterBlocks))
+	for _, block := range s.RegisterBlocks {
+		byStart[block.StartAddress] = block
+	}
+	blocks := make([]RegisterBlock, 0, len(order))
+	for _, start := range order {
+		block, ok := byStart[start]
+		if !ok {
+			return fmt.Errorf("no block of server %s starts at %d, or it is listed twice", s.ID, start)
+		}
+		delete(byStart, start)
+		blocks = append(blocks, block)
+	}
+	s.RegisterBlocks = blocks
+	return nil
+}
+
+// setBlocksEnabled enables and disables the blocks starting at the given
+// addresses, checking all of them before changing any. A disabled block
+// keeps its definition but is not polled. The caller must hold s.mu.
+func (s *ModbusServer) setBlocksEnabled(enable, disable []uint16) error {
+	changes := make(map[uint16]bool, len(enable)+len(disable))
+	for _, start := range enable {
+		changes[start] = false
+	}
+	for _, start := range disable {
+		if _, listed := changes[start]; listed {
+			return fmt.Errorf("block %d is both enabled and disabled", start)
+		}
+		changes[start] = true
+	}
+	starts := make(map[uint16]bool, len(s.RegisterBlocks))
+	for _, block := range s.RegisterBlocks {
+		starts[block.StartAddress] = true
+	}
+	for _, start := range append(enable, disable...) {
+		if !starts[start] {
+			return fmt.Errorf("no block of server %s starts at %d", s.ID, start)
+		}
+	}
+
+	for i := range s.RegisterBlocks {
+		block := &s.RegisterBlocks[i]
+		disabled, ok := changes[block.StartAddress]
+		if !ok || disabled == block.Disabled {
+			continue
+		}
+		block.Disabled = disabled
+		if disabled {
+			s.markBlockDisabled(*block)
+		} else if status := s.blockStatus[block.StartAddress]; status != nil {
+			status.Status = "pending"
+		}
+	}
+	return nil
+}
+
+// markBlockDisabled records that a block is not polled, so that checks of
+// the values read by the last poll skip it. The caller must hold s.mu.
+func (s *ModbusServer) markBlockDisabled(block RegisterBlock) {
+	if s.blockStatus == nil {
+		s.blockStatus = make(map[uint16]*BlockStatus)
+	}
+	status, ok := s.blockStatus[block.StartAddress]
+	if !ok {
+		status = &BlockStatus{StartAddress: block.StartAddress}
+		s.blockStatus[block.StartAddress] = status
+	}
+	status.Length = block.Length
+	status.Status = "disabled"
+}
+
+// handleBlocks lists the register blocks of a server in poll order with
+// their status on GET /api/servers/{id}/blocks. PUT changes the order with
+// {"order": [startAddress, ...]} and switches blocks on and off with
+// {"enable": [startAddress, ...], "disable": [...]}; either may be left out.
+func handleBlocks(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Order   []uint16 `json:"order"`
+			Enable  []uint16 `json:"enable"`
+			Disable []uint16 `json:"disable"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+
+		server.mu.Lock()
+		previous := server.RegisterBlocks
+		var err error
+		if request.Order != nil {
+			err = server.reorderBlocks(request.Order)
+		}
+		if err == nil {
+			if err = server.setBlocksEnabled(request.Enable, request.Disable); err != nil {
+				server.RegisterBlocks = previous
+			}
+		}
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		if request.Order != nil {
+			logMessage(InfoLevel, "Reordered blocks of server %s: %v", id, request.Order)
+		}
+		if len(request.Enable) > 0 || len(request.Disable) > 0 {
+			logMessage(InfoLevel, "Blocks of server %s enabled: %v, disabled: %v", id, request.Enable, request.Disable)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	blocks := server.BlockStatuses()
+	server.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"blocks":  blocks,
+	})
+}
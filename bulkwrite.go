@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum quantities per write request defined by the Modbus specification
+const (
+	maxWriteRegisters = 123
+	maxWriteCoils     = 1968
+)
+
+// Range of values accepted for a 16-bit register: signed minimum to unsigned maximum
+const (
+	registerValueMin = -32768
+	registerValueMax = 65535
+)
+
+// bulkWriteRow is a single address/value pair of a bulk write, with its outcome
+type bulkWriteRow struct {
+	Line    int         `json:"line"`
+	Address uint16      `json:"address"`
+	Value   interface{} `json:"value"`
+	Status  string      `json:"status"` // "valid", "invalid", "ok" or "error"
+	Error   string      `json:"error,omitempty"`
+
+	register uint16  // encoded holding register value
+	coil     bool    // encoded coil value
+	previous *uint16 // value read before writing (coils as 0 or 1), nil if unknown
+}
+
+// bulkWriteInput is an address/value pair as read from an uploaded file
+type bulkWriteInput struct {
+	Line    int
+	Address string
+	Value   string
+}
+
+// handleBulkWrite validates and applies a file of address/value pairs to a server.
+// With preview=true the rows are only validated.
+func handleBulkWrite(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	data, err := readUploadBody(r, "file")
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	inputs, err := parseBulkWriteInputs(data)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid bulk write file: %v", err))
+		return
+	}
+	if len(inputs) == 0 {
+		handleError(w, r, "Bulk write file contains no rows")
+		return
+	}
+
+	rows := make([]*bulkWriteRow, 0, len(inputs))
+	valid := true
+	for _, input := range inputs {
+		row := validateBulkWriteRow(input)
+		if row.Status == "invalid" {
+			valid = false
+		}
+		rows = append(rows, row)
+	}
+
+	preview := r.FormValue("preview") == "true"
+	if !preview && valid {
+		server.mu.Lock()
+		if server.client == nil {
+			server.mu.Unlock()
+			handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+			return
+		}
+		if err := checkWritePolicy(server, bulkWriteTargets(rows), writeAuthorization(r)); err != nil {
+			server.mu.Unlock()
+			handleWritePolicyError(w, r, err)
+			return
+		}
+		applyBulkWrite(server.client, rows)
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Bulk wrote %d values to server %s", len(rows), id)
+		if changes := bulkWriteChanges(rows); len(changes) > 0 {
+			recordWrite(id, "bulk write", changes, 0)
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": valid,
+		"preview": preview,
+		"rows":    rows,
+	}
+	if !valid {
+		response["error"] = "Some rows are invalid; nothing was written"
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseBulkWriteInputs reads address/value pairs from a JSON array of
+// {"address", "value"} objects, a JSON object of address to value, or CSV
+// lines of "address,value" with an optional header
+func parseBulkWriteInputs(data []byte) ([]bulkWriteInput, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	switch trimmed[0] {
+	case '[':
+		var items []struct {
+			Address json.Number `json:"address"`
+			Value   interface{} `json:"value"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		inputs := make([]bulkWriteInput, 0, len(items))
+		for i, item := range items {
+			inputs = append(inputs, bulkWriteInput{Line: i + 1, Address: item.Address.String(), Value: fmt.Sprint(item.Value)})
+		}
+		return inputs, nil
+
+	case '{':
+		var items map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		inputs := make([]bulkWriteInput, 0, len(items))
+		for address, value := range items {
+			inputs = append(inputs, bulkWriteInput{Address: address, Value: fmt.Sprint(value)})
+		}
+		sort.Slice(inputs, func(i, j int) bool {
+			a, _ := strconv.Atoi(inputs[i].Address)
+			b, _ := strconv.Atoi(inputs[j].Address)
+			return a < b
+		})
+		for i := range inputs {
+			inputs[i].Line = i + 1
+		}
+		return inputs, nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(trimmed))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var inputs []bulkWriteInput
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected address,value", line)
+		}
+		// Skip a header row
+		if len(inputs) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			continue
+		}
+		inputs = append(inputs, bulkWriteInput{
+			Line:    line,
+			Address: strings.TrimSpace(record[0]),
+			Value:   strings.TrimSpace(record[1]),
+		})
+	}
+	return inputs, nil
+}
+
+// validateBulkWriteRow checks that an address is writable and encodes its value
+func validateBulkWriteRow(input bulkWriteInput) *bulkWriteRow {
+	row := &bulkWriteRow{Line: input.Line, Value: input.Value, Status: "valid"}
+
+	address, err := strconv.ParseUint(input.Address, 10, 16)
+	if err != nil {
+		row.Status = "invalid"
+		row.Error = fmt.Sprintf("invalid address %q", input.Address)
+		return row
+	}
+	row.Address = uint16(address)
+
+	switch {
+	case row.Address < 10000: // Coils
+		value, err := parseCoilValue(input.Value)
+		if err != nil {
+			row.Status = "invalid"
+			row.Error = err.Error()
+			return row
+		}
+		row.coil = value
+		row.Value = value
+	case row.Address >= 40000 && row.Address < 50000: // Holding Registers
+		value, err := parseRegisterValue(input.Value)
+		if err != nil {
+			row.Status = "invalid"
+			row.Error = err.Error()
+			return row
+		}
+		row.register = value
+		row.Value = value
+	default:
+		row.Status = "invalid"
+		row.Error = fmt.Sprintf("address %d is not a coil or holding register", row.Address)
+	}
+	return row
+}
+
+// parseRegisterValue parses a decimal or 0x-prefixed hex register value.
+// Negative values are stored as 16-bit two's complement.
+func parseRegisterValue(s string) (uint16, error) {
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		v, err := strconv.ParseUint(s[2:], 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid register value %q", s)
+		}
+		return uint16(v), nil
+	}
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || v < registerValueMin || v > registerValueMax {
+		return 0, fmt.Errorf("invalid register value %q (must be between %d and %d)", s, registerValueMin, registerValueMax)
+	}
+	return uint16(v), nil
+}
+
+// parseCoilValue parses a coil value given as 1/0, true/false or on/off
+func parseCoilValue(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "true", "on":
+		return true, nil
+	case "0", "false", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid coil value %q (must be 1/0, true/false or on/off)", s)
+}
+
+// applyBulkWrite writes validated rows, combining consecutive addresses into
+// multiple-write requests, and records the outcome and replaced value on each row.
+// The caller must hold the server's lock.
+func applyBulkWrite(client Device, rows []*bulkWriteRow) {
+	sorted := append([]*bulkWriteRow(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	for start := 0; start < len(sorted); {
+		first := sorted[start]
+		isCoil := first.Address < 10000
+		limit := maxWriteRegisters
+		if isCoil {
+			limit = maxWriteCoils
+		}
+
+		// Extend the run while addresses are consecutive
+		end := start + 1
+		for end < len(sorted) && end-start < limit &&
+			sorted[end].Address == sorted[end-1].Address+1 &&
+			(sorted[end].Address < 10000) == isCoil {
+			end++
+		}
+		run := sorted[start:end]
+
+		// Read the values about to be replaced so the write can be reverted
+		if isCoil {
+			if current, err := client.ReadCoils(first.Address, uint16(len(run))); err == nil {
+				for i, row := range run {
+					var previous uint16
+					if current[i] {
+						previous = 1
+					}
+					row.previous = &previous
+				}
+			}
+		} else if current, err := client.ReadHoldingRegisters(first.Address-40000, uint16(len(run))); err == nil {
+			for i, row := range run {
+				row.previous = &current[i]
+			}
+		}
+
+		var err error
+		if isCoil {
+			values := make([]bool, len(run))
+			for i, row := range run {
+				values[i] = row.coil
+			}
+			if len(values) == 1 {
+				err = client.WriteSingleCoil(first.Address, values[0])
+			} else {
+				err = client.WriteMultipleCoils(first.Address, values)
+			}
+		} else {
+			values := make([]uint16, len(run))
+			for i, row := range run {
+				values[i] = row.register
+			}
+			if len(values) == 1 {
+				err = client.WriteSingleRegister(first.Address-40000, values[0])
+			} else {
+				err = client.WriteMultipleRegisters(first.Address-40000, values)
+			}
+		}
+
+		for _, row := range run {
+			if err != nil {
+				row.Status = "error"
+				row.Error = err.Error()
+			} else {
+				row.Status = "ok"
+			}
+		}
+		start = end
+	}
+}
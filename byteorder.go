@@ -0,0 +1,43 @@
+package main
+
+// byteOrders lists the supported RegisterConfig byte orders, named by the
+// position of the bytes of a value, most significant first, in the words as
+// read: ABCD is big-endian (the default), CDAB has the words swapped, BADC
+// the bytes within each word and DCBA both. Values of more than two words
+// follow the same pattern, e.g. GHEFCDAB for a uint64 in CDAB order.
+var byteOrders = map[string]bool{
+	"ABCD": true,
+	"CDAB": true,
+	"BADC": true,
+	"DCBA": true,
+}
+
+// orderWords converts between the words of a register as the device holds
+// them and big-endian words. Word swaps apply to multi-word numbers, byte
+// swaps to every format with bytes in its words. The conversion is its own
+// inverse, so it serves both decoding and encoding.
+func orderWords(reg RegisterConfig, words []uint16) []uint16 {
+	if reg.ByteOrder == "" || reg.ByteOrder == "ABCD" {
+		return words
+	}
+	ordered := make([]uint16, len(words))
+	copy(ordered, words)
+
+	switch reg.Format {
+	case "float", "double", "uint32", "int32", "uint64", "int64", "unix-time":
+		if n := registerWordCount(reg); len(ordered) >= n && (reg.ByteOrder == "CDAB" || reg.ByteOrder == "DCBA") {
+			for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+				ordered[i], ordered[j] = ordered[j], ordered[i]
+			}
+		}
+	case "string-word", "boolean":
+		// one character or flag per word; the byte order does not apply
+		return ordered
+	}
+	if reg.ByteOrder == "BADC" || reg.ByteOrder == "DCBA" {
+		for i, w := range ordered {
+			ordered[i] = swapBytes(w)
+		}
+	}
+	return ordered
+}
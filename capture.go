@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// maxCaptureAddresses is the largest number of addresses one capture reads
+const maxCaptureAddresses = 125
+
+// CaptureRequest is the body of POST /api/servers/{id}/capture
+type CaptureRequest struct {
+	Addresses []uint16 `json:"addresses"`
+}
+
+// CapturedValue is a value read by a capture, decoded in its configured format
+type CapturedValue struct {
+	Address uint16      `json:"address"`
+	Name    string      `json:"name,omitempty"`
+	Format  string      `json:"format,omitempty"`
+	Value   interface{} `json:"value"`
+	Raw     []uint16    `json:"raw,omitempty"` // register words behind the value
+}
+
+// captureRead is one read request of a capture, covering one or more addresses
+type captureRead struct {
+	start, end uint16 // end is exclusive
+	bits       []bool
+	words      []uint16
+}
+
+// planCapture groups addresses into as few reads as possible: addresses of
+// the same table are read together when the gap between them is at most the
+// server's MaxBlockGap and the read stays within the protocol limit. It
+// returns the reads and the register configuration of each address. The
+// caller must hold s.mu.
+func (s *ModbusServer) planCapture(addresses []uint16) ([]*captureRead, map[uint16]RegisterConfig, error) {
+	sorted := append([]uint16(nil), addresses...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	configs := make(map[uint16]RegisterConfig)
+	var reads []*captureRead
+	for _, addr := range sorted {
+		if _, seen := configs[addr]; seen {
+			continue
+		}
+		rangeEnd, ok := addressRangeEnd(addr)
+		if !ok {
+			return nil, nil, fmt.Errorf("address %d is not in a valid address range", addr)
+		}
+		reg, hasConfig := s.registerMap[addr]
+		if !hasConfig {
+			reg = RegisterConfig{Address: addr}
+		}
+		words := 1
+		if addr >= 30000 {
+			words = max(registerWordCount(reg), 1)
+		}
+		end := int(addr) + words
+		if end-1 > int(rangeEnd) {
+			return nil, nil, fmt.Errorf("%s value at address %d runs past the end of its address range", reg.Format, addr)
+		}
+		configs[addr] = reg
+
+		if n := len(reads); n > 0 {
+			last := reads[n-1]
+			lastEnd, _ := addressRangeEnd(last.start)
+			if lastEnd == rangeEnd && s.addressUnit(addr) == s.addressUnit(last.start) && int(addr) <= int(last.end)+int(s.MaxBlockGap) &&
+				max(end, int(last.end))-int(last.start) <= int(maxBlockLength(addr)) {
+				last.end = uint16(max(end, int(last.end)))
+				continue
+			}
+		}
+		reads = append(reads, &captureRead{start: addr, end: uint16(end)})
+	}
+	return reads, configs, nil
+}
+
+// handleCapture reads a set of addresses straight from the device on POST
+// /api/servers/{id}/capture, with the reads sent back to back while polling
+// is held off, and returns them with a single timestamp. This gives a
+// consistent snapshot of related values such as the voltages and currents
+// of three phases.
+func handleCapture(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	var req CaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid capture request: %v", err))
+		return
+	}
+	if len(req.Addresses) == 0 || len(req.Addresses) > maxCaptureAddresses {
+		handleError(w, r, fmt.Sprintf("Capture requires 1 to %d addresses", maxCaptureAddresses))
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.client == nil {
+		handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+		return
+	}
+
+	reads, configs, err := server.planCapture(req.Addresses)
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	// Send the reads without anything in between; decoding waits until all are done
+	start := time.Now()
+	for _, read := range reads {
+		values, err := readAddresses(server.client, read.start, read.end-read.start)
+		if err == nil {
+			switch v := values.(type) {
+			case []bool:
+				read.bits = v
+				err = checkResponseLength(len(v), read.end-read.start)
+			case []uint16:
+				read.words = v
+				err = checkResponseLength(len(v), read.end-read.start)
+			}
+		}
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error reading %d+%d: %v", read.start, read.end-read.start, err))
+			return
+		}
+	}
+	end := time.Now()
+
+	captured := make([]CapturedValue, 0, len(req.Addresses))
+	for _, addr := range req.Addresses {
+		reg := configs[addr]
+		value := CapturedValue{Address: addr, Name: reg.Name, Format: reg.Format}
+		for _, read := range reads {
+			if addr < read.start || addr >= read.end {
+				continue
+			}
+			if read.bits != nil {
+				value.Value = read.bits[addr-read.start]
+				break
+			}
+			n := max(registerWordCount(reg), 1)
+			value.Raw = read.words[addr-read.start : int(addr-read.start)+n]
+			value.Value = jsonNumber(decodeRegister(reg, value.Raw))
+			break
+		}
+		captured = append(captured, value)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"time":     start.Add(end.Sub(start) / 2),
+		"spanMs":   float64(end.Sub(start).Microseconds()) / 1000,
+		"requests": len(reads),
+		"values":   captured,
+	})
+}
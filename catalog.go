@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogCacheTime is how long the index of the catalog is kept before it is
+// fetched again
+const catalogCacheTime = 10 * time.Minute
+
+// maxCatalogFile bounds the size of the catalog index and of the maps in it
+const maxCatalogFile = 10 << 20
+
+// catalogClient is used for all requests to the register map catalog
+var catalogClient = &http.Client{Timeout: 15 * time.Second}
+
+// catalogURL is the index of the register map catalog (-catalog), disabled if empty
+var catalogURL string
+
+// CatalogIndex lists the register maps of a catalog, such as a repository of
+// community-contributed maps
+type CatalogIndex struct {
+	Maps []CatalogEntry `json:"maps"`
+}
+
+// CatalogEntry is a register map in a catalog
+type CatalogEntry struct {
+	Name        string `json:"name"` // unique within the catalog
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"` // register map file in the interchange format, relative to the index
+}
+
+var (
+	catalogMu      sync.Mutex
+	catalogIndex   *CatalogIndex
+	catalogFetched time.Time
+)
+
+// fetchCatalogFile downloads a file of the catalog
+func fetchCatalogFile(u string) ([]byte, error) {
+	resp, err := catalogClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCatalogFile+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %v", u, err)
+	}
+	if len(data) > maxCatalogFile {
+		return nil, fmt.Errorf("GET %s: larger than %d bytes", u, maxCatalogFile)
+	}
+	return data, nil
+}
+
+// loadCatalog returns the index of the catalog, fetching it if it is not
+// cached, is older than catalogCacheTime or refresh is set. The URLs of the
+// maps are resolved against the URL of the index; entries without a name or
+// URL, and with a name used before, are left out.
+func loadCatalog(refresh bool) (*CatalogIndex, error) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if catalogIndex != nil && !refresh && time.Since(catalogFetched) < catalogCacheTime {
+		return catalogIndex, nil
+	}
+
+	base, err := url.Parse(catalogURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog URL: %v", err)
+	}
+	data, err := fetchCatalogFile(catalogURL)
+	if err != nil {
+		return nil, err
+	}
+	var index CatalogIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid catalog index: %v", err)
+	}
+
+	maps := make([]CatalogEntry, 0, len(index.Maps))
+	seen := make(map[string]bool)
+	for i, entry := range index.Maps {
+		ref, err := url.Parse(entry.URL)
+		switch {
+		case entry.Name == "" || entry.URL == "":
+			logMessage(InfoLevel, "Catalog entry %d has no name or URL and is left out", i+1)
+			continue
+		case seen[entry.Name]:
+			logMessage(InfoLevel, "Catalog entry %d: duplicate name %q is left out", i+1, entry.Name)
+			continue
+		case err != nil:
+			logMessage(InfoLevel, "Catalog entry %s: invalid URL %q is left out", entry.Name, entry.URL)
+			continue
+		}
+		entry.URL = base.ResolveReference(ref).String()
+		if !strings.HasPrefix(entry.URL, "http://") && !strings.HasPrefix(entry.URL, "https://") {
+			logMessage(InfoLevel, "Catalog entry %s: URL %q is not http or https and is left out", entry.Name, entry.URL)
+			continue
+		}
+		seen[entry.Name] = true
+		maps = append(maps, entry)
+	}
+	index.Maps = maps
+
+	catalogIndex = &index
+	catalogFetched = time.Now()
+	logMessage(InfoLevel, "Fetched the register map catalog %s with %d maps", catalogURL, len(maps))
+	return catalogIndex, nil
+}
+
+// handleCatalog lists the maps of the register map catalog on GET
+// /api/catalog (add ?refresh=true to fetch the index again), and serves one
+// of them, checked as an import would check it, on GET /api/catalog?map=name.
+// The map can then be imported with POST /api/servers/{id}/registermap.
+func handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if catalogURL == "" {
+		handleError(w, r, "The register map catalog is disabled; start modbusbrowser with -catalog and the URL of a catalog index")
+		return
+	}
+
+	index, err := loadCatalog(r.URL.Query().Get("refresh") == "true")
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error fetching the catalog: %v", err))
+		return
+	}
+
+	name := r.URL.Query().Get("map")
+	if name == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"catalog": catalogURL,
+			"fetched": catalogFetched,
+			"maps":    index.Maps,
+		})
+		return
+	}
+
+	var entry *CatalogEntry
+	for i := range index.Maps {
+		if index.Maps[i].Name == name {
+			entry = &index.Maps[i]
+		}
+	}
+	if entry == nil {
+		handleError(w, r, fmt.Sprintf("No map named %q in the catalog", name))
+		return
+	}
+	data, err := fetchCatalogFile(entry.URL)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error fetching map %s: %v", name, err))
+		return
+	}
+	m, err := parseRegisterMap(data)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid register map %s: %v", name, err))
+		return
+	}
+	if _, errs, _ := importRegisterMap(m); len(errs) > 0 {
+		handleError(w, r, fmt.Sprintf("Invalid register map %s: %s", name, issueMessages(errs)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
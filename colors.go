@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ColorRule colors a register's value in the register table when the value
+// matches its condition, e.g. {"when": ">100", "color": "red"}
+type ColorRule struct {
+	When  string `json:"when"`  // comparison with a number: "0", "==0", "!=0", "<5", "<=5", ">100" or ">=100"; "true" and "false" for booleans
+	Color string `json:"color"` // one of colorClasses
+}
+
+// colorClasses maps the colors of color rules to the Bootstrap contextual
+// classes the table uses for them
+var colorClasses = map[string]string{
+	"green":  "success",
+	"red":    "danger",
+	"yellow": "warning",
+	"blue":   "primary",
+	"gray":   "secondary",
+}
+
+// colorOperators are the comparisons of color rule conditions, longest first
+// so that "<=" is not read as "<"
+var colorOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseColorCondition splits a color rule condition into its comparison and
+// number. A bare number compares for equality.
+func parseColorCondition(when string) (string, float64, error) {
+	when = strings.TrimSpace(when)
+	switch when {
+	case "true":
+		return "==", 1, nil
+	case "false":
+		return "==", 0, nil
+	}
+	op, operand := "==", when
+	for _, candidate := range colorOperators {
+		if rest, found := strings.CutPrefix(when, candidate); found {
+			op, operand = candidate, strings.TrimSpace(rest)
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(operand, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid condition %q (must be a comparison such as >100, <=5 or ==0)", when)
+	}
+	return op, number, nil
+}
+
+// checkColorRules returns an error for the first invalid color rule
+func checkColorRules(rules []ColorRule) error {
+	for i, rule := range rules {
+		if _, _, err := parseColorCondition(rule.When); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		if colorClasses[rule.Color] == "" {
+			return fmt.Errorf("rule %d: unknown color %q (must be green, red, yellow, blue or gray)", i+1, rule.Color)
+		}
+	}
+	return nil
+}
+
+// valueColor returns the color of the first rule matching a value, or "" if
+// none matches or the value is not numeric. Booleans compare as 1 and 0.
+func valueColor(rules []ColorRule, value interface{}) string {
+	number, ok := toFloat(value)
+	if !ok {
+		return ""
+	}
+	for _, rule := range rules {
+		op, limit, err := parseColorCondition(rule.When)
+		if err != nil {
+			continue
+		}
+		if compare(op, number, limit) {
+			return rule.Color
+		}
+	}
+	return ""
+}
+
+// compare applies a comparison of a condition to a number
+func compare(op string, number, limit float64) bool {
+	switch op {
+	case "==":
+		return number == limit
+	case "!=":
+		return number != limit
+	case "<":
+		return number < limit
+	case "<=":
+		return number <= limit
+	case ">":
+		return number > limit
+	case ">=":
+		return number >= limit
+	}
+	return false
+}
+
+// setColor sets the color of a register data row from the value it holds.
+// ColorClass is for the table template and not part of JSON responses.
+func setColor(row map[string]interface{}, rules []ColorRule) {
+	color := ""
+	if row["Quality"] != "missing" {
+		color = valueColor(rules, row["Value"])
+	}
+	row["Color"], row["ColorClass"] = color, colorClasses[color]
+}
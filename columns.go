@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// registerColumn is a column of the register table
+type registerColumn struct {
+	Key    string   `json:"key"`
+	Title  string   `json:"title"`
+	Fields []string `json:"-"` // keys of the register data included in JSON responses
+}
+
+// registerColumns lists the available register table columns
+var registerColumns = []registerColumn{
+	{"address", "Address", []string{"Address"}},
+	{"name", "Name", []string{"Name", "Note", "URL"}},
+	{"value", "Value", []string{"Value", "State", "Bits", "Quality", "Raw", "Hex", "Color"}},
+	{"format", "Format", []string{"Format"}},
+	{"hex", "Hex", []string{"Hex"}},
+	{"unit", "Unit", []string{"Unit"}},
+	{"description", "Description", []string{"Description"}},
+	{"quality", "Quality", []string{"Quality"}},
+	{"min", "Expected Min", []string{"ExpectedMin"}},
+	{"max", "Expected Max", []string{"ExpectedMax"}},
+	{"lastChange", "Last Change", []string{"LastChange"}},
+}
+
+// defaultColumns are shown for servers without their own column setting
+var defaultColumns = []string{"address", "name", "value", "format"}
+
+// columnKeyList returns the keys of all available columns, comma-separated
+func columnKeyList() string {
+	keys := make([]string, len(registerColumns))
+	for i, column := range registerColumns {
+		keys[i] = column.Key
+	}
+	return strings.Join(keys, ", ")
+}
+
+// findColumn returns the register column with the given key
+func findColumn(key string) (registerColumn, bool) {
+	for _, column := range registerColumns {
+		if column.Key == key {
+			return column, true
+		}
+	}
+	return registerColumn{}, false
+}
+
+// checkColumns verifies that every column key is known and used once
+func checkColumns(keys []string) error {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if _, ok := findColumn(key); !ok {
+			return fmt.Errorf("unknown column %q", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate column %q", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// parseColumns parses a comma-separated list of column keys
+func parseColumns(s string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no columns given")
+	}
+	return keys, checkColumns(keys)
+}
+
+// columnKeys returns the columns shown for the server
+func (s *ModbusServer) columnKeys() []string {
+	if len(s.Columns) > 0 {
+		return s.Columns
+	}
+	return defaultColumns
+}
+
+// ColumnHeaders returns the columns shown for the server, for use in templates
+func (s *ModbusServer) ColumnHeaders() []registerColumn {
+	return columnHeaders(s.columnKeys())
+}
+
+// columnHeaders returns the register columns for a list of keys, skipping unknown keys
+func columnHeaders(keys []string) []registerColumn {
+	columns := make([]registerColumn, 0, len(keys))
+	for _, key := range keys {
+		if column, ok := findColumn(key); ok {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// filterColumns reduces register data to the fields of the given columns.
+// The address is always included.
+func filterColumns(data []map[string]interface{}, keys []string) []map[string]interface{} {
+	fields := []string{"Address"}
+	for _, column := range columnHeaders(keys) {
+		fields = append(fields, column.Fields...)
+	}
+
+	filtered := make([]map[string]interface{}, 0, len(data))
+	for _, row := range data {
+		out := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			out[field] = row[field]
+		}
+		filtered = append(filtered, out)
+	}
+	return filtered
+}
+
+// registerSortFields maps the sort keys accepted by GET /api/servers/{id} to register data fields
+var registerSortFields = map[string]string{
+	"address":     "Address",
+	"name":        "Name",
+	"value":       "Value",
+	"format":      "Format",
+	"unit":        "Unit",
+	"description": "Description",
+	"quality":     "Quality",
+	"lastChange":  "LastChange",
+}
+
+// sortRegisterData orders register data by a sort key, ascending unless desc is set.
+// Numeric values sort numerically and before text; ties keep address order.
+func sortRegisterData(data []map[string]interface{}, key string, desc bool) error {
+	field, ok := registerSortFields[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q", key)
+	}
+
+	less := func(a, b interface{}) bool {
+		if ta, ok := a.(time.Time); ok {
+			return ta.Before(b.(time.Time))
+		}
+		fa, numericA := toFloat(a)
+		fb, numericB := toFloat(b)
+		switch {
+		case numericA && numericB:
+			return fa < fb
+		case numericA != numericB:
+			return numericA
+		default:
+			return fmt.Sprint(a) < fmt.Sprint(b)
+		}
+	}
+
+	sort.SliceStable(data, func(i, j int) bool {
+		a, b := data[i][field], data[j][field]
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return nil
+}
+
+// handleColumns gets or sets the register table columns of a server.
+// Setting an empty list restores the default columns.
+func handleColumns(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Columns []string `json:"columns"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if err := checkColumns(request.Columns); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		server.mu.Lock()
+		server.Columns = request.Columns
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Set columns of server %s to %v", id, request.Columns)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	columns := server.columnKeys()
+	server.mu.Unlock()
+
+	available := make([]map[string]string, 0, len(registerColumns))
+	for _, column := range registerColumns {
+		available = append(available, map[string]string{"key": column.Key, "title": column.Title})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"columns":   columns,
+		"available": available,
+	})
+}
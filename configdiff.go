@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// ConfigDiff describes what uploading a configuration would change in the
+// running setup
+type ConfigDiff struct {
+	Servers    []ServerDiff `json:"servers"`
+	Unaffected []string     `json:"unaffected,omitempty"` // running servers not in the file, which are kept as they are
+	Templates  []string     `json:"templates,omitempty"`  // register map templates added or replaced
+	Exports    []string     `json:"exports,omitempty"`    // export jobs added or replaced
+	Mirrors    []string     `json:"mirrors,omitempty"`    // targets of mirror rules added or replaced
+	Hooks      []string     `json:"hooks,omitempty"`      // hooks added or replaced
+}
+
+// ServerDiff is the change to one server of an uploaded configuration
+type ServerDiff struct {
+	ID        string          `json:"id"`
+	Action    string          `json:"action"`           // "added", "replaced", "merged" or "skipped"
+	Fields    []FieldChange   `json:"fields,omitempty"` // settings other than the register blocks
+	Registers RegisterChanges `json:"registers"`
+}
+
+// FieldChange is a server setting whose value would change
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"` // unset if omitted
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+// RegisterChanges lists the addresses of configured registers that would be
+// added, removed or changed
+type RegisterChanges struct {
+	Added   []uint16 `json:"added,omitempty"`
+	Removed []uint16 `json:"removed,omitempty"`
+	Changed []uint16 `json:"changed,omitempty"`
+}
+
+// runtimeServerFields are JSON fields of a server that report its state
+// rather than its configuration, and are left out of a diff
+var runtimeServerFields = map[string]bool{
+	"registerBlocks":   true, // compared register by register
+	"variant":          true,
+	"connectionStatus": true,
+	"connectionError":  true,
+	"lastDataReceived": true,
+}
+
+// diffConfig compares an uploaded configuration with the running setup, for
+// the given strategy for servers that already exist
+func diffConfig(config ConfigFile, strategy string) ConfigDiff {
+	diff := ConfigDiff{Servers: []ServerDiff{}}
+
+	mu.RLock()
+	inFile := make(map[string]bool)
+	for _, server := range config.Servers {
+		inFile[server.ID] = true
+		existing, exists := servers[server.ID]
+		if !exists {
+			diff.Servers = append(diff.Servers, ServerDiff{
+				ID:        server.ID,
+				Action:    "added",
+				Registers: diffRegisters(nil, server.RegisterBlocks, false),
+			})
+			continue
+		}
+
+		change := ServerDiff{ID: server.ID}
+		existing.mu.Lock()
+		switch strategy {
+		case "skip":
+			change.Action = "skipped"
+		case "merge":
+			change.Action = "merged"
+			change.Registers = diffRegisters(existing.RegisterBlocks, server.RegisterBlocks, true)
+		default:
+			change.Action = "replaced"
+			change.Fields = diffServerFields(existing, server)
+			change.Registers = diffRegisters(existing.RegisterBlocks, server.RegisterBlocks, false)
+		}
+		existing.mu.Unlock()
+		diff.Servers = append(diff.Servers, change)
+	}
+	for id := range servers {
+		if !inFile[id] {
+			diff.Unaffected = append(diff.Unaffected, id)
+		}
+	}
+	mu.RUnlock()
+	sort.Strings(diff.Unaffected)
+
+	for name := range config.Templates {
+		diff.Templates = append(diff.Templates, name)
+	}
+	sort.Strings(diff.Templates)
+	exportJobsMu.Lock()
+	for _, job := range config.Exports {
+		if existing, ok := exportJobs[job.Name]; !ok || !reflect.DeepEqual(existing.job, job) {
+			diff.Exports = append(diff.Exports, job.Name)
+		}
+	}
+	exportJobsMu.Unlock()
+	for _, rule := range config.Mirrors {
+		diff.Mirrors = append(diff.Mirrors, rule.Target)
+	}
+	for _, hook := range config.Hooks {
+		diff.Hooks = append(diff.Hooks, hook.Name)
+	}
+	return diff
+}
+
+// diffServerFields compares the settings of a running server with those of
+// an uploaded one. The caller must hold existing.mu.
+func diffServerFields(existing, uploaded *ModbusServer) []FieldChange {
+	var from, to map[string]json.RawMessage
+	data, _ := json.Marshal(existing)
+	json.Unmarshal(data, &from)
+	data, _ = json.Marshal(uploaded)
+	json.Unmarshal(data, &to)
+
+	fields := make(map[string]bool)
+	for field := range from {
+		fields[field] = true
+	}
+	for field := range to {
+		fields[field] = true
+	}
+
+	var changes []FieldChange
+	for field := range fields {
+		if runtimeServerFields[field] || bytes.Equal(from[field], to[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, From: from[field], To: to[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// diffRegisters compares the configured registers of two sets of blocks.
+// When merging, registers missing from the uploaded blocks are kept, so none
+// are removed.
+func diffRegisters(existing, uploaded []RegisterBlock, merge bool) RegisterChanges {
+	before := registerConfigs(existing)
+	after := registerConfigs(uploaded)
+
+	var changes RegisterChanges
+	for addr, reg := range after {
+		old, exists := before[addr]
+		switch {
+		case !exists:
+			changes.Added = append(changes.Added, addr)
+		case !bytes.Equal(old, reg):
+			changes.Changed = append(changes.Changed, addr)
+		}
+	}
+	if !merge {
+		for addr := range before {
+			if _, exists := after[addr]; !exists {
+				changes.Removed = append(changes.Removed, addr)
+			}
+		}
+	}
+	for _, list := range [][]uint16{changes.Added, changes.Removed, changes.Changed} {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	}
+	return changes
+}
+
+// registerConfigs returns the JSON of each configured register of a set of
+// blocks by address
+func registerConfigs(blocks []RegisterBlock) map[uint16][]byte {
+	configs := make(map[uint16][]byte)
+	for _, block := range blocks {
+		for _, reg := range block.Registers {
+			configs[reg.Address], _ = json.Marshal(reg)
+		}
+	}
+	return configs
+}
+
+// confirmToken identifies an upload together with the diff it was shown
+// with. An upload is only applied when it carries the token of its diff, so
+// a changed file or running setup requires a new confirmation.
+func confirmToken(data []byte, strategy string, diff ConfigDiff) string {
+	hash := sha256.New()
+	hash.Write(data)
+	hash.Write([]byte{0})
+	hash.Write([]byte(strategy))
+	hash.Write([]byte{0})
+	json.NewEncoder(hash).Encode(diff)
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
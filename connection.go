@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Connection modes of a server
+const (
+	connectionPersistent   = "persistent"   // connected all the time (the default)
+	connectionOnDemand     = "on-demand"    // connected for each poll cycle and request
+	connectionDisconnected = "disconnected" // not connected, e.g. to leave the device to another client
+)
+
+// connectionModes lists the supported ModbusServer connection modes
+var connectionModes = map[string]bool{
+	connectionPersistent:   true,
+	connectionOnDemand:     true,
+	connectionDisconnected: true,
+}
+
+// onDemandLinger is how long an on-demand connection opened for a request
+// outside a poll cycle, e.g. from the console, is kept for the next one
+const onDemandLinger = 2 * time.Second
+
+// connectionMode returns the connection mode of the server. The caller must
+// hold s.mu.
+func (s *ModbusServer) connectionMode() string {
+	if s.Connection == "" {
+		return connectionPersistent
+	}
+	return s.Connection
+}
+
+// checkConnectionMode returns an error if a server connection mode is not supported
+func checkConnectionMode(mode string) error {
+	if mode != "" && !connectionModes[mode] {
+		return fmt.Errorf("unknown connection mode %q (must be persistent, on-demand or disconnected)", mode)
+	}
+	return nil
+}
+
+// onDemandDevice is the device of a server with on-demand connections. It
+// connects on the first request and disconnects at the end of the poll
+// cycle, or onDemandLinger after the last request outside of one, so
+// devices that accept few connections are not kept busy between polls. A
+// failed connection fails the remaining requests of the cycle at once
+// instead of timing out on each.
+type onDemandDevice struct {
+	mu      sync.Mutex
+	dial    func(timeout time.Duration) (Device, error)
+	timeout time.Duration
+	device  Device // nil while disconnected
+	err     error  // failure to connect, until release
+	timer   *time.Timer
+}
+
+// dialOnDemand connects to a device once, to find out whether it answers,
+// and returns an on-demand device for it
+func dialOnDemand(dial func(timeout time.Duration) (Device, error), timeout time.Duration) (Device, error) {
+	device, err := dial(timeout)
+	if err != nil {
+		return nil, err
+	}
+	device.Close()
+	return &onDemandDevice{dial: dial, timeout: timeout}, nil
+}
+
+// do runs a request on the device, connecting first if needed
+func (d *onDemandDevice) do(request func(device Device) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.linger()
+	if d.err != nil {
+		return d.err
+	}
+	if d.device == nil {
+		device, err := d.dial(d.timeout)
+		if err != nil {
+			d.err = fmt.Errorf("connecting: %w", err)
+			return d.err
+		}
+		d.device = device
+	}
+	err := request(d.device)
+	if err != nil && d.device.IsConnectionError(err) {
+		d.device.Close()
+		d.device = nil
+	}
+	return err
+}
+
+// linger schedules the release of the connection. The caller must hold d.mu.
+func (d *onDemandDevice) linger() {
+	if d.timer == nil {
+		d.timer = time.AfterFunc(onDemandLinger, d.release)
+		return
+	}
+	d.timer.Reset(onDemandLinger)
+}
+
+// release disconnects from the device and forgets a failure to connect, so
+// the next request connects again
+func (d *onDemandDevice) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.device != nil {
+		d.device.Close()
+		d.device = nil
+	}
+	d.err = nil
+}
+
+// releaseConnection ends the on-demand connection of a server after a poll
+// cycle. The caller must hold s.mu.
+func (s *ModbusServer) releaseConnection() {
+	if d, ok := s.client.(*onDemandDevice); ok {
+		d.release()
+	}
+}
+
+func (d *onDemandDevice) ReadCoils(address uint16, quantity uint16) (values []bool, err error) {
+	err = d.do(func(device Device) error {
+		values, err = device.ReadCoils(address, quantity)
+		return err
+	})
+	return values, err
+}
+
+func (d *onDemandDevice) ReadDiscreteInputs(address uint16, quantity uint16) (values []bool, err error) {
+	err = d.do(func(device Device) error {
+		values, err = device.ReadDiscreteInputs(address, quantity)
+		return err
+	})
+	return values, err
+}
+
+func (d *onDemandDevice) ReadInputRegisters(address uint16, quantity uint16) (values []uint16, err error) {
+	err = d.do(func(device Device) error {
+		values, err = device.ReadInputRegisters(address, quantity)
+		return err
+	})
+	return values, err
+}
+
+func (d *onDemandDevice) ReadHoldingRegisters(address uint16, quantity uint16) (values []uint16, err error) {
+	err = d.do(func(device Device) error {
+		values, err = device.ReadHoldingRegisters(address, quantity)
+		return err
+	})
+	return values, err
+}
+
+func (d *onDemandDevice) WriteSingleCoil(address uint16, value bool) error {
+	return d.do(func(device Device) error { return device.WriteSingleCoil(address, value) })
+}
+
+func (d *onDemandDevice) WriteMultipleCoils(address uint16, values []bool) error {
+	return d.do(func(device Device) error { return device.WriteMultipleCoils(address, values) })
+}
+
+func (d *onDemandDevice) WriteSingleRegister(address uint16, value uint16) error {
+	return d.do(func(device Device) error { return device.WriteSingleRegister(address, value) })
+}
+
+func (d *onDemandDevice) WriteMultipleRegisters(address uint16, values []uint16) error {
+	return d.do(func(device Device) error { return device.WriteMultipleRegisters(address, values) })
+}
+
+func (d *onDemandDevice) readDeviceIdentification() (id *DeviceIdentification, err error) {
+	err = d.do(func(device Device) error {
+		id, err = readDeviceIdentification(device)
+		return err
+	})
+	return id, err
+}
+
+// IsConnectionError reports false: a lost connection is opened again by the
+// next request, so polling carries on instead of reconnecting
+func (d *onDemandDevice) IsConnectionError(err error) bool {
+	return false
+}
+
+func (d *onDemandDevice) setTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeout = timeout
+	if d.device != nil {
+		setDeviceTimeout(d.device, timeout)
+	}
+}
+
+func (d *onDemandDevice) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if d.device != nil {
+		d.device.Close()
+		d.device = nil
+	}
+}
+
+// setConnectionMode changes how the server is connected, closing its
+// current connection. It returns whether the caller must connect the server
+// again. The caller must hold s.mu.
+func (s *ModbusServer) setConnectionMode(mode string) bool {
+	if mode == connectionPersistent {
+		mode = ""
+	}
+	if mode == s.Connection {
+		return false
+	}
+	s.Connection = mode
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+	if mode == connectionDisconnected {
+		s.setConnectionStatus(connectionDisconnected, "")
+		return false
+	}
+	return true
+}
+
+// connectServer connects a server whose connection was closed, retrying in
+// the background if the device does not answer, and makes sure it is polled
+func connectServer(s *ModbusServer) {
+	defer func() { go pollServer(s) }()
+	client, err := connectDevice(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.setConnectionStatus("error", err.Error())
+		go retryConnect(s)
+		return
+	}
+	if s.client != nil {
+		s.client.Close()
+	}
+	s.client = client
+	s.setConnectionStatus("ok", "")
+	s.selectFirmwareVariant()
+}
+
+// handleConnection returns how a server is connected on GET, and changes it
+// on PUT or POST /api/servers/{id}/connection with {"mode": "on-demand"}:
+// persistent keeps a connection open, on-demand connects for each poll cycle
+// and for requests from the console and the API, and disconnected closes the
+// connection and leaves it closed. The mode is independent of polling, so a
+// server can be kept connected for ad-hoc reads without being polled.
+func handleConnection(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if request.Mode == "" {
+			handleError(w, r, "mode is required (persistent, on-demand or disconnected)")
+			return
+		}
+		if err := checkConnectionMode(request.Mode); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		server.mu.Lock()
+		connect := server.setConnectionMode(request.Mode)
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Set connection mode of server %s to %s", id, request.Mode)
+		if connect {
+			connectServer(server)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"mode":    server.connectionMode(),
+		"status":  server.ConnectionStatus,
+		"error":   server.ConnectionError,
+		"polling": !server.Paused,
+	})
+}
+
+// handlePolling returns whether a server is polled on GET, and starts or
+// stops polling on PUT or POST /api/servers/{id}/polling with
+// {"polling": false}. Stopping polling keeps the connection, so the console
+// and the API can still read and write.
+func handlePolling(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Polling *bool `json:"polling"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if request.Polling == nil {
+			handleError(w, r, "polling is required (true or false)")
+			return
+		}
+
+		server.mu.Lock()
+		server.Paused = !*request.Polling
+		server.mu.Unlock()
+		if *request.Polling {
+			logMessage(InfoLevel, "Polling of server %s started", id)
+		} else {
+			logMessage(InfoLevel, "Polling of server %s stopped", id)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"polling": !server.Paused,
+		"mode":    server.connectionMode(),
+		"status":  server.ConnectionStatus,
+	})
+}
+
+// stayDisconnected reports whether the server is to be left disconnected,
+// for reconnect loops to stop
+func (s *ModbusServer) stayDisconnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connectionMode() == connectionDisconnected
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// connectionsTemplate renders the table of open device connections
+const connectionsTemplate = `
+{{define "connections"}}{{if .}}<table class="table table-sm small mb-0">
+<thead><tr><th>#</th><th>Server</th><th>Protocol</th><th>Remote</th><th>Age</th><th>Last Activity</th><th>Pending</th><th>Requests</th><th>Errors</th><th>Last Error</th></tr></thead>
+<tbody>{{range .}}<tr{{if not .Attached}} class="table-warning" title="No longer used by its server and not closed"{{end}}>
+<td>{{.ID}}</td><td>{{.ServerID}}</td><td>{{.Protocol}}</td><td>{{.Remote}}</td><td>{{.Age}}</td>
+<td>{{if .LastActivity.IsZero}}never{{else}}{{.LastActivity.Format "15:04:05.000"}}{{end}}</td>
+<td>{{.Pending}}</td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{.LastError}}</td></tr>{{end}}</tbody>
+</table>{{else}}<small class="text-muted">No open connections</small>{{end}}{{end}}`
+
+// Connection describes an open device connection
+type Connection struct {
+	ID           int       `json:"id"`
+	ServerID     string    `json:"serverId"`
+	Protocol     string    `json:"protocol"`
+	Remote       string    `json:"remote"`
+	Opened       time.Time `json:"opened"`
+	Age          string    `json:"age"`
+	LastActivity time.Time `json:"lastActivity"`
+	Pending      int       `json:"pending"` // requests in progress
+	Requests     uint64    `json:"requests"`
+	Errors       uint64    `json:"errors"`
+	LastError    string    `json:"lastError,omitempty"`
+	Attached     bool      `json:"attached"` // still the server's current connection; false means leaked
+}
+
+// trackedDevice wraps a Device to record its activity in the connection table
+type trackedDevice struct {
+	Device
+	server *ModbusServer
+	mu     sync.Mutex
+	info   Connection
+}
+
+var (
+	connectionsMu    sync.Mutex
+	connections      = make(map[int]*trackedDevice)
+	nextConnectionID = 1
+)
+
+// trackDevice adds a newly opened device to the connection table
+func trackDevice(s *ModbusServer, protocol, remote string, device Device) Device {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	d := &trackedDevice{
+		Device: device,
+		server: s,
+		info: Connection{
+			ID:       nextConnectionID,
+			ServerID: s.ID,
+			Protocol: protocol,
+			Remote:   remote,
+			Opened:   time.Now(),
+		},
+	}
+	nextConnectionID++
+	connections[d.info.ID] = d
+	return d
+}
+
+// begin records the start of a request
+func (d *trackedDevice) begin() {
+	d.mu.Lock()
+	d.info.Pending++
+	d.info.LastActivity = time.Now()
+	d.mu.Unlock()
+}
+
+// end records the outcome of a request
+func (d *trackedDevice) end(err error) {
+	d.mu.Lock()
+	d.info.Pending--
+	d.info.Requests++
+	d.info.LastActivity = time.Now()
+	if err != nil {
+		d.info.Errors++
+		d.info.LastError = err.Error()
+	}
+	d.mu.Unlock()
+}
+
+func (d *trackedDevice) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	d.begin()
+	values, err := d.Device.ReadCoils(address, quantity)
+	d.end(err)
+	return values, err
+}
+
+func (d *trackedDevice) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	d.begin()
+	values, err := d.Device.ReadDiscreteInputs(address, quantity)
+	d.end(err)
+	return values, err
+}
+
+func (d *trackedDevice) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	d.begin()
+	values, err := d.Device.ReadInputRegisters(address, quantity)
+	d.end(err)
+	return values, err
+}
+
+func (d *trackedDevice) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	d.begin()
+	values, err := d.Device.ReadHoldingRegisters(address, quantity)
+	d.end(err)
+	return values, err
+}
+
+func (d *trackedDevice) WriteSingleCoil(address uint16, value bool) error {
+	d.begin()
+	err := d.Device.WriteSingleCoil(address, value)
+	d.end(err)
+	return err
+}
+
+func (d *trackedDevice) WriteMultipleCoils(address uint16, values []bool) error {
+	d.begin()
+	err := d.Device.WriteMultipleCoils(address, values)
+	d.end(err)
+	return err
+}
+
+func (d *trackedDevice) WriteSingleRegister(address uint16, value uint16) error {
+	d.begin()
+	err := d.Device.WriteSingleRegister(address, value)
+	d.end(err)
+	return err
+}
+
+func (d *trackedDevice) WriteMultipleRegisters(address uint16, values []uint16) error {
+	d.begin()
+	err := d.Device.WriteMultipleRegisters(address, values)
+	d.end(err)
+	return err
+}
+
+// Close closes the device and removes it from the connection table
+func (d *trackedDevice) Close() {
+	connectionsMu.Lock()
+	delete(connections, d.info.ID)
+	connectionsMu.Unlock()
+	d.Device.Close()
+}
+
+// openConnections returns the connection table, oldest first
+func openConnections() []Connection {
+	connectionsMu.Lock()
+	devices := make([]*trackedDevice, 0, len(connections))
+	for _, d := range connections {
+		devices = append(devices, d)
+	}
+	connectionsMu.Unlock()
+
+	list := make([]Connection, 0, len(devices))
+	for _, d := range devices {
+		d.mu.Lock()
+		info := d.info
+		d.mu.Unlock()
+
+		// A request may hold the server's lock for a long time, so only check
+		// whether the connection is still in use if the lock is free
+		info.Attached = isActive(d.server)
+		if info.Attached && d.server.mu.TryLock() {
+			info.Attached = d.server.client == Device(d)
+			d.server.mu.Unlock()
+		}
+		info.Age = time.Since(info.Opened).Round(time.Second).String()
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// handleConnections lists the open device connections on GET /api/connections
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := openConnections()
+
+	if isHtmxRequest(r) {
+		w.Header().Set("Content-Type", "text/html")
+		if err := templatesFor(r).ExecuteTemplate(w, "connections", list); err != nil {
+			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"connections": list,
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// slowCycleRatio is the share of the poll interval above which a poll cycle
+// is reported as slow, before it actually overruns
+const slowCycleRatio = 0.8
+
+// cycleStats holds the durations of the poll cycles of a server
+type cycleStats struct {
+	count    int
+	total    time.Duration
+	max      time.Duration
+	interval time.Duration // the last cycle was measured against
+	slow     bool          // the last cycle took more than slowCycleRatio of the interval
+}
+
+// add records the duration of a poll cycle and reports whether the server
+// has just become slow
+func (c *cycleStats) add(elapsed, interval time.Duration) bool {
+	c.count++
+	c.total += elapsed
+	c.max = max(c.max, elapsed)
+	c.interval = interval
+	wasSlow := c.slow
+	c.slow = float64(elapsed) > slowCycleRatio*float64(interval)
+	return c.slow && !wasSlow
+}
+
+// load returns the last cycle as a share of the poll interval. The caller
+// must hold s.mu.
+func (s *ModbusServer) load() float64 {
+	if s.cycles.interval <= 0 {
+		return 0
+	}
+	return float64(s.lastCycle) / float64(s.cycles.interval)
+}
+
+// SlowCycle reports whether the last poll cycle took most of the poll
+// interval, so the server cannot be polled much faster than it is. The caller
+// must hold s.mu.
+func (s *ModbusServer) SlowCycle() bool {
+	return s.cycles.slow
+}
+
+// recordBlockTime records how long a read of block took, including waiting
+// for a shared gateway. The caller must hold s.mu.
+func (s *ModbusServer) recordBlockTime(block RegisterBlock, elapsed time.Duration) {
+	status, ok := s.blockStatus[block.StartAddress]
+	if !ok {
+		return
+	}
+	status.lastRead = elapsed
+	status.maxRead = max(status.maxRead, elapsed)
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// BlockTiming is the read time of one register block
+type BlockTiming struct {
+	StartAddress uint16  `json:"startAddress"`
+	Length       uint16  `json:"length"`
+	LastMs       float64 `json:"lastMs"`
+	MaxMs        float64 `json:"maxMs"`
+}
+
+// CycleStats is the poll cycle timing of one server
+type CycleStats struct {
+	Server     string        `json:"server"`
+	IntervalMs int64         `json:"intervalMs"` // including backoff
+	LastMs     float64       `json:"lastMs"`
+	MeanMs     float64       `json:"meanMs"`
+	MaxMs      float64       `json:"maxMs"`
+	Load       float64       `json:"load"` // last cycle as a share of the interval
+	Slow       bool          `json:"slow"`
+	Cycles     int           `json:"cycles"`
+	Overruns   int           `json:"overruns"`
+	Blocks     []BlockTiming `json:"blocks"` // slowest first
+}
+
+// cycleStats returns the poll cycle timing of a server. The caller must hold s.mu.
+func (s *ModbusServer) cycleStats() CycleStats {
+	stats := CycleStats{
+		Server:     s.ID,
+		IntervalMs: s.cycles.interval.Milliseconds(),
+		LastMs:     milliseconds(s.lastCycle),
+		MaxMs:      milliseconds(s.cycles.max),
+		Load:       s.load(),
+		Slow:       s.cycles.slow,
+		Cycles:     s.cycles.count,
+		Overruns:   s.overruns,
+		Blocks:     []BlockTiming{},
+	}
+	if s.cycles.count > 0 {
+		stats.MeanMs = milliseconds(s.cycles.total / time.Duration(s.cycles.count))
+	}
+	for _, block := range s.RegisterBlocks {
+		timing := BlockTiming{StartAddress: block.StartAddress, Length: block.Length}
+		if status, ok := s.blockStatus[block.StartAddress]; ok {
+			timing.LastMs = milliseconds(status.lastRead)
+			timing.MaxMs = milliseconds(status.maxRead)
+		}
+		stats.Blocks = append(stats.Blocks, timing)
+	}
+	sort.SliceStable(stats.Blocks, func(i, j int) bool {
+		return stats.Blocks[i].LastMs > stats.Blocks[j].LastMs
+	})
+	return stats
+}
+
+// handleStats serves the poll cycle timing of all servers on GET
+// /api/stats?limit=10, the servers whose last cycle used the largest share
+// of their poll interval first
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			handleError(w, r, fmt.Sprintf("Invalid limit: %q", s))
+			return
+		}
+		limit = n
+	}
+
+	mu.RLock()
+	list := make([]CycleStats, 0, len(servers))
+	for _, server := range servers {
+		server.mu.Lock()
+		list = append(list, server.cycleStats())
+		server.mu.Unlock()
+	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Load != list[j].Load {
+			return list[i].Load > list[j].Load
+		}
+		return list[i].Server < list[j].Server
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"servers": list,
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDetectSamples = 5
+	maxDetectSamples     = 20
+	// maxDetectDuration bounds how long sampling a register pair may take
+	maxDetectDuration = 30 * time.Second
+)
+
+// FormatSuggestion is one interpretation of a register pair, ranked by how
+// plausible the sampled values are under it
+type FormatSuggestion struct {
+	Interpretation string   `json:"interpretation"`   // e.g. "float32 CDAB"
+	Format         string   `json:"format,omitempty"` // the register format that decodes it, if supported
+	ByteOrder      string   `json:"byteOrder,omitempty"`
+	Values         []string `json:"values"` // the samples decoded
+	Score          float64  `json:"score"`  // plausibility from 0 to 1
+	Reasons        []string `json:"reasons,omitempty"`
+}
+
+// pairInterpretation decodes the two words of a register pair into one or,
+// for pairs of 16-bit values, two numbers
+type pairInterpretation struct {
+	name      string
+	format    string
+	byteOrder string
+	float     bool
+	decode    func(a, b uint16) []float64
+}
+
+// swapBytes swaps the bytes of a word
+func swapBytes(w uint16) uint16 {
+	return w<<8 | w>>8
+}
+
+// pairInterpretations lists the interpretations tried by format detection.
+// Byte orders are named by the position of the bytes of the value, most
+// significant first, in the two words as read: ABCD is big-endian, CDAB has
+// the words swapped, BADC the bytes within each word and DCBA both.
+var pairInterpretations = []pairInterpretation{
+	{"float32 ABCD", "float", "", true, func(a, b uint16) []float64 {
+		return []float64{float64(math.Float32frombits(uint32(a)<<16 | uint32(b)))}
+	}},
+	{"float32 CDAB", "float", "CDAB", true, func(a, b uint16) []float64 {
+		return []float64{float64(math.Float32frombits(uint32(b)<<16 | uint32(a)))}
+	}},
+	{"float32 BADC", "float", "BADC", true, func(a, b uint16) []float64 {
+		return []float64{float64(math.Float32frombits(uint32(swapBytes(a))<<16 | uint32(swapBytes(b))))}
+	}},
+	{"float32 DCBA", "float", "DCBA", true, func(a, b uint16) []float64 {
+		return []float64{float64(math.Float32frombits(uint32(swapBytes(b))<<16 | uint32(swapBytes(a))))}
+	}},
+	{"int32 ABCD", "int32", "", false, func(a, b uint16) []float64 {
+		return []float64{float64(int32(uint32(a)<<16 | uint32(b)))}
+	}},
+	{"int32 CDAB", "int32", "CDAB", false, func(a, b uint16) []float64 {
+		return []float64{float64(int32(uint32(b)<<16 | uint32(a)))}
+	}},
+	{"uint32 ABCD", "uint32", "", false, func(a, b uint16) []float64 {
+		return []float64{float64(uint32(a)<<16 | uint32(b))}
+	}},
+	{"uint32 CDAB", "uint32", "CDAB", false, func(a, b uint16) []float64 {
+		return []float64{float64(uint32(b)<<16 | uint32(a))}
+	}},
+	{"two int16", "", "", false, func(a, b uint16) []float64 {
+		return []float64{float64(int16(a)), float64(int16(b))}
+	}},
+	{"two uint16", "decimal", "", false, func(a, b uint16) []float64 {
+		return []float64{float64(a), float64(b)}
+	}},
+}
+
+// suggestFormats ranks the interpretations of a register pair by the
+// plausibility of the sampled values, most plausible first. Process values
+// tend to be of moderate size, to have few significant digits and to change
+// little between polls; a wrong byte order turns small changes into jumps.
+func suggestFormats(samples [][2]uint16) []FormatSuggestion {
+	allZero := true
+	for _, sample := range samples {
+		if sample[0] != 0 || sample[1] != 0 {
+			allZero = false
+		}
+	}
+
+	suggestions := make([]FormatSuggestion, 0, len(pairInterpretations))
+	for _, interp := range pairInterpretations {
+		s := FormatSuggestion{Interpretation: interp.name, Format: interp.format, ByteOrder: interp.byteOrder, Score: 1}
+		penalize := func(factor float64, reason string) {
+			s.Score *= factor
+			s.Reasons = append(s.Reasons, reason)
+		}
+
+		decoded := make([][]float64, len(samples))
+		for i, sample := range samples {
+			decoded[i] = interp.decode(sample[0], sample[1])
+			parts := make([]string, len(decoded[i]))
+			for j, v := range decoded[i] {
+				if interp.float {
+					parts[j] = strconv.FormatFloat(v, 'g', -1, 32)
+				} else {
+					parts[j] = strconv.FormatFloat(v, 'f', -1, 64)
+				}
+			}
+			s.Values = append(s.Values, strings.Join(parts, ", "))
+		}
+
+		if allZero {
+			s.Score = 0.5
+			s.Reasons = []string{"every sample is 0, so any format fits"}
+			suggestions = append(suggestions, s)
+			continue
+		}
+
+		var invalid, tiny, huge, precise bool
+		for _, values := range decoded {
+			for _, v := range values {
+				switch {
+				case math.IsNaN(v) || math.IsInf(v, 0):
+					invalid = true
+				case v != 0 && math.Abs(v) < 1e-6:
+					tiny = true
+				case math.Abs(v) > 1e7:
+					huge = true
+				}
+				if interp.float && len(strings.TrimLeft(strings.Split(strconv.FormatFloat(v, 'e', -1, 32), "e")[0], "-")) > 8 {
+					precise = true
+				}
+			}
+		}
+		if invalid {
+			s.Score = 0
+			s.Reasons = append(s.Reasons, "some samples are not a number")
+			suggestions = append(suggestions, s)
+			continue
+		}
+		if tiny {
+			penalize(0.1, "implausibly small values")
+		}
+		if huge {
+			penalize(0.3, "implausibly large values")
+		}
+		if precise {
+			penalize(0.7, "values use every significant digit")
+		}
+
+		if !interp.float && len(decoded[0]) == 1 {
+			lowWordZero := true
+			for _, values := range decoded {
+				if v := int64(values[0]); v == 0 || v%65536 != 0 {
+					lowWordZero = false
+				}
+			}
+			if lowWordZero {
+				penalize(0.5, "the low 16 bits are always 0")
+			}
+		}
+		if len(decoded[0]) == 2 {
+			firstZero := true
+			for _, values := range decoded {
+				if values[0] != 0 {
+					firstZero = false
+				}
+			}
+			if firstZero {
+				penalize(0.8, "the first word is always 0, as in the high word of one 32-bit value")
+			}
+		}
+
+		// Average relative change between consecutive samples
+		var jumps float64
+		for i := 1; i < len(decoded); i++ {
+			var jump float64
+			for j, v := range decoded[i] {
+				prev := decoded[i-1][j]
+				jump = math.Max(jump, math.Abs(v-prev)/math.Max(math.Max(math.Abs(v), math.Abs(prev)), 1))
+			}
+			jumps += jump
+		}
+		if len(decoded) > 1 && jumps/float64(len(decoded)-1) > 0.5 {
+			penalize(0.3, "values jump between samples")
+		}
+
+		suggestions = append(suggestions, s)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].Score > suggestions[j].Score })
+	return suggestions
+}
+
+// handleDetect samples a register pair over several polls on GET
+// /api/servers/{id}/detect?address=40010&samples=5 and returns the likely
+// formats of the pair, ranked by plausibility
+func handleDetect(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	query := r.URL.Query()
+	address, err := strconv.ParseUint(query.Get("address"), 10, 16)
+	addr := uint16(address)
+	if err != nil || addr < 30000 || addr >= 50000 || addr == 39999 || addr == 49999 {
+		handleError(w, r, fmt.Sprintf("Invalid address: %q (must be the first of two input or holding registers)", query.Get("address")))
+		return
+	}
+
+	count := defaultDetectSamples
+	if s := query.Get("samples"); s != "" {
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 1 || count > maxDetectSamples {
+			handleError(w, r, fmt.Sprintf("Invalid number of samples: %q (must be 1 to %d)", s, maxDetectSamples))
+			return
+		}
+	}
+
+	// Take one sample per poll, but keep the whole request short
+	server.mu.Lock()
+	interval := server.pollInterval()
+	server.mu.Unlock()
+	interval = min(interval, maxDetectDuration/time.Duration(count))
+
+	samples := make([][2]uint16, 0, count)
+	var source string
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+		server.mu.Lock()
+		words, from, err := server.previewWords(addr, 2)
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error reading address %d: %v", addr, err))
+			return
+		}
+		source = from
+		samples = append(samples, [2]uint16{words[0], words[1]})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"address":     addr,
+		"source":      source,
+		"samples":     samples,
+		"suggestions": suggestFormats(samples),
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultProtocol is used for servers that do not name a protocol
+const defaultProtocol = "modbus-tcp"
+
+// Device is a connection to a field device. Every protocol maps its points
+// onto the shared register model (coils, discrete inputs, input registers and
+// holding registers, addressed from 0 within each table) so that polling, the
+// UI, reports and outputs work the same for all of them.
+type Device interface {
+	ReadCoils(address uint16, quantity uint16) ([]bool, error)
+	ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error)
+	ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error)
+	ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error)
+	WriteSingleCoil(address uint16, value bool) error
+	WriteMultipleCoils(address uint16, values []bool) error
+	WriteSingleRegister(address uint16, value uint16) error
+	WriteMultipleRegisters(address uint16, values []uint16) error
+
+	// IsConnectionError reports whether err means the connection failed and
+	// must be re-established, as opposed to an error affecting one request
+	IsConnectionError(err error) bool
+	Close()
+}
+
+// DialOptions are the connection settings of a server that protocols may use
+// when connecting
+type DialOptions struct {
+	Source  string        // local IP address or interface name to connect from, "" for any
+	Timeout time.Duration // for connecting and for each response
+}
+
+// ProtocolDialer connects to a device at the given address and port
+type ProtocolDialer func(address string, port int, options DialOptions) (Device, error)
+
+// protocols holds the dialers of all supported protocols by name
+var protocols = make(map[string]ProtocolDialer)
+
+// registerProtocol makes a protocol available to servers. Protocol
+// implementations call it from an init function in their own file.
+func registerProtocol(name string, dial ProtocolDialer) {
+	if _, exists := protocols[name]; exists {
+		panic(fmt.Sprintf("protocol %s registered twice", name))
+	}
+	protocols[name] = dial
+}
+
+// protocolNames returns the names of the supported protocols, sorted
+func protocolNames() []string {
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkProtocol returns an error if a server protocol is not supported
+func checkProtocol(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := protocols[name]; !ok {
+		return fmt.Errorf("unknown protocol %q (supported: %s)", name, strings.Join(protocolNames(), ", "))
+	}
+	return nil
+}
+
+// hostAddress returns a server address without the brackets an IPv6 address
+// may be written in, e.g. [fe80::1%eth0]
+func hostAddress(address string) string {
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address[1 : len(address)-1]
+	}
+	return address
+}
+
+// checkHost returns an error if a server address is not a hostname or an IPv4
+// or IPv6 address. IPv6 addresses may have a zone, as link-local addresses
+// need one: fe80::1%eth0.
+func checkHost(address string) error {
+	host := hostAddress(address)
+	if !strings.Contains(host, ":") {
+		if host != address {
+			return fmt.Errorf("address %q: only IPv6 addresses are written in brackets", address)
+		}
+		return nil
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		return fmt.Errorf("address %q is not a valid IPv6 address (the port is set separately)", address)
+	}
+	return nil
+}
+
+// connectDevice connects to a server using its protocol. Servers with
+// on-demand connections get a device that connects for each poll cycle and
+// request instead, once a first connection has shown the device answers.
+func connectDevice(s *ModbusServer) (Device, error) {
+	s.mu.Lock()
+	dial, err := s.dialer()
+	onDemand := s.connectionMode() == connectionOnDemand
+	timeout := s.timeout()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if onDemand {
+		return dialOnDemand(dial, timeout)
+	}
+	return dial(timeout)
+}
+
+// dialer returns a function connecting to the device of a server, through
+// the path and with the settings it has now, and the given timeout. The
+// caller must hold s.mu.
+func (s *ModbusServer) dialer() (func(timeout time.Duration) (Device, error), error) {
+	name := s.Protocol
+	if name == "" {
+		name = defaultProtocol
+	}
+	dial, ok := protocols[name]
+	if !ok {
+		return nil, checkProtocol(name)
+	}
+	address, port := s.endpoint()
+	gateway := s.Gateway
+	source := s.SourceAddress
+	return func(timeout time.Duration) (Device, error) {
+		device, err := dial(address, port, DialOptions{Source: source, Timeout: timeout})
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := device.(serverDevice); ok {
+			d.attach(s)
+		}
+		if _, ok := device.(unitIDDevice); ok {
+			device = &unitDevice{Device: device, server: s}
+		}
+		if gateway != "" {
+			device = &gatewayDevice{Device: device, gateway: gatewayFor(gateway), server: s}
+		}
+		return trackDevice(s, name, net.JoinHostPort(address, strconv.Itoa(port)), device), nil
+	}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// enumFormats lists the formats whose values can be mapped to states; they
+// are whole numbers, booleans counting as 0 and 1
+var enumFormats = map[string]bool{
+	"":        true,
+	"decimal": true,
+	"int16":   true,
+	"uint32":  true,
+	"int32":   true,
+	"uint64":  true,
+	"int64":   true,
+	"boolean": true,
+}
+
+// checkEnum returns an error if a register's enum map cannot apply to it
+func checkEnum(reg RegisterConfig) error {
+	if len(reg.Enum) == 0 {
+		return nil
+	}
+	if registerFormats[reg.Format] && !enumFormats[reg.Format] {
+		return fmt.Errorf("enum is not supported for format %q (must be a whole-number or boolean format)", reg.Format)
+	}
+	for key, label := range reg.Enum {
+		if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+			if _, err := strconv.ParseUint(key, 10, 64); err != nil {
+				return fmt.Errorf("invalid value %q (must be a whole number such as 0 or 2)", key)
+			}
+		}
+		if label == "" {
+			return fmt.Errorf("value %s has an empty state", key)
+		}
+	}
+	return nil
+}
+
+// enumState returns the state an enum map gives a value, keyed by the value
+// in decimal. Booleans are looked up as 0 and 1.
+func enumState(enum map[string]string, value interface{}) (string, bool) {
+	var key string
+	switch v := value.(type) {
+	case uint64:
+		key = strconv.FormatUint(v, 10)
+	case int64:
+		key = strconv.FormatInt(v, 10)
+	default:
+		number, ok := toFloat(value)
+		if !ok || number != math.Trunc(number) {
+			return "", false
+		}
+		key = strconv.FormatFloat(number, 'f', 0, 64)
+	}
+	state, ok := enum[key]
+	return state, ok
+}
+
+// setState sets the State of a register data row from the value it holds,
+// or nil if the enum map has no state for it. The Value stays the raw number.
+func setState(row map[string]interface{}, enum map[string]string) {
+	row["State"] = nil
+	if len(enum) == 0 || row["Quality"] == "missing" {
+		return
+	}
+	if state, ok := enumState(enum, row["Value"]); ok {
+		row["State"] = state
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventKeepAlive is how often a comment is sent to idle event streams to keep them open
+const eventKeepAlive = 15 * time.Second
+
+// Event is a notification pushed to connected browsers
+type Event struct {
+	Type     string    `json:"type"` // "connection-lost", "reconnected", "failover", "flatline", "flatline-cleared", "alarm", "alarm-cleared", "block-split", "standby-takeover" or "standby-resumed"
+	ServerID string    `json:"serverId"`
+	Address  *uint16   `json:"address,omitempty"` // register the event is about, if any
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// eventHub fans events out to the subscribed event streams
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// events is the hub all server events are published to
+var events = &eventHub{subscribers: make(map[chan Event]struct{})}
+
+// subscribe returns a channel that receives published events
+func (h *eventHub) subscribe() chan Event {
+	ch := make(chan Event, 16)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// unsubscribe stops delivery to a channel returned by subscribe
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+// publish sends an event to every subscriber. Subscribers that are not
+// keeping up miss the event rather than blocking the caller. Events of
+// shelved servers and registers are only logged.
+func (h *eventHub) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if shelf := shelved(e); shelf != nil {
+		logMessage(InfoLevel, "Notification suppressed, %s shelved until %s: %s", shelfName(shelf), shelf.Until.Format(time.RFC3339), e.Message)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// setConnectionStatus updates the connection status of a server and publishes
+// an event when the server drops out or comes back. The caller must hold s.mu.
+func (s *ModbusServer) setConnectionStatus(status, message string) {
+	previous := s.ConnectionStatus
+	if status != previous || len(s.transitions) == 0 {
+		s.recordHealth(status, message)
+	}
+	s.ConnectionStatus = status
+	s.ConnectionError = message
+
+	// Closing the connection on purpose, and opening it again, is not news
+	switch {
+	case status == connectionDisconnected || previous == connectionDisconnected:
+	case status == "ok" && previous != "ok":
+		if s.wasConnected {
+			e := Event{Type: "reconnected", ServerID: s.ID, Message: fmt.Sprintf("Server %s reconnected", s.ID), Time: time.Now()}
+			events.publish(e)
+			s.runConnectionHooks(e)
+		}
+		s.wasConnected = true
+	case status != "ok" && previous == "ok":
+		e := Event{Type: "connection-lost", ServerID: s.ID, Message: fmt.Sprintf("Server %s lost connection: %s", s.ID, message), Time: time.Now()}
+		events.publish(e)
+		s.runConnectionHooks(e)
+	}
+}
+
+// handleEvents streams events to the browser as server-sent events
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				logMessage(ErrorLevel, "Error encoding event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// handleRegisterExport serves the register map of a server as an XLSX spreadsheet.
+// Current values are included when the "values" query parameter is "true".
+func handleRegisterExport(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	includeValues := r.URL.Query().Get("values") == "true"
+
+	header := []string{"Block Start", "Address", "Name", "Format", "String Length"}
+	if includeValues {
+		header = append(header, "Value")
+	}
+
+	server.mu.Lock()
+	values := make(map[uint16]interface{})
+	if includeValues {
+		for _, row := range server.registerData() {
+			values[row["Address"].(uint16)] = row["Value"]
+		}
+	}
+
+	var rows [][]interface{}
+	for _, block := range server.RegisterBlocks {
+		registers := append([]RegisterConfig(nil), block.Registers...)
+		sort.Slice(registers, func(i, j int) bool {
+			return registers[i].Address < registers[j].Address
+		})
+
+		for _, reg := range registers {
+			row := []interface{}{block.StartAddress, reg.Address, reg.Name, reg.Format, nil}
+			if reg.StringLength > 0 {
+				row[4] = reg.StringLength
+			}
+			if includeValues {
+				row = append(row, values[reg.Address])
+			}
+			rows = append(rows, row)
+		}
+	}
+	server.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, id, header, rows); err != nil {
+		handleError(w, r, fmt.Sprintf("Error writing spreadsheet: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-registers.xlsx"`, unsafeFileChars.ReplaceAllString(id, "_")))
+	w.Write(buf.Bytes())
+}
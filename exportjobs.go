@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPendingExports is the number of files per job kept in memory while its
+// destination is unreachable; the oldest are dropped beyond that
+const maxPendingExports = 10
+
+// ExportJob periodically writes the values of selected registers to a file,
+// in a local directory (which may be a mounted SMB or NFS share) or an S3 bucket
+type ExportJob struct {
+	Name           string   `json:"name"`
+	Format         string   `json:"format,omitempty"`         // "csv" (default) or "parquet"
+	Registers      []string `json:"registers"`                // "server" for all its configured registers, or "server:address"
+	Destination    string   `json:"destination"`              // directory or s3://bucket/prefix
+	Interval       float64  `json:"interval,omitempty"`       // seconds covered by each file, default 86400; aligned to local midnight
+	SampleInterval float64  `json:"sampleInterval,omitempty"` // seconds between samples, default 60
+	RetentionDays  int      `json:"retentionDays,omitempty"`  // files older than this are deleted; 0 keeps them all
+}
+
+// exportRow is one sampled register value
+type exportRow struct {
+	Time    time.Time
+	Server  string
+	Address uint16
+	Name    string
+	Value   float64
+}
+
+// exportFile is a written file waiting to be stored at the destination
+type exportFile struct {
+	name string
+	data []byte
+}
+
+// exportRunner samples and writes the files of one job
+type exportRunner struct {
+	job       ExportJob
+	stop      chan struct{}
+	exporting sync.Mutex // held while a file is written and stored
+
+	mu        sync.Mutex
+	rows      []exportRow
+	pending   []exportFile
+	lastRun   time.Time
+	lastFile  string
+	lastError string
+	nextRun   time.Time
+}
+
+var (
+	exportJobsMu sync.Mutex
+	exportJobs   = make(map[string]*exportRunner) // by job name
+)
+
+// interval returns the period covered by each file
+func (j *ExportJob) interval() time.Duration {
+	if j.Interval <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(j.Interval * float64(time.Second))
+}
+
+// sampleInterval returns the time between samples
+func (j *ExportJob) sampleInterval() time.Duration {
+	if j.SampleInterval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(j.SampleInterval * float64(time.Second))
+}
+
+// extension returns the file name extension of the job's format
+func (j *ExportJob) extension() string {
+	if j.Format == "parquet" {
+		return ".parquet"
+	}
+	return ".csv"
+}
+
+// nextBoundary returns the first end of a file period after t. Periods are
+// counted from local midnight, so daily files cover whole days.
+func nextBoundary(t time.Time, interval time.Duration) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return midnight.Add((t.Sub(midnight)/interval + 1) * interval)
+}
+
+// checkUploadedExports returns an error if an upload adds or changes an
+// export job. Jobs write and delete files wherever their destination points,
+// and store to S3 with the credentials of the process, so they are only
+// taken from the -config file. A job that is unchanged from the one of the
+// same name in place is accepted, so a downloaded configuration can be
+// uploaded again.
+func checkUploadedExports(jobs []ExportJob) error {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	for _, job := range jobs {
+		if existing, ok := exportJobs[job.Name]; !ok || !reflect.DeepEqual(existing.job, job) {
+			return fmt.Errorf("export job %s: export jobs can only be set in the -config file, not uploaded", job.Name)
+		}
+	}
+	return nil
+}
+
+// startExportJobs starts the given jobs, replacing running jobs of the same
+// name. Jobs whose definition is unchanged keep running, so the samples
+// collected for their current file are not lost. New or changed jobs are
+// only started if local is set, for configuration read from local files.
+func startExportJobs(jobs []ExportJob, local bool) {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	for _, job := range jobs {
+		existing, ok := exportJobs[job.Name]
+		if ok && reflect.DeepEqual(existing.job, job) {
+			continue
+		}
+		if !local {
+			logMessage(ErrorLevel, "Export job %s: export jobs can only be set in the -config file, left out", job.Name)
+			continue
+		}
+		if ok {
+			close(existing.stop)
+		}
+		runner := &exportRunner{job: job, stop: make(chan struct{})}
+		exportJobs[job.Name] = runner
+		go runner.run()
+		logMessage(InfoLevel, "Started export job %s to %s", job.Name, job.Destination)
+	}
+}
+
+// run samples the job's registers and writes a file at the end of each period
+func (e *exportRunner) run() {
+	sampleTicker := time.NewTicker(e.job.sampleInterval())
+	defer sampleTicker.Stop()
+
+	next := nextBoundary(time.Now(), e.job.interval())
+	e.mu.Lock()
+	e.nextRun = next
+	e.mu.Unlock()
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-sampleTicker.C:
+			e.sample()
+		case <-timer.C:
+			start := next.Add(-e.job.interval())
+			next = nextBoundary(time.Now(), e.job.interval())
+			timer.Reset(time.Until(next))
+
+			e.mu.Lock()
+			rows := e.rows
+			e.rows = nil
+			e.nextRun = next
+			e.mu.Unlock()
+			go e.export(start, rows)
+		}
+	}
+}
+
+// sample records the current values of the job's registers
+func (e *exportRunner) sample() {
+	selected := make(map[string]map[uint16]bool) // server -> addresses, nil for all
+	for _, register := range e.job.Registers {
+		id, address, found := strings.Cut(register, ":")
+		if !found {
+			selected[id] = nil
+			continue
+		}
+		addr, err := strconv.ParseUint(address, 10, 16)
+		if err != nil {
+			continue
+		}
+		if addresses, ok := selected[id]; !ok || addresses != nil {
+			if addresses == nil {
+				addresses = make(map[uint16]bool)
+				selected[id] = addresses
+			}
+			addresses[uint16(addr)] = true
+		}
+	}
+
+	now := time.Now()
+	var rows []exportRow
+	for id, addresses := range selected {
+		mu.RLock()
+		server, exists := servers[id]
+		mu.RUnlock()
+		if !exists {
+			continue
+		}
+
+		server.mu.Lock()
+		if server.ConnectionStatus == "ok" && !server.Paused {
+			for _, row := range server.registerData() {
+				addr := row["Address"].(uint16)
+				if _, configured := server.registerMap[addr]; !configured || (addresses != nil && !addresses[addr]) {
+					continue
+				}
+				if value, numeric := toFloat(row["Value"]); numeric {
+					rows = append(rows, exportRow{now, id, addr, row["Name"].(string), value})
+				}
+			}
+		}
+		server.mu.Unlock()
+	}
+
+	e.mu.Lock()
+	e.rows = append(e.rows, rows...)
+	e.mu.Unlock()
+}
+
+// export writes the rows of the period starting at start, stores the file
+// and any left over from earlier failures, and removes expired files
+func (e *exportRunner) export(start time.Time, rows []exportRow) {
+	e.exporting.Lock()
+	defer e.exporting.Unlock()
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].Time.Equal(rows[j].Time) {
+			return rows[i].Time.Before(rows[j].Time)
+		}
+		if rows[i].Server != rows[j].Server {
+			return rows[i].Server < rows[j].Server
+		}
+		return rows[i].Address < rows[j].Address
+	})
+
+	var buf bytes.Buffer
+	var err error
+	if e.job.Format == "parquet" {
+		err = writeExportParquet(&buf, rows)
+	} else {
+		err = writeExportCSV(&buf, rows)
+	}
+	name := fmt.Sprintf("%s-%s%s", e.job.Name, start.Format("20060102-150405"), e.job.extension())
+	if err != nil {
+		e.fail(fmt.Errorf("error writing %s: %v", name, err))
+		return
+	}
+
+	e.mu.Lock()
+	e.pending = append(e.pending, exportFile{name, buf.Bytes()})
+	if len(e.pending) > maxPendingExports {
+		logMessage(ErrorLevel, "Export job %s: dropping unsent file %s", e.job.Name, e.pending[0].name)
+		e.pending = e.pending[1:]
+	}
+	pending := e.pending
+	e.mu.Unlock()
+
+	sent := 0
+	for _, file := range pending {
+		if err := e.store(file); err != nil {
+			e.fail(fmt.Errorf("error storing %s, retrying with the next file: %v", file.name, err))
+			break
+		}
+		logMessage(InfoLevel, "Export job %s: wrote %s (%d bytes)", e.job.Name, file.name, len(file.data))
+		sent++
+	}
+
+	e.mu.Lock()
+	e.pending = e.pending[sent:]
+	e.lastRun = time.Now()
+	if sent > 0 {
+		e.lastFile = pending[sent-1].name
+	}
+	if sent == len(pending) {
+		e.lastError = ""
+	}
+	e.mu.Unlock()
+
+	if err := e.prune(); err != nil {
+		logMessage(ErrorLevel, "Export job %s: error removing expired files: %v", e.job.Name, err)
+	}
+}
+
+// fail records and logs an error of the job
+func (e *exportRunner) fail(err error) {
+	logMessage(ErrorLevel, "Export job %s: %v", e.job.Name, err)
+	e.mu.Lock()
+	e.lastError = err.Error()
+	e.mu.Unlock()
+}
+
+// store writes a file to the job's destination
+func (e *exportRunner) store(file exportFile) error {
+	if bucket, prefix, ok := parseS3URL(e.job.Destination); ok {
+		client, err := newS3Client()
+		if err != nil {
+			return err
+		}
+		return client.put(bucket, prefix+file.name, file.data)
+	}
+
+	if err := os.MkdirAll(e.job.Destination, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary name first so readers of the share never see a partial file
+	path := filepath.Join(e.job.Destination, file.name)
+	if err := os.WriteFile(path+".tmp", file.data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// prune deletes the job's files older than its retention
+func (e *exportRunner) prune() error {
+	if e.job.RetentionDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -e.job.RetentionDays)
+	isJobFile := func(name string) bool {
+		return strings.HasPrefix(name, e.job.Name+"-") && strings.HasSuffix(name, e.job.extension())
+	}
+
+	if bucket, prefix, ok := parseS3URL(e.job.Destination); ok {
+		client, err := newS3Client()
+		if err != nil {
+			return err
+		}
+		objects, err := client.list(bucket, prefix+e.job.Name+"-")
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if isJobFile(strings.TrimPrefix(object.Key, prefix)) && object.LastModified.Before(cutoff) {
+				if err := client.delete(bucket, object.Key); err != nil {
+					return err
+				}
+				logMessage(InfoLevel, "Export job %s: removed expired %s", e.job.Name, object.Key)
+			}
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(e.job.Destination)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isJobFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(e.job.Destination, entry.Name())); err != nil {
+				return err
+			}
+			logMessage(InfoLevel, "Export job %s: removed expired %s", e.job.Name, entry.Name())
+		}
+	}
+	return nil
+}
+
+// writeExportCSV writes rows as CSV with a header line
+func writeExportCSV(buf *bytes.Buffer, rows []exportRow) error {
+	w := csv.NewWriter(buf)
+	w.Write([]string{"time", "server", "address", "name", "value"})
+	for _, row := range rows {
+		w.Write([]string{
+			row.Time.Format(time.RFC3339Nano),
+			row.Server,
+			strconv.Itoa(int(row.Address)),
+			row.Name,
+			strconv.FormatFloat(row.Value, 'g', -1, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeExportParquet writes rows as a Parquet file with the same columns as the CSV
+func writeExportParquet(buf *bytes.Buffer, rows []exportRow) error {
+	var f parquetFile
+	times := f.column("time", parquetInt64, parquetTimestampMillis)
+	serverIDs := f.column("server", parquetByteArray, parquetUTF8)
+	addresses := f.column("address", parquetInt32, -1)
+	names := f.column("name", parquetByteArray, parquetUTF8)
+	values := f.column("value", parquetDouble, -1)
+	for _, row := range rows {
+		times.int64(row.Time.UnixMilli())
+		serverIDs.string(row.Server)
+		addresses.int32(int32(row.Address))
+		names.string(row.Name)
+		values.double(row.Value)
+	}
+	f.rows = int64(len(rows))
+	return f.write(buf)
+}
+
+// exportJobConfigs returns the definitions of the running jobs, for the exported configuration
+func exportJobConfigs() []ExportJob {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	jobs := make([]ExportJob, 0, len(exportJobs))
+	for _, runner := range exportJobs {
+		jobs = append(jobs, runner.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// handleExports lists the export jobs and the outcome of their last run on GET /api/exports
+func handleExports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	exportJobsMu.Lock()
+	runners := make([]*exportRunner, 0, len(exportJobs))
+	for _, runner := range exportJobs {
+		runners = append(runners, runner)
+	}
+	exportJobsMu.Unlock()
+	sort.Slice(runners, func(i, j int) bool { return runners[i].job.Name < runners[j].job.Name })
+
+	jobs := make([]map[string]interface{}, 0, len(runners))
+	for _, runner := range runners {
+		runner.mu.Lock()
+		status := map[string]interface{}{
+			"job":      runner.job,
+			"nextRun":  runner.nextRun,
+			"samples":  len(runner.rows),
+			"pending":  len(runner.pending),
+			"lastFile": runner.lastFile,
+		}
+		if !runner.lastRun.IsZero() {
+			status["lastRun"] = runner.lastRun
+		}
+		if runner.lastError != "" {
+			status["lastError"] = runner.lastError
+		}
+		runner.mu.Unlock()
+		jobs = append(jobs, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "jobs": jobs})
+}
+
+// checkExports applies the semantic rules to the export jobs
+func (v *configValidator) checkExports(jobs []ExportJob) {
+	seen := make(map[string]bool)
+	for i, job := range jobs {
+		path := fmt.Sprintf("exports[%d]", i)
+		switch {
+		case job.Name == "":
+			v.fail(path, "name is required")
+		case strings.ContainsAny(job.Name, "/\\"):
+			v.fail(path+".name", fmt.Sprintf("name %q must not contain '/' or '\\'", job.Name))
+		case seen[job.Name]:
+			v.fail(path+".name", fmt.Sprintf("duplicate export job name %q", job.Name))
+		}
+		seen[job.Name] = true
+
+		if job.Format != "" && job.Format != "csv" && job.Format != "parquet" {
+			v.fail(path+".format", fmt.Sprintf("format %q must be csv or parquet", job.Format))
+		}
+		if job.Destination == "" {
+			v.fail(path, "destination is required")
+		} else if strings.HasPrefix(job.Destination, "s3://") {
+			if _, _, ok := parseS3URL(job.Destination); !ok {
+				v.fail(path+".destination", fmt.Sprintf("destination %q must be s3://bucket/prefix", job.Destination))
+			}
+		}
+		if len(job.Registers) == 0 {
+			v.fail(path+".registers", "at least one register or server is required")
+		}
+		for j, register := range job.Registers {
+			id, address, found := strings.Cut(register, ":")
+			if id == "" {
+				v.fail(fmt.Sprintf("%s.registers[%d]", path, j), "server id is required")
+			} else if _, err := strconv.ParseUint(address, 10, 16); found && err != nil {
+				v.fail(fmt.Sprintf("%s.registers[%d]", path, j), fmt.Sprintf("invalid register %q (must be server or server:address)", register))
+			}
+		}
+		if job.Interval < 0 {
+			v.fail(path+".interval", "interval must not be negative")
+		}
+		if job.SampleInterval < 0 {
+			v.fail(path+".sampleInterval", "sampleInterval must not be negative")
+		} else if job.sampleInterval() > job.interval() {
+			v.fail(path+".sampleInterval", "sampleInterval must not be greater than interval")
+		}
+		if job.RetentionDays < 0 {
+			v.fail(path+".retentionDays", "retentionDays must not be negative")
+		}
+	}
+}
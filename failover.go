@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// failoverThreshold is the number of consecutive failed polls or connection
+// attempts after which a server with a backup address switches paths
+const failoverThreshold = 3
+
+// endpoint returns the address and port of the path the server currently
+// uses. The caller must hold s.mu.
+func (s *ModbusServer) endpoint() (string, int) {
+	if !s.onBackup {
+		return hostAddress(s.Address), s.Port
+	}
+	port := s.BackupPort
+	if port == 0 {
+		port = s.Port
+	}
+	return hostAddress(s.BackupAddress), port
+}
+
+// ActivePath returns "primary" or "backup", or "" for servers without a
+// backup address. The caller must hold s.mu.
+func (s *ModbusServer) ActivePath() string {
+	switch {
+	case s.BackupAddress == "":
+		return ""
+	case s.onBackup:
+		return "backup"
+	default:
+		return "primary"
+	}
+}
+
+// recordPathResult counts the failed polls and connection attempts of the
+// current path since its last successful poll and, for servers with a backup
+// address, switches to the other path once there are failoverThreshold of
+// them. It reports whether it switched; the caller must then reconnect.
+// The caller must hold s.mu.
+func (s *ModbusServer) recordPathResult(failed bool) bool {
+	if !failed {
+		s.pathFailures = 0
+		return false
+	}
+	s.pathFailures++
+	if s.BackupAddress == "" || s.pathFailures < failoverThreshold {
+		return false
+	}
+
+	s.pathFailures = 0
+	s.failedPolls = 0
+	s.onBackup = !s.onBackup
+	address, port := s.endpoint()
+	message := fmt.Sprintf("Server %s failed over to its %s path %s", s.ID, s.ActivePath(), net.JoinHostPort(address, strconv.Itoa(port)))
+	logMessage(InfoLevel, "%s", message)
+	events.publish(Event{Type: "failover", ServerID: s.ID, Message: message})
+	return true
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rustyoz/modbus"
+)
+
+// faultConfig is the probability, from 0 to 1, of each fault injected into
+// the responses of Modbus reads, to check that polling survives the flaky
+// gateways found in the field
+type faultConfig struct {
+	truncate  float64       // drop bytes from the end of the response
+	extend    float64       // append unexpected bytes to the response
+	corrupt   float64       // flip a bit of the response data
+	framing   float64       // fail as if the transaction ID did not match
+	delay     float64       // respond late
+	delayTime time.Duration // how late
+}
+
+// parseFaultConfig parses a list such as "truncate=0.1,delay=0.2,delay-time=3s"
+func parseFaultConfig(s string) (*faultConfig, error) {
+	config := &faultConfig{delayTime: 2 * time.Second}
+	probabilities := map[string]*float64{
+		"truncate": &config.truncate,
+		"extend":   &config.extend,
+		"corrupt":  &config.corrupt,
+		"framing":  &config.framing,
+		"delay":    &config.delay,
+	}
+	for _, item := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			return nil, fmt.Errorf("expected fault=probability, got %q", item)
+		}
+		if key == "delay-time" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay-time: %v", err)
+			}
+			config.delayTime = d
+			continue
+		}
+		p, ok := probabilities[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown fault %q (supported: truncate, extend, corrupt, framing, delay, delay-time)", key)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("probability of %s must be between 0 and 1", key)
+		}
+		*p = v
+	}
+	return config, nil
+}
+
+// faultClient wraps a Modbus client and damages the responses of its reads
+type faultClient struct {
+	modbus.Client
+	faults *faultConfig
+}
+
+// inject applies randomly chosen faults to the outcome of a read
+func (f *faultClient) inject(results []byte, err error) ([]byte, error) {
+	if err != nil {
+		return results, err
+	}
+	if rand.Float64() < f.faults.delay {
+		time.Sleep(f.faults.delayTime)
+	}
+	if rand.Float64() < f.faults.framing {
+		return nil, errors.New("modbus: response transaction id does not match request (injected fault)")
+	}
+	if rand.Float64() < f.faults.truncate && len(results) > 0 {
+		results = results[:rand.Intn(len(results))]
+	}
+	if rand.Float64() < f.faults.extend {
+		results = append(results, byte(rand.Intn(256)))
+	}
+	if rand.Float64() < f.faults.corrupt && len(results) > 0 {
+		results = append([]byte(nil), results...)
+		results[rand.Intn(len(results))] ^= 1 << rand.Intn(8)
+	}
+	return results, nil
+}
+
+func (f *faultClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return f.inject(f.Client.ReadCoils(address, quantity))
+}
+
+func (f *faultClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return f.inject(f.Client.ReadDiscreteInputs(address, quantity))
+}
+
+func (f *faultClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return f.inject(f.Client.ReadInputRegisters(address, quantity))
+}
+
+func (f *faultClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return f.inject(f.Client.ReadHoldingRegisters(address, quantity))
+}
+
+// testTransport is a Modbus client answering reads from memory: register n
+// holds the value n and coil or input n is on when n is odd
+type testTransport struct {
+	modbus.Client
+	reads int
+}
+
+func (t *testTransport) registers(address, quantity uint16) ([]byte, error) {
+	t.reads++
+	results := make([]byte, 0, int(quantity)*2)
+	for i := uint16(0); i < quantity; i++ {
+		results = append(results, byte((address+i)>>8), byte(address+i))
+	}
+	return results, nil
+}
+
+func (t *testTransport) bits(address, quantity uint16) ([]byte, error) {
+	t.reads++
+	results := make([]byte, (int(quantity)+7)/8)
+	for i := uint16(0); i < quantity; i++ {
+		if (address+i)%2 == 1 {
+			results[i/8] |= 1 << (i % 8)
+		}
+	}
+	return results, nil
+}
+
+func (t *testTransport) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return t.registers(address, quantity)
+}
+
+func (t *testTransport) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return t.registers(address, quantity)
+}
+
+func (t *testTransport) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return t.bits(address, quantity)
+}
+
+func (t *testTransport) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return t.bits(address, quantity)
+}
+
+// testHandler stands in for the TCP connection, counting resets
+type testHandler struct {
+	tcpHandler
+	resets int
+}
+
+func (h *testHandler) Close() error {
+	h.resets++
+	return nil
+}
+
+// newFaultyClient returns a client whose responses from the test transport
+// are damaged as configured by faults, e.g. "truncate=1"
+func newFaultyClient(t *testing.T, faults string) (*ModbusClient, *testTransport, *testHandler) {
+	t.Helper()
+	transport := &testTransport{}
+	handler := &testHandler{}
+	client := &ModbusClient{handler: handler, address: "test", client: transport}
+	if faults != "" {
+		config, err := parseFaultConfig(faults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.client = &faultClient{Client: transport, faults: config}
+	}
+	return client, transport, handler
+}
+
+func TestModbusClientFaults(t *testing.T) {
+	tests := []struct {
+		faults string
+		err    string // expected from every read, or "" if reads succeed
+		resets int    // connection resets per read
+	}{
+		{faults: "", err: ""},
+		{faults: "truncate=1", err: "does not match expected", resets: 1},
+		{faults: "extend=1", err: "does not match expected", resets: 1},
+		{faults: "framing=1", err: "transaction id does not match", resets: 1},
+		{faults: "corrupt=1", err: ""},
+		{faults: "delay=1,delay-time=10ms", err: ""},
+	}
+	reads := []struct {
+		name  string
+		count int
+		read  func(c *ModbusClient) (int, error)
+	}{
+		{"ReadRegister", 1, func(c *ModbusClient) (int, error) {
+			_, err := c.ReadRegister(100)
+			return 1, err
+		}},
+		{"ReadCoil", 1, func(c *ModbusClient) (int, error) {
+			_, err := c.ReadCoil(100)
+			return 1, err
+		}},
+		{"ReadHoldingRegisters", 10, func(c *ModbusClient) (int, error) {
+			values, err := c.ReadHoldingRegisters(100, 10)
+			return len(values), err
+		}},
+		{"ReadInputRegisters", 125, func(c *ModbusClient) (int, error) {
+			values, err := c.ReadInputRegisters(0, 125)
+			return len(values), err
+		}},
+		{"ReadCoils", 13, func(c *ModbusClient) (int, error) {
+			values, err := c.ReadCoils(3, 13)
+			return len(values), err
+		}},
+		{"ReadDiscreteInputs", 2000, func(c *ModbusClient) (int, error) {
+			values, err := c.ReadDiscreteInputs(0, 2000)
+			return len(values), err
+		}},
+	}
+	for _, tt := range tests {
+		for _, r := range reads {
+			name := tt.faults
+			if name == "" {
+				name = "none"
+			}
+			t.Run(name+"/"+r.name, func(t *testing.T) {
+				client, transport, handler := newFaultyClient(t, tt.faults)
+				count, err := r.read(client)
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Errorf("error = %v, want %q", err, tt.err)
+					}
+					if client.IsConnectionError(err) {
+						t.Errorf("%v is treated as a connection error", err)
+					}
+				} else if err != nil {
+					t.Errorf("error = %v", err)
+				} else if count != r.count {
+					t.Errorf("%d values, want %d", count, r.count)
+				}
+				if handler.resets != tt.resets || transport.reads != tt.resets+1 {
+					t.Errorf("%d resets and %d reads, want %d and %d", handler.resets, transport.reads, tt.resets, tt.resets+1)
+				}
+			})
+		}
+	}
+}
+
+func TestModbusClientCorruptValue(t *testing.T) {
+	client, _, _ := newFaultyClient(t, "corrupt=1")
+	value, err := client.ReadRegister(0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := value ^ 0x1234; diff == 0 || diff&(diff-1) != 0 {
+		t.Errorf("value 0x%04X, want 0x1234 with one bit flipped", value)
+	}
+}
+
+func TestModbusClientDelay(t *testing.T) {
+	client, _, _ := newFaultyClient(t, "delay=1,delay-time=20ms")
+	start := time.Now()
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("read took %v, want at least 20ms", elapsed)
+	}
+}
+
+// TestFaultSoak polls blocks of every kind through randomly damaged
+// responses: reads must fail cleanly or return the right values, never panic
+func TestFaultSoak(t *testing.T) {
+	client, _, _ := newFaultyClient(t, "truncate=0.2,extend=0.2,corrupt=0.2,framing=0.2")
+	server := &ModbusServer{ID: "soak", client: client}
+	blocks := []RegisterBlock{
+		{StartAddress: 0, Length: 17},
+		{StartAddress: 10005, Length: 9},
+		{StartAddress: 30000, Length: 125},
+		{StartAddress: 40100, Length: 3},
+	}
+	var failures int
+	for i := 0; i < 500; i++ {
+		block := blocks[i%len(blocks)]
+		if err := server.readBlock(block); err != nil {
+			failures++
+			if client.IsConnectionError(err) {
+				t.Fatalf("block %d: %v is treated as a connection error", block.StartAddress, err)
+			}
+		}
+	}
+	if failures == 0 || failures == 500 {
+		t.Errorf("%d of 500 reads failed", failures)
+	}
+}
+
+// shortDevice returns one value fewer than requested
+type shortDevice struct {
+	Device
+}
+
+func (shortDevice) ReadCoils(address, quantity uint16) ([]bool, error) {
+	return make([]bool, quantity-1), nil
+}
+
+func (shortDevice) ReadHoldingRegisters(address, quantity uint16) ([]uint16, error) {
+	return make([]uint16, quantity-1), nil
+}
+
+func TestReadBlockShortResponse(t *testing.T) {
+	server := &ModbusServer{ID: "short", client: shortDevice{}}
+	for _, block := range []RegisterBlock{{StartAddress: 0, Length: 8}, {StartAddress: 49990, Length: 10}} {
+		err := server.readBlock(block)
+		if err == nil || !strings.Contains(err.Error(), "expected") {
+			t.Errorf("block %d: error = %v", block.StartAddress, err)
+		}
+	}
+}
+
+func TestReadBlockValues(t *testing.T) {
+	client, _, _ := newFaultyClient(t, "")
+	server := &ModbusServer{ID: "ok", client: client}
+	if err := server.readBlock(RegisterBlock{StartAddress: 40010, Length: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.readBlock(RegisterBlock{StartAddress: 10000, Length: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.dataModel.HoldingRegisters[10:15]; [5]uint16(got) != [5]uint16{10, 11, 12, 13, 14} {
+		t.Errorf("holding registers = %v", got)
+	}
+	if got := server.dataModel.DiscreteInputs[:4]; [4]bool(got) != [4]bool{false, true, false, true} {
+		t.Errorf("discrete inputs = %v", got)
+	}
+}
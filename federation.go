@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remotePollInterval is how often remote instances are queried for their servers
+const remotePollInterval = 5 * time.Second
+
+// remoteClient is used for all requests to remote instances
+var remoteClient = &http.Client{Timeout: 5 * time.Second}
+
+// remotesTemplate renders the servers of all remote instances
+const remotesTemplate = `
+{{define "remotes"}}
+{{range .}}
+<div class="card mb-3">
+	<div class="card-header d-flex justify-content-between align-items-center">
+		<div>
+			<span style="display:inline-block;width:12px;height:12px;border-radius:50%;margin-right:8px;vertical-align:middle;background-color:{{if eq .Status "ok"}}#28a745{{else}}#dc3545{{end}};border:1px solid #888;"></span>
+			<strong>Remote: {{.Name}}</strong>
+			<small class="text-muted ms-2">{{if .URL}}{{.URL}}{{else}}push{{end}}{{if .Error}} | {{.Error}}{{end}}{{if not .LastUpdate.IsZero}} | Updated: {{.LastUpdate.Format "15:04:05"}}{{end}}</small>
+		</div>
+		{{if .URL}}<a class="btn btn-secondary btn-sm" href="{{.URL}}" target="_blank">Open</a>{{else}}<span class="badge bg-secondary">pushed</span>{{end}}
+	</div>
+	<ul class="list-group list-group-flush">
+		{{range .Servers}}
+		<li class="list-group-item">
+			<span style="display:inline-block;width:10px;height:10px;border-radius:50%;margin-right:8px;background-color:{{if eq .ConnectionStatus "ok"}}#28a745{{else}}#dc3545{{end}};"></span>
+			{{.ID}} <small class="text-muted">IP: {{.Address}} | Port: {{.Port}} | Last Data Received: {{.LastDataReceived.Format "15:04:05.000"}}{{if .ConnectionError}} | {{.ConnectionError}}{{end}}</small>
+		</li>
+		{{else}}
+		<li class="list-group-item text-muted">No servers</li>
+		{{end}}
+	</ul>
+</div>
+{{end}}
+{{end}}`
+
+// remoteServer is the status of a server on a remote instance, as returned by its GET /api/servers
+type remoteServer struct {
+	ID               string    `json:"ID"`
+	ConnectionStatus string    `json:"ConnectionStatus"`
+	ConnectionError  string    `json:"ConnectionError"`
+	Address          string    `json:"Address"`
+	Port             int       `json:"Port"`
+	PollRate         int       `json:"PollRate"`
+	LastDataReceived time.Time `json:"LastDataReceived"`
+}
+
+// remoteInstance is another modbusbrowser whose servers are shown on this one,
+// either polled from its URL or pushed by it (URL empty)
+type remoteInstance struct {
+	Name       string         `json:"name"`
+	URL        string         `json:"url"`
+	Status     string         `json:"status"` // "ok", "error" or "pending"
+	Error      string         `json:"error,omitempty"`
+	LastUpdate time.Time      `json:"lastUpdate"`
+	Servers    []remoteServer `json:"servers"`
+
+	samples map[string][]PushSample // latest samples per server, for instances that push to this one
+}
+
+var (
+	remotesMu sync.Mutex
+	remotes   []*remoteInstance
+)
+
+// parseRemotes parses a comma-separated list of remote instances given as
+// "name=url" or just "url", in which case the host is used as the name
+func parseRemotes(s string) ([]*remoteInstance, error) {
+	var list []*remoteInstance
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, rawURL, found := strings.Cut(item, "=")
+		if !found {
+			rawURL, name = name, ""
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid remote URL %q", rawURL)
+		}
+		if name == "" {
+			name = u.Host
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate remote name %q", name)
+		}
+		seen[name] = true
+		list = append(list, &remoteInstance{
+			Name:    name,
+			URL:     strings.TrimSuffix(u.String(), "/"),
+			Status:  "pending",
+			Servers: []remoteServer{},
+		})
+	}
+	return list, nil
+}
+
+// startFederation starts polling the given remote instances
+func startFederation(list []*remoteInstance) {
+	remotesMu.Lock()
+	remotes = list
+	remotesMu.Unlock()
+
+	for _, remote := range list {
+		go pollRemote(remote)
+	}
+}
+
+// pollRemote periodically fetches the server list of a remote instance
+func pollRemote(remote *remoteInstance) {
+	for {
+		var result struct {
+			Servers []remoteServer `json:"servers"`
+		}
+		err := fetchRemote(remote.URL+"/api/servers", &result)
+
+		remotesMu.Lock()
+		if err != nil {
+			if remote.Status != "error" {
+				logMessage(ErrorLevel, "Remote %s unavailable: %v", remote.Name, err)
+			}
+			remote.Status = "error"
+			remote.Error = err.Error()
+		} else {
+			sort.Slice(result.Servers, func(i, j int) bool {
+				return result.Servers[i].ID < result.Servers[j].ID
+			})
+			remote.Status = "ok"
+			remote.Error = ""
+			remote.LastUpdate = time.Now()
+			remote.Servers = result.Servers
+		}
+		remotesMu.Unlock()
+
+		time.Sleep(remotePollInterval)
+	}
+}
+
+// fetchRemote decodes the JSON response of a GET request to a remote instance
+func fetchRemote(rawURL string, v interface{}) error {
+	resp, err := remoteClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// findRemote returns the remote instance with the given name
+func findRemote(name string) *remoteInstance {
+	remotesMu.Lock()
+	defer remotesMu.Unlock()
+	for _, remote := range remotes {
+		if remote.Name == name {
+			return remote
+		}
+	}
+	return nil
+}
+
+// handleRemotes lists remote instances and their servers on /api/remotes and
+// proxies register data on /api/remotes/{name}/servers/{id}
+func handleRemotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/remotes"), "/")
+	if path != "" {
+		handleRemoteServer(w, r, path)
+		return
+	}
+
+	remotesMu.Lock()
+	list := make([]remoteInstance, 0, len(remotes))
+	for _, remote := range remotes {
+		entry := *remote
+		if entry.URL == "" && time.Since(entry.LastUpdate) > pushStaleAfter {
+			entry.Status = "error"
+			entry.Error = "no samples pushed recently"
+		}
+		list = append(list, entry)
+	}
+	remotesMu.Unlock()
+
+	if isHtmxRequest(r) {
+		w.Header().Set("Content-Type", "text/html")
+		if err := templatesFor(r).ExecuteTemplate(w, "remotes", list); err != nil {
+			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"remotes": list,
+	})
+}
+
+// handleRemoteServer proxies GET /api/servers/{id} of a remote instance as JSON
+func handleRemoteServer(w http.ResponseWriter, r *http.Request, path string) {
+	name, rest, _ := strings.Cut(path, "/")
+	id, found := strings.CutPrefix(rest, "servers/")
+	if !found || id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	remote := findRemote(name)
+	if remote == nil {
+		handleError(w, r, fmt.Sprintf("Remote not found: %s", name))
+		return
+	}
+
+	// Instances that push to this one: serve their latest samples
+	if remote.URL == "" {
+		remotesMu.Lock()
+		samples, exists := remote.samples[id]
+		remotesMu.Unlock()
+		if !exists {
+			handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    samples,
+		})
+		return
+	}
+
+	target := remote.URL + "/api/servers/" + url.PathEscape(id)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	resp, err := remoteClient.Get(target)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Remote %s unavailable: %v", name, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// maxFilterSamples is the largest number of samples a register filter can use
+const maxFilterSamples = 100
+
+// registerFilters lists the supported RegisterConfig filters
+var registerFilters = map[string]bool{
+	"average": true, // moving average
+	"median":  true,
+}
+
+// recordFilterSamples adds the current value of every filtered register in a
+// block read by the last poll to its sample window. The caller must hold s.mu.
+func (s *ModbusServer) recordFilterSamples() {
+	for _, block := range s.RegisterBlocks {
+		if status := s.blockStatus[block.StartAddress]; status == nil || status.Status != "ok" {
+			continue
+		}
+		for _, reg := range block.Registers {
+			if reg.Filter == "" || reg.Address < 30000 {
+				continue
+			}
+			value, numeric := toFloat(decodeRegister(reg, s.registerWords(block, reg.Address, max(registerWordCount(reg), 1))))
+			if !numeric {
+				continue
+			}
+			if s.filterSamples == nil {
+				s.filterSamples = make(map[uint16][]float64)
+			}
+			samples := append(s.filterSamples[reg.Address], value)
+			if n := max(reg.FilterSamples, 1); len(samples) > n {
+				samples = samples[len(samples)-n:]
+			}
+			s.filterSamples[reg.Address] = samples
+		}
+	}
+}
+
+// filteredValue returns the filtered value of a register, or false if it has
+// no filter or no samples yet. The caller must hold s.mu.
+func (s *ModbusServer) filteredValue(reg RegisterConfig) (float64, bool) {
+	samples := s.filterSamples[reg.Address]
+	if reg.Filter == "" || len(samples) == 0 {
+		return 0, false
+	}
+	if n := max(reg.FilterSamples, 1); len(samples) > n {
+		samples = samples[len(samples)-n:]
+	}
+
+	switch reg.Filter {
+	case "median":
+		sorted := append([]float64(nil), samples...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2, true
+		}
+		return sorted[mid], true
+	default:
+		var sum float64
+		for _, v := range samples {
+			sum += v
+		}
+		return sum / float64(len(samples)), true
+	}
+}
+
+// checkFilter returns an error if a register's filter settings are invalid
+func checkFilter(reg RegisterConfig) error {
+	if reg.Filter == "" {
+		if reg.FilterSamples != 0 {
+			return fmt.Errorf("filterSamples requires a filter")
+		}
+		return nil
+	}
+	if !registerFilters[reg.Filter] {
+		return fmt.Errorf("unknown filter %q (must be average or median)", reg.Filter)
+	}
+	if reg.Address < 30000 || reg.Address >= 50000 {
+		return fmt.Errorf("filter %q requires an input or holding register", reg.Filter)
+	}
+	if !numericFormats[reg.Format] {
+		return fmt.Errorf("filter %q requires a numeric format (decimal, an integer format, float or double)", reg.Filter)
+	}
+	if reg.FilterSamples < 2 || reg.FilterSamples > maxFilterSamples {
+		return fmt.Errorf("filterSamples %d must be between 2 and %d", reg.FilterSamples, maxFilterSamples)
+	}
+	return nil
+}
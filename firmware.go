@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MapTemplate defines the register maps of a device type whose addresses
+// differ between firmware versions. A server created from the template gets
+// the register blocks of the variant matching the firmware version it
+// reports when it connects.
+type MapTemplate struct {
+	FirmwareRegister uint16            `json:"firmwareRegister"` // input or holding register holding the firmware version
+	Variants         []FirmwareVariant `json:"variants"`         // the first match is used
+}
+
+// FirmwareVariant is the register map of a range of firmware versions
+type FirmwareVariant struct {
+	Name           string          `json:"name"`
+	MinFirmware    *uint16         `json:"minFirmware,omitempty"` // no lower bound if unset
+	MaxFirmware    *uint16         `json:"maxFirmware,omitempty"` // no upper bound if unset
+	RegisterBlocks []RegisterBlock `json:"registerBlocks"`
+}
+
+var (
+	mapTemplatesMu sync.RWMutex
+	mapTemplates   = make(map[string]*MapTemplate) // by name
+)
+
+// variantFor returns the first variant covering a firmware version, or nil
+func (t *MapTemplate) variantFor(firmware uint16) *FirmwareVariant {
+	for i := range t.Variants {
+		v := &t.Variants[i]
+		if (v.MinFirmware == nil || firmware >= *v.MinFirmware) && (v.MaxFirmware == nil || firmware <= *v.MaxFirmware) {
+			return v
+		}
+	}
+	return nil
+}
+
+// selectFirmwareVariant reads the firmware version of a server created from a
+// template with variants and switches the server to the matching register
+// map. The blocks are only replaced when the variant differs from the one in
+// use, so changes made to the server's blocks survive reconnects. The caller
+// must hold s.mu and have set s.client.
+func (s *ModbusServer) selectFirmwareVariant() {
+	mapTemplatesMu.RLock()
+	template := mapTemplates[s.Template]
+	mapTemplatesMu.RUnlock()
+	if template == nil || s.client == nil {
+		return
+	}
+
+	values, err := readAddresses(s.client, template.FirmwareRegister, 1)
+	if err == nil {
+		err = checkResponseLength(len(values.([]uint16)), 1)
+	}
+	if err != nil {
+		s.firmware = nil
+		logMessage(ErrorLevel, "Server %s: error reading firmware version at %d, keeping register map %q: %v",
+			s.ID, template.FirmwareRegister, s.Variant, err)
+		return
+	}
+	firmware := values.([]uint16)[0]
+	s.firmware = &firmware
+
+	variant := template.variantFor(firmware)
+	switch {
+	case variant == nil:
+		logMessage(ErrorLevel, "Server %s: no variant of template %s matches firmware %d, keeping register map %q",
+			s.ID, s.Template, firmware, s.Variant)
+	case variant.Name != s.Variant || len(s.RegisterBlocks) == 0:
+		s.RegisterBlocks = copyBlocks(variant.RegisterBlocks)
+		s.registerMap = buildRegisterMap(s.RegisterBlocks)
+		s.Variant = variant.Name
+		logMessage(InfoLevel, "Server %s: firmware %d, using register map %q of template %s", s.ID, firmware, variant.Name, s.Template)
+	}
+}
+
+// Firmware returns the firmware version read when the server connected, or
+// "" if it has not been read. The caller must hold s.mu.
+func (s *ModbusServer) Firmware() string {
+	if s.firmware == nil {
+		return ""
+	}
+	return fmt.Sprint(*s.firmware)
+}
+
+// checkTemplates applies the semantic rules to the register map templates
+func (v *configValidator) checkTemplates(templates map[string]*MapTemplate) {
+	for name, template := range templates {
+		path := "templates." + name
+		if template == nil {
+			v.fail(path, "template must be an object")
+			continue
+		}
+		if a := template.FirmwareRegister; a < 30000 || a >= 50000 {
+			v.fail(path+".firmwareRegister", fmt.Sprintf("firmwareRegister %d must be an input or holding register", a))
+		}
+		if len(template.Variants) == 0 {
+			v.fail(path+".variants", "template requires at least one variant")
+		}
+		seen := make(map[string]bool)
+		for i, variant := range template.Variants {
+			variantPath := fmt.Sprintf("%s.variants[%d]", path, i)
+			switch {
+			case variant.Name == "":
+				v.fail(variantPath, "name is required")
+			case seen[variant.Name]:
+				v.fail(variantPath+".name", fmt.Sprintf("duplicate variant name %q", variant.Name))
+			}
+			seen[variant.Name] = true
+			if variant.MinFirmware != nil && variant.MaxFirmware != nil && *variant.MinFirmware > *variant.MaxFirmware {
+				v.fail(variantPath, "minFirmware must not be greater than maxFirmware")
+			}
+			v.checkBlocks(variantPath, variant.RegisterBlocks)
+		}
+	}
+}
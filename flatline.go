@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// checkFlatlines flags registers with an expected update interval whose
+// value has not changed for longer than that, such as a heartbeat counter of
+// a PLC program that stopped while its communication still works. Only
+// registers in blocks read successfully by the last poll are checked, and an
+// event is published when a register flatlines and when it changes again.
+// The caller must hold s.mu.
+func (s *ModbusServer) checkFlatlines() {
+	now := time.Now()
+	if s.flatlineWatch == nil {
+		s.flatlineWatch = make(map[uint16]time.Time)
+		s.flatlines = make(map[uint16]bool)
+	}
+
+	for _, block := range s.RegisterBlocks {
+		if status := s.blockStatus[block.StartAddress]; status == nil || status.Status != "ok" {
+			continue
+		}
+		for _, reg := range block.Registers {
+			if reg.ExpectedUpdate <= 0 {
+				continue
+			}
+			// Unchanged since the last change of any of its words, or since
+			// watching began if it has not changed yet
+			since, watching := s.flatlineWatch[reg.Address]
+			if !watching {
+				since = now
+				s.flatlineWatch[reg.Address] = now
+			}
+			for j := 0; j < max(registerWordCount(reg), 1); j++ {
+				if t := s.lastChange[reg.Address+uint16(j)]; t.After(since) {
+					since = t
+				}
+			}
+
+			limit := time.Duration(reg.ExpectedUpdate * float64(time.Second))
+			flat := now.Sub(since) > limit
+			if flat == s.flatlines[reg.Address] {
+				continue
+			}
+			s.flatlines[reg.Address] = flat
+
+			name := reg.Name
+			if name == "" {
+				name = fmt.Sprint(reg.Address)
+			}
+			if flat {
+				message := fmt.Sprintf("Server %s: %s has not changed for %s (expected every %s)",
+					s.ID, name, now.Sub(since).Round(time.Second), limit)
+				logMessage(ErrorLevel, "%s", message)
+				events.publish(Event{Type: "flatline", ServerID: s.ID, Address: &reg.Address, Message: message})
+			} else {
+				message := fmt.Sprintf("Server %s: %s is updating again", s.ID, name)
+				logMessage(InfoLevel, "%s", message)
+				events.publish(Event{Type: "flatline-cleared", ServerID: s.ID, Address: &reg.Address, Message: message})
+			}
+		}
+	}
+}
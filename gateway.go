@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// gatewayGap is the minimum time between the end of one request through a
+// shared gateway and the start of the next (-gateway-gap)
+var gatewayGap = 50 * time.Millisecond
+
+// gatewayScheduler serializes the requests of all servers behind one gateway.
+// Requests are served in arrival order, so servers polling several blocks
+// take turns block by block instead of one server holding the line.
+type gatewayScheduler struct {
+	mu      sync.Mutex
+	busy    bool
+	holder  *ModbusServer   // server whose poll loop holds the gateway, see hold
+	queue   []chan struct{} // waiting requests, oldest first
+	lastEnd time.Time
+}
+
+var (
+	gatewaysMu sync.Mutex
+	gateways   = make(map[string]*gatewayScheduler) // by gateway name
+)
+
+// gatewayFor returns the scheduler of a gateway, creating it on first use
+func gatewayFor(name string) *gatewayScheduler {
+	gatewaysMu.Lock()
+	defer gatewaysMu.Unlock()
+	g, ok := gateways[name]
+	if !ok {
+		g = &gatewayScheduler{}
+		gateways[name] = g
+	}
+	return g
+}
+
+// acquire waits for the gateway to be free and for the gap after the
+// previous request to pass
+func (g *gatewayScheduler) acquire() {
+	g.mu.Lock()
+	if g.busy || len(g.queue) > 0 {
+		turn := make(chan struct{})
+		g.queue = append(g.queue, turn)
+		g.mu.Unlock()
+		<-turn
+		g.mu.Lock()
+	}
+	g.busy = true
+	wait := gatewayGap - time.Since(g.lastEnd)
+	g.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// release hands the gateway to the next waiting request
+func (g *gatewayScheduler) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastEnd = time.Now()
+	if len(g.queue) == 0 {
+		g.busy = false
+		return
+	}
+	next := g.queue[0]
+	g.queue = g.queue[1:]
+	close(next)
+}
+
+// hold acquires the gateway for the next block the poll loop of s reads.
+// The poll loop reads with s.mu held, so it waits for its turn before taking
+// s.mu rather than blocking the pages and writes of the server while the
+// other servers behind the gateway are served. Requests of s are sent
+// through the held gateway until unhold.
+func (g *gatewayScheduler) hold(s *ModbusServer) {
+	g.acquire()
+	g.mu.Lock()
+	g.holder = s
+	g.mu.Unlock()
+}
+
+// unhold releases a gateway acquired with hold
+func (g *gatewayScheduler) unhold() {
+	g.mu.Lock()
+	g.holder = nil
+	g.mu.Unlock()
+	g.release()
+}
+
+// heldBy reports whether the poll loop of s holds the gateway
+func (g *gatewayScheduler) heldBy(s *ModbusServer) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.holder == s
+}
+
+// waitGateway waits for the shared gateway of a server before the poll loop
+// reads a block, with s.mu released while waiting. It returns the function
+// releasing the gateway, or false if the server was paused or disconnected
+// meanwhile. The caller must hold s.mu.
+func (s *ModbusServer) waitGateway() (func(), bool) {
+	if s.Gateway == "" {
+		return func() {}, true
+	}
+	g := gatewayFor(s.Gateway)
+	s.mu.Unlock()
+	g.hold(s)
+	s.mu.Lock()
+	if s.Paused || s.client == nil {
+		g.unhold()
+		return nil, false
+	}
+	return g.unhold, true
+}
+
+// gatewayDevice sends the requests of a device through its gateway's scheduler
+type gatewayDevice struct {
+	Device
+	gateway *gatewayScheduler
+	server  *ModbusServer
+}
+
+// turn waits for the gateway, unless the poll loop of the server holds it
+// already, and returns the function ending the request's turn
+func (d *gatewayDevice) turn() func() {
+	if d.gateway.heldBy(d.server) {
+		return func() {}
+	}
+	d.gateway.acquire()
+	return d.gateway.release
+}
+
+func (d *gatewayDevice) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	defer d.turn()()
+	return d.Device.ReadCoils(address, quantity)
+}
+
+func (d *gatewayDevice) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	defer d.turn()()
+	return d.Device.ReadDiscreteInputs(address, quantity)
+}
+
+func (d *gatewayDevice) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	defer d.turn()()
+	return d.Device.ReadInputRegisters(address, quantity)
+}
+
+func (d *gatewayDevice) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	defer d.turn()()
+	return d.Device.ReadHoldingRegisters(address, quantity)
+}
+
+func (d *gatewayDevice) WriteSingleCoil(address uint16, value bool) error {
+	defer d.turn()()
+	return d.Device.WriteSingleCoil(address, value)
+}
+
+func (d *gatewayDevice) WriteMultipleCoils(address uint16, values []bool) error {
+	defer d.turn()()
+	return d.Device.WriteMultipleCoils(address, values)
+}
+
+func (d *gatewayDevice) WriteSingleRegister(address uint16, value uint16) error {
+	defer d.turn()()
+	return d.Device.WriteSingleRegister(address, value)
+}
+
+func (d *gatewayDevice) WriteMultipleRegisters(address uint16, values []uint16) error {
+	defer d.turn()()
+	return d.Device.WriteMultipleRegisters(address, values)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitGatewayReleasesServer(t *testing.T) {
+	other := &ModbusServer{ID: "gw-other"}
+	s := &ModbusServer{ID: "gw-waiting", Gateway: "gw-test", client: &gatewayDevice{}}
+	g := gatewayFor("gw-test")
+	g.hold(other)
+
+	waited := make(chan bool)
+	go func() {
+		s.mu.Lock()
+		release, ok := s.waitGateway()
+		if ok {
+			release()
+		}
+		s.mu.Unlock()
+		waited <- ok
+	}()
+
+	// While s waits for the gateway, its lock is free for pages and writes
+	time.Sleep(20 * time.Millisecond)
+	if !s.mu.TryLock() {
+		t.Fatal("s.mu is held while waiting for the gateway")
+	}
+	if g.heldBy(s) {
+		t.Error("gateway held by s before other released it")
+	}
+	s.mu.Unlock()
+
+	g.unhold()
+	select {
+	case ok := <-waited:
+		if !ok {
+			t.Error("waitGateway gave up")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitGateway did not return")
+	}
+}
+
+func TestGatewayDeviceUsesHeldTurn(t *testing.T) {
+	s := &ModbusServer{ID: "gw-holder"}
+	g := gatewayFor("gw-held")
+	d := &gatewayDevice{gateway: g, server: s}
+
+	// A request of the server holding the gateway does not queue behind
+	// its own turn
+	g.hold(s)
+	done := make(chan struct{})
+	go func() {
+		d.turn()()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("request waited for the gateway its server holds")
+	}
+	g.unhold()
+
+	// Other servers' requests wait for their turn
+	g.hold(&ModbusServer{ID: "gw-else"})
+	done = make(chan struct{})
+	go func() {
+		d.turn()()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("request did not wait for the gateway")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.unhold()
+	<-done
+}
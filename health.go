@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// healthWindow is how far back connection state changes are kept
+	healthWindow = 24 * time.Hour
+	// maxHealthTransitions bounds the changes kept of a server that keeps
+	// dropping out
+	maxHealthTransitions = 10000
+)
+
+// healthTemplate renders the connection timeline of a server as a strip of
+// green and red segments, oldest on the left. Short dropouts get a minimum
+// width so they stay visible on a day's strip.
+const healthTemplate = `
+{{define "health"}}<div class="d-flex mt-1 rounded overflow-hidden" style="height:6px;width:100%;max-width:480px;background-color:#e9ecef" title="Connection over the last {{.Hours}} h">
+{{range .Segments}}<div class="{{if eq .Status "ok"}}bg-success{{else if eq .Status "error"}}bg-danger{{end}}" style="flex:{{.Seconds}} 1 0;{{if eq .Status "error"}}min-width:2px{{end}}" title="{{.From.Format "15:04:05"}} to {{.To.Format "15:04:05"}}: {{.Title}}"></div>{{end}}
+</div>{{end}}`
+
+// healthTransition is a change of the connection status of a server
+type healthTransition struct {
+	t       time.Time
+	status  string
+	message string
+}
+
+// recordHealth records a change of the connection status, dropping changes
+// that are older than healthWindow except the last of them, which gives the
+// state at the start of the window. The caller must hold s.mu.
+func (s *ModbusServer) recordHealth(status, message string) {
+	now := time.Now()
+	s.transitions = append(s.transitions, healthTransition{now, status, message})
+
+	drop := 0
+	for drop+1 < len(s.transitions) && now.Sub(s.transitions[drop+1].t) > healthWindow {
+		drop++
+	}
+	drop = max(drop, len(s.transitions)-maxHealthTransitions)
+	if drop > 0 {
+		s.transitions = append(s.transitions[:0], s.transitions[drop:]...)
+	}
+}
+
+// HealthSegment is a period in which a server's connection status did not change
+type HealthSegment struct {
+	Status  string    `json:"status"` // "ok", "error" or "" before the server was added
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Seconds returns the length of the segment in seconds
+func (g HealthSegment) Seconds() int64 {
+	return int64(g.To.Sub(g.From).Seconds())
+}
+
+// Title describes the segment for its tooltip
+func (g HealthSegment) Title() string {
+	duration := g.To.Sub(g.From).Round(time.Second)
+	switch g.Status {
+	case "ok":
+		return fmt.Sprintf("connected for %s", duration)
+	case "error":
+		if g.Message != "" {
+			return fmt.Sprintf("disconnected for %s (%s)", duration, g.Message)
+		}
+		return fmt.Sprintf("disconnected for %s", duration)
+	}
+	return "not monitored"
+}
+
+// healthSegments returns the connection states of a server from since until
+// now, oldest first. The caller must hold s.mu.
+func (s *ModbusServer) healthSegments(since time.Time) []HealthSegment {
+	now := time.Now()
+	segments := []HealthSegment{}
+	from, status, message := since, "", ""
+	for _, transition := range s.transitions {
+		if transition.t.After(from) {
+			segments = append(segments, HealthSegment{status, from, transition.t, message})
+			from = transition.t
+		}
+		status, message = transition.status, transition.message
+	}
+	return append(segments, HealthSegment{status, from, now, message})
+}
+
+// handleHealth serves the connection timeline of a server on GET
+// /api/servers/{id}/health?hours=24, as a strip for htmx requests or as the
+// list of segments in which the status did not change
+func handleHealth(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hours := int(healthWindow / time.Hour)
+	if s := r.URL.Query().Get("hours"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > hours {
+			handleError(w, r, fmt.Sprintf("Invalid hours: %q (must be 1 to %d)", s, hours))
+			return
+		}
+		hours = n
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	server.mu.Lock()
+	segments := server.healthSegments(time.Now().Add(-time.Duration(hours) * time.Hour))
+	server.mu.Unlock()
+
+	if isHtmxRequest(r) {
+		w.Header().Set("Content-Type", "text/html")
+		if err := templatesFor(r).ExecuteTemplate(w, "health", map[string]interface{}{
+			"Hours":    hours,
+			"Segments": segments,
+		}); err != nil {
+			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"segments": segments,
+	})
+}
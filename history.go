@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// historySampleInterval is how often the historian samples register values
+	historySampleInterval  = time.Second
+	defaultSparklinePoints = 60
+	maxSparklinePoints     = 500
+	// sparklineCacheTime bounds how long a sparkline response is reused, so
+	// dashboards refreshing many sparklines at once read the history store once
+	sparklineCacheTime = 2 * time.Second
+)
+
+// historySample is a numeric register value at a point in time
+type historySample struct {
+	t time.Time
+	v float64
+}
+
+// historySeries is a ring buffer of the samples of one register
+type historySeries struct {
+	name    string
+	samples []historySample
+	next    int // index the next sample is written to once the buffer is full
+}
+
+// historyKey identifies a register of a server
+type historyKey struct {
+	server  string
+	address uint16
+}
+
+// historyStore keeps the recent values of all configured numeric registers
+type historyStore struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	series    map[historyKey]*historySeries
+}
+
+// history is the running historian, nil when disabled (-history-retention 0)
+var history *historyStore
+
+// capacity returns the number of samples kept per register
+func (h *historyStore) capacity() int {
+	return max(int(h.retention/historySampleInterval), 1)
+}
+
+// add appends a sample to a register's series
+func (h *historyStore) add(key historyKey, name string, sample historySample) {
+	series, ok := h.series[key]
+	if !ok {
+		series = &historySeries{}
+		h.series[key] = series
+	}
+	series.name = name
+	if len(series.samples) < h.capacity() {
+		series.samples = append(series.samples, sample)
+		return
+	}
+	series.samples[series.next] = sample
+	series.next = (series.next + 1) % len(series.samples)
+}
+
+// each calls fn for the samples of a series, oldest first
+func (s *historySeries) each(fn func(historySample)) {
+	for i := range s.samples {
+		fn(s.samples[(s.next+i)%len(s.samples)])
+	}
+}
+
+// sample records the current value of every configured numeric register of
+// the servers that are receiving data. Registers of removed servers are dropped.
+func (h *historyStore) sample() {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	active := make(map[string]bool)
+	for _, server := range serverList {
+		active[server.ID] = true
+		server.mu.Lock()
+		if server.ConnectionStatus != "ok" || server.Paused {
+			server.mu.Unlock()
+			continue
+		}
+		for _, row := range server.registerData() {
+			addr := row["Address"].(uint16)
+			if _, configured := server.registerMap[addr]; !configured {
+				continue
+			}
+			value, numeric := toFloat(row["Value"])
+			if !numeric || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			h.add(historyKey{server.ID, addr}, row["Name"].(string), historySample{now, value})
+		}
+		server.mu.Unlock()
+	}
+	for key := range h.series {
+		if !active[key.server] {
+			delete(h.series, key)
+		}
+	}
+}
+
+// runHistory samples register values until the process exits
+func runHistory(retention time.Duration) {
+	h := &historyStore{retention: retention, series: make(map[historyKey]*historySeries)}
+	mu.Lock()
+	history = h
+	mu.Unlock()
+
+	ticker := time.NewTicker(historySampleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.sample()
+	}
+}
+
+// Sparkline is the bucketed history of one register. Buckets without samples are null.
+type Sparkline struct {
+	Server  string     `json:"server"`
+	Address uint16     `json:"address"`
+	Name    string     `json:"name"`
+	Values  []*float64 `json:"values"` // mean of each bucket, oldest first
+	Min     *float64   `json:"min"`    // of the bucket means, for scaling
+	Max     *float64   `json:"max"`
+}
+
+// bucket averages the samples of a series into points buckets of width
+// starting at from
+func (s *historySeries) bucket(from time.Time, width time.Duration, points int) []*float64 {
+	sums := make([]float64, points)
+	counts := make([]int, points)
+	s.each(func(sample historySample) {
+		if sample.t.Before(from) {
+			return
+		}
+		i := int(sample.t.Sub(from) / width)
+		if i < points {
+			sums[i] += sample.v
+			counts[i]++
+		}
+	})
+	values := make([]*float64, points)
+	for i := range values {
+		if counts[i] > 0 {
+			mean := sums[i] / float64(counts[i])
+			values[i] = &mean
+		}
+	}
+	return values
+}
+
+// sparklineResponse is a cached response of handleSparkline
+type sparklineResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+var (
+	sparklineCacheMu sync.Mutex
+	sparklineCache   = make(map[string]sparklineResponse) // by query string
+)
+
+// handleSparkline serves the recent history of many registers at once on GET
+// /api/history/sparkline?points=60&window=15m&register=plc1:40010&server=plc2.
+// register selects one register as server:address and server all configured
+// registers of a server; without either, every register with history is
+// returned. Buckets are aligned to multiples of their width so responses are
+// stable between refreshes, and identical requests within a short time are
+// served from a cache.
+func handleSparkline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	h := history
+	mu.RUnlock()
+	if h == nil {
+		handleError(w, r, "History is disabled (-history-retention 0)")
+		return
+	}
+
+	query := r.URL.Query()
+	points := defaultSparklinePoints
+	if s := query.Get("points"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSparklinePoints {
+			handleError(w, r, fmt.Sprintf("Invalid points: %q (must be 1 to %d)", s, maxSparklinePoints))
+			return
+		}
+		points = n
+	}
+	window := h.retention
+	if s := query.Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			handleError(w, r, fmt.Sprintf("Invalid window: %q", s))
+			return
+		}
+		window = min(d, h.retention)
+	}
+
+	keys := make(map[historyKey]bool)
+	for _, register := range query["register"] {
+		id, address, found := strings.Cut(register, ":")
+		addr, err := strconv.ParseUint(address, 10, 16)
+		if !found || err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid register: %q (must be server:address)", register))
+			return
+		}
+		keys[historyKey{id, uint16(addr)}] = true
+	}
+	serverIDs := make(map[string]bool)
+	for _, id := range query["server"] {
+		serverIDs[id] = true
+	}
+
+	cacheKey := r.URL.RawQuery
+	sparklineCacheMu.Lock()
+	cached, ok := sparklineCache[cacheKey]
+	sparklineCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(cached.body)
+		return
+	}
+
+	width := max(window/time.Duration(points), historySampleInterval)
+	now := time.Now()
+	from := now.Truncate(width).Add(width - time.Duration(points)*width)
+
+	h.mu.RLock()
+	sparklines := make([]Sparkline, 0, len(keys))
+	for key, series := range h.series {
+		if (len(keys) > 0 || len(serverIDs) > 0) && !keys[key] && !serverIDs[key.server] {
+			continue
+		}
+		line := Sparkline{Server: key.server, Address: key.address, Name: series.name, Values: series.bucket(from, width, points)}
+		for _, v := range line.Values {
+			if v == nil {
+				continue
+			}
+			if line.Min == nil || *v < *line.Min {
+				line.Min = v
+			}
+			if line.Max == nil || *v > *line.Max {
+				line.Max = v
+			}
+		}
+		sparklines = append(sparklines, line)
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(sparklines, func(i, j int) bool {
+		if sparklines[i].Server != sparklines[j].Server {
+			return sparklines[i].Server < sparklines[j].Server
+		}
+		return sparklines[i].Address < sparklines[j].Address
+	})
+
+	body, err := json.Marshal(map[string]interface{}{
+		"success":    true,
+		"from":       from,
+		"bucketMs":   width.Milliseconds(),
+		"points":     points,
+		"sparklines": sparklines,
+	})
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error encoding sparklines: %v", err))
+		return
+	}
+	body = append(body, '\n')
+
+	sparklineCacheMu.Lock()
+	for key, entry := range sparklineCache {
+		if now.After(entry.expires) {
+			delete(sparklineCache, key)
+		}
+	}
+	sparklineCache[cacheKey] = sparklineResponse{body, now.Add(min(width, sparklineCacheTime))}
+	sparklineCacheMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// HistorySeries holds the samples of one register
+type HistorySeries struct {
+	Server  string       `json:"server"`
+	Address uint16       `json:"address"`
+	Name    string       `json:"name"`
+	Samples [][2]float64 `json:"samples"` // [unix milliseconds, value], oldest first
+}
+
+// handleHistorySamples serves the raw samples of every register taken after
+// a point in time, to the millisecond, on GET
+// /api/history/samples?since=<RFC 3339 time>, or all kept samples without
+// since. A standby instance uses it to replicate the
+// history of its primary.
+func handleHistorySamples(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	h := history
+	mu.RUnlock()
+	if h == nil {
+		handleError(w, r, "History is disabled (-history-retention 0)")
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid since: %q", s))
+			return
+		}
+		since = t
+	}
+
+	h.mu.RLock()
+	list := make([]HistorySeries, 0, len(h.series))
+	for key, series := range h.series {
+		entry := HistorySeries{Server: key.server, Address: key.address, Name: series.name, Samples: [][2]float64{}}
+		series.each(func(sample historySample) {
+			if sample.t.UnixMilli() > since.UnixMilli() {
+				entry.Samples = append(entry.Samples, [2]float64{float64(sample.t.UnixMilli()), sample.v})
+			}
+		})
+		if len(entry.Samples) > 0 {
+			list = append(list, entry)
+		}
+	}
+	h.mu.RUnlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"series":  list,
+	})
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// defaultHookTimeout bounds how long a hook's command or call may take
+const defaultHookTimeout = 30 * time.Second
+
+// hookEvents lists the events hooks can run on
+var hookEvents = map[string]bool{
+	"connection-lost": true,
+	"reconnected":     true,
+}
+
+// HookConfig runs a shell command or calls a URL when a server loses or
+// regains its connection, for site automation such as power-cycling a
+// cellular router that stays unreachable. The URL and body are templates of
+// a hookContext; the command is run as written and gets the context in
+// MODBUS_* environment variables. Commands are only taken from the -config
+// file, never from an upload.
+type HookConfig struct {
+	Name    string   `json:"name"`
+	Event   string   `json:"event"`             // "connection-lost" or "reconnected"
+	Servers []string `json:"servers,omitempty"` // IDs of the servers the hook is for, all if empty
+	After   float64  `json:"after,omitempty"`   // seconds the connection must stay lost before the hook runs, for connection-lost
+	Command string   `json:"command,omitempty"` // run with sh -c, not a template
+	URL     string   `json:"url,omitempty"`     // called instead of running a command
+	Method  string   `json:"method,omitempty"`  // of the call, POST if empty
+	Body    string   `json:"body,omitempty"`    // of the call, sent as JSON; values must be printed with json
+	Timeout float64  `json:"timeout,omitempty"` // seconds, defaultHookTimeout if 0
+}
+
+// hookContext is what the URL and body templates of a hook are executed
+// with. Commands get it in MODBUS_* environment variables instead, which are
+// safe to use in the shell whatever the message holds.
+type hookContext struct {
+	Hook    string
+	Event   string
+	Server  string
+	Address string
+	Port    int
+	Message string
+	Time    time.Time // of the event
+	Since   time.Time // when the connection was lost
+	Down    float64   // seconds the connection has been lost
+}
+
+// hookState is a configured hook and the outcome of its last run
+type hookState struct {
+	hook      HookConfig
+	url       *template.Template
+	body      *template.Template
+	pending   map[string]*time.Timer // runs waiting for After, by server
+	runs      int
+	lastRun   time.Time
+	lastError string
+}
+
+var (
+	hooksMu        sync.Mutex
+	hooks          = make(map[string]*hookState) // by name
+	connectionLost = make(map[string]time.Time)  // servers whose connection is lost, and since when
+)
+
+// hookTemplateFuncs are the functions of hook templates. json encodes a
+// value as JSON, so that it can be placed in a body whatever it holds.
+var hookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseHookTemplates parses the URL and body templates of a hook. Every value
+// the body prints must go through json, as in {{.Message | json}}, so that a
+// quote or newline in a message cannot break the JSON or add fields to it.
+func parseHookTemplates(hook HookConfig) (url, body *template.Template, err error) {
+	parse := func(field, text string) *template.Template {
+		if text == "" || err != nil {
+			return nil
+		}
+		t, parseErr := template.New(field).Option("missingkey=error").Funcs(hookTemplateFuncs).Parse(text)
+		if parseErr != nil {
+			err = fmt.Errorf("%s: %v", field, parseErr)
+		}
+		return t
+	}
+	url = parse("url", hook.URL)
+	body = parse("body", hook.Body)
+	if body != nil && err == nil {
+		if action := unencodedAction(body.Root); action != "" {
+			err = fmt.Errorf("body: %s must be encoded with json, as in {{%s | json}}", action, strings.Trim(action, "{}"))
+		}
+	}
+	return url, body, err
+}
+
+// unencodedAction returns the first action of a template that prints a value
+// without passing it through json, or "" if there is none
+func unencodedAction(node parse.Node) string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return ""
+		}
+		for _, child := range n.Nodes {
+			if action := unencodedAction(child); action != "" {
+				return action
+			}
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			return "" // assigns a variable, prints nothing
+		}
+		last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+		if ident, ok := last.Args[0].(*parse.IdentifierNode); !ok || ident.Ident != "json" {
+			return n.String()
+		}
+	case *parse.IfNode:
+		return unencodedBranches(&n.BranchNode)
+	case *parse.RangeNode:
+		return unencodedBranches(&n.BranchNode)
+	case *parse.WithNode:
+		return unencodedBranches(&n.BranchNode)
+	}
+	return ""
+}
+
+func unencodedBranches(n *parse.BranchNode) string {
+	if action := unencodedAction(n.List); action != "" {
+		return action
+	}
+	return unencodedAction(n.ElseList)
+}
+
+// checkUploadedHooks returns an error if an upload has a command hook. A
+// command hook that is unchanged from the one of the same name in place,
+// from the -config file, is accepted, so a downloaded configuration can be
+// uploaded again.
+func checkUploadedHooks(configs []HookConfig) error {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	for _, hook := range configs {
+		if hook.Command == "" {
+			continue
+		}
+		if existing, ok := hooks[hook.Name]; !ok || !reflect.DeepEqual(existing.hook, hook) {
+			return fmt.Errorf("hook %s: commands can only be set in the -config file, not uploaded", hook.Name)
+		}
+	}
+	return nil
+}
+
+// startHooks adds hooks, replacing those of the same name. Command hooks are
+// only added if commands is set, for configuration read from local files;
+// others are left out, unless unchanged from those in place.
+func startHooks(configs []HookConfig, commands bool) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	for _, hook := range configs {
+		existing, ok := hooks[hook.Name]
+		if ok && reflect.DeepEqual(existing.hook, hook) {
+			continue
+		}
+		if hook.Command != "" && !commands {
+			logMessage(ErrorLevel, "Hook %s: commands can only be set in the -config file, left out", hook.Name)
+			continue
+		}
+		url, body, err := parseHookTemplates(hook)
+		if err != nil {
+			logMessage(ErrorLevel, "Hook %s: %v", hook.Name, err)
+			continue
+		}
+		if ok {
+			for _, timer := range existing.pending {
+				timer.Stop()
+			}
+		}
+		hooks[hook.Name] = &hookState{
+			hook:    hook,
+			url:     url,
+			body:    body,
+			pending: make(map[string]*time.Timer),
+		}
+		logMessage(InfoLevel, "Added hook %s on %s", hook.Name, hook.Event)
+	}
+}
+
+// hookConfigs returns the configured hooks, for the exported configuration
+func hookConfigs() []HookConfig {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	configs := make([]HookConfig, 0, len(hooks))
+	for _, state := range hooks {
+		configs = append(configs, state.hook)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs
+}
+
+// applies reports whether the hook is for a server
+func (h HookConfig) applies(serverID string) bool {
+	return len(h.Servers) == 0 || slices.Contains(h.Servers, serverID)
+}
+
+// timeout returns how long the hook's command or call may take
+func (h HookConfig) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return time.Duration(h.Timeout * float64(time.Second))
+	}
+	return defaultHookTimeout
+}
+
+// runConnectionHooks runs the hooks of a connection event of a server, or
+// schedules those that wait for the connection to stay lost. A reconnection
+// cancels the waiting runs. The caller must hold s.mu.
+func (s *ModbusServer) runConnectionHooks(e Event) {
+	ctx := hookContext{
+		Event:   e.Type,
+		Server:  s.ID,
+		Address: s.Address,
+		Port:    s.Port,
+		Message: e.Message,
+		Time:    e.Time,
+	}
+	if ctx.Time.IsZero() {
+		ctx.Time = time.Now()
+	}
+
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if e.Type == "connection-lost" {
+		connectionLost[s.ID] = ctx.Time
+		ctx.Since = ctx.Time
+	} else {
+		ctx.Since = connectionLost[s.ID]
+		ctx.Down = ctx.Time.Sub(ctx.Since).Seconds()
+		delete(connectionLost, s.ID)
+		for _, state := range hooks {
+			if timer, ok := state.pending[s.ID]; ok {
+				timer.Stop()
+				delete(state.pending, s.ID)
+			}
+		}
+	}
+
+	for _, state := range hooks {
+		if state.hook.Event != e.Type || !state.hook.applies(s.ID) {
+			continue
+		}
+		ctx := ctx
+		ctx.Hook = state.hook.Name
+		if e.Type == "connection-lost" && state.hook.After > 0 {
+			state.wait(ctx)
+			continue
+		}
+		go state.run(ctx)
+	}
+}
+
+// wait runs the hook once the connection of its context's server has stayed
+// lost for After seconds. The caller must hold hooksMu.
+func (h *hookState) wait(ctx hookContext) {
+	after := time.Duration(h.hook.After * float64(time.Second))
+	if timer, ok := h.pending[ctx.Server]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(after, func() {
+		hooksMu.Lock()
+		current := h.pending[ctx.Server] == timer && connectionLost[ctx.Server].Equal(ctx.Since)
+		if current {
+			delete(h.pending, ctx.Server)
+		}
+		hooksMu.Unlock()
+		if current {
+			ctx.Down = time.Since(ctx.Since).Seconds()
+			h.run(ctx)
+		}
+	})
+	h.pending[ctx.Server] = timer
+}
+
+// run runs the hook's command or call and records the outcome. Hooks of
+// servers whose notifications are shelved do not run.
+func (h *hookState) run(ctx hookContext) {
+	if shelf := shelved(Event{Type: ctx.Event, ServerID: ctx.Server}); shelf != nil {
+		logMessage(InfoLevel, "Hook %s for server %s suppressed, %s shelved until %s", h.hook.Name, ctx.Server, shelfName(shelf), shelf.Until.Format(time.RFC3339))
+		return
+	}
+
+	var err error
+	if h.hook.URL != "" {
+		err = h.call(ctx)
+	} else {
+		err = h.exec(ctx)
+	}
+
+	hooksMu.Lock()
+	h.runs++
+	h.lastRun = time.Now()
+	h.lastError = ""
+	if err != nil {
+		h.lastError = err.Error()
+	}
+	hooksMu.Unlock()
+
+	if err != nil {
+		logMessage(ErrorLevel, "Hook %s for server %s: %v", h.hook.Name, ctx.Server, err)
+		return
+	}
+	logMessage(InfoLevel, "Ran hook %s for server %s on %s", h.hook.Name, ctx.Server, ctx.Event)
+}
+
+// expandHook executes a template of the hook, or returns "" if it has none
+func expandHook(t *template.Template, ctx hookContext) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// exec runs the hook's command with sh -c. The context is only passed in
+// the environment, never pasted into the command line.
+func (h *hookState) exec(ctx hookContext) error {
+	timeout, cancel := context.WithTimeout(context.Background(), h.hook.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(timeout, "sh", "-c", h.hook.Command)
+	cmd.Env = append(os.Environ(),
+		"MODBUS_HOOK="+ctx.Hook,
+		"MODBUS_EVENT="+ctx.Event,
+		"MODBUS_SERVER="+ctx.Server,
+		"MODBUS_ADDRESS="+ctx.Address,
+		"MODBUS_PORT="+strconv.Itoa(ctx.Port),
+		"MODBUS_MESSAGE="+ctx.Message,
+		"MODBUS_DOWN="+strconv.FormatFloat(ctx.Down, 'f', 0, 64),
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if text := strings.TrimSpace(string(output)); text != "" {
+			const maxOutput = 500
+			if len(text) > maxOutput {
+				text = text[:maxOutput] + "..."
+			}
+			return fmt.Errorf("%v: %s", err, text)
+		}
+		return err
+	}
+	return nil
+}
+
+// call sends the hook's HTTP request
+func (h *hookState) call(ctx hookContext) error {
+	target, err := expandHook(h.url, ctx)
+	if err != nil {
+		return err
+	}
+	body, err := expandHook(h.body, ctx)
+	if err != nil {
+		return err
+	}
+	method := h.hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	timeout, cancel := context.WithTimeout(context.Background(), h.hook.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(timeout, method, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, target, resp.Status)
+	}
+	return nil
+}
+
+// handleHooks lists the hooks and the outcome of their last run on GET /api/hooks
+func handleHooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hooksMu.Lock()
+	list := make([]map[string]interface{}, 0, len(hooks))
+	for _, state := range hooks {
+		status := map[string]interface{}{
+			"hook": state.hook,
+			"runs": state.runs,
+		}
+		if !state.lastRun.IsZero() {
+			status["lastRun"] = state.lastRun
+		}
+		if state.lastError != "" {
+			status["lastError"] = state.lastError
+		}
+		if len(state.pending) > 0 {
+			waiting := make([]string, 0, len(state.pending))
+			for id := range state.pending {
+				waiting = append(waiting, id)
+			}
+			sort.Strings(waiting)
+			status["waiting"] = waiting // servers whose connection is lost, until After has passed
+		}
+		list = append(list, status)
+	}
+	hooksMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["hook"].(HookConfig).Name < list[j]["hook"].(HookConfig).Name
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "hooks": list})
+}
+
+// checkHooks applies the semantic rules to the hooks
+func (v *configValidator) checkHooks(configs []HookConfig) {
+	seen := make(map[string]bool)
+	for i, hook := range configs {
+		path := fmt.Sprintf("hooks[%d]", i)
+		switch {
+		case hook.Name == "":
+			v.fail(path+".name", "name is required")
+		case seen[hook.Name]:
+			v.fail(path+".name", fmt.Sprintf("duplicate hook name %q", hook.Name))
+		}
+		seen[hook.Name] = true
+		if !hookEvents[hook.Event] {
+			v.fail(path+".event", fmt.Sprintf("unknown event %q (must be connection-lost or reconnected)", hook.Event))
+		}
+		switch {
+		case hook.Command == "" && hook.URL == "":
+			v.fail(path, "a command or a url is required")
+		case hook.Command != "" && hook.URL != "":
+			v.fail(path, "command and url cannot both be set")
+		}
+		if hook.URL != "" && !strings.Contains(hook.URL, "{{") {
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.fail(path+".url", fmt.Sprintf("url %q must be an http or https URL", hook.URL))
+			}
+		}
+		if hook.Method != "" && hook.URL == "" {
+			v.warn(path+".method", "method only applies to a url")
+		}
+		if hook.After < 0 {
+			v.fail(path+".after", "after must not be negative")
+		} else if hook.After > 0 && hook.Event != "connection-lost" {
+			v.fail(path+".after", "after only applies to connection-lost")
+		}
+		if hook.Timeout < 0 {
+			v.fail(path+".timeout", "timeout must not be negative")
+		}
+		if _, _, err := parseHookTemplates(hook); err != nil {
+			v.fail(path, err.Error())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHookBodyJSON(t *testing.T) {
+	hook := HookConfig{
+		Name: "notify",
+		URL:  "https://example.com/hook?server={{.Server | urlquery}}",
+		Body: `{"server": {{.Server | json}}, "message": {{.Message | json}}, "port": {{.Port | json}}{{if .Down}}, "down": {{.Down | json}}{{end}}}`,
+	}
+	_, body, err := parseHookTemplates(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := hookContext{Server: `plc "1"`, Message: "timeout\n\", \"admin\": true", Port: 502, Down: 12.5}
+	text, err := expandHook(body, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &got); err != nil {
+		t.Fatalf("body %s is not JSON: %v", text, err)
+	}
+	if got["server"] != ctx.Server || got["message"] != ctx.Message || got["port"] != 502.0 || got["down"] != 12.5 {
+		t.Errorf("body = %v", got)
+	}
+	if _, injected := got["admin"]; injected {
+		t.Error("message added a field to the body")
+	}
+}
+
+func TestHookBodyUnencoded(t *testing.T) {
+	tests := []string{
+		`{"server": "{{.Server}}"}`,
+		`{"down": {{printf "%.0f" .Down}}}`,
+		`{{if .Message}}{"message": "{{.Message}}"}{{end}}`,
+		`{{range $i, $s := .Hook}}{{$s}}{{end}}`,
+	}
+	for _, text := range tests {
+		_, _, err := parseHookTemplates(HookConfig{Name: "notify", URL: "https://example.com/", Body: text})
+		if err == nil || !strings.Contains(err.Error(), "must be encoded with json") {
+			t.Errorf("body %s: error = %v, want an encoding error", text, err)
+		}
+	}
+
+	// Assignments print nothing, and conditions are not printed
+	ok := `{{$name := .Server}}{{if eq .Event "reconnected"}}{"server": {{$name | json}}}{{end}}`
+	if _, _, err := parseHookTemplates(HookConfig{Name: "notify", URL: "https://example.com/", Body: ok}); err != nil {
+		t.Errorf("body %s: %v", ok, err)
+	}
+}
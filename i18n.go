@@ -0,0 +1,137 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultLanguage is the language the UI is written in; it needs no locale file
+const defaultLanguage = "en"
+
+// langCookie holds the language a user picked in the UI
+const langCookie = "lang"
+
+//go:embed locales
+var localeFiles embed.FS
+
+// locale translates the UI strings of a language. Strings it has no
+// translation for are shown in English.
+type locale struct {
+	Name    string            `json:"name"`    // of the language in itself, e.g. "Deutsch"
+	Strings map[string]string `json:"strings"` // English text to translated text
+}
+
+var (
+	// locales holds the embedded locale files by language code
+	locales = map[string]*locale{defaultLanguage: {Name: "English"}}
+	// uiLanguage is the language of users who have not picked one, set with -lang
+	uiLanguage = defaultLanguage
+	// localizedTemplates holds a copy of the HTML templates per language
+	localizedTemplates = map[string]*template.Template{}
+)
+
+// Language is a language the UI can be shown in
+type Language struct {
+	Code string
+	Name string
+}
+
+// loadLocales reads the embedded locale files, sets the default language of
+// the UI and prepares the HTML templates for every language. It must run
+// after the templates are parsed and before they are first executed.
+func loadLocales(lang string) error {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return err
+		}
+		var l locale
+		if err := json.Unmarshal(data, &l); err != nil {
+			return fmt.Errorf("locale %s: %v", file.Name(), err)
+		}
+		locales[strings.TrimSuffix(file.Name(), path.Ext(file.Name()))] = &l
+	}
+
+	if _, ok := locales[lang]; !ok {
+		return fmt.Errorf("unknown language %q (available: %s)", lang, strings.Join(languageCodes(), ", "))
+	}
+	uiLanguage = lang
+
+	for code := range locales {
+		clone, err := templates.Clone()
+		if err != nil {
+			return err
+		}
+		localizedTemplates[code] = clone.Funcs(translateFuncs(code))
+	}
+	// Reports and other output not tied to a request use the default language
+	templates = localizedTemplates[uiLanguage]
+	return nil
+}
+
+// languageCodes returns the codes of all available languages, sorted
+func languageCodes() []string {
+	codes := make([]string, 0, len(locales))
+	for code := range locales {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// languages returns all available languages, sorted by code
+func languages() []Language {
+	list := make([]Language, 0, len(locales))
+	for _, code := range languageCodes() {
+		list = append(list, Language{Code: code, Name: locales[code].Name})
+	}
+	return list
+}
+
+// translate returns the text in a language, or in English if the language
+// has no translation for it
+func translate(lang, text string) string {
+	if l, ok := locales[lang]; ok {
+		if translated, ok := l.Strings[text]; ok && translated != "" {
+			return translated
+		}
+	}
+	return text
+}
+
+// translateFuncs returns the template functions translating into a language:
+// {{t "Add Server"}}
+func translateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(text string) string { return translate(lang, text) },
+	}
+}
+
+// requestLanguage returns the language picked by the user making a request,
+// or the default language
+func requestLanguage(r *http.Request) string {
+	if cookie, err := r.Cookie(langCookie); err == nil {
+		if _, ok := locales[cookie.Value]; ok {
+			return cookie.Value
+		}
+	}
+	return uiLanguage
+}
+
+// templatesFor returns the HTML templates in the language of a request
+func templatesFor(r *http.Request) *template.Template {
+	if t, ok := localizedTemplates[requestLanguage(r)]; ok {
+		return t
+	}
+	return templates
+}
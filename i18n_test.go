@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// TestLocaleVerbs checks that translations of texts filled in with printf
+// keep the verbs of the English text, in the same order
+func TestLocaleVerbs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var l locale
+		if err := json.Unmarshal(data, &l); err != nil {
+			t.Fatalf("%s: %v", file.Name(), err)
+		}
+		for text, translated := range l.Strings {
+			if want, got := verbs.FindAllString(text, -1), verbs.FindAllString(translated, -1); !reflect.DeepEqual(want, got) {
+				t.Errorf("%s: %q has verbs %v, want %v as in %q", file.Name(), translated, got, want, text)
+			}
+		}
+	}
+}
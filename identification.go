@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rustyoz/modbus"
+)
+
+// Read Device Identification (function code 43, MEI type 14)
+const (
+	funcCodeEncapsulatedInterface = 0x2B
+	meiReadDeviceID               = 0x0E
+	readDeviceIDBasic             = 0x01 // vendor name, product code and revision
+	readDeviceIDRegular           = 0x02 // and vendor URL, product name, model name and application name
+	// maxDeviceIDRequests bounds the requests for a device that keeps
+	// announcing more objects
+	maxDeviceIDRequests = 8
+)
+
+// DeviceIdentification holds the identification objects a device reports
+type DeviceIdentification struct {
+	VendorName          string `json:"vendorName,omitempty"`
+	ProductCode         string `json:"productCode,omitempty"`
+	Revision            string `json:"revision,omitempty"`
+	VendorURL           string `json:"vendorUrl,omitempty"`
+	ProductName         string `json:"productName,omitempty"`
+	ModelName           string `json:"modelName,omitempty"`
+	UserApplicationName string `json:"userApplicationName,omitempty"`
+}
+
+// set stores an identification object by its ID
+func (id *DeviceIdentification) set(object byte, value string) {
+	switch object {
+	case 0x00:
+		id.VendorName = value
+	case 0x01:
+		id.ProductCode = value
+	case 0x02:
+		id.Revision = value
+	case 0x03:
+		id.VendorURL = value
+	case 0x04:
+		id.ProductName = value
+	case 0x05:
+		id.ModelName = value
+	case 0x06:
+		id.UserApplicationName = value
+	}
+}
+
+// identifyingDevice is implemented by devices that can be asked for their
+// vendor and product
+type identifyingDevice interface {
+	readDeviceIdentification() (*DeviceIdentification, error)
+}
+
+// readDeviceIdentification asks a device for its identification, and
+// returns an error if its protocol or the device does not support it
+func readDeviceIdentification(device Device) (*DeviceIdentification, error) {
+	d, ok := device.(identifyingDevice)
+	if !ok {
+		return nil, errors.New("the protocol does not support device identification")
+	}
+	return d.readDeviceIdentification()
+}
+
+func (d *gatewayDevice) readDeviceIdentification() (*DeviceIdentification, error) {
+	defer d.turn()()
+	return readDeviceIdentification(d.Device)
+}
+
+func (d *trackedDevice) readDeviceIdentification() (*DeviceIdentification, error) {
+	d.begin()
+	id, err := readDeviceIdentification(d.Device)
+	d.end(err)
+	return id, err
+}
+
+// readDeviceIdentification reads the regular identification objects of the
+// device, or the basic ones if it only has those. The library has no
+// function for it, so the request goes through the handler directly.
+func (c *ModbusClient) readDeviceIdentification() (*DeviceIdentification, error) {
+	id, err := c.readDeviceIDObjects(readDeviceIDRegular)
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &modbusErr) {
+		id, err = c.readDeviceIDObjects(readDeviceIDBasic)
+	}
+	return id, err
+}
+
+// readDeviceIDObjects reads the objects of a category, following the
+// device's "more follows" flag over as many responses as it needs
+func (c *ModbusClient) readDeviceIDObjects(category byte) (*DeviceIdentification, error) {
+	if _, ok := c.handler.(*rtuOverTCPHandler); ok {
+		return nil, errors.New("device identification is not supported over modbus-rtu-over-tcp")
+	}
+
+	id := &DeviceIdentification{}
+	object := byte(0)
+	for range maxDeviceIDRequests {
+		data, err := c.send(&modbus.ProtocolDataUnit{
+			FunctionCode: funcCodeEncapsulatedInterface,
+			Data:         []byte{meiReadDeviceID, category, object},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// MEI type, category, conformity level, more follows, next object ID
+		// and number of objects, then ID, length and value of each object
+		if len(data) < 6 || data[0] != meiReadDeviceID {
+			return nil, fmt.Errorf("modbus: invalid device identification response % X", data)
+		}
+		more, next, count := data[3], data[4], int(data[5])
+		rest := data[6:]
+		for range count {
+			if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+				return nil, errors.New("modbus: device identification response is truncated")
+			}
+			id.set(rest[0], string(rest[2:2+int(rest[1])]))
+			rest = rest[2+int(rest[1]):]
+		}
+		if more != 0xFF || next <= object {
+			return id, nil
+		}
+		object = next
+	}
+	return id, nil
+}
+
+// send sends a request PDU through the handler and returns the data of the
+// response, or the exception the device answered with
+func (c *ModbusClient) send(request *modbus.ProtocolDataUnit) ([]byte, error) {
+	aduRequest, err := c.handler.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	aduResponse, err := c.handler.Send(aduRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.handler.Verify(aduRequest, aduResponse); err != nil {
+		return nil, err
+	}
+	response, err := c.handler.Decode(aduResponse)
+	if err != nil {
+		return nil, err
+	}
+	if response.FunctionCode != request.FunctionCode {
+		if response.FunctionCode == request.FunctionCode|0x80 && len(response.Data) > 0 {
+			return nil, &modbus.ModbusError{FunctionCode: response.FunctionCode, ExceptionCode: response.Data[0]}
+		}
+		return nil, fmt.Errorf("modbus: response function code '%v' does not match request '%v'", response.FunctionCode, request.FunctionCode)
+	}
+	return response.Data, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// journalFile is the name of the journal in the -journal directory
+const journalFile = "journal.json"
+
+// Journal is the state of the instance written to disk by -journal, from
+// which it restarts after a crash or a power loss
+type Journal struct {
+	// Configuration, as from /api/config
+	Config json.RawMessage `json:"config"`
+	// Last values read from each server, by server ID
+	Values map[string]*JournalValues `json:"values,omitempty"`
+	// Shelved notifications
+	Shelves []Shelf `json:"shelves,omitempty"`
+	// Active alarms, so they are not raised and notified again
+	Alarms []Alarm `json:"alarms,omitempty"`
+}
+
+// JournalValues are the last values read from a server
+type JournalValues struct {
+	LastDataReceived time.Time            `json:"lastDataReceived"`
+	Blocks           []JournalBlock       `json:"blocks"`
+	LastChange       map[uint16]time.Time `json:"lastChange,omitempty"`
+}
+
+// JournalBlock holds the values of a register block: bits for coils and
+// discrete inputs, words for registers
+type JournalBlock struct {
+	StartAddress uint16   `json:"startAddress"`
+	Bits         []bool   `json:"bits,omitempty"`
+	Words        []uint16 `json:"words,omitempty"`
+}
+
+// journalState returns the journal of the running instance. Servers are
+// encoded one at a time under their own lock rather than all at once.
+func journalState() (*Journal, error) {
+	journal := &Journal{Values: make(map[string]*JournalValues)}
+
+	mu.RLock()
+	list := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		list = append(list, server)
+	}
+	mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	config := struct {
+		ConfigFile
+		Servers []json.RawMessage `json:"servers"` // replaces ConfigFile.Servers
+	}{
+		ConfigFile: ConfigFile{SchemaVersion: currentConfigVersion},
+		Servers:    make([]json.RawMessage, 0, len(list)),
+	}
+	for _, server := range list {
+		server.mu.Lock()
+		data, err := json.Marshal(server)
+		values := server.journalValues()
+		server.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		config.Servers = append(config.Servers, data)
+		if values != nil {
+			journal.Values[server.ID] = values
+		}
+	}
+
+	mapTemplatesMu.RLock()
+	if len(mapTemplates) > 0 {
+		config.Templates = mapTemplates
+	}
+	config.Exports = exportJobConfigs()
+	config.Mirrors = mirrorConfigs()
+	config.Hooks = hookConfigs()
+	data, err := json.Marshal(config)
+	mapTemplatesMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	journal.Config = data
+
+	shelvesMu.Lock()
+	now := time.Now()
+	for _, shelf := range shelves {
+		if now.Before(shelf.Until) {
+			journal.Shelves = append(journal.Shelves, *shelf)
+		}
+	}
+	shelvesMu.Unlock()
+	sort.Slice(journal.Shelves, func(i, j int) bool {
+		a, b := journal.Shelves[i].keyOf(), journal.Shelves[j].keyOf()
+		if a.server != b.server {
+			return a.server < b.server
+		}
+		return a.address < b.address
+	})
+	if alarms := activeAlarms(); len(alarms) > 0 {
+		journal.Alarms = alarms
+	}
+	return journal, nil
+}
+
+// journalValues returns the values of the blocks that were read, or
+// restored and not read since, nil if there are none. The caller must hold
+// s.mu.
+func (s *ModbusServer) journalValues() *JournalValues {
+	values := &JournalValues{LastDataReceived: s.LastDataReceived, LastChange: make(map[uint16]time.Time, len(s.lastChange))}
+	for addr, t := range s.lastChange {
+		values.LastChange[addr] = t
+	}
+	for _, block := range s.RegisterBlocks {
+		status := s.blockStatus[block.StartAddress]
+		read := status != nil && !status.LastSuccess.IsZero()
+		if !read && !s.restored[block.StartAddress] {
+			continue
+		}
+		saved := JournalBlock{StartAddress: block.StartAddress}
+		start, end := int(block.StartAddress), int(block.StartAddress)+int(block.Length)
+		switch {
+		case end > 50000:
+			continue
+		case start < 10000 && end <= 10000:
+			saved.Bits = append([]bool(nil), s.dataModel.Coils[start:end]...)
+		case start >= 10000 && end <= 20000:
+			saved.Bits = append([]bool(nil), s.dataModel.DiscreteInputs[start-10000:end-10000]...)
+		case start >= 30000 && end <= 40000:
+			saved.Words = append([]uint16(nil), s.dataModel.InputRegisters[start-30000:end-30000]...)
+		case start >= 40000:
+			saved.Words = append([]uint16(nil), s.dataModel.HoldingRegisters[start-40000:end-40000]...)
+		default:
+			continue // spans two tables
+		}
+		values.Blocks = append(values.Blocks, saved)
+	}
+	if len(values.Blocks) == 0 {
+		return nil
+	}
+	return values
+}
+
+// restoreValues puts the values of a journal back in the data model of a
+// server about to start. They are shown as stale until their block is read.
+func (s *ModbusServer) restoreValues(values *JournalValues) {
+	s.LastDataReceived = values.LastDataReceived
+	s.lastChange = values.LastChange
+	s.restored = make(map[uint16]bool)
+	for _, block := range values.Blocks {
+		start := int(block.StartAddress)
+		switch {
+		case start < 10000 && start+len(block.Bits) <= 10000:
+			copy(s.dataModel.Coils[start:], block.Bits)
+		case start >= 10000 && start < 20000 && start+len(block.Bits) <= 20000:
+			copy(s.dataModel.DiscreteInputs[start-10000:], block.Bits)
+		case start >= 30000 && start < 40000 && start+len(block.Words) <= 40000:
+			copy(s.dataModel.InputRegisters[start-30000:], block.Words)
+		case start >= 40000 && start+len(block.Words) <= 50000:
+			copy(s.dataModel.HoldingRegisters[start-40000:], block.Words)
+		default:
+			continue
+		}
+		s.restored[block.StartAddress] = true
+	}
+}
+
+// restoreAlarms makes the journaled alarms of a server about to start active
+// again. They are not notified again, and are cleared as usual once their
+// register is read within its limits.
+func (s *ModbusServer) restoreAlarms(alarms []Alarm) {
+	for i := range alarms {
+		alarm := alarms[i]
+		if alarm.ServerID != s.ID || alarm.Cleared != nil {
+			continue
+		}
+		if s.alarms == nil {
+			s.alarms = make(map[uint16]*Alarm)
+		}
+		s.alarms[alarm.Address] = &alarm
+		alarmLogMu.Lock()
+		alarmLog = append(alarmLog, &alarm)
+		alarmLogMu.Unlock()
+	}
+}
+
+// writeJournal replaces the journal in dir. It is written to a temporary
+// file and synced before it is renamed over the previous one, so a power
+// loss leaves either the old or the new journal, never a partial one.
+func writeJournal(dir string, data []byte) error {
+	path := filepath.Join(dir, journalFile)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself survives a power loss
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// runJournal writes the state of the instance to the journal in dir every
+// interval, skipping the write when nothing changed so an idle instance
+// does not wear out the flash storage of an edge box
+func runJournal(dir string, interval time.Duration) {
+	var last []byte
+	failing := false
+	for range time.Tick(interval) {
+		journal, err := journalState()
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(journal)
+		}
+		if err == nil && bytes.Equal(data, last) {
+			continue
+		}
+		if err == nil {
+			err = writeJournal(dir, data)
+		}
+		if err != nil {
+			// Log once until the journal can be written again
+			if !failing {
+				logMessage(ErrorLevel, "Error writing journal: %v", err)
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			logMessage(ErrorLevel, "Journal written again")
+		}
+		failing = false
+		last = data
+	}
+}
+
+// restoreJournal starts the servers, values, shelves and alarms of the
+// journal in dir. It returns false if there is no journal, or if configFile,
+// the -config file, was changed after the journal was written and is to be
+// loaded instead. A journal that cannot be read is moved aside so the
+// instance still starts.
+func restoreJournal(dir, configFile string) (bool, error) {
+	path := filepath.Join(dir, journalFile)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if configFile != "" {
+		if config, err := os.Stat(configFile); err == nil && config.ModTime().After(info.ModTime()) {
+			log.Printf("%s changed since the journal was written at %s; loading it instead", configFile, info.ModTime().Format(time.RFC3339))
+			return false, nil
+		}
+	}
+
+	var journal Journal
+	var config ConfigFile
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &journal)
+	}
+	if err == nil {
+		var migrated []byte
+		migrated, _, err = migrateConfig(journal.Config)
+		if err == nil {
+			err = json.Unmarshal(migrated, &config)
+		}
+	}
+	if err != nil {
+		bad := path + ".bad"
+		if renameErr := os.Rename(path, bad); renameErr != nil {
+			return false, fmt.Errorf("journal %s is not valid (%v) and cannot be moved aside: %v", path, err, renameErr)
+		}
+		logMessage(ErrorLevel, "Journal %s is not valid, moved to %s: %v", path, bad, err)
+		return false, nil
+	}
+
+	shelvesMu.Lock()
+	now := time.Now()
+	for i := range journal.Shelves {
+		shelf := journal.Shelves[i]
+		if now.Before(shelf.Until) {
+			shelves[shelf.keyOf()] = &shelf
+		}
+	}
+	shelvesMu.Unlock()
+
+	startConfig(config, func(server *ModbusServer) {
+		if values, ok := journal.Values[server.ID]; ok {
+			server.restoreValues(values)
+		}
+		server.restoreAlarms(journal.Alarms)
+	})
+	log.Printf("Restored %d servers from the journal written at %s", len(config.Servers), info.ModTime().Format(time.RFC3339))
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+// alarmLogCount returns the number of logged alarms of a server
+func alarmLogCount(id string) int {
+	alarmLogMu.Lock()
+	defer alarmLogMu.Unlock()
+	n := 0
+	for _, alarm := range alarmLog {
+		if alarm.ServerID == id {
+			n++
+		}
+	}
+	return n
+}
+
+func TestJournalAlarms(t *testing.T) {
+	high := 100.0
+	blocks := []RegisterBlock{{StartAddress: 40001, Length: 1, Registers: []RegisterConfig{
+		{Address: 40001, Name: "Pressure", AlarmHigh: &high},
+	}}}
+	newServer := func() *ModbusServer {
+		return &ModbusServer{
+			ID: "journal-alarm", RegisterBlocks: blocks, registerMap: buildRegisterMap(blocks),
+			blockStatus: map[uint16]*BlockStatus{40001: {StartAddress: 40001, Length: 1, Status: "ok"}},
+		}
+	}
+
+	server := newServer()
+	server.dataModel.HoldingRegisters[1] = 120
+	server.checkAlarms()
+	mu.Lock()
+	servers[server.ID] = server
+	mu.Unlock()
+	journal, err := journalState()
+	mu.Lock()
+	delete(servers, server.ID)
+	mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.Alarms) != 1 || journal.Alarms[0].Address != 40001 || journal.Alarms[0].Limit != "high" {
+		t.Fatalf("journaled alarms = %+v", journal.Alarms)
+	}
+	raised := journal.Alarms[0].Raised
+
+	// After the restart the alarm is active, and a value still beyond the
+	// limit does not raise it again
+	restarted := newServer()
+	logged := alarmLogCount(restarted.ID)
+	restarted.restoreAlarms(journal.Alarms)
+	restarted.dataModel.HoldingRegisters[1] = 130
+	restarted.checkAlarms()
+	alarm := restarted.alarms[40001]
+	if alarm == nil || !alarm.Raised.Equal(raised) || alarm.Cleared != nil {
+		t.Fatalf("restored alarm = %+v", alarm)
+	}
+	if n := alarmLogCount(restarted.ID); n != logged+1 {
+		t.Errorf("%d alarms logged after the restart, want the restored one", n-logged)
+	}
+
+	restarted.dataModel.HoldingRegisters[1] = 90
+	restarted.checkAlarms()
+	if len(restarted.alarms) != 0 || alarm.Cleared == nil {
+		t.Errorf("alarm not cleared: %+v", alarm)
+	}
+}
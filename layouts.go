@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the register table column widths kept in an arrangement, in pixels
+const (
+	minColumnWidth = 30
+	maxColumnWidth = 2000
+)
+
+// Arrangement is how the monitoring screen is arranged, as saved in a
+// layout or remembered for a browser
+type Arrangement struct {
+	Collapsed []string       `json:"collapsed"` // IDs of servers whose tables are collapsed
+	Order     []string       `json:"order"`     // IDs of servers in display order; others follow in the usual order
+	Hidden    []string       `json:"hidden"`    // IDs of servers not shown
+	Widths    map[string]int `json:"widths"`    // register table column widths in pixels, by column key
+}
+
+// check verifies the column widths and replaces missing lists with empty ones
+func (a *Arrangement) check() error {
+	for key, width := range a.Widths {
+		if _, ok := findColumn(key); !ok {
+			return fmt.Errorf("unknown column %q", key)
+		}
+		if width < minColumnWidth || width > maxColumnWidth {
+			return fmt.Errorf("width of column %s must be between %d and %d pixels", key, minColumnWidth, maxColumnWidth)
+		}
+	}
+	if a.Collapsed == nil {
+		a.Collapsed = []string{}
+	}
+	if a.Order == nil {
+		a.Order = []string{}
+	}
+	if a.Hidden == nil {
+		a.Hidden = []string{}
+	}
+	if a.Widths == nil {
+		a.Widths = map[string]int{}
+	}
+	return nil
+}
+
+// Layout is a named arrangement of the monitoring screen
+type Layout struct {
+	Name string `json:"name"`
+	Arrangement
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	layoutsMu  sync.Mutex
+	layouts    = make(map[string]*Layout)
+	layoutFile string // file layouts are persisted to, in memory only if empty
+)
+
+// loadLayouts reads saved layouts from path. A missing file is not an error.
+func loadLayouts(path string) error {
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+
+	layoutFile = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []*Layout
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, layout := range saved {
+		if err := layout.check(); err != nil {
+			return fmt.Errorf("%s: layout %s: %v", path, layout.Name, err)
+		}
+		layouts[layout.Name] = layout
+	}
+	return nil
+}
+
+// saveLayouts writes all layouts to the layout file.
+// The caller must hold layoutsMu.
+func saveLayouts() error {
+	if layoutFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sortedLayouts(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(layoutFile, data, 0644)
+}
+
+// sortedLayouts returns the layouts ordered by name.
+// The caller must hold layoutsMu.
+func sortedLayouts() []*Layout {
+	list := make([]*Layout, 0, len(layouts))
+	for _, layout := range layouts {
+		list = append(list, layout)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// handleLayouts lists layouts on /api/layouts and gets, saves or deletes
+// a single layout on /api/layouts/{name}
+func handleLayouts(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/layouts"), "/")
+
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"layouts": sortedLayouts(),
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		layout, exists := layouts[name]
+		if !exists {
+			handleError(w, r, fmt.Sprintf("Layout not found: %s", name))
+			return
+		}
+		json.NewEncoder(w).Encode(layout)
+
+	case http.MethodPut, http.MethodPost:
+		var layout Layout
+		if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid layout: %v", err))
+			return
+		}
+		if err := layout.check(); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid layout: %v", err))
+			return
+		}
+		layout.Name = name
+		layout.Updated = time.Now()
+		layouts[name] = &layout
+
+		if err := saveLayouts(); err != nil {
+			handleError(w, r, fmt.Sprintf("Error saving layouts: %v", err))
+			return
+		}
+		logMessage(InfoLevel, "Saved layout %s", name)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"layout":  &layout,
+		})
+
+	case http.MethodDelete:
+		if _, exists := layouts[name]; !exists {
+			handleError(w, r, fmt.Sprintf("Layout not found: %s", name))
+			return
+		}
+		delete(layouts, name)
+
+		if err := saveLayouts(); err != nil {
+			handleError(w, r, fmt.Sprintf("Error saving layouts: %v", err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sessionCookie identifies a browser so its screen state survives page reloads
+const sessionCookie = "modbusbrowser_session"
+
+// maxSessions bounds the number of remembered browser sessions; the least
+// recently updated is forgotten first
+const maxSessions = 1000
+
+// SessionState is the current screen state of one browser
+type SessionState struct {
+	Layout string `json:"layout"` // selected layout, if any
+	Arrangement
+	Updated time.Time `json:"updated"`
+}
+
+// sessions holds the screen state per session cookie, guarded by layoutsMu
+var sessions = make(map[string]*SessionState)
+
+// sessionID returns the session of the request, issuing a new cookie if it has none
+func sessionID(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, nil
+}
+
+// handleSession gets or replaces the screen state of the requesting browser on /api/session
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	id, err := sessionID(w, r)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error creating session: %v", err))
+		return
+	}
+
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		state, exists := sessions[id]
+		if !exists {
+			state = &SessionState{}
+			state.check()
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"session": state,
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var state SessionState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid session state: %v", err))
+			return
+		}
+		if err := state.check(); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid session state: %v", err))
+			return
+		}
+		state.Updated = time.Now()
+		sessions[id] = &state
+		pruneSessions()
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// pruneSessions forgets the least recently updated sessions beyond maxSessions.
+// The caller must hold layoutsMu.
+func pruneSessions() {
+	for len(sessions) > maxSessions {
+		var oldest string
+		for id, state := range sessions {
+			if oldest == "" || state.Updated.Before(sessions[oldest].Updated) {
+				oldest = id
+			}
+		}
+		delete(sessions, oldest)
+	}
+}
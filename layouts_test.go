@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLayoutArrangement(t *testing.T) {
+	layoutsMu.Lock()
+	saved, savedFile := layouts, layoutFile
+	layouts, layoutFile = make(map[string]*Layout), filepath.Join(t.TempDir(), "layouts.json")
+	layoutsMu.Unlock()
+	defer func() {
+		layoutsMu.Lock()
+		layouts, layoutFile = saved, savedFile
+		layoutsMu.Unlock()
+	}()
+
+	put := func(name, body string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		handleLayouts(w, httptest.NewRequest(http.MethodPut, "/api/layouts/"+name, strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	resp := put("control-room", `{"collapsed": ["plc-3"], "order": ["plc-2", "plc-1"], "hidden": ["test-rig"], "widths": {"name": 240, "value": 90}}`)
+	if resp["success"] != true {
+		t.Fatalf("saving layout: %v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	handleLayouts(w, httptest.NewRequest(http.MethodGet, "/api/layouts/control-room", nil))
+	var layout Layout
+	if err := json.NewDecoder(w.Body).Decode(&layout); err != nil {
+		t.Fatal(err)
+	}
+	want := Arrangement{
+		Collapsed: []string{"plc-3"},
+		Order:     []string{"plc-2", "plc-1"},
+		Hidden:    []string{"test-rig"},
+		Widths:    map[string]int{"name": 240, "value": 90},
+	}
+	if layout.Name != "control-room" || !reflect.DeepEqual(layout.Arrangement, want) {
+		t.Errorf("layout = %+v, want %+v", layout, want)
+	}
+
+	// Layouts saved before order, visibility and widths existed still load
+	data, err := os.ReadFile(layoutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"widths"`) {
+		t.Errorf("layout file lacks the widths: %s", data)
+	}
+	old := filepath.Join(t.TempDir(), "old.json")
+	os.WriteFile(old, []byte(`[{"name": "night shift", "collapsed": ["plc-1"]}]`), 0644)
+	if err := loadLayouts(old); err != nil {
+		t.Fatal(err)
+	}
+	layoutsMu.Lock()
+	night := layouts["night shift"]
+	layoutsMu.Unlock()
+	if night == nil || night.Order == nil || night.Hidden == nil || night.Widths == nil || night.Collapsed[0] != "plc-1" {
+		t.Errorf("old layout loaded as %+v", night)
+	}
+
+	for _, body := range []string{
+		`{"widths": {"color": 100}}`,
+		`{"widths": {"name": 5}}`,
+		`{"widths": {"name": 5000}}`,
+		`{"order": "plc-1"}`,
+	} {
+		if resp := put("bad", body); resp["success"] != false {
+			t.Errorf("layout %s was accepted", body)
+		}
+	}
+}
+
+func TestSessionArrangement(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/session", strings.NewReader(`{"layout": "a", "hidden": ["plc-9"], "widths": {"address": 60}}`))
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "test-session"})
+	handleSession(w, req)
+	if !strings.Contains(w.Body.String(), `"success":true`) {
+		t.Fatalf("saving session: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/session", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "test-session"})
+	handleSession(w, req)
+	var resp struct {
+		Session SessionState `json:"session"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := Arrangement{Collapsed: []string{}, Order: []string{}, Hidden: []string{"plc-9"}, Widths: map[string]int{"address": 60}}
+	if resp.Session.Layout != "a" || !reflect.DeepEqual(resp.Session.Arrangement, want) {
+		t.Errorf("session = %+v, want %+v", resp.Session, want)
+	}
+
+	layoutsMu.Lock()
+	delete(sessions, "test-session")
+	layoutsMu.Unlock()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// mailer sends email through an SMTP server. The credentials, if the server
+// needs any, come from the SMTP_USERNAME and SMTP_PASSWORD environment
+// variables rather than flags, so they do not show up in the process list.
+type mailer struct {
+	server   string // host:port
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+// mailAttachment is a file attached to an email
+type mailAttachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// newMailer returns a mailer for a comma-separated list of recipients. from
+// defaults to modbusbrowser@<hostname>.
+func newMailer(server, from, to string) (*mailer, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, fmt.Errorf("invalid SMTP server %q (must be host:port)", server)
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipients: %v", err)
+	}
+	if from == "" {
+		hostname, _ := os.Hostname()
+		if hostname == "" {
+			hostname = "localhost"
+		}
+		from = "modbusbrowser@" + hostname
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender: %v", err)
+	}
+
+	m := &mailer{
+		server:   server,
+		from:     sender.Address,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+	}
+	for _, recipient := range recipients {
+		m.to = append(m.to, recipient.Address)
+	}
+	return m, nil
+}
+
+// send sends a plain text message with attachments to all recipients
+func (m *mailer) send(subject, body string, attachments []mailAttachment) error {
+	var msg bytes.Buffer
+	writer := multipart.NewWriter(&msg)
+	header := []string{
+		"From: " + m.from,
+		"To: " + strings.Join(m.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary(),
+	}
+	msg.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(part, []byte(body))
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.name})},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64Lines(part, attachment.data)
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, _ := net.SplitHostPort(m.server)
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	return smtp.SendMail(m.server, auth, m.from, m.to, msg.Bytes())
+}
+
+// writeBase64Lines writes data base64 encoded in lines of 76 characters, as
+// MIME requires
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// serveSMTP accepts one connection on l, speaks just enough SMTP for
+// smtp.SendMail and sends the recipients and message it received on ch
+func serveSMTP(l net.Listener, ch chan<- []string) {
+	conn, err := l.Accept()
+	if err != nil {
+		close(ch)
+		return
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 test ESMTP")
+	var received []string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			close(ch)
+			return
+		}
+		switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+		case "EHLO", "HELO", "MAIL":
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			received = append(received, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, _ := text.ReadDotBytes()
+			received = append(received, string(data))
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			ch <- received
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestMailerSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ch := make(chan []string, 1)
+	go serveSMTP(l, ch)
+
+	m, err := newMailer(l.Addr().String(), "Plant <plant@example.com>", "a@example.com, Shift Lead <b@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := bytes.Repeat([]byte("%PDF binary \x00\xFF "), 20)
+	err = m.send("Report für Linie 3", "Alarms: 2\n", []mailAttachment{
+		{name: "report.html", contentType: "text/html; charset=utf-8", data: []byte("<h1>Report</h1>")},
+		{name: "report.pdf", contentType: "application/pdf", data: pdf},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := <-ch
+	if len(received) != 3 || received[0] != "a@example.com" || received[1] != "b@example.com" {
+		t.Fatalf("received %q", received)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(received[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "Report für Linie 3" || msg.Header.Get("From") != "plant@example.com" {
+		t.Errorf("subject %q, from %q", subject, msg.Header.Get("From"))
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		encoded, _ := io.ReadAll(part)
+		for _, line := range strings.Fields(string(encoded)) {
+			if len(line) > 76 {
+				t.Errorf("line of %d characters", len(line))
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(encoded)), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part.FileName()+"|"+part.Header.Get("Content-Type")+"|"+string(data))
+	}
+	want := []string{
+		"|text/plain; charset=utf-8|Alarms: 2\n",
+		"report.html|text/html; charset=utf-8|<h1>Report</h1>",
+		"report.pdf|application/pdf|" + string(pdf),
+	}
+	if strings.Join(parts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("parts = %q, want %q", parts, want)
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	tests := []struct {
+		server, from, to string
+		ok               bool
+	}{
+		{"mail.example.com:587", "", "a@example.com", true},
+		{"mail.example.com", "", "a@example.com", false},
+		{"mail.example.com:25", "", "not an address", false},
+		{"mail.example.com:25", "bad sender", "a@example.com", false},
+	}
+	for _, tt := range tests {
+		m, err := newMailer(tt.server, tt.from, tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("newMailer(%q, %q, %q) error = %v", tt.server, tt.from, tt.to, err)
+		}
+		if err == nil && !strings.HasPrefix(m.from, "modbusbrowser@") {
+			t.Errorf("default sender %q", m.from)
+		}
+	}
+}
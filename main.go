@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"encoding/json"
 	"flag"
@@ -11,6 +12,7 @@ import (
 	"math"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -32,10 +34,11 @@ const (
 )
 
 var (
-	servers   = make(map[string]*ModbusServer)
-	mu        sync.RWMutex
-	logLevel  LogLevel
-	templates *template.Template
+	servers       = make(map[string]*ModbusServer)
+	addingServers = make(map[string]bool) // IDs of servers being added by POST /api/servers or the server import, guarded by mu
+	mu            sync.RWMutex
+	logLevel      LogLevel
+	templates     *template.Template
 )
 
 // logMessage logs a message if the current log level is sufficient
@@ -48,9 +51,83 @@ func logMessage(level LogLevel, format string, v ...interface{}) {
 // RegisterConfig represents the configuration for a register
 type RegisterConfig struct {
 	Name         string `json:"name"`
-	Format       string `json:"format"` // "decimal", "hex", "float", "boolean", "string-byte", "string-word"
+	Format       string `json:"format"` // "decimal", "hex", "int16", "uint32", "int32", "uint64", "int64", "float", "double", "boolean", "bitfield", "string-byte", "string-word", "unix-time", "datetime-bcd", "datetime-iec", "datetime-words"
 	Address      uint16 `json:"address"`
 	StringLength int    `json:"stringLength,omitempty"`
+	// Order of the bytes of the value in its words: "ABCD" (default),
+	// "CDAB", "BADC" or "DCBA"
+	ByteOrder string `json:"byteOrder,omitempty"`
+	// Expected value range used to flag suspect samples (not alarms)
+	ExpectedMin *float64 `json:"expectedMin,omitempty"`
+	ExpectedMax *float64 `json:"expectedMax,omitempty"`
+	// Seconds within which the value should change, e.g. for a heartbeat
+	// counter; staying constant for longer is reported as a flatline
+	ExpectedUpdate float64 `json:"expectedUpdate,omitempty"`
+	// Alarm limits: a value above AlarmHigh or below AlarmLow raises an alarm
+	AlarmLow  *float64 `json:"alarmLow,omitempty"`
+	AlarmHigh *float64 `json:"alarmHigh,omitempty"`
+	// Smoothing of noisy values over the last FilterSamples polls: "average" or "median"
+	Filter        string `json:"filter,omitempty"`
+	FilterSamples int    `json:"filterSamples,omitempty"`
+	// Included in parameter set capture and restore
+	Parameter   bool   `json:"parameter,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Documentation shown via the info icon next to the name
+	Note string `json:"note,omitempty"`
+	URL  string `json:"url,omitempty"` // e.g. the vendor manual page
+	// Exposed by the SNMP agent at the base OID + server OID + this OID
+	OID string `json:"oid,omitempty"`
+	// Writes are subject to the server's write policy
+	Critical bool `json:"critical,omitempty"`
+	// Colors of the value in the register table; the first matching rule applies
+	Colors []ColorRule `json:"colors,omitempty"`
+	// States shown for values of a status word, e.g. {"0": "Stopped", "1": "Running"}
+	Enum map[string]string `json:"enum,omitempty"`
+	// Named bits of a bitfield register, e.g. the flags of an alarm word
+	Bits []BitConfig `json:"bits,omitempty"`
+	// Seconds a write of on holds a momentary coil or boolean on before it is
+	// switched off again, e.g. for a start pushbutton
+	Pulse float64 `json:"pulse,omitempty"`
+	// Value source when the server uses the simulator protocol
+	Generator *GeneratorConfig `json:"generator,omitempty"`
+	// Changes the simulator makes when the register is written
+	Reactions []ReactionConfig `json:"reactions,omitempty"`
+}
+
+// registerFormats lists the supported RegisterConfig formats
+var registerFormats = map[string]bool{
+	"decimal":        true,
+	"hex":            true,
+	"int16":          true,
+	"uint32":         true,
+	"int32":          true,
+	"uint64":         true,
+	"int64":          true,
+	"float":          true,
+	"double":         true,
+	"boolean":        true,
+	"bitfield":       true,
+	"string-byte":    true,
+	"string-word":    true,
+	"unix-time":      true,
+	"datetime-bcd":   true,
+	"datetime-iec":   true,
+	"datetime-words": true,
+}
+
+// numericFormats lists the formats decoded to a number; registers without a
+// format are decimal
+var numericFormats = map[string]bool{
+	"":        true,
+	"decimal": true,
+	"int16":   true,
+	"uint32":  true,
+	"int32":   true,
+	"uint64":  true,
+	"int64":   true,
+	"float":   true,
+	"double":  true,
 }
 
 // RegisterBlock represents a block of registers to read
@@ -58,6 +135,8 @@ type RegisterBlock struct {
 	StartAddress uint16           `json:"startAddress"`
 	Length       uint16           `json:"length"`
 	Registers    []RegisterConfig `json:"registers"`
+	Disabled     bool             `json:"disabled,omitempty"` // kept in the configuration but not polled
+	UnitID       *uint8           `json:"unitId,omitempty"`   // unit ID of the device holding the block behind a bridge, defaultUnitID if nil
 }
 
 // ServerConfig represents the configuration for a Modbus server
@@ -71,7 +150,18 @@ type ServerConfig struct {
 
 // ConfigFile represents the entire configuration file
 type ConfigFile struct {
-	Servers []*ModbusServer `json:"servers"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Servers       []*ModbusServer `json:"servers"`
+	// Register maps per firmware version, for servers created from a template
+	Templates map[string]*MapTemplate `json:"templates,omitempty"`
+	// Scheduled exports of register values to files
+	Exports []ExportJob `json:"exports,omitempty"`
+	// Values read from one server and written to another
+	Mirrors []MirrorRule `json:"mirrors,omitempty"`
+	// Commands and calls run when servers lose or regain their connection
+	Hooks []HookConfig `json:"hooks,omitempty"`
+	// Values of ${NAME} placeholders, resolved when the file is uploaded
+	Variables map[string]string `json:"variables,omitempty"`
 }
 
 // ModbusDataModel represents the complete Modbus data model
@@ -89,15 +179,69 @@ type ModbusServer struct {
 	Port             int                       `json:"port"`
 	PollRate         int                       `json:"pollRate"`
 	RegisterBlocks   []RegisterBlock           `json:"registerBlocks"`
-	client           *ModbusClient             `json:"-"`
+	Columns          []string                  `json:"columns,omitempty"`       // register table columns, defaultColumns if empty
+	OID              string                    `json:"oid,omitempty"`           // SNMP OID prefix for the server's registers
+	Protocol         string                    `json:"protocol,omitempty"`      // defaultProtocol if empty
+	Paused           bool                      `json:"paused,omitempty"`        // polling suspended
+	Connection       string                    `json:"connection,omitempty"`    // persistent if empty, on-demand or disconnected
+	WritePolicy      string                    `json:"writePolicy,omitempty"`   // for critical registers, defaultWritePolicy if empty
+	MaxBlockGap      uint16                    `json:"maxBlockGap,omitempty"`   // unused addresses read to merge two blocks into one request
+	Template         string                    `json:"template,omitempty"`      // register map the server was created from, for fleet-wide changes
+	Variant          string                    `json:"variant,omitempty"`       // firmware variant of the template in use
+	BackupAddress    string                    `json:"backupAddress,omitempty"` // redundant path to the same device, used after sustained errors
+	BackupPort       int                       `json:"backupPort,omitempty"`    // Port if 0
+	Gateway          string                    `json:"gateway,omitempty"`       // shared gateway whose requests are scheduled in turn with other servers
+	SourceAddress    string                    `json:"sourceAddress,omitempty"` // local IP address or interface to connect from, on hosts with several networks
+	Timeout          int                       `json:"timeout,omitempty"`       // for connecting and each response in ms, defaultTimeout if 0
+	SkipOverrun      bool                      `json:"skipOverrun,omitempty"`   // skip the tick after a poll cycle that took longer than the poll interval
+	AutoSplit        bool                      `json:"autoSplit,omitempty"`     // find the readable parts of blocks failing with Illegal Data Address
+	Timezone         string                    `json:"timezone,omitempty"`      // IANA name dates and times are shown in, the host's if empty
+	Notes            string                    `json:"notes,omitempty"`         // free text, e.g. commissioning remarks
+	Checklist        []ChecklistItem           `json:"checklist,omitempty"`     // commissioning steps
+	client           Device                    `json:"-"`
 	mu               sync.Mutex                `json:"-"`
 	registerMap      map[uint16]RegisterConfig `json:"-"`
 	dataModel        ModbusDataModel           `json:"-"`
+	blockStatus      map[uint16]*BlockStatus   `json:"-"`                // keyed by block start address
+	lastChange       map[uint16]time.Time      `json:"-"`                // time each address last changed value
+	wasConnected     bool                      `json:"-"`                // set once the server has connected, for reconnect events
+	failedPolls      int                       `json:"-"`                // consecutive polls without a successful read, for backoff
+	firmware         *uint16                   `json:"-"`                // version read at connect time, for templates with variants
+	onBackup         bool                      `json:"-"`                // polling through BackupAddress
+	pathFailures     int                       `json:"-"`                // consecutive failures of the current path, for failover
+	lastCycle        time.Duration             `json:"-"`                // duration of the last poll cycle
+	overruns         int                       `json:"-"`                // poll cycles that took longer than the poll interval
+	cycles           cycleStats                `json:"-"`                // poll cycle durations, for /api/stats
+	flatlineWatch    map[uint16]time.Time      `json:"-"`                // when flatline checks of each register began
+	flatlines        map[uint16]bool           `json:"-"`                // registers not changing within their expected update interval
+	alarms           map[uint16]*Alarm         `json:"-"`                // active alarms by register, see checkAlarms
+	filterSamples    map[uint16][]float64      `json:"-"`                // recent values of filtered registers, oldest first
+	polling          bool                      `json:"-"`                // a pollServer goroutine is running
+	transitions      []healthTransition        `json:"-"`                // connection status changes, for the health timeline
+	restored         map[uint16]bool           `json:"-"`                // blocks whose values are from the journal and not read since
 	ConnectionStatus string                    `json:"connectionStatus"` // "ok" or "error"
 	ConnectionError  string                    `json:"connectionError,omitempty"`
 	LastDataReceived time.Time                 `json:"lastDataReceived"`
 }
 
+// BlockStatus holds the outcome of the most recent reads of a register block
+type BlockStatus struct {
+	StartAddress  uint16    `json:"startAddress"`
+	Length        uint16    `json:"length"`
+	Status        string    `json:"status"` // "ok", "error", "pending" or "disabled"
+	LastSuccess   time.Time `json:"lastSuccess"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+
+	// Readable and unreadable parts found after an Illegal Data Address,
+	// until a user applies them
+	Split []BlockRange `json:"split,omitempty"`
+
+	lastRead    time.Duration // duration of the last read, for /api/stats
+	maxRead     time.Duration // longest read
+	splitProbed bool          // parts were searched for since the block was last read
+}
+
 // HTML templates
 const (
 	serverListTemplate = `
@@ -111,41 +255,95 @@ const (
 						<button class="btn btn-sm btn-outline-secondary me-2" onclick="toggleServerTable('{{.ID}}')">
 							<span id="toggle-icon-{{.ID}}">▼</span>
 						</button>
+						<div class="btn-group btn-group-sm me-2">
+							<button class="btn btn-outline-secondary" onclick="moveServer('{{.ID}}', -1)" title="{{t "Move up"}}"><i class="bi bi-arrow-up"></i></button>
+							<button class="btn btn-outline-secondary" onclick="moveServer('{{.ID}}', 1)" title="{{t "Move down"}}"><i class="bi bi-arrow-down"></i></button>
+							<button class="btn btn-outline-secondary" onclick="hideServer('{{.ID}}')" title="{{t "Hide"}}"><i class="bi bi-eye-slash"></i></button>
+						</div>
 						<div>
-							<h5 class="mb-0">Server: {{.ID}}</h5>
+							<h5 class="mb-0">{{t "Server"}}: {{.ID}}</h5>
 							<div hx-get="/api/serverstatus/{{.ID}}" hx-target="#server-{{.ID}}-status" hx-swap="innerHTML" hx-trigger="load, every 1s" id="server-{{.ID}}-status"></div>
+							<div hx-get="/api/servers/{{.ID}}/health" hx-trigger="load, every 60s" hx-swap="innerHTML"></div>
 						</div>
 					</div>
 					<div>
 						<button class="btn btn-info btn-sm me-2" onclick="showAddBlockModal('{{.ID}}')" data-server-id="{{.ID}}">
-							<i class="bi bi-plus-circle"></i> Add Block
+							<i class="bi bi-plus-circle"></i> {{t "Add Block"}}
 						</button>
 						<button class="btn btn-info btn-sm me-2" onclick="showAddRegisterModal('{{.ID}}')" data-server-id="{{.ID}}">
-							<i class="bi bi-plus-circle"></i> Add Register
+							<i class="bi bi-plus-circle"></i> {{t "Add Register"}}
 						</button>
 						<button class="btn btn-info btn-sm me-2" onclick="showBulkAddModal('{{.ID}}')" data-server-id="{{.ID}}">
-							<i class="bi bi-plus-circle"></i> Bulk Add
+							<i class="bi bi-plus-circle"></i> {{t "Bulk Add"}}
+						</button>
+						<button class="btn btn-warning btn-sm me-2" onclick="showBulkWriteModal('{{.ID}}')" data-server-id="{{.ID}}">
+							<i class="bi bi-pencil-square"></i> {{t "Bulk Write"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="showWriteHistory('{{.ID}}')">
+							<i class="bi bi-clock-history"></i> {{t "Write History"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="showApprovals('{{.ID}}')">
+							<i class="bi bi-shield-check"></i> {{t "Approvals"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="captureParameters('{{.ID}}')">
+							<i class="bi bi-box-arrow-down"></i> {{t "Capture Parameters"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="restoreParameters('{{.ID}}')">
+							<i class="bi bi-box-arrow-up"></i> {{t "Restore Parameters"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="showColumnsModal('{{.ID}}')">
+							<i class="bi bi-layout-three-columns"></i> {{t "Columns"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="showBlocksModal('{{.ID}}')">
+							<i class="bi bi-list-ol"></i> {{t "Blocks"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="setConnectionMode('{{.ID}}')">
+							<i class="bi bi-plug"></i> {{t "Connection"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="togglePolling('{{.ID}}')">
+							<i class="bi bi-pause-circle"></i> {{t "Polling"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="setServerTimeout('{{.ID}}')">
+							<i class="bi bi-stopwatch"></i> {{t "Timeout"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="shelveServer('{{.ID}}')">
+							<i class="bi bi-bell-slash"></i> {{t "Shelve"}}
+						</button>
+						<button class="btn btn-secondary btn-sm me-2" onclick="showNotesModal('{{.ID}}')">
+							<i class="bi bi-journal-check"></i> {{t "Notes"}}
+						</button>
+						<a class="btn btn-secondary btn-sm me-2" href="/api/servers/{{.ID}}/registers.xlsx?values=true">
+							<i class="bi bi-download"></i> {{t "Export"}}
+						</a>
+						<a class="btn btn-secondary btn-sm me-2" href="/api/servers/{{.ID}}/registermap" title="{{t "Download the register map in the portable interchange format"}}">
+							<i class="bi bi-filetype-json"></i> {{t "Export Map"}}
+						</a>
+						<button class="btn btn-secondary btn-sm me-2" onclick="importRegisterMap('{{.ID}}')">
+							<i class="bi bi-upload"></i> {{t "Import Map"}}
 						</button>
 						<button class="btn btn-danger btn-sm" 
 								hx-delete="/api/servers/{{.ID}}"
 								hx-confirm="Are you sure you want to remove server {{.ID}}?"
 								hx-target="#server-{{.ID}}"
-								hx-swap="outerHTML swap:1s">Remove</button>
+								hx-swap="outerHTML swap:1s">{{t "Remove"}}</button>
 					</div>
 				</div>
 				<div class="card-body" id="server-content-{{.ID}}">
+					<div class="d-flex align-items-center mb-2 small">
+						<label class="text-muted me-2" for="time-travel-{{.ID}}">{{t "Show values at"}}</label>
+						<input type="datetime-local" step="1" class="form-control form-control-sm w-auto me-2" id="time-travel-{{.ID}}" onchange="timeTravel('{{.ID}}', this.value)">
+						<button class="btn btn-outline-secondary btn-sm" onclick="timeTravel('{{.ID}}', '')">{{t "Live"}}</button>
+					</div>
 					<div class="table-responsive">
 						<table class="table table-striped table-hover">
 							<thead>
 								<tr>
-									<th>Address</th>
-									<th>Name</th>
-									<th>Value</th>
-									<th>Format</th>
+									{{$id := .ID}}{{range .ColumnHeaders}}<th style="cursor:pointer;position:relative;" data-column-key="{{.Key}}" onclick="sortRegisters('{{$id}}', '{{.Key}}')">{{t .Title}} <span class="sort-indicator" data-server-id="{{$id}}" data-sort-key="{{.Key}}"></span><span class="column-resizer" onmousedown="startColumnResize(event, '{{.Key}}')" onclick="event.stopPropagation()"></span></th>{{end}}
 								</tr>
 							</thead>
 							<tbody hx-get="/api/servers/{{.ID}}" 
 								   hx-trigger="load, every 1s" 
+								   hx-vals='js:{...registerSortParams("{{.ID}}"), ...timeTravelParams("{{.ID}}")}'
 								   hx-swap="innerHTML">
 							</tbody>
 						</table>
@@ -157,24 +355,41 @@ const (
 
 	registerTableTemplate = `
 		{{define "registerTable"}}
-		{{range .Data}}
-		<tr>
-			<td>{{.Address}}</td>
-			<td>{{.Name}}</td>
-			<td class="register-value">{{.Value}}</td>
-			<td>{{.Format}}</td>
+		{{if not .At.IsZero}}
+		<tr class="table-info">
+			<td colspan="{{len .Columns}}"><strong>{{t "Historical values at"}} {{.At.Local.Format "2006-01-02 15:04:05 MST"}}</strong> {{t "from the historian, not live."}} <a href="#" onclick="timeTravel('{{.ServerID}}', ''); return false;">{{t "Back to live"}}</a></td>
+		</tr>
+		{{end}}
+		{{range $row := .Data}}
+		<tr{{if eq .Quality "suspect"}} class="table-warning" title="{{t "Value outside expected range"}}"{{else if eq .Quality "flatline"}} class="table-danger" title="{{t "Value has not changed within its expected update interval"}}"{{else if eq .Quality "stale"}} class="table-secondary" title="{{t "Value from before a restart, not read from the device since"}}"{{end}}>
+			{{range $.Columns}}
+			{{if eq .Key "address"}}<td>{{$row.Address}}</td>
+			{{else if eq .Key "name"}}<td>{{$row.Name}}{{if $row.URL}} <a href="{{$row.URL}}" target="_blank" rel="noopener" class="text-decoration-none" title="{{if $row.Note}}{{$row.Note}} | {{end}}{{t "Open documentation"}}">&#9432;</a>{{else if $row.Note}} <span class="text-primary" style="cursor:help;" title="{{$row.Note}}">&#9432;</span>{{end}}</td>
+			{{else if eq .Key "value"}}<td class="register-value{{with $row.ColorClass}} table-{{.}} fw-bold{{end}}"{{if $row.Raw}} title="Dec: {{range $row.Raw}}{{.}} {{end}}| Hex: {{range $row.Hex}}{{.}} {{end}}"{{end}}>{{if eq $row.Quality "missing"}}<span class="text-muted" title="{{t "No value recorded at this time"}}">—</span>{{else if $row.Bits}}{{range $row.Bits}}<span class="badge {{if .Set}}bg-warning text-dark{{else}}bg-light text-muted border{{end}} me-1" title="{{t "Bit"}} {{.Bit}}">{{.Name}}</span>{{end}}{{else if $row.State}}<span title="{{t "Value"}}: {{$row.Value}}">{{$row.State}}</span>{{else}}{{$row.Value}}{{end}}{{if eq $row.Quality "suspect"}} <span class="badge bg-warning text-dark">{{t "suspect"}}</span>{{else if eq $row.Quality "flatline"}} <span class="badge bg-danger">{{t "flatline"}}</span>{{else if eq $row.Quality "stale"}} <span class="badge bg-secondary">{{t "stale"}}</span>{{end}}{{if and $row.Writable $.At.IsZero}}{{if $row.Toggle}} <span class="form-check form-switch d-inline-block ms-2 mb-0 align-middle"><input class="form-check-input" type="checkbox" role="switch" title="{{t "Switch on or off"}}"{{if $row.Value}} checked{{end}} onchange="writeRegister('{{$.ServerID}}', {{$row.Address}}, 'boolean', {{$row.Value}}, {}, this.checked)"></span>{{else}} <button type="button" class="btn btn-link btn-sm p-0 ms-1 text-decoration-none" title="{{t "Write"}}" onclick="writeRegister('{{$.ServerID}}', {{$row.Address}}, '{{$row.Format}}', {{$row.Value}})">&#9998;</button>{{end}}{{end}}</td>
+			{{else if eq .Key "format"}}<td>{{$row.Format}}</td>
+			{{else if eq .Key "hex"}}<td>{{range $row.Hex}}{{.}} {{end}}</td>
+			{{else if eq .Key "unit"}}<td>{{$row.Unit}}</td>
+			{{else if eq .Key "description"}}<td>{{$row.Description}}</td>
+			{{else if eq .Key "quality"}}<td>{{$row.Quality}}</td>
+			{{else if eq .Key "min"}}<td>{{with $row.ExpectedMin}}{{.}}{{end}}</td>
+			{{else if eq .Key "max"}}<td>{{with $row.ExpectedMax}}{{.}}{{end}}</td>
+			{{else if eq .Key "lastChange"}}<td>{{if not $row.LastChange.IsZero}}{{$row.LastChange.Format "15:04:05.000"}}{{end}}</td>
+			{{end}}
+			{{end}}
 		</tr>
 		{{end}}
 		<tr>
-			<td colspan="4" style="display:none;" id="last-data-{{.ServerID}}">{{.LastDataReceived.Format "15:04:05.000"}}</td>
+			<td colspan="{{len .Columns}}" style="display:none;" id="last-data-{{.ServerID}}">{{.LastDataReceived.Format "15:04:05.000"}}</td>
 		</tr>
 		{{end}}
 `
 
-	serverStatusTemplate = `{{define "serverStatus"}}<small class="text-muted">IP: {{.Address}} | Port: {{.Port}} | Poll: {{.PollRate}} ms | Last Data Received: {{.LastDataReceived.Format "15:04:05.000"}}</small></div>{{end}}`
+	serverStatusTemplate = `{{define "serverStatus"}}<small class="text-muted">{{t "IP"}}: {{.Address}} | {{t "Port"}}: {{.Port}} | {{t "Poll"}}: {{.PollRate}} ms{{with .Timeout}} | {{t "Timeout"}}: {{.}} ms{{end}}{{with .Gateway}} | {{t "Gateway"}}: {{.}}{{end}}{{with .ActivePath}} | {{t "Path"}}: <span{{if eq . "backup"}} class="text-warning"{{end}}>{{.}}</span>{{end}}{{if .Firmware}} | {{t "Firmware"}}: {{.Firmware}}{{if .Variant}} ({{.Variant}}){{end}}{{end}}{{if .PollBackoff}} <span class="text-warning" title="{{printf (t "%d polls failed in a row") .FailedPolls}}">({{printf (t "backed off to %d ms") .PollBackoff}})</span>{{end}}{{if .Overruns}} <span class="text-warning" title="{{printf (t "Poll cycles that took longer than the poll interval; the last took %d ms") .LastCycle}}">({{printf (t "%d overruns") .Overruns}})</span>{{else if .SlowCycle}} <span class="text-warning" title="{{t "The last poll cycle took most of the poll interval; see /api/stats for the slowest blocks"}}">({{printf (t "cycle %d ms") .LastCycle}})</span>{{end}} | {{t "Last Data Received"}}: {{.LastDataReceived.Format "15:04:05.000"}}{{if .Paused}} | <span class="text-warning">{{t "Polling paused"}}</span>{{end}}{{if eq .Connection "on-demand"}} | {{t "Connected on demand"}}{{else if eq .Connection "disconnected"}} | <span class="text-warning">{{t "Disconnected"}}</span>{{end}}{{with .ChecklistProgress}} | {{t "Checklist"}}: {{.}}{{end}}{{with .Shelved}} | <span class="text-warning">{{t "Notifications shelved"}} {{.}}</span>{{end}}</small>
+		{{range .BlockStatuses}}<span class="badge {{if eq .Status "ok"}}bg-success{{else if eq .Status "error"}}bg-danger{{else}}bg-secondary{{end}} me-1" title="{{t "Last success"}}: {{if .LastSuccess.IsZero}}{{t "never"}}{{else}}{{.LastSuccess.Format "15:04:05.000"}}{{end}}{{if .LastError}} | {{t "Last error at"}} {{.LastErrorTime.Format "15:04:05.000"}}: {{.LastError}}{{end}}">{{.StartAddress}}+{{.Length}}</span>{{end}}</div>{{end}}`
 )
 
-// serveStaticFile serves a file from the embedded filesystem with the correct MIME type
+// serveStaticFile serves a static file, from -static-dir or the embedded
+// filesystem, with the correct MIME type
 func serveStaticFile(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/static/")
 
@@ -186,18 +401,26 @@ func serveStaticFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Serve the file from the static directory
-	http.FileServer(http.FS(staticFiles)).ServeHTTP(w, r)
+	http.FileServer(http.FS(staticFS)).ServeHTTP(w, r)
 }
 
 func main() {
 	// Parse templates once at startup
-	templates = template.Must(template.New("serverStatus").Parse(serverStatusTemplate))
+	templates = template.Must(template.New("serverStatus").Funcs(translateFuncs(defaultLanguage)).Parse(serverStatusTemplate))
 	templates = template.Must(templates.Parse(serverListTemplate))
 	templates = template.Must(templates.Parse(registerTableTemplate))
+	templates = template.Must(templates.Parse(reportTemplate))
+	templates = template.Must(templates.Parse(summaryTemplate))
+	templates = template.Must(templates.Parse(instancesTemplate))
+	templates = template.Must(templates.Parse(remotesTemplate))
+	templates = template.Must(templates.Parse(connectionsTemplate))
+	templates = template.Must(templates.Parse(availabilityTemplate))
+	templates = template.Must(templates.Parse(healthTemplate))
+	templates = template.Must(templates.Parse(plainTemplate))
 
 	// Custom usage message
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Modbus Browser v0.1.0\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", versionBanner())
 		fmt.Fprintf(flag.CommandLine.Output(), "A web-based Modbus client for monitoring and configuring Modbus devices\n\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "GitHub: https://github.com/rustyoz/modbusbrowser\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "Author: https://github.com/rustyoz\n")
@@ -214,11 +437,63 @@ func main() {
 		fmt.Fprintf(flag.CommandLine.Output(), "\nExamples:\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  %s -port 8080 -log-level debug\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "  %s -port 9000 -log-level info\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -access-log\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -config /etc/modbusbrowser/modbus.json\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -config /etc/modbusbrowser/modbus.json -journal /var/lib/modbusbrowser\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -report-dir reports -report-interval 8h\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -report-format pdf -report-email shift@example.com -smtp-server mail.example.com:587\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -layout-file layouts.json\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -mdns -mdns-name \"Line 3 gateway\"\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -remotes site1=http://10.0.1.5:8080,site2=http://10.0.2.5:8080\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -push-url http://central:8080/api/push -push-token secret -push-spool spool\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -snmp-port 1161 -snmp-community monitoring\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -catalog https://raw.githubusercontent.com/example/modbus-maps/main/index.json\n", os.Args[0])
 	}
 
 	// Parse command line flags
 	port := flag.Int("port", 8080, "Port to start the server on")
 	logLevelStr := flag.String("log-level", "error", "Log level (error, info, debug)")
+	accessLogFlag := flag.Bool("access-log", false, "Log every HTTP request")
+	reportDir := flag.String("report-dir", "", "Directory to write scheduled reports to (disabled if empty)")
+	reportInterval := flag.Duration("report-interval", 8*time.Hour, "Period covered by each scheduled report")
+	reportFormat := flag.String("report-format", "html", "Formats of scheduled reports: html, pdf or html,pdf")
+	reportRegistersFlag := flag.String("report-registers", "", "Comma-separated servers and registers (server, server:address or server:name) reports cover (default all)")
+	reportEmail := flag.String("report-email", "", "Comma-separated addresses to email scheduled reports to (disabled if empty)")
+	smtpServer := flag.String("smtp-server", "localhost:25", "SMTP server (host:port) for emailed reports; credentials from SMTP_USERNAME and SMTP_PASSWORD")
+	smtpFrom := flag.String("smtp-from", "", "Sender address of emailed reports (default modbusbrowser@<hostname>)")
+	columnsFlag := flag.String("columns", strings.Join(defaultColumns, ","), "Default register table columns ("+columnKeyList()+")")
+	mdnsEnabled := flag.Bool("mdns", false, "Advertise the web UI via mDNS and discover other instances")
+	mdnsName := flag.String("mdns-name", "", "Instance name advertised via mDNS (default \"Modbus Browser on <hostname>\")")
+	remotesFlag := flag.String("remotes", "", "Comma-separated remote instances to aggregate, as name=url or url")
+	pushURL := flag.String("push-url", "", "Forward polled samples to this URL, e.g. http://central:8080/api/push (disabled if empty)")
+	pushToken := flag.String("push-token", "", "Bearer token sent with forwarded samples")
+	pushName := flag.String("push-name", "", "Instance name sent with forwarded samples (default hostname)")
+	pushInterval := flag.Duration("push-interval", 5*time.Second, "How often samples are forwarded")
+	pushSpool := flag.String("push-spool", "", "Directory to spool unsent samples to while the push URL is unreachable (memory only if empty)")
+	pushLimit := flag.Int("push-limit", defaultPushQueueLimit, "Maximum number of unsent sample batches kept, the oldest are dropped beyond")
+	pushMaxAge := flag.Duration("push-max-age", 24*time.Hour, "Unsent sample batches older than this are dropped (kept until -push-limit if 0)")
+	approversFlag := flag.String("approvers", "", "Comma-separated name=token of the operators who approve writes under the approval write policy, by bearer token (required by that policy)")
+	rawWriteTokenFlag := flag.String("raw-write-token", "", "Accept raw writes on /api/servers/{id}/raw-write with this bearer token (disabled if empty)")
+	ingestTokenFlag := flag.String("ingest-token", "", "Accept samples pushed to /api/push with this bearer token (disabled if empty)")
+	snmpPort := flag.Int("snmp-port", 0, "UDP port for the SNMP agent exposing register values (disabled if 0)")
+	snmpCommunity := flag.String("snmp-community", "public", "SNMP community accepted by the agent")
+	snmpBaseOID := flag.String("snmp-base-oid", defaultSNMPBaseOID, "OID under which register values are exposed")
+	layoutFilePath := flag.String("layout-file", "", "File to persist saved screen layouts to (kept in memory only if empty)")
+	langFlag := flag.String("lang", defaultLanguage, "Language of the web UI for users who have not picked one (en, de, es)")
+	staticDirFlag := flag.String("static-dir", "", "Directory of files served in place of the built-in ones under /static/, e.g. custom.css, custom.js and a logo (disabled if empty)")
+	writePolicyFlag := flag.String("write-policy", writePolicyNone, "Confirmation required for writes to critical registers of servers without their own policy (none, confirm, approval)")
+	gatewayGapFlag := flag.Duration("gateway-gap", gatewayGap, "Minimum time between requests through a shared gateway")
+	historyRetention := flag.Duration("history-retention", time.Hour, "How long register values are kept for sparklines (disabled if 0)")
+	s3EndpointFlag := flag.String("s3-endpoint", "", "Base URL of an S3-compatible store for export jobs, e.g. http://minio:9000 (AWS if empty)")
+	standbyOf := flag.String("standby-of", "", "Run as a hot standby of the primary instance at this URL, e.g. http://primary:8080 (disabled if empty)")
+	standbyTimeout := flag.Duration("standby-timeout", 15*time.Second, "How long the primary must be unreachable before the standby takes over polling")
+	standbyInterval := flag.Duration("standby-interval", 5*time.Second, "How often the standby replicates from the primary")
+	standbyHistory := flag.Bool("standby-history", true, "Replicate the history of the primary for sparklines after a takeover")
+	configFlag := flag.String("config", "", "Configuration file to load and start polling at startup, as uploaded to /api/config/upload (none if empty)")
+	wsOriginsFlag := flag.String("ws-origins", "", "Comma-separated origins, besides the instance itself, whose pages may open the WebSocket, e.g. https://scada.example.com")
+	journalDir := flag.String("journal", "", "Directory to journal the servers, last values and shelves to, to restart with them after a crash or power loss (disabled if empty)")
+	journalInterval := flag.Duration("journal-interval", 10*time.Second, "How often the journal is written")
+	catalogFlag := flag.String("catalog", "", "URL of the index of a register map catalog to browse from the UI, e.g. index.json in a GitHub repository (disabled if empty)")
 	flag.Parse()
 
 	// Set log level
@@ -230,9 +505,52 @@ func main() {
 	default:
 		logLevel = ErrorLevel
 	}
+	accessLog = *accessLogFlag
+
+	if columns, err := parseColumns(*columnsFlag); err != nil {
+		log.Fatalf("Invalid -columns: %v", err)
+	} else {
+		defaultColumns = columns
+	}
+	if list, err := parseApprovers(*approversFlag); err != nil {
+		log.Fatalf("Invalid -approvers: %v", err)
+	} else {
+		approvers = list
+	}
+	if !writePolicies[*writePolicyFlag] {
+		log.Fatalf("Invalid -write-policy: %s", *writePolicyFlag)
+	}
+	if err := checkWritePolicySetting(*writePolicyFlag); err != nil {
+		log.Fatalf("Invalid -write-policy: %v", err)
+	}
+	defaultWritePolicy = *writePolicyFlag
+	if err := loadLocales(*langFlag); err != nil {
+		log.Fatalf("Invalid -lang: %v", err)
+	}
+	gatewayGap = *gatewayGapFlag
+	s3Endpoint = *s3EndpointFlag
+	catalogURL = *catalogFlag
+	origins, err := parseOrigins(*wsOriginsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -ws-origins: %v", err)
+	}
+	wsOrigins = origins
+	features = map[string]bool{
+		"tls":        false, // not supported; serve HTTPS through a reverse proxy
+		"mqtt":       false, // not supported
+		"historian":  *historyRetention > 0,
+		"reports":    *reportDir != "" || *reportEmail != "",
+		"standby":    *standbyOf != "",
+		"federation": *remotesFlag != "",
+		"push":       *pushURL != "",
+		"snmp":       *snmpPort != 0,
+		"mdns":       *mdnsEnabled,
+		"catalog":    catalogURL != "",
+		"journal":    *journalDir != "",
+	}
 
 	// Print intro message without logging
-	fmt.Println("Modbus Browser v0.1.0")
+	fmt.Println(versionBanner())
 	fmt.Println("https://github.com/rustyoz/modbusbrowser")
 	fmt.Println("https://github.com/rustyoz")
 	fmt.Println("https://github.com/rustyoz/modbusbrowser/issues")
@@ -245,14 +563,156 @@ func main() {
 	http.Handle("/", http.HandlerFunc(ServeIndex))
 
 	http.HandleFunc("/api/servers/config/", handleServerConfig)
+	http.HandleFunc("/api/servers/import", handleServerImport)
 	http.HandleFunc("/api/servers/", handleServer)
 	http.HandleFunc("/api/servers", handleServers)
 	http.HandleFunc("/api/config/upload", handleConfigUpload)
+	http.HandleFunc("/api/config/validate", handleConfigValidate)
+	http.HandleFunc("/api/config/schema", handleConfigSchema)
 	http.HandleFunc("/api/config", handleGetConfig)
 	http.HandleFunc("/api/serverstatus/", handleServerStatus)
+	http.HandleFunc("/api/report", handleReport)
+	http.HandleFunc("/api/history/sparkline", handleSparkline)
+	http.HandleFunc("/api/history/samples", handleHistorySamples)
+	http.HandleFunc("/api/standby", handleStandby)
+	http.HandleFunc("/api/exports", handleExports)
+	http.HandleFunc("/api/mirrors", handleMirrors)
+	http.HandleFunc("/api/hooks", handleHooks)
+	http.HandleFunc("/api/registermap/schema", handleRegisterMapSchema)
+	http.HandleFunc("/api/catalog", handleCatalog)
+	http.HandleFunc("/api/availability", handleAvailability)
+	http.HandleFunc("/api/availability/report", handleAvailability)
+	http.HandleFunc("/api/layouts", handleLayouts)
+	http.HandleFunc("/api/layouts/", handleLayouts)
+	http.HandleFunc("/api/session", handleSession)
+	http.HandleFunc("/api/events", handleEvents)
+	http.HandleFunc("/api/alarms", handleAlarms)
+	http.HandleFunc("/api/ws", handleWebSocket)
+	http.HandleFunc("/api/summary", handleSummary)
+	http.HandleFunc("/api/stats", handleStats)
+	http.HandleFunc("/api/stats/memory", handleMemoryStats)
+	http.HandleFunc("/api/connections", handleConnections)
+	http.HandleFunc("/api/templates", handleTemplates)
+	http.HandleFunc("/api/templates/", handleTemplates)
+	http.HandleFunc("/api/instances", handleInstances)
+	http.HandleFunc("/api/remotes", handleRemotes)
+	http.HandleFunc("/api/remotes/", handleRemotes)
+	http.HandleFunc("/api/push", handlePush)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/api/version", handleVersion)
+	http.HandleFunc("/plain", handlePlain)
+	http.HandleFunc("/api/profiles", handleProfiles)
+
+	if reportRegisters, err = parseReportSelection(*reportRegistersFlag); err != nil {
+		log.Fatalf("Invalid -report-registers: %v", err)
+	}
+	if *reportDir != "" || *reportEmail != "" {
+		formats, err := parseReportFormats(*reportFormat)
+		if err != nil {
+			log.Fatalf("Invalid -report-format: %v", err)
+		}
+		schedule := reportSchedule{dir: *reportDir, formats: formats, interval: *reportInterval}
+		if *reportDir != "" {
+			if err := os.MkdirAll(*reportDir, 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *reportEmail != "" {
+			if schedule.mail, err = newMailer(*smtpServer, *smtpFrom, *reportEmail); err != nil {
+				log.Fatalf("Invalid report email settings: %v", err)
+			}
+		}
+		go runReports(schedule)
+	}
+
+	if *historyRetention > 0 {
+		go runHistory(*historyRetention)
+	}
+	go runAvailability()
+
+	if *layoutFilePath != "" {
+		if err := loadLayouts(*layoutFilePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *staticDirFlag != "" {
+		if err := setStaticDir(*staticDirFlag); err != nil {
+			log.Fatalf("Invalid -static-dir: %v", err)
+		}
+	}
+
+	if *remotesFlag != "" {
+		list, err := parseRemotes(*remotesFlag)
+		if err != nil {
+			log.Fatalf("Invalid -remotes: %v", err)
+		}
+		startFederation(list)
+	}
+
+	// After a crash or power loss, carry on from the journal
+	restored := false
+	if *journalDir != "" {
+		if *standbyOf != "" {
+			log.Fatal("-journal cannot be combined with -standby-of: a standby takes its configuration from the primary")
+		}
+		if *journalInterval <= 0 {
+			log.Fatalf("Invalid -journal-interval: %s", *journalInterval)
+		}
+		if err := os.MkdirAll(*journalDir, 0755); err != nil {
+			log.Fatalf("Invalid -journal: %v", err)
+		}
+		var err error
+		if restored, err = restoreJournal(*journalDir, *configFlag); err != nil {
+			log.Fatalf("Invalid -journal: %v", err)
+		}
+	}
+
+	if *configFlag != "" && !restored {
+		if *standbyOf != "" {
+			log.Fatal("-config cannot be combined with -standby-of: a standby takes its configuration from the primary")
+		}
+		if err := loadStartupConfig(*configFlag); err != nil {
+			log.Fatalf("Invalid -config: %v", err)
+		}
+	}
+	if *journalDir != "" {
+		go runJournal(*journalDir, *journalInterval)
+	}
+
+	if *standbyOf != "" {
+		u, err := url.Parse(*standbyOf)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -standby-of: %q", *standbyOf)
+		}
+		retention := time.Duration(0)
+		if *standbyHistory {
+			retention = *historyRetention
+		}
+		go runStandby(*standbyOf, *standbyInterval, *standbyTimeout, retention)
+	}
+
+	ingestToken = *ingestTokenFlag
+	rawWriteToken = *rawWriteTokenFlag
+	if *pushURL != "" {
+		if err := startPush(*pushURL, *pushToken, *pushName, *pushSpool, *pushInterval, *pushLimit, *pushMaxAge); err != nil {
+			log.Fatalf("Invalid push settings: %v", err)
+		}
+	}
+
+	if *snmpPort != 0 {
+		if err := startSNMP(*snmpPort, *snmpCommunity, *snmpBaseOID); err != nil {
+			log.Fatalf("Invalid SNMP settings: %v", err)
+		}
+	}
+
+	if *mdnsEnabled {
+		if err := startMDNS(*mdnsName, *port); err != nil {
+			logMessage(ErrorLevel, "mDNS disabled: %v", err)
+		}
+	}
 
 	logMessage(ErrorLevel, "Starting server on port %d...", *port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), instrument(http.DefaultServeMux)); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -260,12 +720,18 @@ func main() {
 func handleServers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		// Return list of servers
+		// Return list of servers, optionally only those in a given state ("ok",
+		// "error", "stale" or "disconnected") or with active alarms ("alarm")
+		filter := r.URL.Query().Get("status")
 		mu.RLock()
 		var serverList []map[string]interface{}
 		serverList = make([]map[string]interface{}, 0, len(servers))
 		for id, srv := range servers {
 			srv.mu.Lock()
+			if filter != "" && !srv.matchesFilter(filter) {
+				srv.mu.Unlock()
+				continue
+			}
 			serverList = append(serverList, map[string]interface{}{
 				"ID":               id,
 				"ConnectionStatus": srv.ConnectionStatus,
@@ -274,6 +740,7 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 				"Port":             srv.Port,
 				"PollRate":         srv.PollRate,
 				"LastDataReceived": srv.LastDataReceived,
+				"ColumnHeaders":    srv.ColumnHeaders(),
 			})
 			srv.mu.Unlock()
 		}
@@ -281,7 +748,7 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 
 		if isHtmxRequest(r) {
 			w.Header().Set("Content-Type", "text/html")
-			if err := templates.ExecuteTemplate(w, "serverList", serverList); err != nil {
+			if err := templatesFor(r).ExecuteTemplate(w, "serverList", serverList); err != nil {
 				handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
 				return
 			}
@@ -294,10 +761,19 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		// Add new server
 		var config struct {
-			ID       string `json:"id" form:"id"`
-			Address  string `json:"address" form:"address"`
-			Port     int    `json:"port" form:"port"`
-			PollRate int    `json:"pollRate" form:"pollRate"`
+			ID            string `json:"id" form:"id"`
+			Address       string `json:"address" form:"address"`
+			Port          int    `json:"port" form:"port"`
+			PollRate      int    `json:"pollRate" form:"pollRate"`
+			Protocol      string `json:"protocol" form:"protocol"`
+			WritePolicy   string `json:"writePolicy" form:"writePolicy"`
+			MaxBlockGap   uint16 `json:"maxBlockGap" form:"maxBlockGap"`
+			Template      string `json:"template" form:"template"`
+			BackupAddress string `json:"backupAddress" form:"backupAddress"`
+			BackupPort    int    `json:"backupPort" form:"backupPort"`
+			Gateway       string `json:"gateway" form:"gateway"`
+			SourceAddress string `json:"sourceAddress" form:"sourceAddress"`
+			Timeout       int    `json:"timeout" form:"timeout"`
 		}
 
 		// Handle both JSON and form data
@@ -315,6 +791,63 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 			config.Address = r.FormValue("address")
 			config.Port, _ = strconv.Atoi(r.FormValue("port"))
 			config.PollRate, _ = strconv.Atoi(r.FormValue("pollRate"))
+			config.Protocol = r.FormValue("protocol")
+			config.WritePolicy = r.FormValue("writePolicy")
+			config.Template = r.FormValue("template")
+			config.BackupAddress = r.FormValue("backupAddress")
+			config.BackupPort, _ = strconv.Atoi(r.FormValue("backupPort"))
+			config.Gateway = r.FormValue("gateway")
+			config.SourceAddress = r.FormValue("sourceAddress")
+			config.Timeout, _ = strconv.Atoi(r.FormValue("timeout"))
+			if gap, err := strconv.ParseUint(r.FormValue("maxBlockGap"), 10, 16); err == nil {
+				config.MaxBlockGap = uint16(gap)
+			}
+		}
+
+		if err := checkProtocol(config.Protocol); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		if err := checkWritePolicySetting(config.WritePolicy); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid write policy: %v", err))
+			return
+		}
+		for _, address := range []string{config.Address, config.BackupAddress} {
+			if err := checkHost(address); err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+		}
+		if config.SourceAddress != "" {
+			if _, err := sourceAddr(config.SourceAddress, config.Address); err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+		}
+		if err := checkTimeout(config.Timeout); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		if config.PollRate <= 0 {
+			handleError(w, r, fmt.Sprintf("pollRate %d must be greater than 0", config.PollRate))
+			return
+		}
+
+		// Replacing a running server here would leave its connection and
+		// poller running; existing servers are changed through a config
+		// upload. The ID is reserved until the server is in the list, so
+		// two requests adding the same server cannot both pass.
+		mu.Lock()
+		_, exists := servers[config.ID]
+		if !exists && !addingServers[config.ID] {
+			addingServers[config.ID] = true
+		} else {
+			exists = true
+		}
+		mu.Unlock()
+		if exists {
+			handleErrorStatus(w, r, http.StatusConflict, fmt.Sprintf("Server %s already exists", config.ID))
+			return
 		}
 
 		// Initialize the complete Modbus data model
@@ -325,55 +858,30 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 			Address:          config.Address,
 			Port:             config.Port,
 			PollRate:         config.PollRate,
+			Protocol:         config.Protocol,
+			WritePolicy:      config.WritePolicy,
+			MaxBlockGap:      config.MaxBlockGap,
+			Template:         config.Template,
+			BackupAddress:    config.BackupAddress,
+			BackupPort:       config.BackupPort,
+			Gateway:          config.Gateway,
+			SourceAddress:    config.SourceAddress,
+			Timeout:          config.Timeout,
 			registerMap:      make(map[uint16]RegisterConfig),
 			dataModel:        dataModel,
 			ConnectionStatus: "error", // default to error until connected
 		}
 
-		// Try to create Modbus client
-		client, err := NewModbusClient(server.Address, server.Port)
-		if err == nil {
-			server.client = client
-			server.ConnectionStatus = "ok"
-			server.ConnectionError = ""
-		} else {
-			server.ConnectionStatus = "error"
-			server.ConnectionError = err.Error()
-			// Start retry goroutine
-			go func(s *ModbusServer) {
-				for {
-					time.Sleep(1 * time.Second)
-					client, err := NewModbusClient(s.Address, s.Port)
-					if err == nil {
-						s.mu.Lock()
-						s.client = client
-						s.ConnectionStatus = "ok"
-						s.ConnectionError = ""
-						s.mu.Unlock()
-						break
-					} else {
-						s.mu.Lock()
-						s.ConnectionStatus = "error"
-						s.ConnectionError = err.Error()
-						s.mu.Unlock()
-					}
-				}
-			}(server)
-		}
-
-		// Add server to map
+		startServer(server)
 		mu.Lock()
-		servers[server.ID] = server
+		delete(addingServers, server.ID)
 		mu.Unlock()
 
-		// Start polling in background
-		go pollServer(server)
-
 		w.WriteHeader(http.StatusCreated)
 		if isHtmxRequest(r) {
 			w.Header().Set("HX-Trigger", "load")
 			w.Header().Set("Content-Type", "text/html")
-			if err := templates.ExecuteTemplate(w, "serverList", []map[string]interface{}{{
+			if err := templatesFor(r).ExecuteTemplate(w, "serverList", []map[string]interface{}{{
 				"ID":               server.ID,
 				"ConnectionStatus": server.ConnectionStatus,
 				"ConnectionError":  server.ConnectionError,
@@ -381,6 +889,7 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 				"Port":             server.Port,
 				"PollRate":         server.PollRate,
 				"LastDataReceived": server.LastDataReceived,
+				"ColumnHeaders":    server.ColumnHeaders(),
 			}}); err != nil {
 				handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
 				return
@@ -396,13 +905,149 @@ func handleServers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startServer connects to a new server, retrying in the background until the
+// device answers, adds it to the server list and starts polling it
+func startServer(server *ModbusServer) {
+	// Try to connect to the device
+	if server.connectionMode() == connectionDisconnected {
+		server.setConnectionStatus(connectionDisconnected, "")
+	} else if client, err := connectDevice(server); err == nil {
+		server.client = client
+		server.setConnectionStatus("ok", "")
+		server.selectFirmwareVariant()
+	} else {
+		server.setConnectionStatus("error", err.Error())
+		go retryConnect(server)
+	}
+
+	// Add server to map
+	mu.Lock()
+	servers[server.ID] = server
+	mu.Unlock()
+
+	// Start polling in background
+	go pollServer(server)
+}
+
+// retryConnect tries to connect to a server every second until the device
+// answers, the server is removed or it is to stay disconnected
+func retryConnect(s *ModbusServer) {
+	for {
+		time.Sleep(1 * time.Second)
+		if !isActive(s) || s.stayDisconnected() {
+			return
+		}
+		client, err := connectDevice(s)
+		if err == nil {
+			s.mu.Lock()
+			if s.client != nil {
+				s.client.Close()
+			}
+			s.client = client
+			s.setConnectionStatus("ok", "")
+			s.selectFirmwareVariant()
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Lock()
+		s.setConnectionStatus("error", err.Error())
+		s.recordPathResult(true)
+		s.mu.Unlock()
+	}
+}
+
 func handleServer(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/api/servers/"):]
+	id, resource, _ := strings.Cut(r.URL.Path[len("/api/servers/"):], "/")
 	if id == "" {
 		http.Error(w, "Server ID required", http.StatusBadRequest)
 		return
 	}
 
+	// Dispatch per-server sub-resources
+	switch resource {
+	case "":
+	case "registers.xlsx":
+		handleRegisterExport(w, r, id)
+		return
+	case "registermap":
+		handleRegisterMap(w, r, id)
+		return
+	case "bulkwrite":
+		handleBulkWrite(w, r, id)
+		return
+	case "alarmlimits":
+		handleAlarmLimits(w, r, id)
+		return
+	case "write":
+		handleWrite(w, r, id)
+		return
+	case "raw":
+		handleRawRead(w, r, id)
+		return
+	case "profile":
+		handleProfile(w, r, id)
+		return
+	case "raw-write":
+		handleRawWrite(w, r, id)
+		return
+	case "parameters":
+		handleParameters(w, r, id)
+		return
+	case "columns":
+		handleColumns(w, r, id)
+		return
+	case "blocks":
+		handleBlocks(w, r, id)
+		return
+	case "split":
+		handleSplit(w, r, id)
+		return
+	case "notes":
+		handleNotes(w, r, id)
+		return
+	case "health":
+		handleHealth(w, r, id)
+		return
+	case "timeout":
+		handleTimeout(w, r, id)
+		return
+	case "shelve":
+		handleShelve(w, r, id)
+		return
+	case "preview":
+		handlePreview(w, r, id)
+		return
+	case "detect":
+		handleDetect(w, r, id)
+		return
+	case "capture":
+		handleCapture(w, r, id)
+		return
+	case "writes":
+		handleWriteHistory(w, r, id, "")
+		return
+	case "approvals":
+		handleApprovals(w, r, id, "")
+		return
+	case "connection":
+		handleConnection(w, r, id)
+		return
+	case "polling":
+		handlePolling(w, r, id)
+		return
+	default:
+		if path, found := strings.CutPrefix(resource, "writes/"); found {
+			handleWriteHistory(w, r, id, path)
+			return
+		}
+		if path, found := strings.CutPrefix(resource, "approvals/"); found {
+			handleApprovals(w, r, id, path)
+			return
+		}
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		mu.RLock()
@@ -414,188 +1059,94 @@ func handleServer(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		server.mu.Lock()
-		defer server.mu.Unlock()
-
-		// Convert register values to JSON-serializable format
-		data := make([]map[string]interface{}, 0)
-
-		// Only include values that are configured in register blocks
-		for _, block := range server.RegisterBlocks {
-
-			// log the block details
-			logMessage(DebugLevel, "block: %+v", block)
-
-			for i := uint16(0); i < block.Length; i++ {
-				addr := block.StartAddress + i
-				regConfig, hasConfig := server.registerMap[addr]
-				if !hasConfig {
-					// create default config
-					regConfig = RegisterConfig{
-						Name:    fmt.Sprintf("Register %d", addr),
-						Format:  "decimal",
-						Address: addr,
-					}
-				}
+		// Values at a past time, from the historian
+		var at time.Time
+		mu.RLock()
+		h := history
+		mu.RUnlock()
+		if s := r.URL.Query().Get("at"); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			switch {
+			case h == nil:
+				err = fmt.Errorf("History is disabled (-history-retention 0)")
+			case err != nil:
+				err = fmt.Errorf("Invalid time: %q", s)
+			default:
+				err = h.checkTime(t)
+			}
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			at = t
+		}
 
-				// Get value from data model based on address range
-				var value interface{}
-				switch {
-				case addr < 10000: // Coils
-					if addr >= uint16(len(server.dataModel.Coils)) {
-						// panic as this should not happen
-						panic(fmt.Sprintf("Coil address out of range: %d", addr))
-					}
-					value = server.dataModel.Coils[addr]
-				case addr < 20000: // Discrete Inputs
-					if addr-10000 >= uint16(len(server.dataModel.DiscreteInputs)) {
-						// panic as this should not happen
-						panic(fmt.Sprintf("Discrete Input address out of range: %d", addr))
-					}
-					value = server.dataModel.DiscreteInputs[addr-10000]
-				case addr < 40000: // Input Registers
-					if addr-30000 >= uint16(len(server.dataModel.InputRegisters)) {
-						// panic as this should not happen
-						panic(fmt.Sprintf("Input Register address out of range: %d", addr))
-					}
-					value = server.dataModel.InputRegisters[addr-30000]
-				default: // Holding Registers
-					if addr-40000 >= uint16(len(server.dataModel.HoldingRegisters)) {
-						// panic as this should not happen
-						panic(fmt.Sprintf("Holding Register address out of range: %d", addr))
-					}
-					value = server.dataModel.HoldingRegisters[addr-40000]
-				}
+		// tableData decodes the rows to show; the caller must hold server.mu
+		tableData := func() ([]map[string]interface{}, error) {
+			data := server.registerData()
+			if !at.IsZero() {
+				h.historicalData(id, server.registerMap, data, at)
+			}
 
-				// Format value based on format type
-				var displayValue interface{}
-				switch regConfig.Format {
-				case "hex":
-					if v, ok := value.(uint16); ok {
-						displayValue = fmt.Sprintf("0x%04X", v)
-					} else {
-						displayValue = value
-					}
-				case "float":
-					// get the next register and combine to form a float32
-					if addr+1 >= block.StartAddress+block.Length {
-						displayValue = "N/A"
-					} else {
-						if addr < 40000 { // input registers
-							// combine to form a float32 by shifting the bytes
-							bits := uint32(value.(uint16))<<16 + uint32(server.dataModel.InputRegisters[addr+1-30000])
-							displayValue = math.Float32frombits(bits)
-						} else {
-							// combine to form a float32 by shifting the bytes
-							bits := uint32(value.(uint16))<<16 + uint32(server.dataModel.HoldingRegisters[addr+1-40000])
-							displayValue = math.Float32frombits(bits)
-						}
-					}
-					i = i + 1
-				case "boolean":
-					if v, ok := value.(uint16); ok {
-						displayValue = v != 0
-					} else {
-						displayValue = value
-					}
-				case "string-byte":
-					// For string-byte format, we need to read multiple registers and combine them
-					if addr < 40000 { // input registers
-						registers := make([]uint16, regConfig.StringLength/2+1)
-						for j := uint16(0); j < uint16(len(registers)); j++ {
-							if addr+j >= block.StartAddress+block.Length {
-								registers[j] = 0
-							} else {
-								registers[j] = server.dataModel.InputRegisters[addr+j-30000]
-							}
-						}
-						// Convert registers to bytes and then to string
-						bytes := make([]byte, 0, regConfig.StringLength)
-						for _, reg := range registers {
-							bytes = append(bytes, byte(reg>>8), byte(reg))
-						}
-						// Trim null bytes and convert to string
-						displayValue = strings.TrimRight(string(bytes), "\x00")
-					} else { // holding registers
-						registers := make([]uint16, regConfig.StringLength/2+1)
-						for j := uint16(0); j < uint16(len(registers)); j++ {
-							if addr+j >= block.StartAddress+block.Length {
-								registers[j] = 0
-							} else {
-								registers[j] = server.dataModel.HoldingRegisters[addr+j-40000]
-							}
-						}
-						// Convert registers to bytes and then to string
-						bytes := make([]byte, 0, regConfig.StringLength)
-						for _, reg := range registers {
-							bytes = append(bytes, byte(reg>>8), byte(reg))
-						}
-						// Trim null bytes and convert to string
-						displayValue = strings.TrimRight(string(bytes), "\x00")
-					}
-					i = i + uint16(regConfig.StringLength/2)
-				case "string-word":
-					// For string-word format, each register represents one character
-					if addr < 40000 { // input registers
-						registers := make([]uint16, regConfig.StringLength)
-						for j := uint16(0); j < uint16(len(registers)); j++ {
-							if addr+j >= block.StartAddress+block.Length {
-								registers[j] = 0
-							} else {
-								registers[j] = server.dataModel.InputRegisters[addr+j-30000]
-							}
-						}
-						// Convert registers to characters
-						chars := make([]rune, 0, regConfig.StringLength)
-						for _, reg := range registers {
-							chars = append(chars, rune(reg))
-						}
-						displayValue = string(chars)
-					} else { // holding registers
-						registers := make([]uint16, regConfig.StringLength)
-						for j := uint16(0); j < uint16(len(registers)); j++ {
-							if addr+j >= block.StartAddress+block.Length {
-								registers[j] = 0
-							} else {
-								registers[j] = server.dataModel.HoldingRegisters[addr+j-40000]
-							}
-						}
-						// Convert registers to characters
-						chars := make([]rune, 0, regConfig.StringLength)
-						for _, reg := range registers {
-							chars = append(chars, rune(reg))
-						}
-						displayValue = string(chars)
-					}
-					i = i + uint16(regConfig.StringLength-1)
-				default: // decimal
-					displayValue = value
+			// Optional ordering, applied before rendering since the fragment is replaced on every poll
+			if key := r.URL.Query().Get("sort"); key != "" {
+				if err := sortRegisterData(data, key, r.URL.Query().Get("order") == "desc"); err != nil {
+					return nil, err
 				}
-
-				data = append(data, map[string]interface{}{
-					"Address": addr,
-					"Name":    regConfig.Name,
-					"Value":   displayValue,
-					"Format":  regConfig.Format,
-				})
 			}
+			return data, nil
 		}
 
 		if isHtmxRequest(r) {
-			w.Header().Set("Content-Type", "text/html")
-			if err := templates.ExecuteTemplate(w, "registerTable", map[string]interface{}{
-				"Data":             data,
-				"ServerID":         id,
-				"LastDataReceived": server.LastDataReceived,
-			}); err != nil {
-				handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+			// Overlapping refreshes of the same table, e.g. from several
+			// browsers, share one rendering
+			body, err := renderShared(renderKey(r, id), func(b *bytes.Buffer) error {
+				server.mu.Lock()
+				defer server.mu.Unlock()
+				data, err := tableData()
+				if err != nil {
+					return err
+				}
+				if err := templatesFor(r).ExecuteTemplate(b, "registerTable", map[string]interface{}{
+					"Data":             data,
+					"Columns":          server.ColumnHeaders(),
+					"ServerID":         id,
+					"LastDataReceived": server.LastDataReceived,
+					"At":               at,
+				}); err != nil {
+					return fmt.Errorf("Error executing template: %v", err)
+				}
+				return nil
+			})
+			if err != nil {
+				handleError(w, r, err.Error())
 				return
 			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(body)
 		} else {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": true,
-				"data":    data,
-			})
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			data, err := tableData()
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			response := map[string]interface{}{
+				"success":     true,
+				"data":        filterColumns(data, server.columnKeys()),
+				"blocks":      server.BlockStatuses(),
+				"pollBackoff": server.PollBackoff(),
+				"firmware":    server.Firmware(),
+				"variant":     server.Variant,
+				"activePath":  server.ActivePath(),
+				"lastCycleMs": server.LastCycle(),
+				"overruns":    server.Overruns(),
+			}
+			if !at.IsZero() {
+				response["at"] = at
+			}
+			json.NewEncoder(w).Encode(response)
 		}
 
 	case http.MethodDelete:
@@ -627,7 +1178,160 @@ func handleServer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// registerData decodes the configured register blocks into table rows.
+// The caller must hold s.mu.
+func (s *ModbusServer) registerData() []map[string]interface{} {
+	// Convert register values to JSON-serializable format
+	data := make([]map[string]interface{}, 0)
+
+	// Only include values that are configured in register blocks
+	for _, block := range s.RegisterBlocks {
+		if block.Disabled {
+			continue
+		}
+
+		// log the block details
+		logMessage(DebugLevel, "block: %+v", block)
+
+		for i := uint16(0); i < block.Length; i++ {
+			addr := block.StartAddress + i
+			regConfig, hasConfig := s.registerMap[addr]
+			if !hasConfig {
+				// create default config
+				regConfig = RegisterConfig{
+					Name:    fmt.Sprintf("Register %d", addr),
+					Format:  "decimal",
+					Address: addr,
+				}
+			}
+
+			// Get value from data model based on address range
+			var value interface{}
+			switch {
+			case addr < 10000: // Coils
+				if addr >= uint16(len(s.dataModel.Coils)) {
+					// panic as this should not happen
+					panic(fmt.Sprintf("Coil address out of range: %d", addr))
+				}
+				value = s.dataModel.Coils[addr]
+			case addr < 20000: // Discrete Inputs
+				if addr-10000 >= uint16(len(s.dataModel.DiscreteInputs)) {
+					// panic as this should not happen
+					panic(fmt.Sprintf("Discrete Input address out of range: %d", addr))
+				}
+				value = s.dataModel.DiscreteInputs[addr-10000]
+			case addr < 40000: // Input Registers
+				if addr-30000 >= uint16(len(s.dataModel.InputRegisters)) {
+					// panic as this should not happen
+					panic(fmt.Sprintf("Input Register address out of range: %d", addr))
+				}
+				value = s.dataModel.InputRegisters[addr-30000]
+			default: // Holding Registers
+				if addr-40000 >= uint16(len(s.dataModel.HoldingRegisters)) {
+					// panic as this should not happen
+					panic(fmt.Sprintf("Holding Register address out of range: %d", addr))
+				}
+				value = s.dataModel.HoldingRegisters[addr-40000]
+			}
+
+			// Format value based on format type; the words of multi-register
+			// formats are skipped
+			first := i
+			var displayValue interface{}
+			if addr < 30000 {
+				displayValue = value
+			} else {
+				n := max(registerWordCount(regConfig), 1)
+				displayValue = inLocation(regConfig, decodeRegister(regConfig, s.registerWords(block, addr, n)), s.location())
+				i += uint16(n - 1)
+				if filtered, ok := s.filteredValue(regConfig); ok {
+					displayValue = filtered
+				}
+			}
+
+			// Flag samples outside the expected range as suspect
+			quality := "good"
+			numeric, ok := toFloat(displayValue)
+			if !ok {
+				numeric, ok = toFloat(value)
+			}
+			if ok && ((regConfig.ExpectedMin != nil && numeric < *regConfig.ExpectedMin) ||
+				(regConfig.ExpectedMax != nil && numeric > *regConfig.ExpectedMax)) {
+				quality = "suspect"
+			}
+			if s.flatlines[addr] {
+				quality = "flatline"
+			}
+			if s.restored[block.StartAddress] {
+				quality = "stale"
+			}
+
+			// Include the raw words behind the value for alternate representations
+			var raw []uint16
+			var hex []string
+			if addr >= 30000 {
+				raw = s.registerWords(block, addr, int(i-first)+1)
+				for _, word := range raw {
+					hex = append(hex, fmt.Sprintf("0x%04X", word))
+				}
+			}
+
+			// Most recent change of any of the words behind the value
+			var lastChange time.Time
+			for j := first; j <= i && j < block.Length; j++ {
+				if t := s.lastChange[block.StartAddress+j]; t.After(lastChange) {
+					lastChange = t
+				}
+			}
+
+			row := map[string]interface{}{
+				"Address":     addr,
+				"Name":        regConfig.Name,
+				"Value":       displayValue,
+				"Format":      regConfig.Format,
+				"Quality":     quality,
+				"Raw":         raw,
+				"Hex":         hex,
+				"Unit":        regConfig.Unit,
+				"Description": regConfig.Description,
+				"Note":        regConfig.Note,
+				"URL":         regConfig.URL,
+				"ExpectedMin": regConfig.ExpectedMin,
+				"ExpectedMax": regConfig.ExpectedMax,
+				"LastChange":  lastChange,
+				"Writable":    addr < 10000 || addr >= 40000, // coils and holding registers
+			}
+			if _, ok := displayValue.(bool); ok && row["Writable"] == true {
+				row["Toggle"] = true // shown as an on/off switch
+			}
+			setColor(row, regConfig.Colors)
+			setState(row, regConfig.Enum)
+			setBits(row, regConfig)
+			data = append(data, row)
+		}
+	}
+
+	return data
+}
+
+// registerWords returns up to n raw register words starting at addr,
+// stopping at the end of the block. The caller must hold s.mu.
+func (s *ModbusServer) registerWords(block RegisterBlock, addr uint16, n int) []uint16 {
+	words := make([]uint16, 0, n)
+	for j := 0; j < n && int(addr)+j < int(block.StartAddress)+int(block.Length); j++ {
+		a := addr + uint16(j)
+		if a < 40000 {
+			words = append(words, s.dataModel.InputRegisters[a-30000])
+		} else {
+			words = append(words, s.dataModel.HoldingRegisters[a-40000])
+		}
+	}
+	return words
+}
+
 // handleConfigUpload handles the upload of a configuration file or direct JSON configuration
+// The changes are returned as a diff with a confirm token, and only applied
+// when the same upload is repeated with confirm=<token>.
 func handleConfigUpload(w http.ResponseWriter, r *http.Request) {
 	logMessage(DebugLevel, "handleConfigUpload: %s %s", r.Method, r.URL.Path)
 
@@ -638,68 +1342,169 @@ func handleConfigUpload(w http.ResponseWriter, r *http.Request) {
 
 	var config ConfigFile
 
-	// Check if this is a file upload or direct JSON
-	contentType := r.Header.Get("Content-Type")
-	if strings.Contains(contentType, "multipart/form-data") {
-		// Handle file upload
-		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-			handleError(w, r, fmt.Sprintf("Failed to parse form: %v", err))
-			return
-		}
+	data, err := readUploadBody(r, "config")
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
 
-		file, _, err := r.FormFile("config")
-		if err != nil {
-			handleError(w, r, fmt.Sprintf("Failed to get file: %v", err))
-			return
-		}
-		defer file.Close()
+	// Resolve ${NAME} placeholders from the upload, the file and the environment
+	data, issues := substituteVariables(data, uploadVariables(r), false)
+	if len(issues) > 0 {
+		handleError(w, r, fmt.Sprintf("Invalid config: %s", issueMessages(issues)))
+		return
+	}
 
-		// Read and parse JSON
-		data, err := io.ReadAll(file)
-		if err != nil {
-			handleError(w, r, fmt.Sprintf("Failed to read file: %v", err))
-			return
-		}
+	// Apply the same rules as /api/config/validate and -config, so blocks
+	// the data model cannot hold and the like are never applied
+	issues, warnings := validateConfig(data)
+	if len(issues) > 0 {
+		handleError(w, r, fmt.Sprintf("Invalid config: %s", issueMessages(issues)))
+		return
+	}
 
-		if err := json.Unmarshal(data, &config); err != nil {
-			handleError(w, r, fmt.Sprintf("Invalid JSON: %v", err))
-			logMessage(ErrorLevel, "Invalid JSON: %v", err)
-			return
-		}
-	} else {
-		// Handle direct JSON
-		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-			handleError(w, r, fmt.Sprintf("Invalid JSON: %v", err))
-			logMessage(ErrorLevel, "Invalid JSON: %v", err)
-			return
-		}
+	// Upgrade older configuration files to the current format
+	data, migrationWarnings, err := migrateConfig(data)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid config: %v", err))
+		logMessage(ErrorLevel, "Invalid config: %v", err)
+		return
+	}
+	for _, warning := range migrationWarnings {
+		logMessage(InfoLevel, "Config migration: %s: %s", warning.Path, warning.Message)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid JSON: %v", err))
+		logMessage(ErrorLevel, "Invalid JSON: %v", err)
+		return
 	}
 
 	logMessage(DebugLevel, "config: %+v", config)
 
+	// Anyone who can reach the UI can upload, so hooks cannot run commands
+	if err := checkUploadedHooks(config.Hooks); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid config: %v", err))
+		return
+	}
+
+	// nor export jobs write files or store to S3
+	if err := checkUploadedExports(config.Exports); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid config: %v", err))
+		return
+	}
+
+	// Strategy for servers that already exist: "replace" (default), "merge" or "skip"
+	strategy := r.FormValue("strategy")
+	if strategy == "" {
+		strategy = "replace"
+	}
+	if strategy != "replace" && strategy != "merge" && strategy != "skip" {
+		handleError(w, r, fmt.Sprintf("Invalid merge strategy: %s", strategy))
+		return
+	}
+
+	// Show what the upload would change and apply it only once confirmed
+	diff := diffConfig(config, strategy)
+	token := confirmToken(data, strategy, diff)
+	if r.FormValue("confirm") != token {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":         false,
+			"confirmRequired": true,
+			"error":           "Review the changes and upload again with the confirm token to apply them",
+			"diff":            diff,
+			"confirm":         token,
+		})
+		return
+	}
+
+	// Register map templates replace those of the same name and must be in
+	// place before the servers connect
+	mapTemplatesMu.Lock()
+	for name, template := range config.Templates {
+		mapTemplates[name] = template
+	}
+	mapTemplatesMu.Unlock()
+
+	// Export jobs are not taken from uploads
+	startExportJobs(config.Exports, false)
+
+	// Mirror rules replace those with the same target
+	startMirrors(config.Mirrors)
+
+	// Hooks replace those of the same name; commands are not taken from uploads
+	startHooks(config.Hooks, false)
+
 	// Process each server in the config
-	for _, server := range config.Servers {
-		// Create register map
-		registerMap := make(map[uint16]RegisterConfig)
-		for _, block := range server.RegisterBlocks {
-			for _, reg := range block.Registers {
-				registerMap[reg.Address] = reg
+	actions := make(map[string]string)
+	for i, server := range config.Servers {
+		mu.RLock()
+		existing, exists := servers[server.ID]
+		mu.RUnlock()
+
+		if exists {
+			switch strategy {
+			case "skip":
+				logMessage(InfoLevel, "Skipping existing server %s", server.ID)
+				actions[server.ID] = "skipped"
+				continue
+			case "merge":
+				existing.mu.Lock()
+				existing.mergeBlocks(server.RegisterBlocks)
+				existing.mu.Unlock()
+				logMessage(InfoLevel, "Merged register blocks into existing server %s", server.ID)
+				actions[server.ID] = "merged"
+				continue
+			default:
+				// Update the running server rather than starting a second poller for it
+				existing.mu.Lock()
+				reconnect := existing.update(server)
+				existing.mu.Unlock()
+				if reconnect {
+					logMessage(InfoLevel, "Server %s: connection settings changed, reconnecting", server.ID)
+					go retryConnect(existing)
+				}
+				logMessage(InfoLevel, "Updated existing server %s", server.ID)
+				actions[server.ID] = "replaced"
+				config.Servers[i] = existing
+				continue
 			}
+		} else {
+			actions[server.ID] = "added"
 		}
 
 		// Set additional fields
 		server.client = nil // Will be set below
-		server.registerMap = registerMap
+		server.registerMap = buildRegisterMap(server.RegisterBlocks)
 		server.dataModel = ModbusDataModel{}
 
-		// Create Modbus client
-		client, err := NewModbusClient(server.Address, server.Port)
-		if err != nil {
-			handleError(w, r, fmt.Sprintf("Failed to create Modbus client for server %s: %v", server.ID, err))
-			logMessage(ErrorLevel, "Failed to create Modbus client for server %s: %v", server.ID, err)
-			continue
+		// Connect to the device, through the backup path if the primary is down
+		var client Device
+		var err error
+		disconnected := server.connectionMode() == connectionDisconnected
+		if !disconnected {
+			client, err = connectDevice(server)
+		}
+		if err != nil && server.BackupAddress != "" {
+			logMessage(InfoLevel, "Server %s: primary path unavailable, connecting through the backup: %v", server.ID, err)
+			server.onBackup = true
+			client, err = connectDevice(server)
+		}
+		if disconnected {
+			server.setConnectionStatus(connectionDisconnected, "")
+		} else if err != nil {
+			// Add it anyway, like a newly added server, and keep trying in the background
+			logMessage(ErrorLevel, "Failed to connect to server %s, retrying in the background: %v", server.ID, err)
+			server.setConnectionStatus("error", err.Error())
+			warnings = append(warnings, ConfigIssue{
+				Path:    fmt.Sprintf("servers[%d]", i),
+				Message: fmt.Sprintf("Not connected, retrying in the background: %v", err),
+			})
+			go retryConnect(server)
+		} else {
+			server.client = client
+			server.selectFirmwareVariant()
 		}
-		server.client = client
 
 		// Add server to map
 		mu.Lock()
@@ -713,17 +1518,53 @@ func handleConfigUpload(w http.ResponseWriter, r *http.Request) {
 	if isHtmxRequest(r) {
 		w.Header().Set("HX-Trigger", "load")
 		w.Header().Set("Content-Type", "text/html")
-		if err := templates.ExecuteTemplate(w, "serverList", config.Servers); err != nil {
+		if err := templatesFor(r).ExecuteTemplate(w, "serverList", config.Servers); err != nil {
 			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
 			return
 		}
 	} else {
+		if warnings == nil {
+			warnings = []ConfigIssue{}
+		}
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
+			"success":  true,
+			"warnings": warnings,
+			"actions":  actions,
 		})
 	}
 }
 
+// readUploadBody reads an uploaded file from the given multipart form field,
+// or the request body itself if the request is not a multipart upload
+func readUploadBody(r *http.Request, field string) ([]byte, error) {
+	// Check if this is a file upload or direct JSON
+	contentType := r.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "multipart/form-data") {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read request body: %v", err)
+		}
+		return data, nil
+	}
+
+	// Handle file upload
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
+		return nil, fmt.Errorf("Failed to parse form: %v", err)
+	}
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get file: %v", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file: %v", err)
+	}
+	return data, nil
+}
+
 // handleGetConfig returns the current server configuration
 func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	logMessage(DebugLevel, "handleGetConfig: %s %s", r.Method, r.URL.Path)
@@ -738,7 +1579,8 @@ func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 
 	// Convert current servers to configuration format
 	config := ConfigFile{
-		Servers: make([]*ModbusServer, 0, len(servers)),
+		SchemaVersion: currentConfigVersion,
+		Servers:       make([]*ModbusServer, 0, len(servers)),
 	}
 
 	for _, server := range servers {
@@ -747,6 +1589,21 @@ func handleGetConfig(w http.ResponseWriter, r *http.Request) {
 		server.mu.Unlock()
 	}
 
+	mapTemplatesMu.RLock()
+	defer mapTemplatesMu.RUnlock()
+	if len(mapTemplates) > 0 {
+		config.Templates = mapTemplates
+	}
+	if jobs := exportJobConfigs(); len(jobs) > 0 {
+		config.Exports = jobs
+	}
+	if rules := mirrorConfigs(); len(rules) > 0 {
+		config.Mirrors = rules
+	}
+	if configs := hookConfigs(); len(configs) > 0 {
+		config.Hooks = configs
+	}
+
 	err := json.NewEncoder(w).Encode(config)
 	if err != nil {
 		logMessage(ErrorLevel, "Error encoding config: %v", err)
@@ -779,40 +1636,60 @@ func handleServerConfig(w http.ResponseWriter, r *http.Request) {
 		server.mu.Unlock()
 
 	case http.MethodPost:
-		var config ModbusServer
+		// Adding blocks posts only registerBlocks, which must not reset the gap
+		var config struct {
+			ModbusServer
+			MaxBlockGap *uint16 `json:"maxBlockGap"`
+		}
 
 		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
 			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
 			return
 		}
+		if config.MaxBlockGap != nil && *config.MaxBlockGap > 125 {
+			handleError(w, r, fmt.Sprintf("maxBlockGap %d must not be greater than 125", *config.MaxBlockGap))
+			return
+		}
 
-		server.mu.Lock()
-		// Update register map
-		registerMap := make(map[uint16]RegisterConfig)
 		for _, block := range config.RegisterBlocks {
-			for _, reg := range block.Registers {
-				registerMap[reg.Address] = reg
+			end, ok := addressRangeEnd(block.StartAddress)
+			if !ok || int(block.StartAddress)+int(block.Length)-1 > int(end) {
+				handleError(w, r, fmt.Sprintf("Block %d+%d is not within a single address range", block.StartAddress, block.Length))
+				return
 			}
 		}
-		server.registerMap = registerMap
+
+		server.mu.Lock()
+		if config.MaxBlockGap != nil {
+			server.MaxBlockGap = *config.MaxBlockGap
+		}
+		// Update register map
+		server.registerMap = buildRegisterMap(config.RegisterBlocks)
 
 		// Process new blocks and merge with existing ones
 		var newBlocks []RegisterBlock
 		for _, newBlock := range config.RegisterBlocks {
 			merged := false
+			// Bit reads return up to 2000 values, register reads 125
+			limit := maxBlockLength(newBlock.StartAddress)
+			tableEnd, _ := addressRangeEnd(newBlock.StartAddress)
 
 			// Try to merge with existing blocks
 			for i, existingBlock := range server.RegisterBlocks {
-				// Check if blocks overlap or are adjacent
+				if end, _ := addressRangeEnd(existingBlock.StartAddress); end != tableEnd || existingBlock.unitID() != newBlock.unitID() {
+					continue // blocks of different tables or units are read separately
+				}
+				// Check if blocks overlap, are adjacent or are separated by at most
+				// MaxBlockGap unused addresses, which are then read as well
 				if newBlock.StartAddress >= existingBlock.StartAddress &&
-					newBlock.StartAddress <= existingBlock.StartAddress+existingBlock.Length {
+					int(newBlock.StartAddress) <= int(existingBlock.StartAddress)+int(existingBlock.Length)+int(server.MaxBlockGap) {
 
 					// Calculate total length needed
 					endAddr := newBlock.StartAddress + newBlock.Length
 					existingEndAddr := existingBlock.StartAddress + existingBlock.Length
 					totalLength := uint16(math.Max(float64(endAddr), float64(existingEndAddr))) - existingBlock.StartAddress
 
-					if totalLength <= 125 {
+					if totalLength <= limit {
 						// Merge blocks
 						server.RegisterBlocks[i].Length = totalLength
 						server.RegisterBlocks[i].Registers = append(server.RegisterBlocks[i].Registers, newBlock.Registers...)
@@ -823,18 +1700,19 @@ func handleServerConfig(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if !merged {
-				// Split block if length > 125
+				// Split block if it is longer than a single read allows
 				remaining := newBlock.Length
 				currentAddr := newBlock.StartAddress
 				currentRegIdx := 0
 
 				for remaining > 0 {
-					length := uint16(math.Min(float64(remaining), 125))
+					length := min(remaining, limit)
 
 					// Create new block
 					block := RegisterBlock{
 						StartAddress: currentAddr,
 						Length:       length,
+						UnitID:       newBlock.UnitID,
 					}
 
 					// Add registers that fall within this block
@@ -867,17 +1745,187 @@ func handleServerConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// buildRegisterMap indexes the registers of the given blocks by address
+func buildRegisterMap(blocks []RegisterBlock) map[uint16]RegisterConfig {
+	registerMap := make(map[uint16]RegisterConfig)
+	for _, block := range blocks {
+		for _, reg := range block.Registers {
+			registerMap[reg.Address] = reg
+		}
+	}
+	return registerMap
+}
+
+// mergeBlocks merges register blocks into the server's configuration. Blocks
+// starting at the same address as an existing block are combined, with
+// registers from the new block replacing those at the same address.
+// The caller must hold s.mu.
+func (s *ModbusServer) mergeBlocks(blocks []RegisterBlock) {
+	for _, newBlock := range blocks {
+		merged := false
+		for i := range s.RegisterBlocks {
+			existing := &s.RegisterBlocks[i]
+			if existing.StartAddress != newBlock.StartAddress {
+				continue
+			}
+			if newBlock.Length > existing.Length {
+				existing.Length = newBlock.Length
+			}
+			if newBlock.UnitID != nil {
+				existing.UnitID = newBlock.UnitID
+			}
+			for _, reg := range newBlock.Registers {
+				replaced := false
+				for j := range existing.Registers {
+					if existing.Registers[j].Address == reg.Address {
+						existing.Registers[j] = reg
+						replaced = true
+						break
+					}
+				}
+				if !replaced {
+					existing.Registers = append(existing.Registers, reg)
+				}
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			s.RegisterBlocks = append(s.RegisterBlocks, newBlock)
+		}
+	}
+	s.registerMap = buildRegisterMap(s.RegisterBlocks)
+}
+
+// update applies an uploaded configuration of the same server in place, so
+// uploading a file again keeps the server's connection, poller and status.
+// It returns whether the device is now reached differently, in which case
+// the connection has been closed for the caller to reopen. The caller must
+// hold s.mu.
+func (s *ModbusServer) update(config *ModbusServer) bool {
+	reconnect := s.Address != config.Address || s.Port != config.Port ||
+		s.Protocol != config.Protocol || s.SourceAddress != config.SourceAddress ||
+		s.BackupAddress != config.BackupAddress || s.BackupPort != config.BackupPort ||
+		s.Connection != config.Connection
+
+	s.Address = config.Address
+	s.Port = config.Port
+	s.PollRate = config.PollRate
+	s.RegisterBlocks = config.RegisterBlocks
+	s.Columns = config.Columns
+	s.OID = config.OID
+	s.Protocol = config.Protocol
+	s.Paused = config.Paused
+	s.Connection = config.Connection
+	s.WritePolicy = config.WritePolicy
+	s.MaxBlockGap = config.MaxBlockGap
+	s.Template = config.Template
+	s.Variant = config.Variant
+	s.BackupAddress = config.BackupAddress
+	s.BackupPort = config.BackupPort
+	s.Gateway = config.Gateway
+	s.SourceAddress = config.SourceAddress
+	s.Timeout = config.Timeout
+	s.SkipOverrun = config.SkipOverrun
+	s.AutoSplit = config.AutoSplit
+	s.Timezone = config.Timezone
+	s.Notes = config.Notes
+	s.Checklist = config.Checklist
+	s.registerMap = buildRegisterMap(s.RegisterBlocks)
+
+	if !reconnect {
+		if s.client != nil {
+			setDeviceTimeout(s.client, s.timeout())
+		}
+		return false
+	}
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+	s.onBackup = false
+	s.pathFailures = 0
+	if s.connectionMode() == connectionDisconnected {
+		s.setConnectionStatus(connectionDisconnected, "")
+		return false
+	}
+	return true
+}
+
+// recordBlockResult records the outcome of a read of block. The caller must hold s.mu.
+func (s *ModbusServer) recordBlockResult(block RegisterBlock, err error) {
+	if s.blockStatus == nil {
+		s.blockStatus = make(map[uint16]*BlockStatus)
+	}
+	status, ok := s.blockStatus[block.StartAddress]
+	if !ok {
+		status = &BlockStatus{StartAddress: block.StartAddress}
+		s.blockStatus[block.StartAddress] = status
+	}
+	status.Length = block.Length
+	if err != nil {
+		status.Status = "error"
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now()
+	} else {
+		status.Status = "ok"
+		status.LastSuccess = time.Now()
+	}
+}
+
+// BlockStatuses returns the read status of each register block in polling order.
+// The caller must hold s.mu.
+func (s *ModbusServer) BlockStatuses() []BlockStatus {
+	statuses := make([]BlockStatus, 0, len(s.RegisterBlocks))
+	for _, block := range s.RegisterBlocks {
+		status := BlockStatus{
+			StartAddress: block.StartAddress,
+			Length:       block.Length,
+			Status:       "pending",
+		}
+		if recorded, ok := s.blockStatus[block.StartAddress]; ok {
+			status = *recorded
+			status.Length = block.Length
+		}
+		if block.Disabled {
+			status.Status = "disabled"
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// isActive reports whether server is still the registered server for its ID.
+// Pollers and reconnect loops of removed or replaced servers use it to stop.
+func isActive(server *ModbusServer) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return servers[server.ID] == server
+}
+
 // Helper functions
 func isHtmxRequest(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("HX-Request"), "true")
 }
 
 func handleError(w http.ResponseWriter, r *http.Request, message string) {
+	handleErrorStatus(w, r, 0, message)
+}
+
+// handleErrorStatus responds like handleError with an HTTP status; 0 keeps
+// the default of handleError
+func handleErrorStatus(w http.ResponseWriter, r *http.Request, status int, message string) {
 	log.Print(message)
 	if isHtmxRequest(r) {
-		w.WriteHeader(http.StatusBadRequest)
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
 		fmt.Fprintf(w, `<div class="alert alert-danger">%s</div>`, message)
 	} else {
+		if status != 0 {
+			w.WriteHeader(status)
+		}
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
 			"error":   message,
@@ -887,95 +1935,235 @@ func handleError(w http.ResponseWriter, r *http.Request, message string) {
 
 // pollServer continuously polls a Modbus server for data
 func pollServer(server *ModbusServer) {
-	ticker := time.NewTicker(time.Duration(server.PollRate) * time.Millisecond)
+	// A server is polled by one goroutine at a time
+	server.mu.Lock()
+	if server.polling {
+		server.mu.Unlock()
+		return
+	}
+	server.polling = true
+	interval := server.pollInterval()
+	server.mu.Unlock()
+	defer func() {
+		server.mu.Lock()
+		server.polling = false
+		server.mu.Unlock()
+	}()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	skip := false
+poll:
 	for range ticker.C {
-		mu.RLock()
-		_, exists := servers[server.ID]
-		mu.RUnlock()
-
-		if !exists {
+		if !isActive(server) {
 			return
 		}
 
 		server.mu.Lock()
+		if server.Paused {
+			server.mu.Unlock()
+			continue
+		}
 		if server.client == nil {
-			server.ConnectionStatus = "error"
-			server.ConnectionError = "not connected"
+			if server.connectionMode() != connectionDisconnected {
+				server.setConnectionStatus("error", "not connected")
+			}
+			server.mu.Unlock()
+			continue
+		}
+		// Give the device a breather after a cycle that overran
+		if skip {
+			skip = false
 			server.mu.Unlock()
 			continue
 		}
-		// Process each register block
+		// Process each register block. A block that fails with an exception or
+		// timeout is marked bad and the remaining blocks are still read. Behind
+		// a shared gateway, s.mu is released while waiting for each block's turn.
+		start := time.Now()
+		succeeded := false
+		var lastErr error
 		for _, block := range server.RegisterBlocks {
-			switch {
-			case block.StartAddress < 10000: // Coils
-				values, err := server.client.ReadCoils(block.StartAddress, block.Length)
-				if err != nil {
-					server.ConnectionStatus = "error"
-					server.ConnectionError = err.Error()
-					server.mu.Unlock()
-					// Start retry goroutine if not already retrying
-					go retryConnection(server)
-					return
-				}
-				copy(server.dataModel.Coils[block.StartAddress:block.StartAddress+block.Length], values)
-			case block.StartAddress < 20000: // Discrete Inputs
-				values, err := server.client.ReadDiscreteInputs(block.StartAddress-10000, block.Length)
-				if err != nil {
-					server.ConnectionStatus = "error"
-					server.ConnectionError = err.Error()
-					server.mu.Unlock()
-					go retryConnection(server)
-					return
-				}
-				copy(server.dataModel.DiscreteInputs[block.StartAddress-10000:block.StartAddress-10000+block.Length], values)
-			case block.StartAddress < 40000: // Input Registers
-				values, err := server.client.ReadInputRegisters(block.StartAddress-30000, block.Length)
-				if err != nil {
-					server.ConnectionStatus = "error"
-					server.ConnectionError = err.Error()
-					server.mu.Unlock()
-					go retryConnection(server)
-					return
-				}
-				copy(server.dataModel.InputRegisters[block.StartAddress-30000:block.StartAddress-30000+block.Length], values)
-			default: // Holding Registers
-				values, err := server.client.ReadHoldingRegisters(block.StartAddress-40000, block.Length)
-				if err != nil {
-					server.ConnectionStatus = "error"
-					server.ConnectionError = err.Error()
-					server.mu.Unlock()
-					go retryConnection(server)
-					return
-				}
-				copy(server.dataModel.HoldingRegisters[block.StartAddress-40000:block.StartAddress-40000+block.Length], values)
+			if block.Disabled {
+				server.markBlockDisabled(block)
+				continue
+			}
+			blockStart := time.Now()
+			release, ok := server.waitGateway()
+			if !ok {
+				server.mu.Unlock()
+				continue poll
+			}
+			err := server.readBlock(block)
+			release()
+			server.recordBlockResult(block, err)
+			server.checkAutoSplit(block, err)
+			server.recordBlockTime(block, time.Since(blockStart))
+			if err == nil {
+				succeeded = true
+				delete(server.restored, block.StartAddress)
+				pollThroughput.add(int(block.Length))
+				// Set last data received time after successful read
+				server.LastDataReceived = time.Now()
+				continue
+			}
+
+			lastErr = err
+			logMessage(DebugLevel, "Error reading block %d+%d from server %s: %v", block.StartAddress, block.Length, server.ID, err)
+			if server.client.IsConnectionError(err) {
+				server.setConnectionStatus("error", err.Error())
+				server.mu.Unlock()
+				// Start retry goroutine if not already retrying
+				go retryConnection(server)
+				return
 			}
-			// Set last data received time after successful read
-			server.LastDataReceived = time.Now()
 		}
-		server.ConnectionStatus = "ok"
-		server.ConnectionError = ""
+		if lastErr != nil && !succeeded {
+			server.setConnectionStatus("error", lastErr.Error())
+		} else {
+			server.setConnectionStatus("ok", "")
+		}
+
+		// Back off while no block can be read, so a dead device does not use
+		// up the bandwidth of a shared gateway
+		failed := lastErr != nil && !succeeded
+		skip = server.recordCycle(time.Since(start), interval)
+		server.recordPollResult(failed)
+		server.recordFilterSamples()
+		server.checkFlatlines()
+		server.checkAlarms()
+		server.releaseConnection()
+
+		// Switch a redundant server to its other path after sustained errors
+		if server.recordPathResult(failed) {
+			server.client.Close()
+			server.client = nil
+			server.mu.Unlock()
+			go retryConnection(server)
+			return
+		}
+		if next := server.pollInterval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
 		server.mu.Unlock()
+
+		forwardMirrors(server)
+	}
+}
+
+// checkResponseLength returns an error if a device returned a different
+// number of values than requested, so a misbehaving device cannot cause an
+// out of range access
+func checkResponseLength(got int, want uint16) error {
+	if got != int(want) {
+		return fmt.Errorf("device returned %d values, expected %d", got, want)
 	}
+	return nil
+}
+
+// readBlock reads a register block into the data model. The caller must hold s.mu.
+func (s *ModbusServer) readBlock(block RegisterBlock) error {
+	// Changes are only tracked once the block has been read before
+	status, ok := s.blockStatus[block.StartAddress]
+	trackChanges := ok && !status.LastSuccess.IsZero()
+	if s.lastChange == nil {
+		s.lastChange = make(map[uint16]time.Time)
+	}
+	now := time.Now()
+	changed := func(j int) {
+		if trackChanges {
+			s.lastChange[block.StartAddress+uint16(j)] = now
+		}
+	}
+
+	switch {
+	case block.StartAddress < 10000: // Coils
+		values, err := s.client.ReadCoils(block.StartAddress, block.Length)
+		if err != nil {
+			return err
+		}
+		if err := checkResponseLength(len(values), block.Length); err != nil {
+			return err
+		}
+		dst := s.dataModel.Coils[block.StartAddress : block.StartAddress+block.Length]
+		for j := range values {
+			if dst[j] != values[j] {
+				changed(j)
+			}
+		}
+		copy(dst, values)
+	case block.StartAddress < 20000: // Discrete Inputs
+		values, err := s.client.ReadDiscreteInputs(block.StartAddress-10000, block.Length)
+		if err != nil {
+			return err
+		}
+		if err := checkResponseLength(len(values), block.Length); err != nil {
+			return err
+		}
+		dst := s.dataModel.DiscreteInputs[block.StartAddress-10000 : block.StartAddress-10000+block.Length]
+		for j := range values {
+			if dst[j] != values[j] {
+				changed(j)
+			}
+		}
+		copy(dst, values)
+	case block.StartAddress < 40000: // Input Registers
+		values, err := s.client.ReadInputRegisters(block.StartAddress-30000, block.Length)
+		if err != nil {
+			return err
+		}
+		if err := checkResponseLength(len(values), block.Length); err != nil {
+			return err
+		}
+		dst := s.dataModel.InputRegisters[block.StartAddress-30000 : block.StartAddress-30000+block.Length]
+		for j := range values {
+			if dst[j] != values[j] {
+				changed(j)
+			}
+		}
+		copy(dst, values)
+	default: // Holding Registers
+		values, err := s.client.ReadHoldingRegisters(block.StartAddress-40000, block.Length)
+		if err != nil {
+			return err
+		}
+		if err := checkResponseLength(len(values), block.Length); err != nil {
+			return err
+		}
+		dst := s.dataModel.HoldingRegisters[block.StartAddress-40000 : block.StartAddress-40000+block.Length]
+		for j := range values {
+			if dst[j] != values[j] {
+				changed(j)
+			}
+		}
+		copy(dst, values)
+	}
+	return nil
 }
 
 // retryConnection tries to reconnect every second until successful, then restarts polling
 func retryConnection(server *ModbusServer) {
 	for {
 		time.Sleep(1 * time.Second)
-		client, err := NewModbusClient(server.Address, server.Port)
+		if !isActive(server) || server.stayDisconnected() {
+			return
+		}
+		client, err := connectDevice(server)
 		server.mu.Lock()
 		if err == nil {
+			if server.client != nil {
+				server.client.Close()
+			}
 			server.client = client
-			server.ConnectionStatus = "ok"
-			server.ConnectionError = ""
+			server.setConnectionStatus("ok", "")
+			server.selectFirmwareVariant()
 			server.mu.Unlock()
 			go pollServer(server)
 			return
 		} else {
-			server.ConnectionStatus = "error"
-			server.ConnectionError = err.Error()
+			server.setConnectionStatus("error", err.Error())
+			server.recordPathResult(true)
 		}
 		server.mu.Unlock()
 	}
@@ -999,7 +2187,7 @@ func handleServerStatus(w http.ResponseWriter, r *http.Request) {
 	server.mu.Lock()
 	defer server.mu.Unlock()
 	w.Header().Set("Content-Type", "text/html")
-	if err := templates.ExecuteTemplate(w, "serverStatus", server); err != nil {
+	if err := templatesFor(r).ExecuteTemplate(w, "serverStatus", server); err != nil {
 		handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
 		return
 	}
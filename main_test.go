@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func postServer(body string, htmx bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/servers", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if htmx {
+		r.Header.Set("HX-Request", "true")
+	}
+	w := httptest.NewRecorder()
+	handleServers(w, r)
+	return w
+}
+
+func TestAddServerDuplicate(t *testing.T) {
+	const body = `{"id": "sim-dup", "address": "localhost", "port": 502, "pollRate": 1000, "protocol": "simulator"}`
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(servers, "sim-dup")
+		mu.Unlock()
+	})
+
+	// Of concurrent requests adding the same server, exactly one succeeds
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postServer(body, false).Code
+		}()
+	}
+	wg.Wait()
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d of %d requests added the server", created, len(codes))
+	}
+
+	w := postServer(body, true)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `class="alert alert-danger"`) {
+		t.Errorf("htmx duplicate: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAddServerPollRate(t *testing.T) {
+	for _, rate := range []string{"0", "-5"} {
+		w := postServer(`{"id": "sim-rate", "address": "localhost", "port": 502, "protocol": "simulator", "pollRate": `+rate+`}`, false)
+		if !strings.Contains(w.Body.String(), "pollRate "+rate+" must be greater than 0") {
+			t.Errorf("pollRate %s: %s", rate, w.Body)
+		}
+	}
+	mu.RLock()
+	_, added := servers["sim-rate"]
+	mu.RUnlock()
+	if added {
+		t.Error("server with an invalid poll rate was added")
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mDNS multicast group and the services this application advertises
+const (
+	mdnsAddress      = "224.0.0.251:5353"
+	mdnsHTTPService  = "_http._tcp.local"
+	mdnsOwnService   = "_modbusbrowser._tcp.local" // used to find other instances
+	mdnsTTL          = 120                         // seconds
+	mdnsQueryPeriod  = time.Minute
+	mdnsInstanceLife = 5 * time.Minute // instances not seen for this long are dropped
+)
+
+// DNS record types and classes used by the responder
+const (
+	dnsTypeA      = 1
+	dnsTypePTR    = 12
+	dnsTypeTXT    = 16
+	dnsTypeSRV    = 33
+	dnsTypeANY    = 255
+	dnsClassIN    = 1
+	dnsClassMask  = 0x7FFF // strips the cache-flush / unicast-response bit
+	dnsCacheFlush = 0x8000
+)
+
+// dnsRecord is a resource record, with rdata already encoded for sending or
+// decoded into the fields used for discovery
+type dnsRecord struct {
+	Name   string
+	Type   uint16
+	Class  uint16
+	TTL    uint32
+	Target string // PTR and SRV
+	Port   uint16 // SRV
+	IP     net.IP // A
+	Text   []string
+}
+
+// Instance is another modbusbrowser found on the network
+type Instance struct {
+	Name     string    `json:"name"`
+	Host     string    `json:"host"`
+	Port     uint16    `json:"port"`
+	URL      string    `json:"url,omitempty"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// instancesTemplate renders links to discovered instances
+const instancesTemplate = `
+{{define "instances"}}{{if .}}<small class="text-muted">Other instances:</small>
+{{range .}}{{if .URL}}<a class="btn btn-sm btn-outline-secondary ms-1" href="{{.URL}}" target="_blank">{{.Name}}</a>{{end}}{{end}}{{end}}{{end}}`
+
+// mdnsResponder advertises this instance and tracks other instances
+type mdnsResponder struct {
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	instance string // instance label, e.g. "modbusbrowser on plant-gw"
+	host     string // e.g. "plant-gw.local"
+	port     int
+
+	mu       sync.Mutex
+	srv      map[string]dnsRecord // instance name -> SRV record
+	addrs    map[string]net.IP    // host name -> address
+	lastSeen map[string]time.Time // instance name -> time last announced
+}
+
+// mdns is the running responder, nil when mDNS is disabled
+var mdns *mdnsResponder
+
+// startMDNS advertises the web UI on port under the given instance name
+// and starts looking for other instances
+func startMDNS(name string, port int) error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "modbusbrowser"
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	if name == "" {
+		name = "Modbus Browser on " + hostname
+	}
+
+	m := &mdnsResponder{
+		conn:     conn,
+		group:    group,
+		instance: strings.ReplaceAll(name, ".", "-"),
+		host:     hostname + ".local",
+		port:     port,
+		srv:      make(map[string]dnsRecord),
+		addrs:    make(map[string]net.IP),
+		lastSeen: make(map[string]time.Time),
+	}
+	mdns = m
+
+	go m.serve()
+	go m.browse()
+	logMessage(InfoLevel, "Advertising %q via mDNS as %s port %d", m.instance, m.host, port)
+	return nil
+}
+
+// records returns the records describing this instance
+func (m *mdnsResponder) records() []dnsRecord {
+	var records []dnsRecord
+	for _, service := range []string{mdnsHTTPService, mdnsOwnService} {
+		full := m.instance + "." + service
+		records = append(records,
+			dnsRecord{Name: service, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Target: full},
+			dnsRecord{Name: full, Type: dnsTypeSRV, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, Target: m.host, Port: uint16(m.port)},
+			dnsRecord{Name: full, Type: dnsTypeTXT, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, Text: []string{"path=/"}},
+		)
+	}
+	for _, ip := range localIPv4s() {
+		records = append(records, dnsRecord{Name: m.host, Type: dnsTypeA, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, IP: ip})
+	}
+	return records
+}
+
+// serve answers queries for this instance and records announcements of others
+func (m *mdnsResponder) serve() {
+	// Announce on startup
+	m.send(m.records(), nil)
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			logMessage(ErrorLevel, "mDNS read error: %v", err)
+			return
+		}
+
+		isResponse, questions, records, err := parseDNSMessage(buf[:n])
+		if err != nil {
+			logMessage(DebugLevel, "Ignoring malformed mDNS packet: %v", err)
+			continue
+		}
+		if isResponse {
+			m.learn(records)
+			continue
+		}
+
+		var answers []dnsRecord
+		for _, q := range questions {
+			for _, record := range m.records() {
+				if strings.EqualFold(record.Name, q.Name) && (q.Type == record.Type || q.Type == dnsTypeANY) {
+					answers = append(answers, record)
+				}
+			}
+		}
+		if len(answers) > 0 {
+			logMessage(DebugLevel, "Answering mDNS query with %d records", len(answers))
+			m.send(answers, nil)
+		}
+	}
+}
+
+// browse periodically asks other instances to announce themselves
+func (m *mdnsResponder) browse() {
+	for {
+		m.send(nil, []dnsRecord{{Name: mdnsOwnService, Type: dnsTypePTR, Class: dnsClassIN}})
+		time.Sleep(mdnsQueryPeriod)
+	}
+}
+
+// learn records SRV and A records of other instances
+func (m *mdnsResponder) learn(records []dnsRecord) {
+	own := m.instance + "." + mdnsOwnService
+	suffix := "." + mdnsOwnService
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, record := range records {
+		switch record.Type {
+		case dnsTypeSRV:
+			if strings.HasSuffix(strings.ToLower(record.Name), suffix) && !strings.EqualFold(record.Name, own) {
+				m.srv[record.Name] = record
+				m.lastSeen[record.Name] = time.Now()
+			}
+		case dnsTypeA:
+			m.addrs[strings.ToLower(record.Name)] = record.IP
+		}
+	}
+}
+
+// instances returns the other instances seen recently
+func (m *mdnsResponder) instances() []Instance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Instance{}
+	for name, srv := range m.srv {
+		seen := m.lastSeen[name]
+		if time.Since(seen) > mdnsInstanceLife {
+			continue
+		}
+		instance := Instance{
+			Name:     strings.TrimSuffix(name, "."+mdnsOwnService),
+			Host:     srv.Target,
+			Port:     srv.Port,
+			LastSeen: seen,
+		}
+		if ip, ok := m.addrs[strings.ToLower(srv.Target)]; ok {
+			instance.URL = fmt.Sprintf("http://%s/", net.JoinHostPort(ip.String(), fmt.Sprint(srv.Port)))
+		}
+		list = append(list, instance)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// send multicasts a message with the given answers or questions
+func (m *mdnsResponder) send(answers, questions []dnsRecord) {
+	msg, err := buildDNSMessage(answers, questions)
+	if err != nil {
+		logMessage(ErrorLevel, "Error building mDNS message: %v", err)
+		return
+	}
+	if _, err := m.conn.WriteToUDP(msg, m.group); err != nil {
+		logMessage(DebugLevel, "mDNS send error: %v", err)
+	}
+}
+
+// handleInstances lists other modbusbrowser instances found via mDNS
+func handleInstances(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	instances := []Instance{}
+	if mdns != nil {
+		instances = mdns.instances()
+	}
+
+	if isHtmxRequest(r) {
+		w.Header().Set("Content-Type", "text/html")
+		if err := templatesFor(r).ExecuteTemplate(w, "instances", instances); err != nil {
+			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"enabled":   mdns != nil,
+		"instances": instances,
+	})
+}
+
+// localIPv4s returns the IPv4 addresses of the up, non-loopback interfaces
+func localIPv4s() []net.IP {
+	var ips []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ip4 := ipNet.IP.To4(); ip4 != nil {
+					ips = append(ips, ip4)
+				}
+			}
+		}
+	}
+	return ips
+}
+
+// buildDNSMessage encodes an mDNS response (answers) or query (questions)
+func buildDNSMessage(answers, questions []dnsRecord) ([]byte, error) {
+	msg := make([]byte, 12)
+	if len(answers) > 0 {
+		binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	}
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+
+	var err error
+	for _, q := range questions {
+		if msg, err = appendDNSName(msg, q.Name); err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint16(msg, q.Type)
+		msg = binary.BigEndian.AppendUint16(msg, q.Class)
+	}
+
+	for _, record := range answers {
+		if msg, err = appendDNSName(msg, record.Name); err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint16(msg, record.Type)
+		msg = binary.BigEndian.AppendUint16(msg, record.Class)
+		msg = binary.BigEndian.AppendUint32(msg, record.TTL)
+
+		var rdata []byte
+		switch record.Type {
+		case dnsTypePTR:
+			rdata, err = appendDNSName(nil, record.Target)
+		case dnsTypeSRV:
+			rdata = binary.BigEndian.AppendUint16(nil, 0) // priority
+			rdata = binary.BigEndian.AppendUint16(rdata, 0)
+			rdata = binary.BigEndian.AppendUint16(rdata, record.Port)
+			rdata, err = appendDNSName(rdata, record.Target)
+		case dnsTypeTXT:
+			for _, text := range record.Text {
+				rdata = append(rdata, byte(len(text)))
+				rdata = append(rdata, text...)
+			}
+		case dnsTypeA:
+			rdata = record.IP.To4()
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+	}
+	return msg, nil
+}
+
+// appendDNSName appends a dotted name as DNS labels. The first label (the
+// instance name) may contain any characters except dots.
+func appendDNSName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS label %q in %q", label, name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+var errDNSShort = errors.New("message too short")
+
+// parseDNSMessage decodes the questions and resource records of a DNS message
+func parseDNSMessage(msg []byte) (isResponse bool, questions, records []dnsRecord, err error) {
+	if len(msg) < 12 {
+		return false, nil, nil, errDNSShort
+	}
+	isResponse = msg[2]&0x80 != 0
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var q dnsRecord
+		if q.Name, off, err = readDNSName(msg, off); err != nil {
+			return
+		}
+		if off+4 > len(msg) {
+			return false, nil, nil, errDNSShort
+		}
+		q.Type = binary.BigEndian.Uint16(msg[off:])
+		q.Class = binary.BigEndian.Uint16(msg[off+2:]) & dnsClassMask
+		off += 4
+		questions = append(questions, q)
+	}
+
+	for i := 0; i < rrcount; i++ {
+		var record dnsRecord
+		if record.Name, off, err = readDNSName(msg, off); err != nil {
+			return
+		}
+		if off+10 > len(msg) {
+			return false, nil, nil, errDNSShort
+		}
+		record.Type = binary.BigEndian.Uint16(msg[off:])
+		record.Class = binary.BigEndian.Uint16(msg[off+2:]) & dnsClassMask
+		record.TTL = binary.BigEndian.Uint32(msg[off+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return false, nil, nil, errDNSShort
+		}
+		rdata := msg[off : off+rdlen]
+
+		switch record.Type {
+		case dnsTypePTR:
+			record.Target, _, err = readDNSName(msg, off)
+		case dnsTypeSRV:
+			if rdlen < 7 {
+				return false, nil, nil, errDNSShort
+			}
+			record.Port = binary.BigEndian.Uint16(rdata[4:])
+			record.Target, _, err = readDNSName(msg, off+6)
+		case dnsTypeA:
+			if rdlen == 4 {
+				record.IP = net.IP(append([]byte(nil), rdata...))
+			}
+		}
+		if err != nil {
+			return
+		}
+		off += rdlen
+		records = append(records, record)
+	}
+	return isResponse, questions, records, nil
+}
+
+// readDNSName reads a possibly compressed name at off and returns it with
+// the offset following it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if jumps++; jumps > 10 {
+				return "", 0, errors.New("too many compression pointers")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
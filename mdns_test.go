@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	instance := "Plant Browser (2)." + mdnsOwnService
+	answers := []dnsRecord{
+		{Name: mdnsOwnService, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Target: instance},
+		{Name: instance, Type: dnsTypeSRV, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, Port: 8080, Target: "plant.local"},
+		{Name: instance, Type: dnsTypeTXT, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, Text: []string{"path=/", "version=1"}},
+		{Name: "plant.local", Type: dnsTypeA, Class: dnsClassIN | dnsCacheFlush, TTL: mdnsTTL, IP: net.IPv4(192, 168, 1, 20)},
+	}
+	msg, err := buildDNSMessage(answers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isResponse, questions, records, err := parseDNSMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isResponse || len(questions) != 0 {
+		t.Errorf("isResponse = %v, %d questions", isResponse, len(questions))
+	}
+	// Classes come back without the cache-flush bit, and TXT strings are not decoded
+	want := []dnsRecord{
+		{Name: mdnsOwnService, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Target: instance},
+		{Name: instance, Type: dnsTypeSRV, Class: dnsClassIN, TTL: mdnsTTL, Port: 8080, Target: "plant.local"},
+		{Name: instance, Type: dnsTypeTXT, Class: dnsClassIN, TTL: mdnsTTL},
+		{Name: "plant.local", Type: dnsTypeA, Class: dnsClassIN, TTL: mdnsTTL, IP: net.IP{192, 168, 1, 20}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+
+	query, err := buildDNSMessage(nil, []dnsRecord{{Name: mdnsHTTPService, Type: dnsTypePTR, Class: dnsClassIN}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isResponse, questions, records, err = parseDNSMessage(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isResponse || len(records) != 0 || !reflect.DeepEqual(questions, []dnsRecord{{Name: mdnsHTTPService, Type: dnsTypePTR, Class: dnsClassIN}}) {
+		t.Errorf("query parsed as %v, %+v, %+v", isResponse, questions, records)
+	}
+}
+
+func TestDNSMessageInvalidName(t *testing.T) {
+	for _, name := range []string{"a..local", string(make([]byte, 64)) + ".local"} {
+		if _, err := buildDNSMessage([]dnsRecord{{Name: name, Type: dnsTypeA, IP: net.IPv4(10, 0, 0, 1)}}, nil); err == nil {
+			t.Errorf("buildDNSMessage accepted name %q", name)
+		}
+	}
+}
+
+func TestParseDNSMessageCompressed(t *testing.T) {
+	// A response for "x._http._tcp.local" whose PTR target points back
+	// into the question name
+	msg := []byte{
+		0x00, 0x00, 0x84, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		// question at offset 12: _http._tcp.local PTR IN
+		5, '_', 'h', 't', 't', 'p', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0x00, 0x0C, 0x00, 0x01,
+		// answer: pointer to the question name, PTR IN, TTL 120, target x + pointer
+		0xC0, 12, 0x00, 0x0C, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x04,
+		1, 'x', 0xC0, 12,
+	}
+	_, questions, records, err := parseDNSMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 1 || questions[0].Name != "_http._tcp.local" {
+		t.Errorf("questions = %+v", questions)
+	}
+	want := []dnsRecord{{Name: "_http._tcp.local", Type: dnsTypePTR, Class: dnsClassIN, TTL: 120, Target: "x._http._tcp.local"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+}
+
+func TestParseDNSMessageMalformed(t *testing.T) {
+	answer, err := buildDNSMessage([]dnsRecord{{Name: "plant.local", Type: dnsTypeSRV, Class: dnsClassIN, TTL: mdnsTTL, Port: 80, Target: "plant.local"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 1, 0, 1}
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"short header", answer[:11]},
+		{"truncated name", answer[:15]},
+		{"truncated record header", answer[:len("plant.local")+2+12+4]},
+		{"truncated rdata", answer[:len(answer)-1]},
+		{"pointer loop", loop},
+		{"pointer past the end", []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 200, 0, 1, 0, 1}},
+		{"missing question", []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := parseDNSMessage(tt.msg); err == nil {
+			t.Errorf("%s: parseDNSMessage accepted % X", tt.name, tt.msg)
+		}
+	}
+}
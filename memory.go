@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// ServerMemory is the memory held for one server, in bytes. Sizes are
+// estimated from the number of values kept; the overhead of maps and of the
+// allocator is not included, so the process uses somewhat more.
+type ServerMemory struct {
+	Server         string `json:"server"`
+	DataModel      int64  `json:"dataModel"`      // the coils and registers read from the device
+	RegisterState  int64  `json:"registerState"`  // register map, change times, flatline and filter state
+	History        int64  `json:"history"`        // historian samples, for sparklines and time travel
+	HistorySamples int    `json:"historySamples"` // number of samples kept
+	WriteHistory   int64  `json:"writeHistory"`   // recorded writes, for revert
+	Writes         int    `json:"writes"`         // number of writes kept
+	Report         int64  `json:"report"`         // statistics of the current report period
+	Total          int64  `json:"total"`
+}
+
+// RuntimeMemory is the memory and garbage collection statistics of the process
+type RuntimeMemory struct {
+	HeapAlloc    uint64     `json:"heapAlloc"` // bytes of live and not yet collected objects
+	HeapInuse    uint64     `json:"heapInuse"`
+	HeapObjects  uint64     `json:"heapObjects"`
+	Sys          uint64     `json:"sys"` // bytes obtained from the operating system
+	NumGC        uint32     `json:"numGC"`
+	PauseTotalMs float64    `json:"pauseTotalMs"`
+	LastGC       *time.Time `json:"lastGC,omitempty"` // nil before the first collection
+	Goroutines   int        `json:"goroutines"`
+}
+
+// serverMemory estimates the memory held in the server itself. The caller
+// must hold s.mu.
+func (s *ModbusServer) serverMemory() ServerMemory {
+	var reg RegisterConfig
+	var t time.Time
+	state := int64(len(s.registerMap)) * int64(unsafe.Sizeof(reg)+unsafe.Sizeof(uint16(0)))
+	state += int64(len(s.lastChange)+len(s.flatlineWatch)) * int64(unsafe.Sizeof(t)+unsafe.Sizeof(uint16(0)))
+	for _, samples := range s.filterSamples {
+		state += int64(cap(samples)) * int64(unsafe.Sizeof(float64(0)))
+	}
+	state += int64(cap(s.transitions)) * int64(unsafe.Sizeof(healthTransition{}))
+	return ServerMemory{
+		Server:        s.ID,
+		DataModel:     int64(unsafe.Sizeof(s.dataModel)),
+		RegisterState: state,
+	}
+}
+
+// historyMemory adds the size of the historian's samples to the servers
+func (h *historyStore) historyMemory(list map[string]*ServerMemory) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for key, series := range h.series {
+		if m, ok := list[key.server]; ok {
+			m.History += int64(cap(series.samples)) * int64(unsafe.Sizeof(historySample{}))
+			m.HistorySamples += len(series.samples)
+		}
+	}
+}
+
+// writeHistoryMemory adds the size of the recorded writes to the servers
+func writeHistoryMemory(list map[string]*ServerMemory) {
+	writeHistoryMu.Lock()
+	defer writeHistoryMu.Unlock()
+	for id, ops := range writeHistory {
+		m, ok := list[id]
+		if !ok {
+			continue
+		}
+		m.Writes += len(ops)
+		for _, op := range ops {
+			m.WriteHistory += int64(unsafe.Sizeof(*op))
+			for _, change := range op.Changes {
+				m.WriteHistory += int64(unsafe.Sizeof(change)) + 2*int64(cap(change.Previous)+cap(change.Values))
+			}
+		}
+	}
+}
+
+// reportMemory adds the size of the report statistics to the servers
+func (c *reportCollector) reportMemory(list map[string]*ServerMemory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, stats := range c.stats {
+		if m, ok := list[id]; ok {
+			m.Report += int64(len(stats)) * int64(unsafe.Sizeof(registerStats{})+unsafe.Sizeof(uint16(0)))
+		}
+	}
+}
+
+// runtimeMemory returns the memory statistics of the Go runtime
+func runtimeMemory() RuntimeMemory {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	memory := RuntimeMemory{
+		HeapAlloc:    stats.HeapAlloc,
+		HeapInuse:    stats.HeapInuse,
+		HeapObjects:  stats.HeapObjects,
+		Sys:          stats.Sys,
+		NumGC:        stats.NumGC,
+		PauseTotalMs: milliseconds(time.Duration(stats.PauseTotalNs)),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+	if stats.LastGC > 0 {
+		lastGC := time.Unix(0, int64(stats.LastGC))
+		memory.LastGC = &lastGC
+	}
+	return memory
+}
+
+// handleMemoryStats serves the estimated memory held for each server on GET
+// /api/stats/memory?limit=10, the largest first, with the memory and garbage
+// collection statistics of the process
+func handleMemoryStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			handleError(w, r, fmt.Sprintf("Invalid limit: %q", s))
+			return
+		}
+		limit = n
+	}
+
+	mu.RLock()
+	byID := make(map[string]*ServerMemory, len(servers))
+	for _, server := range servers {
+		server.mu.Lock()
+		m := server.serverMemory()
+		server.mu.Unlock()
+		byID[m.Server] = &m
+	}
+	h, c := history, collector
+	mu.RUnlock()
+
+	// The historian and the report collector lock servers while holding
+	// their own lock, so they are only locked after the servers are released
+	if h != nil {
+		h.historyMemory(byID)
+	}
+	writeHistoryMemory(byID)
+	if c != nil {
+		c.reportMemory(byID)
+	}
+
+	list := make([]ServerMemory, 0, len(byID))
+	var total int64
+	for _, m := range byID {
+		m.Total = m.DataModel + m.RegisterState + m.History + m.WriteHistory + m.Report
+		total += m.Total
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		return list[i].Server < list[j].Server
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"total":   total,
+		"servers": list,
+		"runtime": runtimeMemory(),
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// endpointKey identifies the requests counted together
+type endpointKey struct {
+	endpoint string
+	method   string
+	status   int
+}
+
+// endpointLatency is the latency histogram of one endpoint
+type endpointLatency struct {
+	buckets []uint64 // cumulative counts per latencyBuckets entry
+	count   uint64
+	sum     float64
+}
+
+// httpMetrics holds request counts and latencies per endpoint
+type httpMetrics struct {
+	mu        sync.Mutex
+	requests  map[endpointKey]uint64
+	latencies map[string]*endpointLatency
+}
+
+// requestMetrics collects the metrics of all HTTP requests
+var requestMetrics = &httpMetrics{
+	requests:  make(map[endpointKey]uint64),
+	latencies: make(map[string]*endpointLatency),
+}
+
+// accessLog enables logging of every HTTP request
+var accessLog bool
+
+// observe records a completed request
+func (m *httpMetrics) observe(endpoint, method string, status int, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[endpointKey{endpoint, method, status}]++
+	latency, ok := m.latencies[endpoint]
+	if !ok {
+		latency = &endpointLatency{buckets: make([]uint64, len(latencyBuckets))}
+		m.latencies[endpoint] = latency
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			latency.buckets[i]++
+		}
+	}
+	latency.count++
+	latency.sum += seconds
+}
+
+// statusRecorder captures the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush supports streaming handlers such as /api/events
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports the WebSocket upgrade of /api/ws
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument wraps a handler to record per-endpoint metrics and, if enabled,
+// write an access log line for every request
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// Count by registered pattern rather than path to keep the number of series bounded
+		_, endpoint := mux.Handler(r)
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		requestMetrics.observe(endpoint, r.Method, rec.status, elapsed)
+
+		if accessLog {
+			log.Printf("access method=%s path=%q endpoint=%q status=%d bytes=%d duration=%s remote=%s user_agent=%q",
+				r.Method, r.URL.Path, endpoint, rec.status, rec.bytes, elapsed.Round(time.Microsecond), r.RemoteAddr, r.UserAgent())
+		}
+	})
+}
+
+// handleMetrics serves request metrics in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestMetrics.mu.Lock()
+	keys := make([]endpointKey, 0, len(requestMetrics.requests))
+	for key := range requestMetrics.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	endpoints := make([]string, 0, len(requestMetrics.latencies))
+	for endpoint := range requestMetrics.latencies {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var b strings.Builder
+	b.WriteString("# HELP modbusbrowser_http_requests_total HTTP requests by endpoint, method and status.\n")
+	b.WriteString("# TYPE modbusbrowser_http_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "modbusbrowser_http_requests_total{endpoint=%q,method=%q,status=\"%d\"} %d\n",
+			key.endpoint, key.method, key.status, requestMetrics.requests[key])
+	}
+
+	b.WriteString("# HELP modbusbrowser_http_request_duration_seconds HTTP request latency by endpoint.\n")
+	b.WriteString("# TYPE modbusbrowser_http_request_duration_seconds histogram\n")
+	for _, endpoint := range endpoints {
+		latency := requestMetrics.latencies[endpoint]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&b, "modbusbrowser_http_request_duration_seconds_bucket{endpoint=%q,le=\"%g\"} %d\n", endpoint, bound, latency.buckets[i])
+		}
+		fmt.Fprintf(&b, "modbusbrowser_http_request_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", endpoint, latency.count)
+		fmt.Fprintf(&b, "modbusbrowser_http_request_duration_seconds_sum{endpoint=%q} %g\n", endpoint, latency.sum)
+		fmt.Fprintf(&b, "modbusbrowser_http_request_duration_seconds_count{endpoint=%q} %d\n", endpoint, latency.count)
+	}
+	requestMetrics.mu.Unlock()
+
+	mu.RLock()
+	ids := make([]string, 0, len(servers))
+	for id := range servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	b.WriteString("# HELP modbusbrowser_poll_overruns_total Poll cycles that took longer than the poll interval, by server.\n")
+	b.WriteString("# TYPE modbusbrowser_poll_overruns_total counter\n")
+	for _, id := range ids {
+		server := servers[id]
+		server.mu.Lock()
+		fmt.Fprintf(&b, "modbusbrowser_poll_overruns_total{server=%q} %d\n", id, server.overruns)
+		server.mu.Unlock()
+	}
+	mu.RUnlock()
+
+	b.WriteString("# HELP modbusbrowser_table_renders_shared_total Register table refreshes served from a rendering already in progress for another request.\n")
+	b.WriteString("# TYPE modbusbrowser_table_renders_shared_total counter\n")
+	fmt.Fprintf(&b, "modbusbrowser_table_renders_shared_total %d\n", sharedRenders.Load())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// currentConfigVersion is the schemaVersion written by this version of the application
+const currentConfigVersion = 1
+
+// configMigrations upgrade a decoded configuration file by one schema version.
+// configMigrations[i] migrates from version i to version i+1 and returns
+// warnings about deprecated content it changed.
+var configMigrations = []func(config map[string]interface{}) []ConfigIssue{
+	migrateConfigV0,
+}
+
+// migrateConfig upgrades a configuration file to currentConfigVersion.
+// Files without a schemaVersion are treated as version 0.
+func migrateConfig(data []byte) ([]byte, []ConfigIssue, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+
+	version := 0
+	if v, ok := config["schemaVersion"]; ok {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return nil, nil, fmt.Errorf("schemaVersion must be a non-negative integer")
+		}
+		version = int(f)
+	}
+	if version > currentConfigVersion {
+		return nil, nil, fmt.Errorf("schemaVersion %d is newer than the supported version %d", version, currentConfigVersion)
+	}
+	if version == currentConfigVersion {
+		return data, nil, nil
+	}
+
+	var warnings []ConfigIssue
+	for ; version < currentConfigVersion; version++ {
+		warnings = append(warnings, configMigrations[version](config)...)
+	}
+	config["schemaVersion"] = currentConfigVersion
+
+	migrated, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrated, warnings, nil
+}
+
+// migrateConfigV0 removes the "type" field from blocks and registers, which
+// older versions of the UI wrote but the address already determines
+func migrateConfigV0(config map[string]interface{}) []ConfigIssue {
+	var warnings []ConfigIssue
+	removeType := func(obj map[string]interface{}, path string) {
+		if obj == nil {
+			return
+		}
+		if _, ok := obj["type"]; ok {
+			delete(obj, "type")
+			warnings = append(warnings, ConfigIssue{
+				Path:    path + ".type",
+				Message: "deprecated field \"type\" was removed; the register type is derived from the address",
+			})
+		}
+	}
+
+	for i, server := range jsonObjects(config["servers"]) {
+		if server == nil {
+			continue
+		}
+		for j, block := range jsonObjects(server["registerBlocks"]) {
+			blockPath := fmt.Sprintf("servers[%d].registerBlocks[%d]", i, j)
+			removeType(block, blockPath)
+			if block == nil {
+				continue
+			}
+			for k, reg := range jsonObjects(block["registers"]) {
+				removeType(reg, fmt.Sprintf("%s.registers[%d]", blockPath, k))
+			}
+		}
+	}
+	return warnings
+}
+
+// jsonObjects returns the elements of a decoded JSON array as objects.
+// Elements that are not objects are returned as nil, so indexes match the source array.
+func jsonObjects(v interface{}) []map[string]interface{} {
+	items, _ := v.([]interface{})
+	objects := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		objects[i], _ = item.(map[string]interface{})
+	}
+	return objects
+}
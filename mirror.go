@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorRule forwards the value of a register of one server to a coil or
+// holding register of another, for devices that cannot talk to each other
+type MirrorRule struct {
+	Name   string  `json:"name,omitempty"`
+	Source string  `json:"source"`           // server:address of any table
+	Target string  `json:"target"`           // server:address of a coil or holding register
+	Scale  float64 `json:"scale,omitempty"`  // multiplier applied to the source value, 1 if 0
+	Offset float64 `json:"offset,omitempty"` // added after scaling
+}
+
+// mirrorState is a running mirror rule and the outcome of its last write
+type mirrorState struct {
+	rule          MirrorRule
+	sourceID      string
+	sourceAddress uint16
+	targetID      string
+	targetAddress uint16
+	written       []uint16 // words last written to the target
+	lastWrite     time.Time
+	lastValue     interface{} // value last written, after scaling
+	lastError     string
+	writes        int
+}
+
+var (
+	mirrorsMu sync.Mutex
+	mirrors   = make(map[string]*mirrorState) // by target
+)
+
+// parseRegisterRef parses a "server:address" register reference
+func parseRegisterRef(ref string) (string, uint16, error) {
+	id, address, found := strings.Cut(ref, ":")
+	addr, err := strconv.ParseUint(address, 10, 16)
+	if !found || id == "" || err != nil {
+		return "", 0, fmt.Errorf("invalid register %q (must be server:address)", ref)
+	}
+	if _, ok := addressRangeEnd(uint16(addr)); !ok {
+		return "", 0, fmt.Errorf("address %d is not in a valid address range", addr)
+	}
+	return id, uint16(addr), nil
+}
+
+// scale returns the rule's multiplier
+func (m MirrorRule) scale() float64 {
+	if m.Scale == 0 {
+		return 1
+	}
+	return m.Scale
+}
+
+// startMirrors adds mirror rules, replacing those with the same target
+func startMirrors(rules []MirrorRule) {
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+	for _, rule := range rules {
+		sourceID, sourceAddress, err := parseRegisterRef(rule.Source)
+		if err != nil {
+			logMessage(ErrorLevel, "Mirror %s: %v", rule.Target, err)
+			continue
+		}
+		targetID, targetAddress, err := parseRegisterRef(rule.Target)
+		if err != nil {
+			logMessage(ErrorLevel, "Mirror %s: %v", rule.Target, err)
+			continue
+		}
+		if existing, ok := mirrors[rule.Target]; ok && reflect.DeepEqual(existing.rule, rule) {
+			continue
+		}
+		mirrors[rule.Target] = &mirrorState{
+			rule:          rule,
+			sourceID:      sourceID,
+			sourceAddress: sourceAddress,
+			targetID:      targetID,
+			targetAddress: targetAddress,
+		}
+		logMessage(InfoLevel, "Mirroring %s to %s", rule.Source, rule.Target)
+	}
+}
+
+// sourceValue returns the value of a register as read by the last poll, or
+// false if the block holding it was not read successfully. The caller must
+// hold s.mu.
+func (s *ModbusServer) sourceValue(addr uint16) (interface{}, bool) {
+	for _, block := range s.RegisterBlocks {
+		if addr < block.StartAddress || int(addr) >= int(block.StartAddress)+int(block.Length) {
+			continue
+		}
+		if status := s.blockStatus[block.StartAddress]; status == nil || status.Status != "ok" {
+			return nil, false
+		}
+		switch {
+		case addr < 10000:
+			return s.dataModel.Coils[addr], true
+		case addr < 20000:
+			return s.dataModel.DiscreteInputs[addr-10000], true
+		}
+		reg, hasConfig := s.registerMap[addr]
+		if !hasConfig {
+			reg = RegisterConfig{Address: addr}
+		}
+		if filtered, ok := s.filteredValue(reg); ok {
+			return filtered, true
+		}
+		return decodeRegister(reg, s.registerWords(block, addr, max(registerWordCount(reg), 1))), true
+	}
+	return nil, false
+}
+
+// forwardMirrors writes the values a server has just polled to the targets
+// of the mirror rules reading from it. A target is written when the value
+// differs from the one last written, or from the target's own polled value.
+func forwardMirrors(source *ModbusServer) {
+	mirrorsMu.Lock()
+	var states []*mirrorState
+	for _, state := range mirrors {
+		if state.sourceID == source.ID {
+			states = append(states, state)
+		}
+	}
+	mirrorsMu.Unlock()
+
+	for _, state := range states {
+		source.mu.Lock()
+		value, ok := source.sourceValue(state.sourceAddress)
+		source.mu.Unlock()
+		if !ok {
+			continue
+		}
+		number, numeric := toFloat(value)
+		if !numeric || math.IsNaN(number) || math.IsInf(number, 0) {
+			state.fail(fmt.Errorf("source value %v is not a number", value))
+			continue
+		}
+		state.forward(number*state.rule.scale() + state.rule.Offset)
+	}
+}
+
+// forward writes a scaled source value to the target
+func (m *mirrorState) forward(number float64) {
+	mu.RLock()
+	target, exists := servers[m.targetID]
+	mu.RUnlock()
+	if !exists {
+		m.fail(fmt.Errorf("target server %s not found", m.targetID))
+		return
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	// Coils and booleans take whether the value is not 0, integer registers
+	// the rounded value
+	var value interface{} = number
+	reg := target.registerMap[m.targetAddress]
+	isCoil := m.targetAddress < 10000
+	switch {
+	case isCoil || reg.Format == "boolean":
+		value = number != 0
+	case reg.Format != "float" && reg.Format != "double":
+		value = math.Round(number)
+	}
+	plan, err := planWrite(target, WriteRequest{Address: m.targetAddress, Value: value})
+	if err != nil {
+		m.fail(err)
+		return
+	}
+	if plan.Pulse > 0 {
+		m.fail(fmt.Errorf("target %s is a momentary register and cannot be mirrored", m.rule.Target))
+		return
+	}
+
+	// Rewrite an unchanged value only if the target's own poll since the
+	// last write shows something else, e.g. after the device restarted
+	mirrorsMu.Lock()
+	unchanged := slices.Equal(plan.Values, m.written)
+	lastWrite := m.lastWrite
+	mirrorsMu.Unlock()
+	if unchanged {
+		current, ok := target.sourceValue(m.targetAddress)
+		if !ok || !target.LastDataReceived.After(lastWrite) {
+			return
+		}
+		if polled, _, err := encodeWriteValue(reg, isCoil, current); err == nil && slices.Equal(plan.Values, polled) {
+			return
+		}
+	}
+
+	if target.client == nil {
+		m.fail(fmt.Errorf("target server %s is not connected", m.targetID))
+		return
+	}
+	if err := checkWritePolicy(target, []WriteChange{{Address: plan.Address, Values: plan.Values}}, WriteAuthorization{}); err != nil {
+		m.fail(err)
+		return
+	}
+	if err := writeParameter(target.client, ParameterValue{Address: plan.Address, Values: plan.Values}); err != nil {
+		m.fail(fmt.Errorf("error writing %s: %v", m.rule.Target, err))
+		return
+	}
+
+	mirrorsMu.Lock()
+	m.written = plan.Values
+	m.lastWrite = time.Now()
+	m.lastValue = value
+	m.writes++
+	if m.lastError != "" {
+		logMessage(InfoLevel, "Mirror %s to %s is writing again", m.rule.Source, m.rule.Target)
+	}
+	m.lastError = ""
+	mirrorsMu.Unlock()
+	logMessage(DebugLevel, "Mirrored %v from %s to %s", value, m.rule.Source, m.rule.Target)
+}
+
+// fail records the error of a mirror rule, logging it when it changes
+func (m *mirrorState) fail(err error) {
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+	if err.Error() != m.lastError {
+		logMessage(ErrorLevel, "Mirror %s to %s: %v", m.rule.Source, m.rule.Target, err)
+	}
+	m.lastError = err.Error()
+	m.written = nil
+}
+
+// mirrorConfigs returns the running mirror rules, for the exported configuration
+func mirrorConfigs() []MirrorRule {
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+	rules := make([]MirrorRule, 0, len(mirrors))
+	for _, state := range mirrors {
+		rules = append(rules, state.rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Target < rules[j].Target })
+	return rules
+}
+
+// handleMirrors lists the mirror rules and the outcome of their last write on GET /api/mirrors
+func handleMirrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mirrorsMu.Lock()
+	list := make([]map[string]interface{}, 0, len(mirrors))
+	for _, state := range mirrors {
+		status := map[string]interface{}{
+			"rule":   state.rule,
+			"writes": state.writes,
+		}
+		if !state.lastWrite.IsZero() {
+			status["lastWrite"] = state.lastWrite
+			status["lastValue"] = state.lastValue
+		}
+		if state.lastError != "" {
+			status["lastError"] = state.lastError
+		}
+		list = append(list, status)
+	}
+	mirrorsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["rule"].(MirrorRule).Target < list[j]["rule"].(MirrorRule).Target
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "mirrors": list})
+}
+
+// checkMirrors applies the semantic rules to the mirror rules
+func (v *configValidator) checkMirrors(rules []MirrorRule) {
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		path := fmt.Sprintf("mirrors[%d]", i)
+		if _, _, err := parseRegisterRef(rule.Source); err != nil {
+			v.fail(path+".source", err.Error())
+		}
+		_, target, err := parseRegisterRef(rule.Target)
+		switch {
+		case err != nil:
+			v.fail(path+".target", err.Error())
+		case target >= 10000 && (target < 40000 || target >= 50000):
+			v.fail(path+".target", fmt.Sprintf("target address %d is not a coil or holding register", target))
+		case seen[rule.Target]:
+			v.fail(path+".target", fmt.Sprintf("duplicate mirror target %q", rule.Target))
+		case rule.Target == rule.Source:
+			v.fail(path+".target", "target must differ from source")
+		}
+		seen[rule.Target] = true
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rustyoz/modbus"
@@ -9,16 +15,51 @@ import (
 
 // ModbusClient represents a connection to a Modbus server
 type ModbusClient struct {
-	handler *modbus.TCPClientHandler
+	handler tcpHandler
+	address string
 	client  modbus.Client
 }
 
-// NewModbusClient creates a new Modbus client
-func NewModbusClient(address string, port int) (*ModbusClient, error) {
-	handler := modbus.NewTCPClientHandler(fmt.Sprintf("%s:%d", address, port))
-	handler.Timeout = 10 * time.Second
-	handler.SlaveId = 1
+// tcpHandler is a Modbus handler over a TCP connection that can be opened
+// and reset: the library's, a sourceHandler or an rtuOverTCPHandler
+type tcpHandler interface {
+	modbus.ClientHandler
+	Connect() error
+	Close() error
+}
+
+func init() {
+	registerProtocol("modbus-tcp", func(address string, port int, options DialOptions) (Device, error) {
+		client, err := NewModbusClient(address, port, options.Source, options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+// NewModbusClient creates a new Modbus client, connecting from the local
+// address or interface source unless it is "". timeout bounds connecting and
+// each request.
+func NewModbusClient(address string, port int, source string, timeout time.Duration) (*ModbusClient, error) {
+	tcp := modbus.NewTCPClientHandler(net.JoinHostPort(address, strconv.Itoa(port)))
+	tcp.Timeout = timeout
+	tcp.SlaveId = defaultUnitID
+
+	var handler tcpHandler = tcp
+	if source != "" {
+		local, err := sourceAddr(source, address)
+		if err != nil {
+			return nil, err
+		}
+		handler = &sourceHandler{TCPClientHandler: tcp, dialer: net.Dialer{Timeout: tcp.Timeout, LocalAddr: local}}
+	}
 
+	return newModbusClient(handler, tcp.Address)
+}
+
+// newModbusClient connects a handler to the device at address
+func newModbusClient(handler tcpHandler, address string) (*ModbusClient, error) {
 	err := handler.Connect()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Modbus server: %v", err)
@@ -28,10 +69,29 @@ func NewModbusClient(address string, port int) (*ModbusClient, error) {
 
 	return &ModbusClient{
 		handler: handler,
+		address: address,
 		client:  client,
 	}, nil
 }
 
+// setTimeout changes the timeout of the next requests and connections
+func (c *ModbusClient) setTimeout(timeout time.Duration) {
+	switch h := c.handler.(type) {
+	case *modbus.TCPClientHandler:
+		h.Timeout = timeout
+	case *sourceHandler:
+		h.mu.Lock()
+		h.Timeout = timeout
+		h.dialer.Timeout = timeout
+		h.mu.Unlock()
+	case *rtuOverTCPHandler:
+		h.mu.Lock()
+		h.Timeout = timeout
+		h.dialer.Timeout = timeout
+		h.mu.Unlock()
+	}
+}
+
 // Close closes the Modbus connection
 func (c *ModbusClient) Close() {
 	if c.handler != nil {
@@ -39,9 +99,80 @@ func (c *ModbusClient) Close() {
 	}
 }
 
+// request performs a read and checks the response carries the expected number
+// of bytes. Framing errors such as a transaction ID mismatch mean the TCP
+// stream is out of step with our requests (typically a late response to a
+// request that timed out), and a reset means the device or a firewall closed
+// an idle connection, so in both cases the connection is reopened and the
+// read retried once, within the same poll cycle.
+func (c *ModbusClient) request(expected int, read func() ([]byte, error)) ([]byte, error) {
+	results, err := read()
+	if err == nil && len(results) != expected {
+		err = fmt.Errorf("modbus: response length '%v' does not match expected '%v'", len(results), expected)
+	}
+	switch {
+	case err == nil:
+		return results, nil
+	case isFramingError(err):
+		logMessage(InfoLevel, "Resynchronizing connection to %s after invalid response: %v", c.address, err)
+	case isResetError(err):
+		logMessage(InfoLevel, "Reconnecting to %s after the connection was closed: %v", c.address, err)
+	default:
+		return results, err
+	}
+	c.handler.Close()
+
+	results, err = read()
+	if err == nil && len(results) != expected {
+		err = fmt.Errorf("modbus: response length '%v' does not match expected '%v'", len(results), expected)
+	}
+	return results, err
+}
+
+// isFramingError reports whether err is a malformed or mismatched response
+// rather than a Modbus exception or connection error
+func isFramingError(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "modbus: response") || strings.HasPrefix(msg, "modbus: length")
+}
+
+// isResetError reports whether err means the connection was closed by the
+// other end, e.g. by a firewall dropping idle connections, so that a new
+// connection is likely to work
+func isResetError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE)
+}
+
+// IsConnectionError reports whether err means the connection itself failed,
+// as opposed to a Modbus exception, a malformed response or a timeout
+// which only affect the request that caused them
+func (c *ModbusClient) IsConnectionError(err error) bool {
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &modbusErr) || isFramingError(err) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// unpackBits expands the packed bit response of a coil or discrete input read
+func unpackBits(results []byte, quantity uint16) []bool {
+	bits := make([]bool, quantity)
+	for i := uint16(0); i < quantity; i++ {
+		bits[i] = results[i/8]&(1<<(i%8)) != 0
+	}
+	return bits
+}
+
 // ReadRegister reads a single register
 func (c *ModbusClient) ReadRegister(address uint16) (uint16, error) {
-	results, err := c.client.ReadHoldingRegisters(address, 1)
+	results, err := c.request(2, func() ([]byte, error) {
+		return c.client.ReadHoldingRegisters(address, 1)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -50,16 +181,20 @@ func (c *ModbusClient) ReadRegister(address uint16) (uint16, error) {
 
 // ReadCoil reads a single coil
 func (c *ModbusClient) ReadCoil(address uint16) (bool, error) {
-	results, err := c.client.ReadCoils(address, 1)
+	results, err := c.request(1, func() ([]byte, error) {
+		return c.client.ReadCoils(address, 1)
+	})
 	if err != nil {
 		return false, err
 	}
-	return results[0] == 1, nil
+	return results[0]&1 == 1, nil
 }
 
 // ReadHoldingRegisters reads multiple holding registers
 func (c *ModbusClient) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
-	results, err := c.client.ReadHoldingRegisters(address, quantity)
+	results, err := c.request(int(quantity)*2, func() ([]byte, error) {
+		return c.client.ReadHoldingRegisters(address, quantity)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +208,9 @@ func (c *ModbusClient) ReadHoldingRegisters(address uint16, quantity uint16) ([]
 
 // ReadInputRegisters reads multiple input registers
 func (c *ModbusClient) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
-	results, err := c.client.ReadInputRegisters(address, quantity)
+	results, err := c.request(int(quantity)*2, func() ([]byte, error) {
+		return c.client.ReadInputRegisters(address, quantity)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -87,28 +224,63 @@ func (c *ModbusClient) ReadInputRegisters(address uint16, quantity uint16) ([]ui
 
 // ReadCoils reads multiple coils
 func (c *ModbusClient) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
-	results, err := c.client.ReadCoils(address, quantity)
+	results, err := c.request((int(quantity)+7)/8, func() ([]byte, error) {
+		return c.client.ReadCoils(address, quantity)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	coils := make([]bool, quantity)
-	for i := uint16(0); i < quantity; i++ {
-		coils[i] = results[i] == 1
-	}
-	return coils, nil
+	return unpackBits(results, quantity), nil
 }
 
 // ReadDiscreteInputs reads multiple discrete inputs
 func (c *ModbusClient) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
-	results, err := c.client.ReadDiscreteInputs(address, quantity)
+	results, err := c.request((int(quantity)+7)/8, func() ([]byte, error) {
+		return c.client.ReadDiscreteInputs(address, quantity)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	inputs := make([]bool, quantity)
-	for i := uint16(0); i < quantity; i++ {
-		inputs[i] = results[i] == 1
+	return unpackBits(results, quantity), nil
+}
+
+// WriteSingleRegister writes a single holding register
+func (c *ModbusClient) WriteSingleRegister(address uint16, value uint16) error {
+	_, err := c.client.WriteSingleRegister(address, value)
+	return err
+}
+
+// WriteMultipleRegisters writes consecutive holding registers
+func (c *ModbusClient) WriteMultipleRegisters(address uint16, values []uint16) error {
+	data := make([]byte, len(values)*2)
+	for i, v := range values {
+		data[i*2] = byte(v >> 8)
+		data[i*2+1] = byte(v)
+	}
+	_, err := c.client.WriteMultipleRegisters(address, uint16(len(values)), data)
+	return err
+}
+
+// WriteSingleCoil writes a single coil
+func (c *ModbusClient) WriteSingleCoil(address uint16, value bool) error {
+	var v uint16
+	if value {
+		v = 0xFF00
+	}
+	_, err := c.client.WriteSingleCoil(address, v)
+	return err
+}
+
+// WriteMultipleCoils writes consecutive coils
+func (c *ModbusClient) WriteMultipleCoils(address uint16, values []bool) error {
+	data := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			data[i/8] |= 1 << (i % 8)
+		}
 	}
-	return inputs, nil
+	_, err := c.client.WriteMultipleCoils(address, uint16(len(values)), data)
+	return err
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ChecklistItem is a step of a server's commissioning checklist
+type ChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// ChecklistProgress returns "done/total" for servers with a checklist, or
+// "" without one. The caller must hold s.mu.
+func (s *ModbusServer) ChecklistProgress() string {
+	if len(s.Checklist) == 0 {
+		return ""
+	}
+	done := 0
+	for _, item := range s.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(s.Checklist))
+}
+
+// checkChecklist returns an error if a checklist has an item without text
+func checkChecklist(items []ChecklistItem) error {
+	for i, item := range items {
+		if strings.TrimSpace(item.Text) == "" {
+			return fmt.Errorf("checklist item %d has no text", i+1)
+		}
+	}
+	return nil
+}
+
+// handleNotes gets or replaces the notes and commissioning checklist of a
+// server on GET and PUT /api/servers/{id}/notes
+func handleNotes(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Notes     string          `json:"notes"`
+			Checklist []ChecklistItem `json:"checklist"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if err := checkChecklist(request.Checklist); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		server.mu.Lock()
+		server.Notes = request.Notes
+		server.Checklist = request.Checklist
+		progress := server.ChecklistProgress()
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Updated notes of server %s (checklist %s)", id, progress)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	response := map[string]interface{}{
+		"success":   true,
+		"notes":     server.Notes,
+		"checklist": append([]ChecklistItem{}, server.Checklist...),
+	}
+	server.mu.Unlock()
+	json.NewEncoder(w).Encode(response)
+}
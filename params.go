@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ParameterFile is a named set of configuration register values captured from a device
+type ParameterFile struct {
+	Name       string           `json:"name"`
+	ServerID   string           `json:"serverId"`
+	Captured   time.Time        `json:"captured"`
+	Parameters []ParameterValue `json:"parameters"`
+}
+
+// ParameterValue holds the raw words of one parameter register.
+// Coils are stored as a single 0 or 1.
+type ParameterValue struct {
+	Address uint16   `json:"address"`
+	Name    string   `json:"name,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Values  []uint16 `json:"values"`
+}
+
+// ParameterResult is the outcome of restoring one parameter
+type ParameterResult struct {
+	Address uint16 `json:"address"`
+	Name    string `json:"name,omitempty"`
+	Status  string `json:"status"` // "ok", "mismatch" or "error"
+	Error   string `json:"error,omitempty"`
+}
+
+// unsafeFileChars matches characters that are replaced in download file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// handleParameters captures (GET) or restores (POST) the parameter registers of a server
+func handleParameters(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = fmt.Sprintf("%s-%s", id, time.Now().Format("20060102-150405"))
+		}
+
+		server.mu.Lock()
+		file, err := server.captureParameters(name)
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error capturing parameters: %v", err))
+			return
+		}
+		logMessage(InfoLevel, "Captured %d parameters from server %s", len(file.Parameters), id)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, unsafeFileChars.ReplaceAllString(name, "_")))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(file)
+
+	case http.MethodPost:
+		data, err := readUploadBody(r, "file")
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		var file ParameterFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid parameter file: %v", err))
+			return
+		}
+		for _, param := range file.Parameters {
+			if err := checkParameter(param); err != nil {
+				handleError(w, r, fmt.Sprintf("Invalid parameter file: %v", err))
+				return
+			}
+		}
+
+		server.mu.Lock()
+		if server.client == nil {
+			server.mu.Unlock()
+			handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+			return
+		}
+		targets := make([]WriteChange, len(file.Parameters))
+		for i, param := range file.Parameters {
+			targets[i] = WriteChange{Address: param.Address, Values: param.Values}
+		}
+		if err := checkWritePolicy(server, targets, writeAuthorization(r)); err != nil {
+			server.mu.Unlock()
+			handleWritePolicyError(w, r, err)
+			return
+		}
+		results, changes := restoreParameters(server.client, file.Parameters)
+		server.mu.Unlock()
+		if len(changes) > 0 {
+			recordWrite(id, "parameters", changes, 0)
+		}
+
+		success := true
+		for _, result := range results {
+			if result.Status != "ok" {
+				success = false
+			}
+		}
+		logMessage(InfoLevel, "Restored parameter set %q to server %s (verified: %v)", file.Name, id, success)
+
+		response := map[string]interface{}{
+			"success": success,
+			"name":    file.Name,
+			"results": results,
+		}
+		if !success {
+			response["error"] = "Some parameters failed to restore or verify"
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// captureParameters reads every register marked as a parameter from the device.
+// The caller must hold the server's lock.
+func (s *ModbusServer) captureParameters(name string) (*ParameterFile, error) {
+	if s.client == nil {
+		return nil, fmt.Errorf("server %s is not connected", s.ID)
+	}
+
+	file := &ParameterFile{
+		Name:       name,
+		ServerID:   s.ID,
+		Captured:   time.Now(),
+		Parameters: []ParameterValue{},
+	}
+
+	for _, block := range s.RegisterBlocks {
+		for _, reg := range block.Registers {
+			if !reg.Parameter {
+				continue
+			}
+			param := ParameterValue{Address: reg.Address, Name: reg.Name, Format: reg.Format}
+			values, err := readParameter(s.client, reg.Address, registerWordCount(reg))
+			if err != nil {
+				return nil, fmt.Errorf("register %d: %v", reg.Address, err)
+			}
+			param.Values = values
+			file.Parameters = append(file.Parameters, param)
+		}
+	}
+
+	sort.Slice(file.Parameters, func(i, j int) bool {
+		return file.Parameters[i].Address < file.Parameters[j].Address
+	})
+	return file, nil
+}
+
+// restoreParameters writes each parameter and reads it back to verify it.
+// It also returns the changes made, with the values they replaced.
+func restoreParameters(client Device, params []ParameterValue) ([]ParameterResult, []WriteChange) {
+	results := make([]ParameterResult, 0, len(params))
+	var changes []WriteChange
+	for _, param := range params {
+		result := ParameterResult{Address: param.Address, Name: param.Name, Status: "ok"}
+
+		previous, _ := readParameter(client, param.Address, len(param.Values))
+		if err := writeParameter(client, param); err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		changes = append(changes, WriteChange{Address: param.Address, Previous: previous, Values: param.Values})
+
+		readBack, err := readParameter(client, param.Address, len(param.Values))
+		if err != nil {
+			result.Status = "error"
+			result.Error = fmt.Sprintf("verify: %v", err)
+		} else {
+			for i := range param.Values {
+				if readBack[i] != param.Values[i] {
+					result.Status = "mismatch"
+					result.Error = fmt.Sprintf("wrote %v but read back %v", param.Values, readBack)
+					break
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, changes
+}
+
+// checkParameter verifies that a parameter can be written back to a device
+func checkParameter(param ParameterValue) error {
+	switch {
+	case len(param.Values) == 0:
+		return fmt.Errorf("parameter %d has no values", param.Address)
+	case param.Address < 10000: // Coils
+		if len(param.Values) != 1 || param.Values[0] > 1 {
+			return fmt.Errorf("coil parameter %d must have a single value of 0 or 1", param.Address)
+		}
+	case param.Address >= 40000 && param.Address < 50000: // Holding Registers
+		if len(param.Values) > maxWriteRegisters || int(param.Address)+len(param.Values) > 50000 {
+			return fmt.Errorf("parameter %d has too many values", param.Address)
+		}
+	default:
+		return fmt.Errorf("parameter %d is not a coil or holding register", param.Address)
+	}
+	return nil
+}
+
+// readParameter reads the raw words of a coil or holding register parameter
+func readParameter(client Device, address uint16, words int) ([]uint16, error) {
+	switch {
+	case address < 10000: // Coils
+		values, err := client.ReadCoils(address, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponseLength(len(values), 1); err != nil {
+			return nil, err
+		}
+		if values[0] {
+			return []uint16{1}, nil
+		}
+		return []uint16{0}, nil
+	case address >= 40000 && address < 50000: // Holding Registers
+		return client.ReadHoldingRegisters(address-40000, uint16(words))
+	default:
+		return nil, fmt.Errorf("address %d is not a coil or holding register", address)
+	}
+}
+
+// writeParameter writes the raw words of a coil or holding register parameter
+func writeParameter(client Device, param ParameterValue) error {
+	switch {
+	case param.Address < 10000: // Coils
+		return client.WriteSingleCoil(param.Address, param.Values[0] == 1)
+	case len(param.Values) == 1:
+		return client.WriteSingleRegister(param.Address-40000, param.Values[0])
+	default:
+		return client.WriteMultipleRegisters(param.Address-40000, param.Values)
+	}
+}
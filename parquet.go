@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Parquet physical types, converted types and other constants from the
+// format specification (parquet.thrift)
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetRequired     = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// Thrift compact protocol field types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, which
+// Parquet uses for its page headers and file metadata
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int16 // last field id of each open struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.fields[len(t.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(uint64(id)<<1 ^ uint64(id>>15))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) begin()          { t.fields = append(t.fields, 0) }
+func (t *thriftWriter) end()            { t.buf.WriteByte(0); t.fields = t.fields[:len(t.fields)-1] }
+func (t *thriftWriter) i32(v int32)     { t.varint(uint64(uint32(v<<1 ^ v>>31))) }
+func (t *thriftWriter) i64(v int64)     { t.varint(uint64(v<<1 ^ v>>63)) }
+func (t *thriftWriter) binary(s string) { t.varint(uint64(len(s))); t.buf.WriteString(s) }
+
+func (t *thriftWriter) list(n int, elem byte) {
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xF0 | elem)
+	t.varint(uint64(n))
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) { t.field(id, thriftI32); t.i32(v) }
+func (t *thriftWriter) fieldI64(id int16, v int64) { t.field(id, thriftI64); t.i64(v) }
+func (t *thriftWriter) fieldString(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// parquetColumn is a required column whose values are PLAIN encoded
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	values    bytes.Buffer
+}
+
+// parquetFile builds a Parquet file with one row group of required columns
+type parquetFile struct {
+	columns []*parquetColumn
+	rows    int64
+}
+
+func (f *parquetFile) column(name string, typ, converted int32) *parquetColumn {
+	c := &parquetColumn{name: name, typ: typ, converted: converted}
+	f.columns = append(f.columns, c)
+	return c
+}
+
+func (c *parquetColumn) int32(v int32) {
+	c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+}
+
+func (c *parquetColumn) int64(v int64) {
+	c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+}
+
+func (c *parquetColumn) double(v float64) {
+	c.values.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func (c *parquetColumn) string(s string) {
+	c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+	c.values.WriteString(s)
+}
+
+// write writes the file: each column as a single uncompressed data page,
+// followed by the file metadata
+func (f *parquetFile) write(w io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(f.columns))
+	for i, c := range f.columns {
+		var header thriftWriter
+		header.begin()
+		header.fieldI32(1, parquetDataPage)
+		header.fieldI32(2, int32(c.values.Len()))
+		header.fieldI32(3, int32(c.values.Len()))
+		header.field(5, thriftStruct)
+		header.begin()
+		header.fieldI32(1, int32(f.rows))
+		header.fieldI32(2, parquetPlain)
+		header.fieldI32(3, parquetRLE)
+		header.fieldI32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[i] = chunk{int64(out.Len()), int64(header.buf.Len() + c.values.Len())}
+		out.Write(header.buf.Bytes())
+		out.Write(c.values.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.fieldI32(1, 1)
+	meta.field(2, thriftList)
+	meta.list(len(f.columns)+1, thriftStruct)
+	meta.begin()
+	meta.fieldString(4, "schema")
+	meta.fieldI32(5, int32(len(f.columns)))
+	meta.end()
+	for _, c := range f.columns {
+		meta.begin()
+		meta.fieldI32(1, c.typ)
+		meta.fieldI32(3, parquetRequired)
+		meta.fieldString(4, c.name)
+		if c.converted >= 0 {
+			meta.fieldI32(6, c.converted)
+		}
+		meta.end()
+	}
+	meta.fieldI64(3, f.rows)
+	meta.field(4, thriftList)
+	meta.list(1, thriftStruct)
+	meta.begin()
+	meta.field(1, thriftList)
+	meta.list(len(f.columns), thriftStruct)
+	var total int64
+	for i, c := range f.columns {
+		meta.begin()
+		meta.fieldI64(2, chunks[i].offset)
+		meta.field(3, thriftStruct)
+		meta.begin()
+		meta.fieldI32(1, c.typ)
+		meta.field(2, thriftList)
+		meta.list(2, thriftI32)
+		meta.i32(parquetPlain)
+		meta.i32(parquetRLE)
+		meta.field(3, thriftList)
+		meta.list(1, thriftBinary)
+		meta.binary(c.name)
+		meta.fieldI32(4, parquetUncompressed)
+		meta.fieldI64(5, f.rows)
+		meta.fieldI64(6, chunks[i].size)
+		meta.fieldI64(7, chunks[i].size)
+		meta.fieldI64(9, chunks[i].offset)
+		meta.end()
+		meta.end()
+		total += chunks[i].size
+	}
+	meta.fieldI64(2, total)
+	meta.fieldI64(3, f.rows)
+	meta.end()
+	meta.fieldString(6, "modbusbrowser")
+	meta.end()
+
+	out.Write(meta.buf.Bytes())
+	out.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	out.WriteString("PAR1")
+	_, err := w.Write(out.Bytes())
+	return err
+}
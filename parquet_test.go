@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes Thrift compact protocol structs into maps from field
+// id to value: int64 for integers, []byte for binary, []interface{} for
+// lists and map[int16]interface{} for structs
+type thriftReader struct {
+	b   []byte
+	off int
+}
+
+var errThriftShort = errors.New("thrift data truncated")
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.off >= len(r.b) {
+		return 0, errThriftShort
+	}
+	r.off++
+	return r.b[r.off-1], nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.off:])
+	if n <= 0 {
+		return 0, errThriftShort
+	}
+	r.off += n
+	return v, nil
+}
+
+func (r *thriftReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		v, err := r.varint()
+		return int64(v>>1) ^ -int64(v&1), err
+	case thriftBinary:
+		n, err := r.varint()
+		if err != nil || uint64(len(r.b)-r.off) < n {
+			return nil, errThriftShort
+		}
+		r.off += int(n)
+		return r.b[r.off-int(n) : r.off], nil
+	case thriftList:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		list := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			v, err := r.value(header & 0x0F)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unexpected thrift type %d", typ)
+}
+
+func (r *thriftReader) readStruct() (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v>>1) ^ -int16(v&1)
+		}
+		if fields[id], err = r.value(header & 0x0F); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestParquetReadBack(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var rows []exportRow
+	for i := 0; i < 20; i++ {
+		rows = append(rows, exportRow{
+			Time:    start.Add(time.Duration(i) * time.Second),
+			Server:  fmt.Sprintf("plc-%d", i%3),
+			Address: uint16(40001 + i),
+			Name:    fmt.Sprintf("Register %d", i),
+			Value:   float64(i) * -1.5,
+		})
+	}
+	var buf bytes.Buffer
+	if err := writeExportParquet(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Magic at both ends, with the footer length before the trailing one
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	if footerStart < 4 {
+		t.Fatalf("footer length %d does not fit in %d bytes", footerLen, len(data))
+	}
+	reader := &thriftReader{b: data[footerStart : len(data)-8]}
+	meta, err := reader.readStruct()
+	if err != nil {
+		t.Fatalf("reading file metadata: %v", err)
+	}
+	if reader.off != footerLen {
+		t.Errorf("file metadata is %d bytes, footer length says %d", reader.off, footerLen)
+	}
+	if meta[1] != int64(1) || meta[3] != int64(len(rows)) || string(meta[6].([]byte)) != "modbusbrowser" {
+		t.Errorf("version %v, num_rows %v, created_by %q", meta[1], meta[3], meta[6])
+	}
+
+	// Schema: the root, then one required leaf per column
+	type column struct {
+		name      string
+		typ       int64
+		converted interface{}
+	}
+	want := []column{
+		{"time", parquetInt64, int64(parquetTimestampMillis)},
+		{"server", parquetByteArray, int64(parquetUTF8)},
+		{"address", parquetInt32, nil},
+		{"name", parquetByteArray, int64(parquetUTF8)},
+		{"value", parquetDouble, nil},
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(want)+1 {
+		t.Fatalf("%d schema elements, want %d", len(schema), len(want)+1)
+	}
+	if root := schema[0].(map[int16]interface{}); root[5] != int64(len(want)) {
+		t.Errorf("root num_children = %v", root[5])
+	}
+	for i, c := range want {
+		element := schema[i+1].(map[int16]interface{})
+		got := column{string(element[4].([]byte)), element[1].(int64), element[6]}
+		if got != c || element[3] != int64(parquetRequired) {
+			t.Errorf("schema element %d = %+v, repetition %v, want %+v", i+1, got, element[3], c)
+		}
+	}
+
+	// One row group whose column chunks point at a data page each
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	group := groups[0].(map[int16]interface{})
+	if group[3] != int64(len(rows)) {
+		t.Errorf("row group num_rows = %v", group[3])
+	}
+	chunks := group[1].([]interface{})
+	if len(chunks) != len(want) {
+		t.Fatalf("%d column chunks, want %d", len(chunks), len(want))
+	}
+	var total int64
+	values := make([][]byte, len(want))
+	for i, c := range chunks {
+		chunk := c.(map[int16]interface{})
+		cm := chunk[3].(map[int16]interface{})
+		path := cm[3].([]interface{})
+		if len(path) != 1 || string(path[0].([]byte)) != want[i].name || cm[1] != want[i].typ ||
+			cm[4] != int64(parquetUncompressed) || cm[5] != int64(len(rows)) {
+			t.Errorf("column chunk %d metadata = %v", i, cm)
+		}
+		offset, size := cm[9].(int64), cm[7].(int64)
+		if chunk[2] != offset || offset < 4 || offset+size > int64(footerStart) {
+			t.Fatalf("column chunk %d at %d+%d, file offset %v", i, offset, size, chunk[2])
+		}
+		total += size
+
+		page := &thriftReader{b: data[offset : offset+size]}
+		header, err := page.readStruct()
+		if err != nil {
+			t.Fatalf("column %d page header: %v", i, err)
+		}
+		dataPage := header[5].(map[int16]interface{})
+		if header[1] != int64(parquetDataPage) || dataPage[1] != int64(len(rows)) || dataPage[2] != int64(parquetPlain) {
+			t.Errorf("column %d page header = %v", i, header)
+		}
+		values[i] = page.b[page.off:]
+		if header[3] != int64(len(values[i])) {
+			t.Errorf("column %d page size %v, has %d bytes of values", i, header[3], len(values[i]))
+		}
+	}
+	if group[2] != total {
+		t.Errorf("row group total_byte_size = %v, chunks add up to %d", group[2], total)
+	}
+
+	// PLAIN encoded values
+	readString := func(b []byte) (string, []byte) {
+		n := binary.LittleEndian.Uint32(b)
+		return string(b[4 : 4+n]), b[4+n:]
+	}
+	var got []exportRow
+	for range rows {
+		var row exportRow
+		row.Time = time.UnixMilli(int64(binary.LittleEndian.Uint64(values[0]))).UTC()
+		values[0] = values[0][8:]
+		row.Server, values[1] = readString(values[1])
+		row.Address = uint16(binary.LittleEndian.Uint32(values[2]))
+		values[2] = values[2][4:]
+		row.Name, values[3] = readString(values[3])
+		row.Value = math.Float64frombits(binary.LittleEndian.Uint64(values[4]))
+		values[4] = values[4][8:]
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read back %v, want %v", got, rows)
+	}
+}
+
+func TestThriftWriterFieldIDs(t *testing.T) {
+	// Deltas up to 15 are packed into the type byte; larger jumps and
+	// decreasing ids need the long form
+	var w thriftWriter
+	w.begin()
+	w.fieldI32(1, -1)
+	w.fieldI32(16, 300)
+	w.fieldI64(40, math.MaxInt64)
+	w.fieldString(2, "x")
+	w.field(3, thriftList)
+	w.list(20, thriftI32)
+	for i := int32(0); i < 20; i++ {
+		w.i32(i)
+	}
+	w.end()
+
+	got, err := (&thriftReader{b: w.buf.Bytes()}).readStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]interface{}, 20)
+	for i := range list {
+		list[i] = int64(i)
+	}
+	want := map[int16]interface{}{1: int64(-1), 16: int64(300), 40: int64(math.MaxInt64), 2: []byte("x"), 3: list}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkPDF verifies the cross-reference table and stream lengths of a PDF
+// written by pdfDocument and returns its page count
+func checkPDF(t *testing.T, data []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("missing PDF header or trailer")
+	}
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatalf("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point to the xref table", xref)
+	}
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	fmt.Sscanf(lines[1], "%d %d", &first, &count)
+	if first != 0 || lines[2] != "0000000000 65535 f " {
+		t.Fatalf("xref table starts with %q, %q", lines[1], lines[2])
+	}
+	for i := 1; i < count; i++ {
+		entry := lines[2+i]
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("xref entry %d is %q", i, entry)
+		}
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points to %q", i, data[offset:min(offset+20, len(data))])
+		}
+	}
+	if !strings.Contains(string(data[xref:]), fmt.Sprintf("/Size %d ", count)) {
+		t.Errorf("trailer /Size does not match the %d xref entries", count)
+	}
+
+	for _, m := range regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1) {
+		length, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		if !bytes.HasPrefix(data[m[1]+length:], []byte("endstream")) {
+			t.Errorf("stream at %d is not %d bytes long", m[1], length)
+		}
+	}
+
+	pages := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(data)
+	if pages == nil {
+		t.Fatalf("missing page tree")
+	}
+	n, _ := strconv.Atoi(string(pages[1]))
+	if got := bytes.Count(data, []byte("/Type /Page /Parent")); got != n {
+		t.Errorf("page tree counts %d pages, found %d", n, got)
+	}
+	return n
+}
+
+func TestPDFDocument(t *testing.T) {
+	doc := newPDFDocument(pdfA4LandscapeWidth, pdfA4LandscapeHeight, "Test (1)")
+	doc.text(40, 500, 12, pdfHelveticaBold, "Title")
+	doc.line(40, 495, 800, 495)
+	doc.addPage()
+	doc.text(40, 500, 9, pdfHelvetica, "Second page")
+
+	var buf bytes.Buffer
+	if err := doc.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if pages := checkPDF(t, buf.Bytes()); pages != 2 {
+		t.Errorf("%d pages, want 2", pages)
+	}
+	for _, want := range []string{
+		"BT /F2 12 Tf 40.00 500.00 Td (Title) Tj ET",
+		"0.5 w 40.00 495.00 m 800.00 495.00 l S",
+		"(Page 2 of 2)",
+		"/Title (Test \\(1\\))",
+		"/MediaBox [0 0 842 595]",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestPDFEmptyDocument(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newPDFDocument(100, 100, "").write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if pages := checkPDF(t, buf.Bytes()); pages != 1 {
+		t.Errorf("%d pages, want 1", pages)
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"Tank 1", "(Tank 1)"},
+		{`a(b)c\d`, `(a\(b\)c\\d)`},
+		{"line\nbreak", "(line break)"},
+		{"25 °C", `(25 \260C)`},
+		{"Füllstand", `(F\374llstand)`},
+		{"5 €", `(5 \200)`},
+		{"a – b…", `(a \226 b\205)`},
+		{"水位", "(??)"},
+	}
+	for _, tt := range tests {
+		if got := pdfString(tt.s); got != tt.want {
+			t.Errorf("pdfString(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPDFTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a bit too long", 10, "a bit too…"},
+		{"Füllstandsmessung", 5, "Füll…"},
+	}
+	for _, tt := range tests {
+		if got := pdfTruncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("pdfTruncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Refresh interval of the plain view in seconds
+const (
+	defaultPlainRefresh = 5
+	maxPlainRefresh     = 3600
+)
+
+// plainTemplate renders the register tables as a complete page that needs
+// neither JavaScript nor CSS from the browser and reloads itself with a meta
+// refresh, for locked-down HMI panels, old browsers and screen readers
+const plainTemplate = `
+{{define "plain"}}<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="UTF-8">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>Modbus Browser</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 0.9rem; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+caption { text-align: left; font-weight: bold; padding: 4px 0; }
+th, td { border: 1px solid #dee2e6; padding: 2px 6px; text-align: left; }
+th { background-color: #f8f9fa; }
+.error { color: #dc3545; }
+.suspect { background-color: #fff3cd; }
+.flatline { background-color: #f8d7da; }
+</style>
+</head>
+<body>
+<h1>Modbus Browser</h1>
+<p>{{t "Updated"}} {{.Time.Format "2006-01-02 15:04:05"}}{{if .Refresh}}, {{t "every"}} {{.Refresh}} s{{end}}. <a href="/">{{t "Full view"}}</a></p>
+{{if gt (len .Servers) 1}}<nav><ul>{{range .Servers}}<li><a href="#server-{{.ID}}">{{.ID}}</a>{{if ne .ConnectionStatus "ok"}} <span class="error">({{t "not connected"}})</span>{{end}}</li>{{end}}</ul></nav>{{end}}
+{{range .Servers}}
+<h2 id="server-{{.ID}}">{{t "Server"}}: {{.ID}}</h2>
+<p>{{t "IP"}}: {{.Address}} | {{t "Port"}}: {{.Port}} | {{t "Status"}}: {{if eq .ConnectionStatus "ok"}}{{t "Connected"}}{{else}}<strong class="error">{{t "Error"}}{{with .ConnectionError}}: {{.}}{{end}}</strong>{{end}} | {{t "Last Data Received"}}: {{.LastDataReceived.Format "15:04:05"}}</p>
+<table>
+<caption>{{t "Registers of server"}} {{.ID}}</caption>
+<thead><tr>{{range .Columns}}<th scope="col">{{t .Title}}</th>{{end}}</tr></thead>
+<tbody>
+{{$columns := .Columns}}{{range $row := .Data}}<tr{{if ne .Quality "good"}} class="{{.Quality}}"{{end}}>
+{{range $columns}}{{if eq .Key "address"}}<th scope="row">{{$row.Address}}</th>
+{{else if eq .Key "name"}}<td>{{$row.Name}}{{if $row.URL}} (<a href="{{$row.URL}}">{{t "documentation"}}</a>){{end}}</td>
+{{else if eq .Key "value"}}<td>{{with $row.State}}{{.}} ({{$row.Value}}){{else}}{{$row.Value}}{{end}}{{with $row.Bits}}<ul>{{range .}}<li>{{.Name}}: {{if .Set}}{{t "on"}}{{else}}{{t "off"}}{{end}}</li>{{end}}</ul>{{end}}{{if ne $row.Quality "good"}} ({{$row.Quality}}){{end}}</td>
+{{else if eq .Key "format"}}<td>{{$row.Format}}</td>
+{{else if eq .Key "hex"}}<td>{{range $row.Hex}}{{.}} {{end}}</td>
+{{else if eq .Key "unit"}}<td>{{$row.Unit}}</td>
+{{else if eq .Key "description"}}<td>{{$row.Description}}</td>
+{{else if eq .Key "quality"}}<td>{{$row.Quality}}</td>
+{{else if eq .Key "min"}}<td>{{with $row.ExpectedMin}}{{.}}{{end}}</td>
+{{else if eq .Key "max"}}<td>{{with $row.ExpectedMax}}{{.}}{{end}}</td>
+{{else if eq .Key "lastChange"}}<td>{{if not $row.LastChange.IsZero}}{{$row.LastChange.Format "15:04:05"}}{{end}}</td>
+{{end}}{{end}}</tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>{{t "No servers"}}</p>
+{{end}}
+</body>
+</html>
+{{end}}`
+
+// plainServer is a server in the plain view
+type plainServer struct {
+	ID               string
+	Address          string
+	Port             int
+	ConnectionStatus string
+	ConnectionError  string
+	LastDataReceived time.Time
+	Columns          []registerColumn
+	Data             []map[string]interface{}
+}
+
+// handlePlain serves the register tables of all servers, or of one with
+// ?server=id, as a page that works without JavaScript and reloads every
+// ?refresh=5 seconds (0 to not reload)
+func handlePlain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refresh := defaultPlainRefresh
+	if s := r.URL.Query().Get("refresh"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxPlainRefresh {
+			http.Error(w, fmt.Sprintf("Invalid refresh: %q (must be 0 to %d seconds)", s, maxPlainRefresh), http.StatusBadRequest)
+			return
+		}
+		refresh = n
+	}
+	id := r.URL.Query().Get("server")
+
+	mu.RLock()
+	list := make([]plainServer, 0, len(servers))
+	for _, server := range servers {
+		if id != "" && server.ID != id {
+			continue
+		}
+		server.mu.Lock()
+		list = append(list, plainServer{
+			ID:               server.ID,
+			Address:          server.Address,
+			Port:             server.Port,
+			ConnectionStatus: server.ConnectionStatus,
+			ConnectionError:  server.ConnectionError,
+			LastDataReceived: server.LastDataReceived,
+			Columns:          server.ColumnHeaders(),
+			Data:             server.registerData(),
+		})
+		server.mu.Unlock()
+	}
+	mu.RUnlock()
+
+	if id != "" && len(list) == 0 {
+		http.Error(w, fmt.Sprintf("Server not found: %s", id), http.StatusNotFound)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templatesFor(r).ExecuteTemplate(w, "plain", map[string]interface{}{
+		"Lang":    requestLanguage(r),
+		"Refresh": refresh,
+		"Time":    time.Now(),
+		"Servers": list,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Error executing template: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultPreviewStringLength is the string length previewed when none is given
+const defaultPreviewStringLength = 16
+
+// decodeRegister decodes the raw words of a register in its format. Words
+// past the end of a block are missing: strings are decoded from the words
+// present and a float without both words is "N/A".
+func decodeRegister(reg RegisterConfig, words []uint16) interface{} {
+	if len(words) == 0 {
+		return "N/A"
+	}
+	words = orderWords(reg, words)
+	switch reg.Format {
+	case "hex":
+		return fmt.Sprintf("0x%04X", words[0])
+	case "int16":
+		return int16(words[0])
+	case "uint32", "int32", "uint64", "int64":
+		n := registerWordCount(reg)
+		if len(words) < n {
+			return "N/A"
+		}
+		var v uint64
+		for _, word := range words[:n] {
+			v = v<<16 | uint64(word)
+		}
+		switch reg.Format {
+		case "uint32":
+			return uint32(v)
+		case "int32":
+			return int32(uint32(v))
+		case "int64":
+			return int64(v)
+		}
+		return v
+	case "float":
+		if len(words) < 2 {
+			return "N/A"
+		}
+		return math.Float32frombits(uint32(words[0])<<16 | uint32(words[1]))
+	case "double":
+		if len(words) < 4 {
+			return "N/A"
+		}
+		var bits uint64
+		for _, word := range words[:4] {
+			bits = bits<<16 | uint64(word)
+		}
+		return math.Float64frombits(bits)
+	case "boolean":
+		return words[0] != 0
+	case "string-byte":
+		bytes := make([]byte, 0, 2*len(words))
+		for _, word := range words {
+			bytes = append(bytes, byte(word>>8), byte(word))
+		}
+		// An odd length leaves the low byte of the last register unused
+		if reg.StringLength > 0 && len(bytes) > reg.StringLength {
+			bytes = bytes[:reg.StringLength]
+		}
+		return strings.TrimRight(string(bytes), "\x00")
+	case "string-word":
+		chars := make([]rune, reg.StringLength)
+		for i := range chars {
+			if i < len(words) {
+				chars[i] = rune(words[i])
+			}
+		}
+		return string(chars)
+	case "unix-time", "datetime-bcd", "datetime-iec", "datetime-words":
+		return decodeTimestamp(reg.Format, words)
+	default: // decimal and bitfield
+		return words[0]
+	}
+}
+
+// jsonNumber returns a decoded value that JSON can hold: JSON has no NaN or
+// infinity, so such floats are returned as text
+func jsonNumber(value interface{}) interface{} {
+	switch f := value.(type) {
+	case float32:
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return fmt.Sprint(f)
+		}
+	case float64:
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprint(f)
+		}
+	}
+	return value
+}
+
+// FormatPreview is a register value decoded in one format
+type FormatPreview struct {
+	Format string      `json:"format"`
+	Value  interface{} `json:"value"`
+	Words  int         `json:"words"` // registers the format occupies
+}
+
+// handlePreview decodes the current raw words at an address in one or more
+// formats on GET /api/servers/{id}/preview?address=40010&format=float, so
+// that a format can be checked before it is saved, optionally in a
+// &byteOrder=CDAB. Without a format, every format is previewed. The words are taken from the last poll when a
+// configured block covers them and are read from the device otherwise.
+func handlePreview(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	query := r.URL.Query()
+	address, err := strconv.ParseUint(query.Get("address"), 10, 16)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid address: %q", query.Get("address")))
+		return
+	}
+	addr := uint16(address)
+	if _, ok := addressRangeEnd(addr); !ok {
+		handleError(w, r, fmt.Sprintf("Address %d is not in a valid address range", addr))
+		return
+	}
+
+	stringLength := defaultPreviewStringLength
+	if s := query.Get("stringLength"); s != "" {
+		stringLength, err = strconv.Atoi(s)
+		if err != nil || stringLength < 1 || stringLength > 2*int(maxBlockLength(addr)) {
+			handleError(w, r, fmt.Sprintf("Invalid string length: %q", s))
+			return
+		}
+	}
+
+	byteOrder := query.Get("byteOrder")
+	if byteOrder != "" && !byteOrders[byteOrder] {
+		handleError(w, r, fmt.Sprintf("Unknown byte order: %q", byteOrder))
+		return
+	}
+
+	var formats []string
+	for _, f := range query["format"] {
+		for _, format := range strings.Split(f, ",") {
+			if !registerFormats[format] {
+				handleError(w, r, fmt.Sprintf("Unknown format: %q", format))
+				return
+			}
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		for format := range registerFormats {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// Coils and discrete inputs are a single bit whatever the format
+	if addr < 30000 {
+		value, source, err := server.previewBit(addr)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error reading address %d: %v", addr, err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"address":  addr,
+			"source":   source,
+			"previews": []FormatPreview{{Format: "boolean", Value: value, Words: 1}},
+		})
+		return
+	}
+
+	regs := make([]RegisterConfig, len(formats))
+	n := 1
+	for i, format := range formats {
+		regs[i] = RegisterConfig{Address: addr, Format: format, StringLength: stringLength, ByteOrder: byteOrder}
+		n = max(n, registerWordCount(regs[i]))
+	}
+	if rangeEnd, _ := addressRangeEnd(addr); int(addr)+n-1 > int(rangeEnd) {
+		n = int(rangeEnd) - int(addr) + 1
+	}
+	n = min(n, int(maxBlockLength(addr)))
+
+	words, source, err := server.previewWords(addr, n)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error reading address %d: %v", addr, err))
+		return
+	}
+
+	previews := make([]FormatPreview, len(regs))
+	hex := make([]string, len(words))
+	for i, reg := range regs {
+		count := min(registerWordCount(reg), len(words))
+		value := inLocation(reg, decodeRegister(reg, words[:count]), server.location())
+		previews[i] = FormatPreview{Format: reg.Format, Value: jsonNumber(value), Words: registerWordCount(reg)}
+	}
+	for i, word := range words {
+		hex[i] = fmt.Sprintf("0x%04X", word)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"address":  addr,
+		"source":   source,
+		"raw":      words,
+		"hex":      hex,
+		"previews": previews,
+	})
+}
+
+// previewWords returns n register words from addr, from the last poll if a
+// block covers all of them and from the device otherwise, with the source
+// ("poll" or "device"). The caller must hold s.mu.
+func (s *ModbusServer) previewWords(addr uint16, n int) ([]uint16, string, error) {
+	for _, block := range s.RegisterBlocks {
+		if addr >= block.StartAddress && int(addr)+n <= int(block.StartAddress)+int(block.Length) {
+			return s.registerWords(block, addr, n), "poll", nil
+		}
+	}
+	if s.client == nil {
+		return nil, "", fmt.Errorf("server %s is not connected and no block covers %d registers from the address", s.ID, n)
+	}
+	values, err := readAddresses(s.client, addr, uint16(n))
+	if err != nil {
+		return nil, "", err
+	}
+	words := values.([]uint16)
+	if err := checkResponseLength(len(words), uint16(n)); err != nil {
+		return nil, "", err
+	}
+	return words, "device", nil
+}
+
+// previewBit returns a coil or discrete input like previewWords
+func (s *ModbusServer) previewBit(addr uint16) (bool, string, error) {
+	for _, block := range s.RegisterBlocks {
+		if addr >= block.StartAddress && int(addr) < int(block.StartAddress)+int(block.Length) {
+			if addr < 10000 {
+				return s.dataModel.Coils[addr], "poll", nil
+			}
+			return s.dataModel.DiscreteInputs[addr-10000], "poll", nil
+		}
+	}
+	if s.client == nil {
+		return false, "", fmt.Errorf("server %s is not connected and no block covers the address", s.ID)
+	}
+	values, err := readAddresses(s.client, addr, 1)
+	if err != nil {
+		return false, "", err
+	}
+	bits := values.([]bool)
+	if err := checkResponseLength(len(bits), 1); err != nil {
+		return false, "", err
+	}
+	return bits[0], "device", nil
+}
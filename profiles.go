@@ -0,0 +1,220 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed profiles
+var profileFiles embed.FS
+
+// DeviceProfile is the register map of a device model, applied to a server
+// to name its registers without entering them by hand
+type DeviceProfile struct {
+	Name           string               `json:"name"`
+	Description    string               `json:"description,omitempty"`
+	Identification DeviceIdentification `json:"identification"` // texts the identification must contain, ignoring case; empty ones match anything
+	Probes         []ProfileProbe       `json:"probes,omitempty"`
+	RegisterBlocks []RegisterBlock      `json:"registerBlocks"`
+}
+
+// ProfileProbe is a register read to recognize devices that do not answer
+// identification requests: it must be readable and within the range
+type ProfileProbe struct {
+	Address uint16   `json:"address"`
+	Format  string   `json:"format,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+// ProfileMatch is a profile that fits a device, and why
+type ProfileMatch struct {
+	Profile string `json:"profile"`
+	Reason  string `json:"reason"`
+}
+
+// deviceProfiles holds the built-in profiles by name
+var deviceProfiles = make(map[string]*DeviceProfile)
+
+func init() {
+	files, err := profileFiles.ReadDir("profiles")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := profileFiles.ReadFile(path.Join("profiles", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var profile DeviceProfile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			panic(fmt.Sprintf("profile %s: %v", file.Name(), err))
+		}
+		v := &configValidator{}
+		v.checkBlocks(file.Name(), profile.RegisterBlocks)
+		if len(v.errors) > 0 {
+			panic(fmt.Sprintf("profile %s: %s: %s", file.Name(), v.errors[0].Path, v.errors[0].Message))
+		}
+		deviceProfiles[profile.Name] = &profile
+	}
+}
+
+// profileNames returns the names of the built-in profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(deviceProfiles))
+	for name := range deviceProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasIdentification reports whether the profile is recognized by identification
+func (p *DeviceProfile) hasIdentification() bool {
+	return p.Identification != DeviceIdentification{}
+}
+
+// matchesIdentification reports whether every identification text of the
+// profile is part of the device's
+func (p *DeviceProfile) matchesIdentification(id *DeviceIdentification) bool {
+	contains := func(value, text string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(text))
+	}
+	want := p.Identification
+	return contains(id.VendorName, want.VendorName) && contains(id.ProductCode, want.ProductCode) &&
+		contains(id.Revision, want.Revision) && contains(id.VendorURL, want.VendorURL) &&
+		contains(id.ProductName, want.ProductName) && contains(id.ModelName, want.ModelName) &&
+		contains(id.UserApplicationName, want.UserApplicationName)
+}
+
+// matchesProbes reports whether every probe of the profile reads a value in
+// its range from the device
+func (p *DeviceProfile) matchesProbes(device Device) bool {
+	for _, probe := range p.Probes {
+		reg := RegisterConfig{Address: probe.Address, Format: probe.Format}
+		values, err := readAddresses(device, probe.Address, uint16(registerWordCount(reg)))
+		if err != nil {
+			return false
+		}
+		var value interface{}
+		switch v := values.(type) {
+		case []bool:
+			value = v[0]
+		case []uint16:
+			value = decodeRegister(reg, v)
+		}
+		number, ok := toFloat(value)
+		if !ok || (probe.Min != nil && number < *probe.Min) || (probe.Max != nil && number > *probe.Max) {
+			return false
+		}
+	}
+	return len(p.Probes) > 0
+}
+
+// detectProfiles asks the device of a server for its identification and
+// tries the probes of the profiles it does not identify, and returns the
+// profiles that fit, those found by identification first. The caller must
+// hold s.mu.
+func (s *ModbusServer) detectProfiles() (*DeviceIdentification, []ProfileMatch, error) {
+	if s.client == nil {
+		return nil, nil, fmt.Errorf("server %s is not connected", s.ID)
+	}
+
+	id, err := readDeviceIdentification(s.client)
+	if err != nil {
+		logMessage(InfoLevel, "Server %s: no device identification, trying probe reads: %v", s.ID, err)
+		id = nil
+	}
+
+	matches := []ProfileMatch{}
+	var probed []ProfileMatch
+	for _, name := range profileNames() {
+		profile := deviceProfiles[name]
+		switch {
+		case id != nil && profile.hasIdentification() && profile.matchesIdentification(id):
+			matches = append(matches, ProfileMatch{name, fmt.Sprintf("identified as %s %s", id.VendorName, strings.TrimSpace(id.ProductName+" "+id.ProductCode))})
+		case profile.matchesProbes(s.client):
+			probed = append(probed, ProfileMatch{name, "probe reads are in range"})
+		}
+	}
+	return id, append(matches, probed...), nil
+}
+
+// handleProfiles lists the built-in device profiles on GET /api/profiles
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	profiles := make([]*DeviceProfile, 0, len(deviceProfiles))
+	for _, name := range profileNames() {
+		profiles = append(profiles, deviceProfiles[name])
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"profiles": profiles,
+	})
+}
+
+// handleProfile detects the profiles that fit the device of a server on GET
+// /api/servers/{id}/profile, and replaces the server's register blocks with
+// those of a profile on PUT or POST with {"profile": "Eastron SDM630"}
+func handleProfile(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		server.mu.Lock()
+		identification, matches, err := server.detectProfiles()
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":        true,
+			"identification": identification,
+			"matches":        matches,
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Profile string `json:"profile"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		profile, ok := deviceProfiles[request.Profile]
+		if !ok {
+			handleError(w, r, fmt.Sprintf("Unknown profile: %q (available: %s)", request.Profile, strings.Join(profileNames(), ", ")))
+			return
+		}
+
+		server.mu.Lock()
+		server.RegisterBlocks = copyBlocks(profile.RegisterBlocks)
+		server.registerMap = buildRegisterMap(server.RegisterBlocks)
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Applied profile %s to server %s", profile.Name, id)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"profile": profile.Name,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxPulse is the longest a pulse may hold a coil on. Longer commands are
+// better written as separate on and off writes.
+const maxPulse = 60.0
+
+// checkPulse returns an error if a register cannot be pulsed for the given
+// number of seconds. A pulse of 0 is no pulse.
+func checkPulse(reg RegisterConfig, pulse float64) error {
+	switch {
+	case pulse == 0:
+		return nil
+	case pulse < 0:
+		return fmt.Errorf("pulse must not be negative")
+	case pulse > maxPulse:
+		return fmt.Errorf("pulse must not be longer than %g seconds", maxPulse)
+	case reg.Address >= 10000 && reg.Format != "boolean":
+		return fmt.Errorf("only coils and boolean registers can be pulsed")
+	}
+	return nil
+}
+
+// pulseWrite writes a planned pulse: the register is switched on, held for
+// the plan's pulse time and switched off again. s.mu is released while
+// waiting so the server keeps being polled. The caller must hold s.mu.
+func pulseWrite(s *ModbusServer, plan *WritePlan) error {
+	if err := writeParameter(s.client, ParameterValue{Address: plan.Address, Values: plan.Values}); err != nil {
+		return fmt.Errorf("error switching address %d on: %v", plan.Address, err)
+	}
+
+	s.mu.Unlock()
+	time.Sleep(time.Duration(plan.Pulse * float64(time.Second)))
+	s.mu.Lock()
+
+	// A coil left on is worse than a missed command, so switching off is
+	// retried
+	off := make([]uint16, len(plan.Values))
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			s.mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+			s.mu.Lock()
+		}
+		if s.client == nil {
+			err = fmt.Errorf("server %s disconnected", s.ID)
+			continue
+		}
+		if err = writeParameter(s.client, ParameterValue{Address: plan.Address, Values: off}); err == nil {
+			return nil
+		}
+	}
+	logMessage(ErrorLevel, "Pulse on %s address %d: error switching off, it may still be on: %v", s.ID, plan.Address, err)
+	return fmt.Errorf("address %d was switched on but switching it off failed, it may still be on: %v", plan.Address, err)
+}
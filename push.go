@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pushStaleAfter is how long a pushing instance may be silent before it is shown as in error
+const pushStaleAfter = time.Minute
+
+// defaultPushQueueLimit is the number of unsent batches kept by default, in
+// memory or in the spool directory; about a day at the default interval
+const defaultPushQueueLimit = 17280
+
+// maxRejectedBatches is the number of batches rejected by the push endpoint
+// that are kept in the spool directory for inspection
+const maxRejectedBatches = 100
+
+// pushRejectedDir is the directory in the spool directory that batches the
+// push endpoint rejected are moved to
+const pushRejectedDir = "rejected"
+
+// PushBatch is a set of samples forwarded by an edge instance
+type PushBatch struct {
+	Instance string       `json:"instance"`
+	Time     time.Time    `json:"time"`
+	Servers  []PushServer `json:"servers"`
+}
+
+// PushServer is the status and configured register values of one server at the time of a batch
+type PushServer struct {
+	ID               string       `json:"id"`
+	Address          string       `json:"address"`
+	Port             int          `json:"port"`
+	ConnectionStatus string       `json:"connectionStatus"`
+	ConnectionError  string       `json:"connectionError,omitempty"`
+	LastDataReceived time.Time    `json:"lastDataReceived"`
+	Samples          []PushSample `json:"samples"`
+}
+
+// PushSample is a single register value
+type PushSample struct {
+	Address uint16      `json:"address"`
+	Name    string      `json:"name"`
+	Value   interface{} `json:"value"`
+	Quality string      `json:"quality"`
+}
+
+// pushEntry is a queued batch: its data when kept in memory, or the spool
+// file holding it, which is only read when the batch is sent
+type pushEntry struct {
+	time time.Time
+	data []byte
+	file string
+}
+
+// pusher forwards samples to a central instance or other HTTP endpoint
+type pusher struct {
+	url      string
+	token    string
+	name     string
+	spoolDir string
+	limit    int           // unsent batches kept, the oldest are dropped beyond
+	maxAge   time.Duration // unsent batches older than this are dropped, kept if 0
+	queue    []pushEntry
+	failing  bool // the last send failed, so the next success is logged
+	dropped  int  // batches dropped by the limits since the last successful send
+}
+
+// pushRejectedError is returned for a batch the endpoint refused for good,
+// such as with 400 Bad Request, which sending again would not change
+type pushRejectedError struct {
+	status string
+}
+
+func (e *pushRejectedError) Error() string {
+	return "rejected: " + e.status
+}
+
+// startPush begins forwarding samples every interval. Batches that cannot be
+// delivered are kept, in spoolDir if set, and sent in order once the
+// endpoint is reachable. At most limit batches no older than maxAge are kept,
+// so a long outage does not fill the disk or the memory.
+func startPush(url, token, name, spoolDir string, interval time.Duration, limit int, maxAge time.Duration) error {
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		name = hostname
+	}
+	if limit < 1 {
+		return fmt.Errorf("queue limit %d must be at least 1", limit)
+	}
+
+	p := &pusher{url: url, token: token, name: name, spoolDir: spoolDir, limit: limit, maxAge: maxAge}
+	if spoolDir != "" {
+		if err := os.MkdirAll(spoolDir, 0755); err != nil {
+			return err
+		}
+		if err := p.loadSpool(); err != nil {
+			return err
+		}
+	}
+
+	go p.run(interval)
+	return nil
+}
+
+// spoolFile returns the name of the spool file of a batch, which sorts by
+// the time of the batch
+func spoolFile(t time.Time) string {
+	return fmt.Sprintf("batch-%020d.json", t.UnixNano())
+}
+
+// loadSpool queues the batches left in the spool directory by a previous
+// run. Only their names are kept in memory.
+func (p *pusher) loadSpool() error {
+	files, err := filepath.Glob(filepath.Join(p.spoolDir, "batch-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		var nanos int64
+		if _, err := fmt.Sscanf(filepath.Base(file), "batch-%d.json", &nanos); err != nil {
+			continue
+		}
+		p.queue = append(p.queue, pushEntry{time: time.Unix(0, nanos), file: file})
+	}
+	if len(files) > 0 {
+		logMessage(InfoLevel, "Loaded %d spooled push batches", len(files))
+	}
+	p.trim()
+	return nil
+}
+
+// run collects a batch every interval and sends everything queued
+func (p *pusher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.enqueue(collectPushBatch(p.name)); err != nil {
+			logMessage(ErrorLevel, "Error queueing push batch: %v", err)
+		}
+		p.flush()
+	}
+}
+
+// enqueue adds a batch to the queue, spooling it to disk if enabled
+func (p *pusher) enqueue(batch PushBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	entry := pushEntry{time: batch.Time, data: data}
+	if p.spoolDir != "" {
+		entry.file = filepath.Join(p.spoolDir, spoolFile(batch.Time))
+		if err := os.WriteFile(entry.file, data, 0644); err != nil {
+			return err
+		}
+		entry.data = nil
+	}
+	p.queue = append(p.queue, entry)
+	p.trim()
+	return nil
+}
+
+// trim drops the oldest batches beyond the limit and those older than maxAge
+func (p *pusher) trim() {
+	drop := max(len(p.queue)-p.limit, 0)
+	if p.maxAge > 0 {
+		cutoff := time.Now().Add(-p.maxAge)
+		for drop < len(p.queue) && p.queue[drop].time.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	for _, entry := range p.queue[:drop] {
+		p.remove(entry)
+	}
+	if p.dropped == 0 {
+		logMessage(ErrorLevel, "Push queue full, dropping the oldest batches")
+	}
+	p.dropped += drop
+	p.queue = append([]pushEntry(nil), p.queue[drop:]...)
+}
+
+// remove deletes the spool file of a batch, if it has one
+func (p *pusher) remove(entry pushEntry) {
+	if entry.file == "" {
+		return
+	}
+	if err := os.Remove(entry.file); err != nil && !os.IsNotExist(err) {
+		logMessage(ErrorLevel, "Error removing spooled batch: %v", err)
+	}
+}
+
+// flush sends queued batches in order, stopping at the first failure.
+// Batches the endpoint rejects are set aside rather than sent again forever.
+func (p *pusher) flush() {
+	for len(p.queue) > 0 {
+		entry := p.queue[0]
+		data := entry.data
+		if entry.file != "" {
+			var err error
+			if data, err = os.ReadFile(entry.file); err != nil {
+				logMessage(ErrorLevel, "Error reading spooled batch, dropping it: %v", err)
+				p.queue = p.queue[1:]
+				continue
+			}
+		}
+
+		err := p.send(data)
+		var rejected *pushRejectedError
+		if errors.As(err, &rejected) {
+			logMessage(ErrorLevel, "Push to %s %v, setting the batch of %s aside", p.url, err, entry.time.Format(time.RFC3339))
+			p.reject(entry)
+			p.queue = p.queue[1:]
+			continue
+		}
+		if err != nil {
+			if !p.failing {
+				logMessage(ErrorLevel, "Push to %s failed, queueing samples: %v", p.url, err)
+				p.failing = true
+			}
+			return
+		}
+		if p.failing {
+			logMessage(ErrorLevel, "Push to %s resumed, sending %d queued batches", p.url, len(p.queue))
+			p.failing = false
+		}
+		if p.dropped > 0 {
+			logMessage(ErrorLevel, "Push to %s dropped %d batches while failing", p.url, p.dropped)
+			p.dropped = 0
+		}
+		p.remove(entry)
+		p.queue = p.queue[1:]
+	}
+}
+
+// reject moves the spool file of a rejected batch to the rejected directory,
+// keeping the last maxRejectedBatches, or drops the batch if not spooled
+func (p *pusher) reject(entry pushEntry) {
+	if entry.file == "" {
+		return
+	}
+	dir := filepath.Join(p.spoolDir, pushRejectedDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logMessage(ErrorLevel, "Error keeping rejected batch: %v", err)
+		p.remove(entry)
+		return
+	}
+	if err := os.Rename(entry.file, filepath.Join(dir, filepath.Base(entry.file))); err != nil {
+		logMessage(ErrorLevel, "Error keeping rejected batch: %v", err)
+		p.remove(entry)
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "batch-*.json"))
+	if err != nil || len(files) <= maxRejectedBatches {
+		return
+	}
+	sort.Strings(files)
+	for _, file := range files[:len(files)-maxRejectedBatches] {
+		os.Remove(file)
+	}
+}
+
+// send posts one batch to the push endpoint. A client error other than a
+// timeout or rate limit is returned as a pushRejectedError.
+func (p *pusher) send(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden:
+		return &pushRejectedError{resp.Status}
+	default:
+		return fmt.Errorf("%s", resp.Status)
+	}
+}
+
+// collectPushBatch samples the status and configured registers of all servers
+func collectPushBatch(name string) PushBatch {
+	batch := PushBatch{Instance: name, Time: time.Now(), Servers: []PushServer{}}
+
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	for _, server := range serverList {
+		server.mu.Lock()
+		entry := PushServer{
+			ID:               server.ID,
+			Address:          server.Address,
+			Port:             server.Port,
+			ConnectionStatus: server.ConnectionStatus,
+			ConnectionError:  server.ConnectionError,
+			LastDataReceived: server.LastDataReceived,
+			Samples:          []PushSample{},
+		}
+		for _, row := range server.registerData() {
+			addr := row["Address"].(uint16)
+			if _, configured := server.registerMap[addr]; !configured {
+				continue
+			}
+			entry.Samples = append(entry.Samples, PushSample{
+				Address: addr,
+				Name:    row["Name"].(string),
+				Value:   jsonNumber(row["Value"]),
+				Quality: row["Quality"].(string),
+			})
+		}
+		server.mu.Unlock()
+		batch.Servers = append(batch.Servers, entry)
+	}
+
+	sort.Slice(batch.Servers, func(i, j int) bool {
+		return batch.Servers[i].ID < batch.Servers[j].ID
+	})
+	return batch
+}
+
+// ingestToken is the bearer token edge instances must present to POST /api/push,
+// which is disabled when empty
+var ingestToken string
+
+// handlePush accepts batches from edge instances and shows them as remote
+// instances. Only the newest batch of each instance is kept: batches that
+// were spooled while the link was down are acknowledged, so the edge moves
+// on, but older than what is shown and not kept anywhere. The spool only
+// preserves them for push URLs that store every batch.
+func handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ingestToken == "" {
+		http.Error(w, "Push ingest is disabled", http.StatusNotFound)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ingestToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var batch PushBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid push batch: %v", err), http.StatusBadRequest)
+		return
+	}
+	if batch.Instance == "" {
+		http.Error(w, "Push batch has no instance name", http.StatusBadRequest)
+		return
+	}
+
+	remotesMu.Lock()
+	var remote *remoteInstance
+	for _, existing := range remotes {
+		if existing.Name == batch.Instance && existing.URL == "" {
+			remote = existing
+			break
+		}
+	}
+	if remote == nil {
+		remote = &remoteInstance{Name: batch.Instance}
+		remotes = append(remotes, remote)
+		logMessage(InfoLevel, "Receiving pushed samples from %s", batch.Instance)
+	}
+	// Spooled batches arrive after newer ones were shown; only the newest is kept
+	if !batch.Time.Before(remote.LastUpdate) {
+		remote.Status = "ok"
+		remote.Error = ""
+		remote.LastUpdate = batch.Time
+		remote.Servers = make([]remoteServer, 0, len(batch.Servers))
+		remote.samples = make(map[string][]PushSample, len(batch.Servers))
+		for _, server := range batch.Servers {
+			remote.Servers = append(remote.Servers, remoteServer{
+				ID:               server.ID,
+				ConnectionStatus: server.ConnectionStatus,
+				ConnectionError:  server.ConnectionError,
+				Address:          server.Address,
+				Port:             server.Port,
+				LastDataReceived: server.LastDataReceived,
+			})
+			remote.samples[server.ID] = server.Samples
+		}
+	}
+	remotesMu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RawBlock holds the undecoded values of a register block as read by the
+// last poll. The address is the protocol address within the table, starting
+// at 0, like in raw writes.
+type RawBlock struct {
+	StartAddress uint16      `json:"startAddress"`
+	Length       uint16      `json:"length"`
+	Table        string      `json:"table"` // "coils", "discreteInputs", "inputRegisters" or "holdingRegisters"
+	Address      uint16      `json:"address"`
+	Status       string      `json:"status"`
+	Time         *time.Time  `json:"time"`   // of the last successful read, null if never read
+	Values       interface{} `json:"values"` // []bool for coils and discrete inputs, []uint16 words otherwise
+}
+
+// rawBlock returns the values of a block as read by the last poll. The caller
+// must hold s.mu.
+func (s *ModbusServer) rawBlock(block RegisterBlock, status BlockStatus) RawBlock {
+	raw := RawBlock{StartAddress: block.StartAddress, Length: block.Length, Status: status.Status}
+	if !status.LastSuccess.IsZero() {
+		t := status.LastSuccess
+		raw.Time = &t
+	}
+	start, end := block.StartAddress, block.StartAddress+block.Length
+	switch {
+	case start < 10000:
+		raw.Table, raw.Address = "coils", start
+		raw.Values = append([]bool{}, s.dataModel.Coils[start:end]...)
+	case start < 20000:
+		raw.Table, raw.Address = "discreteInputs", start-10000
+		raw.Values = append([]bool{}, s.dataModel.DiscreteInputs[start-10000:end-10000]...)
+	case start < 40000:
+		raw.Table, raw.Address = "inputRegisters", start-30000
+		raw.Values = append([]uint16{}, s.dataModel.InputRegisters[start-30000:end-30000]...)
+	default:
+		raw.Table, raw.Address = "holdingRegisters", start-40000
+		raw.Values = append([]uint16{}, s.dataModel.HoldingRegisters[start-40000:end-40000]...)
+	}
+	return raw
+}
+
+// handleRawRead serves the undecoded values of a server's register blocks
+// on GET /api/servers/{id}/raw, or of the block starting at an address with
+// ?block=40000, so external scripts can decode them their own way while
+// modbusbrowser polls the device
+func handleRawRead(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	block := -1
+	if s := r.URL.Query().Get("block"); s != "" {
+		start, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid block: %q (must be the start address of a block)", s))
+			return
+		}
+		block = int(start)
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	server.mu.Lock()
+	blocks := make([]RawBlock, 0, len(server.RegisterBlocks))
+	for i, status := range server.BlockStatuses() {
+		if block < 0 || int(status.StartAddress) == block {
+			blocks = append(blocks, server.rawBlock(server.RegisterBlocks[i], status))
+		}
+	}
+	server.mu.Unlock()
+
+	if block >= 0 && len(blocks) == 0 {
+		handleError(w, r, fmt.Sprintf("No block of server %s starts at %d", id, block))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"blocks":  blocks,
+	})
+}
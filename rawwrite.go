@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rawWriteToken is the bearer token external systems must present to
+// POST /api/servers/{id}/raw-write, which is disabled when empty
+var rawWriteToken string
+
+// RawWriteRequest is the body of POST /api/servers/{id}/raw-write. The
+// address is the protocol address within the table, starting at 0, and the
+// values are written as given: true/false or 1/0 for coils and raw 16-bit
+// words for holding registers.
+type RawWriteRequest struct {
+	Table   string        `json:"table"` // "coils" or "holdingRegisters"
+	Address uint16        `json:"address"`
+	Values  []interface{} `json:"values"`
+	Client  string        `json:"client,omitempty"` // name of the calling system, recorded in the write history
+	WriteAuthorization
+}
+
+// handleRawWrite writes coils or holding registers for external systems
+// through the server's managed connection, without requiring the addresses
+// to be configured. Writes are subject to the server's write policy and are
+// recorded in its write history like writes made in the UI.
+func handleRawWrite(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rawWriteToken == "" {
+		http.Error(w, "Raw writes are disabled", http.StatusNotFound)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rawWriteToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	var req RawWriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid raw write request: %v", err))
+		return
+	}
+
+	var base uint16
+	var limit int
+	switch req.Table {
+	case "coils":
+		base, limit = 0, maxWriteCoils
+	case "holdingRegisters":
+		base, limit = 40000, maxWriteRegisters
+	default:
+		handleError(w, r, fmt.Sprintf("Invalid table %q (must be coils or holdingRegisters)", req.Table))
+		return
+	}
+	switch {
+	case len(req.Values) == 0:
+		handleError(w, r, "Raw write requires at least one value")
+		return
+	case len(req.Values) > limit:
+		handleError(w, r, fmt.Sprintf("%d values exceed the maximum of %d per write", len(req.Values), limit))
+		return
+	case int(req.Address)+len(req.Values) > 10000:
+		handleError(w, r, fmt.Sprintf("Address %d+%d is out of range (0-9999)", req.Address, len(req.Values)))
+		return
+	}
+
+	addr := base + req.Address
+	values := make([]uint16, len(req.Values))
+	for i, value := range req.Values {
+		text := fmt.Sprint(value)
+		if f, ok := value.(float64); ok {
+			text = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		var err error
+		if base == 0 {
+			var on bool
+			if on, err = parseCoilValue(text); on {
+				values[i] = 1
+			}
+		} else {
+			values[i], err = parseRegisterValue(text)
+		}
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Value %d (address %d): %v", i, addr+uint16(i), err))
+			return
+		}
+	}
+
+	// Coils are recorded one change per coil, as in the rest of the write history
+	var changes []WriteChange
+	if base == 0 {
+		for i, v := range values {
+			changes = append(changes, WriteChange{Address: addr + uint16(i), Values: []uint16{v}})
+		}
+	} else {
+		changes = []WriteChange{{Address: addr, Values: values}}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.client == nil {
+		handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+		return
+	}
+	if err := checkWritePolicy(server, changes, req.WriteAuthorization); err != nil {
+		handleWritePolicyError(w, r, err)
+		return
+	}
+
+	var err error
+	if base == 0 {
+		previous, readErr := server.client.ReadCoils(addr, uint16(len(values)))
+		if readErr == nil && checkResponseLength(len(previous), uint16(len(values))) == nil {
+			for i, on := range previous {
+				if on {
+					changes[i].Previous = []uint16{1}
+				} else {
+					changes[i].Previous = []uint16{0}
+				}
+			}
+		}
+		coils := make([]bool, len(values))
+		for i, v := range values {
+			coils[i] = v == 1
+		}
+		if len(coils) == 1 {
+			err = server.client.WriteSingleCoil(addr, coils[0])
+		} else {
+			err = server.client.WriteMultipleCoils(addr, coils)
+		}
+	} else {
+		changes[0].Previous, _ = readParameter(server.client, addr, len(values))
+		err = writeParameter(server.client, ParameterValue{Address: addr, Values: values})
+	}
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Error writing %s %d+%d: %v", req.Table, req.Address, len(values), err))
+		return
+	}
+
+	source := "raw write"
+	if req.Client != "" {
+		source += " (" + req.Client + ")"
+	}
+	op := recordWrite(id, source, changes, 0)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"writeId": op.ID,
+		"write":   op,
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RegisterMapChange is a change to the register map shared by the servers of
+// a template, e.g. after a firmware update moved or retyped registers
+type RegisterMapChange struct {
+	Op           string `json:"op"`                     // "rename", "format" or "shift"
+	Address      uint16 `json:"address,omitempty"`      // register to rename or reformat
+	Name         string `json:"name,omitempty"`         // new name, for rename
+	Format       string `json:"format,omitempty"`       // new format, for format
+	StringLength int    `json:"stringLength,omitempty"` // new string length, for the string formats
+	From         uint16 `json:"from,omitempty"`         // first address of the registers to shift
+	To           uint16 `json:"to,omitempty"`           // last address of the registers to shift
+	By           int    `json:"by,omitempty"`           // addresses to shift by, negative to shift down
+}
+
+// RefactorRequest is the body of POST /api/templates/{name}/refactor
+type RefactorRequest struct {
+	Changes []RegisterMapChange `json:"changes"`
+	DryRun  bool                `json:"dryRun,omitempty"`
+}
+
+// copyBlocks returns a deep copy of register blocks
+func copyBlocks(blocks []RegisterBlock) []RegisterBlock {
+	copied := make([]RegisterBlock, len(blocks))
+	for i, block := range blocks {
+		copied[i] = block
+		copied[i].Registers = append([]RegisterConfig(nil), block.Registers...)
+	}
+	return copied
+}
+
+// findRegister returns the block and register index of the register at addr
+func findRegister(blocks []RegisterBlock, addr uint16) (int, int, bool) {
+	for i, block := range blocks {
+		for j, reg := range block.Registers {
+			if reg.Address == addr {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// applyMapChanges applies changes to register blocks in place and describes
+// each change made
+func applyMapChanges(blocks []RegisterBlock, changes []RegisterMapChange) ([]string, error) {
+	var summary []string
+	for n, change := range changes {
+		var err error
+		var description string
+		switch change.Op {
+		case "rename":
+			description, err = renameRegister(blocks, change)
+		case "format":
+			description, err = reformatRegister(blocks, change)
+		case "shift":
+			description, err = shiftRegisters(blocks, change)
+		default:
+			err = fmt.Errorf("unknown op %q (must be rename, format or shift)", change.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("change %d: %v", n+1, err)
+		}
+		summary = append(summary, description)
+	}
+	return summary, nil
+}
+
+func renameRegister(blocks []RegisterBlock, change RegisterMapChange) (string, error) {
+	if change.Name == "" {
+		return "", errors.New("rename requires a name")
+	}
+	i, j, ok := findRegister(blocks, change.Address)
+	if !ok {
+		return "", fmt.Errorf("no register configured at address %d", change.Address)
+	}
+	reg := &blocks[i].Registers[j]
+	description := fmt.Sprintf("renamed %d from %q to %q", reg.Address, reg.Name, change.Name)
+	reg.Name = change.Name
+	return description, nil
+}
+
+func reformatRegister(blocks []RegisterBlock, change RegisterMapChange) (string, error) {
+	if !registerFormats[change.Format] {
+		return "", fmt.Errorf("invalid format %q", change.Format)
+	}
+	if strings.HasPrefix(change.Format, "string") && change.StringLength < 1 {
+		return "", fmt.Errorf("%s format requires a string length", change.Format)
+	}
+	i, j, ok := findRegister(blocks, change.Address)
+	if !ok {
+		return "", fmt.Errorf("no register configured at address %d", change.Address)
+	}
+	if change.Address < 30000 && change.Format != "boolean" {
+		return "", fmt.Errorf("address %d is a coil or discrete input and can only be boolean", change.Address)
+	}
+	reg := &blocks[i].Registers[j]
+	updated := *reg
+	updated.Format = change.Format
+	updated.StringLength = change.StringLength
+	block := blocks[i]
+	if int(reg.Address)+registerWordCount(updated) > int(block.StartAddress)+int(block.Length) {
+		return "", fmt.Errorf("%s value at address %d needs %d registers and runs past the end of block %d+%d",
+			change.Format, reg.Address, registerWordCount(updated), block.StartAddress, block.Length)
+	}
+	description := fmt.Sprintf("changed format of %d from %s to %s", reg.Address, reg.Format, change.Format)
+	*reg = updated
+	return description, nil
+}
+
+// shiftRegisters moves the registers in [From, To] by By addresses. Blocks
+// covering the range grow or move so that they still cover their registers.
+func shiftRegisters(blocks []RegisterBlock, change RegisterMapChange) (string, error) {
+	tableEnd, ok := addressRangeEnd(change.From)
+	switch {
+	case !ok:
+		return "", fmt.Errorf("address %d is not in a valid address range", change.From)
+	case change.To < change.From:
+		return "", errors.New("shift requires to to be at or after from")
+	case change.To > tableEnd:
+		return "", fmt.Errorf("shift range %d-%d crosses the end of its address range at %d", change.From, change.To, tableEnd)
+	case change.By == 0:
+		return "", errors.New("shift requires a non-zero by")
+	}
+	tableStart := int(tableEnd) - 9999
+	if int(change.From)+change.By < tableStart || int(change.To)+change.By > int(tableEnd) {
+		return "", fmt.Errorf("shifting %d-%d by %d leaves its address range", change.From, change.To, change.By)
+	}
+	from, to := int(change.From), int(change.To)+1 // [from, to)
+
+	moved := 0
+	owner := make(map[uint16]bool) // addresses of the registers after the shift
+	for i := range blocks {
+		block := &blocks[i]
+		start, end := int(block.StartAddress), int(block.StartAddress)+int(block.Length)
+		if end <= from || start >= to {
+			for _, reg := range block.Registers {
+				if owner[reg.Address] {
+					return "", fmt.Errorf("address %d would be configured twice", reg.Address)
+				}
+				owner[reg.Address] = true
+			}
+			continue
+		}
+
+		// The block is the span of its part outside the range and its part
+		// inside the range, moved
+		newStart, newEnd := max(start, from)+change.By, min(end, to)+change.By
+		if start < from {
+			newStart, newEnd = min(newStart, start), max(newEnd, from)
+		}
+		if end > to {
+			newStart, newEnd = min(newStart, to), max(newEnd, end)
+		}
+		limit := int(maxBlockLength(uint16(newStart)))
+		if newEnd-newStart > limit {
+			return "", fmt.Errorf("block %d+%d would grow to %d addresses, more than the maximum of %d per read",
+				block.StartAddress, block.Length, newEnd-newStart, limit)
+		}
+		block.StartAddress, block.Length = uint16(newStart), uint16(newEnd-newStart)
+
+		for j := range block.Registers {
+			reg := &block.Registers[j]
+			if int(reg.Address) >= from && int(reg.Address) < to {
+				reg.Address = uint16(int(reg.Address) + change.By)
+				moved++
+			}
+			if owner[reg.Address] {
+				return "", fmt.Errorf("address %d would be configured twice", reg.Address)
+			}
+			owner[reg.Address] = true
+		}
+	}
+	return fmt.Sprintf("shifted %d registers in %d-%d by %d", moved, change.From, change.To, change.By), nil
+}
+
+// templateServers returns the servers created from a template, sorted by ID
+func templateServers(name string) []*ModbusServer {
+	mu.RLock()
+	defer mu.RUnlock()
+	var list []*ModbusServer
+	for _, server := range servers {
+		if server.Template == name {
+			list = append(list, server)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// handleTemplates lists the templates servers were created from on GET
+// /api/templates, and changes the register map of every server of a template
+// in one operation on POST /api/templates/{name}/refactor. The changes are
+// applied to all servers or, if any of them fails, to none.
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/templates"), "/")
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		templates := make(map[string][]string)
+		mu.RLock()
+		for _, server := range servers {
+			if server.Template != "" {
+				templates[server.Template] = append(templates[server.Template], server.ID)
+			}
+		}
+		mu.RUnlock()
+		for _, ids := range templates {
+			sort.Strings(ids)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"templates": templates,
+		})
+		return
+	}
+
+	name, action, _ := strings.Cut(path, "/")
+	if action != "refactor" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RefactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid refactor request: %v", err))
+		return
+	}
+	if len(req.Changes) == 0 {
+		handleError(w, r, "Refactor requires at least one change")
+		return
+	}
+
+	list := templateServers(name)
+	if len(list) == 0 {
+		handleError(w, r, fmt.Sprintf("No servers were created from template %q", name))
+		return
+	}
+
+	// Hold every server's lock so the fleet changes at once
+	for _, server := range list {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+	}
+
+	updated := make([][]RegisterBlock, len(list))
+	results := make(map[string][]string)
+	for i, server := range list {
+		updated[i] = copyBlocks(server.RegisterBlocks)
+		summary, err := applyMapChanges(updated[i], req.Changes)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Server %s: %v; no server was changed", server.ID, err))
+			return
+		}
+		results[server.ID] = summary
+	}
+
+	if !req.DryRun {
+		for i, server := range list {
+			server.RegisterBlocks = updated[i]
+			server.registerMap = buildRegisterMap(server.RegisterBlocks)
+		}
+		logMessage(InfoLevel, "Applied %d register map changes to the %d servers of template %s", len(req.Changes), len(list), name)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"dryRun":  req.DryRun,
+		"servers": results,
+	})
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// registerMapFormat identifies register map interchange files
+const registerMapFormat = "modbusbrowser-register-map"
+
+// registerMapVersion is the version of the interchange format written. Files
+// of later versions are rejected rather than read in part.
+const registerMapVersion = 1
+
+// registerMapSchema is the JSON Schema of the interchange format, served on
+// GET /api/registermap/schema
+//
+//go:embed schema/register-map.schema.json
+var registerMapSchema []byte
+
+// RegisterMap is the portable form of a server's register map, for sharing
+// maps between installations and generating them with vendor tooling.
+// Registers are addressed by table and protocol address (0 to 9999) rather
+// than by the browser's 40001-style numbering.
+type RegisterMap struct {
+	Schema    string        `json:"$schema,omitempty"`
+	Format    string        `json:"format"`  // registerMapFormat
+	Version   int           `json:"version"` // registerMapVersion
+	Device    *MapDevice    `json:"device,omitempty"`
+	Blocks    []MapBlock    `json:"blocks,omitempty"` // read layout; one block per run of adjacent registers if empty
+	Registers []MapRegister `json:"registers"`
+}
+
+// MapDevice describes the device a register map is for
+type MapDevice struct {
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Firmware    string `json:"firmware,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// MapBlock is a range of addresses read in one request
+type MapBlock struct {
+	Table   string `json:"table"`
+	Address uint16 `json:"address"`
+	Length  uint16 `json:"length"`
+	UnitID  *uint8 `json:"unitId,omitempty"`
+}
+
+// MapRegister is a register of a register map
+type MapRegister struct {
+	Table        string            `json:"table"`   // "coil", "discrete", "input" or "holding"
+	Address      uint16            `json:"address"` // protocol address within the table
+	Name         string            `json:"name"`
+	Format       string            `json:"format,omitempty"` // as RegisterConfig; decimal if empty
+	ByteOrder    string            `json:"byteOrder,omitempty"`
+	StringLength int               `json:"stringLength,omitempty"`
+	Unit         string            `json:"unit,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Enum         map[string]string `json:"enum,omitempty"`
+	Bits         []BitConfig       `json:"bits,omitempty"`
+}
+
+// mapTables maps the tables of register maps to the first address of their
+// range in the data model, in the order maps list them
+var mapTables = []struct {
+	name string
+	base uint16
+}{
+	{"coil", 0},
+	{"discrete", 10000},
+	{"input", 30000},
+	{"holding", 40000},
+}
+
+// tableBase returns the first data model address of a register map table
+func tableBase(table string) (uint16, bool) {
+	for _, t := range mapTables {
+		if t.name == table {
+			return t.base, true
+		}
+	}
+	return 0, false
+}
+
+// tableOf returns the register map table and protocol address of a data
+// model address
+func tableOf(addr uint16) (string, uint16) {
+	for i := len(mapTables) - 1; i >= 0; i-- {
+		if addr >= mapTables[i].base {
+			return mapTables[i].name, addr - mapTables[i].base
+		}
+	}
+	return mapTables[0].name, addr
+}
+
+// exportRegisterMap converts register blocks to a register map, with the
+// registers in table and address order and the blocks in poll order.
+// Disabled blocks are left out.
+func exportRegisterMap(blocks []RegisterBlock) RegisterMap {
+	m := RegisterMap{Format: registerMapFormat, Version: registerMapVersion, Registers: []MapRegister{}}
+	for _, block := range blocks {
+		if block.Disabled {
+			continue
+		}
+		table, address := tableOf(block.StartAddress)
+		m.Blocks = append(m.Blocks, MapBlock{Table: table, Address: address, Length: block.Length, UnitID: block.UnitID})
+		for _, reg := range block.Registers {
+			table, address := tableOf(reg.Address)
+			m.Registers = append(m.Registers, MapRegister{
+				Table:        table,
+				Address:      address,
+				Name:         reg.Name,
+				Format:       reg.Format,
+				ByteOrder:    reg.ByteOrder,
+				StringLength: reg.StringLength,
+				Unit:         reg.Unit,
+				Description:  reg.Description,
+				Enum:         reg.Enum,
+				Bits:         reg.Bits,
+			})
+		}
+	}
+	sort.SliceStable(m.Registers, func(i, j int) bool {
+		a, _ := tableBase(m.Registers[i].Table)
+		b, _ := tableBase(m.Registers[j].Table)
+		return a+m.Registers[i].Address < b+m.Registers[j].Address
+	})
+	return m
+}
+
+// parseRegisterMap decodes a register map file, rejecting unknown fields and
+// versions this browser does not know
+func parseRegisterMap(data []byte) (*RegisterMap, error) {
+	var header struct {
+		Format  string `json:"format"`
+		Version int    `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if header.Format != registerMapFormat {
+		return nil, fmt.Errorf("format must be %q, not %q", registerMapFormat, header.Format)
+	}
+	if header.Version < 1 || header.Version > registerMapVersion {
+		return nil, fmt.Errorf("unsupported version %d (this browser reads version %d)", header.Version, registerMapVersion)
+	}
+
+	var m RegisterMap
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// importRegisterMap converts a register map to register blocks, checking it
+// as a configuration file's blocks are checked
+func importRegisterMap(m *RegisterMap) ([]RegisterBlock, []ConfigIssue, []ConfigIssue) {
+	v := &configValidator{}
+
+	// Registers in data model addresses, with the path of each in the map
+	registers := make([]RegisterConfig, 0, len(m.Registers))
+	paths := make(map[uint16]string)
+	for i, r := range m.Registers {
+		path := fmt.Sprintf("registers[%d]", i)
+		base, ok := tableBase(r.Table)
+		switch {
+		case !ok:
+			v.fail(path+".table", fmt.Sprintf("unknown table %q (must be coil, discrete, input or holding)", r.Table))
+			continue
+		case r.Address > 9999:
+			v.fail(path+".address", fmt.Sprintf("address %d is out of range (must be 0 to 9999)", r.Address))
+			continue
+		}
+		addr := base + r.Address
+		if other, ok := paths[addr]; ok {
+			v.fail(path+".address", fmt.Sprintf("%s register %d is also defined by %s", r.Table, r.Address, other))
+			continue
+		}
+		paths[addr] = path
+		registers = append(registers, RegisterConfig{
+			Name:         r.Name,
+			Format:       r.Format,
+			Address:      addr,
+			ByteOrder:    r.ByteOrder,
+			StringLength: r.StringLength,
+			Unit:         r.Unit,
+			Description:  r.Description,
+			Enum:         r.Enum,
+			Bits:         r.Bits,
+		})
+	}
+	sort.Slice(registers, func(i, j int) bool { return registers[i].Address < registers[j].Address })
+
+	var blocks []RegisterBlock
+	if len(m.Blocks) > 0 {
+		for i, b := range m.Blocks {
+			path := fmt.Sprintf("blocks[%d]", i)
+			base, ok := tableBase(b.Table)
+			switch {
+			case !ok:
+				v.fail(path+".table", fmt.Sprintf("unknown table %q (must be coil, discrete, input or holding)", b.Table))
+				continue
+			case b.Address > 9999:
+				v.fail(path+".address", fmt.Sprintf("address %d is out of range (must be 0 to 9999)", b.Address))
+				continue
+			}
+			blocks = append(blocks, RegisterBlock{StartAddress: base + b.Address, Length: b.Length, UnitID: b.UnitID})
+		}
+		for _, reg := range registers {
+			placed := false
+			for i := range blocks {
+				if reg.Address >= blocks[i].StartAddress && int(reg.Address) < int(blocks[i].StartAddress)+int(blocks[i].Length) {
+					blocks[i].Registers = append(blocks[i].Registers, reg)
+					placed = true
+					break
+				}
+			}
+			if !placed {
+				v.fail(paths[reg.Address], "register is not in any block")
+			}
+		}
+	} else {
+		blocks = adjacentBlocks(registers)
+	}
+	if len(v.errors) > 0 {
+		return nil, v.errors, v.warnings
+	}
+
+	// Apply the checks of configuration files, reporting them by the paths
+	// of the map: a block's issues by the block, or by its first register if
+	// the map has no blocks
+	prefixes := make(map[string]string)
+	for i, block := range blocks {
+		blockPath := fmt.Sprintf("blocks[%d]", i)
+		if len(m.Blocks) == 0 {
+			blockPath = paths[block.Registers[0].Address]
+		}
+		prefixes[fmt.Sprintf(".registerBlocks[%d]", i)] = blockPath
+		for j, reg := range block.Registers {
+			prefixes[fmt.Sprintf(".registerBlocks[%d].registers[%d]", i, j)] = paths[reg.Address]
+		}
+	}
+	mapPath := func(path string) string {
+		longest := ""
+		for prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+				longest = prefix
+			}
+		}
+		if longest == "" {
+			return path
+		}
+		rest := path[len(longest):]
+		if strings.HasPrefix(rest, ".length") || strings.HasPrefix(rest, ".startAddress") {
+			rest = ""
+		}
+		return prefixes[longest] + rest
+	}
+	check := &configValidator{}
+	check.checkBlocks("", blocks)
+	for _, issue := range check.errors {
+		v.fail(mapPath(issue.Path), issue.Message)
+	}
+	for _, issue := range check.warnings {
+		v.warn(mapPath(issue.Path), issue.Message)
+	}
+	return blocks, v.errors, v.warnings
+}
+
+// adjacentBlocks lays out registers, in address order, as one block per run
+// of registers without gaps between them, up to the longest read of their table
+func adjacentBlocks(registers []RegisterConfig) []RegisterBlock {
+	var blocks []RegisterBlock
+	for _, reg := range registers {
+		words := uint16(max(registerWordCount(reg), 1))
+		if reg.Address < 20000 {
+			words = 1
+		}
+		if n := len(blocks); n > 0 {
+			last := &blocks[n-1]
+			end := last.StartAddress + last.Length
+			rangeEnd, _ := addressRangeEnd(last.StartAddress)
+			if reg.Address <= end && reg.Address <= rangeEnd && int(reg.Address)+int(words)-int(last.StartAddress) <= int(maxBlockLength(last.StartAddress)) {
+				last.Length = max(last.Length, reg.Address+words-last.StartAddress)
+				last.Registers = append(last.Registers, reg)
+				continue
+			}
+		}
+		blocks = append(blocks, RegisterBlock{StartAddress: reg.Address, Length: words, Registers: []RegisterConfig{reg}})
+	}
+	return blocks
+}
+
+// issueMessages joins issues as "path: message; ..."
+func issueMessages(issues []ConfigIssue) string {
+	messages := make([]string, len(issues))
+	for i, issue := range issues {
+		messages[i] = fmt.Sprintf("%s: %s", issue.Path, issue.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// handleRegisterMap serves the register map of a server in the interchange
+// format on GET /api/servers/{id}/registermap, and replaces the server's
+// register blocks with those of an uploaded map on PUT or POST
+func handleRegisterMap(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		server.mu.Lock()
+		m := exportRegisterMap(server.RegisterBlocks)
+		server.mu.Unlock()
+
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error encoding register map: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-registermap.json"`, unsafeFileChars.ReplaceAllString(id, "_")))
+		w.Write(append(data, '\n'))
+
+	case http.MethodPut, http.MethodPost:
+		data, err := readUploadBody(r, "map")
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		m, err := parseRegisterMap(data)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid register map: %v", err))
+			return
+		}
+		blocks, errs, warnings := importRegisterMap(m)
+		if warnings == nil {
+			warnings = []ConfigIssue{}
+		}
+		if len(errs) > 0 {
+			handleError(w, r, fmt.Sprintf("Invalid register map: %s", issueMessages(errs)))
+			return
+		}
+		if len(blocks) == 0 {
+			handleError(w, r, "Invalid register map: no registers")
+			return
+		}
+
+		server.mu.Lock()
+		server.RegisterBlocks = blocks
+		server.registerMap = buildRegisterMap(server.RegisterBlocks)
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Imported a register map of %d registers in %d blocks to server %s", len(m.Registers), len(blocks), id)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"registers": len(m.Registers),
+			"blocks":    len(blocks),
+			"warnings":  warnings,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRegisterMapSchema serves the JSON Schema of the register map
+// interchange format on GET /api/registermap/schema
+func handleRegisterMapSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(registerMapSchema)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// tableRender is a rendering of the register table of a server in progress,
+// which requests for the same table wait for instead of rendering it again
+type tableRender struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+// tableRenders holds the renderings in progress by key
+var tableRenders = struct {
+	sync.Mutex
+	inFlight map[string]*tableRender
+}{inFlight: make(map[string]*tableRender)}
+
+// sharedRenders counts the requests served from another request's rendering
+var sharedRenders atomic.Uint64
+
+// renderKey identifies the requests that render the same register table:
+// the server, the query (sort order and time) and the language
+func renderKey(r *http.Request, id string) string {
+	return id + "\x00" + r.URL.RawQuery + "\x00" + requestLanguage(r)
+}
+
+// renderShared renders the register table of a request once for all requests
+// with the same key that arrive while it is rendered, so several browsers
+// refreshing the same server take its lock and decode its registers once per
+// refresh rather than once each. Renderings are not kept once done, so
+// every refresh shows the latest values.
+func renderShared(key string, render func(b *bytes.Buffer) error) ([]byte, error) {
+	tableRenders.Lock()
+	if call, ok := tableRenders.inFlight[key]; ok {
+		tableRenders.Unlock()
+		<-call.done
+		sharedRenders.Add(1)
+		return call.body, call.err
+	}
+	// Waiters get an error rather than an empty table if render panics
+	call := &tableRender{done: make(chan struct{}), err: errors.New("Error rendering the register table")}
+	tableRenders.inFlight[key] = call
+	tableRenders.Unlock()
+
+	defer func() {
+		tableRenders.Lock()
+		delete(tableRenders.inFlight, key)
+		tableRenders.Unlock()
+		close(call.done)
+	}()
+	var b bytes.Buffer
+	call.err = render(&b)
+	call.body = b.Bytes()
+	return call.body, call.err
+}
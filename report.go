@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportSampleInterval is how often register values are sampled for report statistics
+const reportSampleInterval = time.Second
+
+// reportTemplate renders a standalone HTML snapshot report
+const reportTemplate = `
+{{define "report"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Modbus Browser Report {{.End.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 0.9rem; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #dee2e6; padding: 2px 6px; text-align: left; }
+th { background-color: #f8f9fa; }
+.error { color: #dc3545; }
+</style>
+</head>
+<body>
+<h1>Modbus Browser Report</h1>
+<p>Period: {{.Start.Format "2006-01-02 15:04:05"}} to {{.End.Format "2006-01-02 15:04:05"}} | Alarms: {{len .Alarms}}, {{.ActiveAlarms}} active</p>
+{{range .Servers}}
+<h2>Server: {{.ID}}</h2>
+<p>IP: {{.Address}} | Port: {{.Port}} | Status: {{if eq .ConnectionStatus "ok"}}ok{{else}}<span class="error">{{.ConnectionStatus}} {{.ConnectionError}}</span>{{end}} | Last Data Received: {{.LastDataReceived.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<thead><tr><th>Address</th><th>Name</th><th>Value</th><th>Min</th><th>Max</th><th>Avg</th><th>Samples</th></tr></thead>
+<tbody>
+{{range .Registers}}<tr><td>{{.Address}}</td><td>{{.Name}}</td><td>{{.Last}}</td>{{if .Count}}<td>{{printf "%g" .Min}}</td><td>{{printf "%g" .Max}}</td><td>{{printf "%g" .Avg}}</td>{{else}}<td>-</td><td>-</td><td>-</td>{{end}}<td>{{.Count}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{end}}
+<h2>Alarms</h2>
+{{if .Alarms}}<table>
+<thead><tr><th>Server</th><th>Register</th><th>Limit</th><th>Value</th><th>Raised</th><th>Cleared</th></tr></thead>
+<tbody>
+{{range .Alarms}}<tr><td>{{.ServerID}}</td><td>{{.Name}} ({{.Address}})</td><td>{{.Limit}}</td><td>{{printf "%g" .Value}}</td><td>{{.Raised.Format "2006-01-02 15:04:05"}}</td><td>{{if .Cleared}}{{.Cleared.Format "2006-01-02 15:04:05"}}{{else}}<span class="error">active</span>{{end}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}<p>No alarms in this period.</p>
+{{end}}
+</body>
+</html>
+{{end}}`
+
+// registerStats accumulates the numeric values of a register over a report period
+type registerStats struct {
+	Address uint16
+	Name    string
+	Last    interface{}
+	Min     float64
+	Max     float64
+	Sum     float64
+	Count   int
+}
+
+// Avg returns the mean of the sampled values
+func (s *registerStats) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// serverReport is the per-server section of a report
+type serverReport struct {
+	ID               string
+	Address          string
+	Port             int
+	ConnectionStatus string
+	ConnectionError  string
+	LastDataReceived time.Time
+	Registers        []*registerStats
+}
+
+// reportData is the content of a report, rendered as HTML or PDF
+type reportData struct {
+	Start   time.Time
+	End     time.Time
+	Servers []serverReport
+	Alarms  []Alarm // raised during the period or active at any time in it
+}
+
+// ActiveAlarms returns the number of alarms still active at the end of the period
+func (d reportData) ActiveAlarms() int {
+	n := 0
+	for _, alarm := range d.Alarms {
+		if alarm.Cleared == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// reportFormats are the formats a report can be rendered in, with their
+// content types
+var reportFormats = map[string]string{
+	"html": "text/html; charset=utf-8",
+	"pdf":  "application/pdf",
+}
+
+// parseReportFormats parses a comma-separated list of report formats
+func parseReportFormats(s string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(s, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if _, ok := reportFormats[format]; !ok {
+			return nil, fmt.Errorf("unknown report format %q (supported: html, pdf)", format)
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// reportSelection is the registers a report covers, by server ID: nil for
+// all configured registers of the server, or the addresses and names of the
+// registers. A nil selection covers all servers.
+type reportSelection map[string][]string
+
+// parseReportSelection parses a comma-separated list of servers and
+// registers, given as "server", "server:address" or "server:name"
+func parseReportSelection(s string) (reportSelection, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	selection := make(reportSelection)
+	for _, entry := range strings.Split(s, ",") {
+		id, register, found := strings.Cut(strings.TrimSpace(entry), ":")
+		register = strings.TrimSpace(register)
+		switch {
+		case id == "":
+			return nil, fmt.Errorf("server id is required in %q", entry)
+		case found && register == "":
+			return nil, fmt.Errorf("register is required in %q (must be server, server:address or server:name)", entry)
+		case !found:
+			selection[id] = nil
+		default:
+			if registers, ok := selection[id]; !ok || registers != nil {
+				selection[id] = append(registers, register)
+			}
+		}
+	}
+	return selection, nil
+}
+
+// includesServer reports whether a report covers any register of a server
+func (selection reportSelection) includesServer(id string) bool {
+	_, ok := selection[id]
+	return selection == nil || ok
+}
+
+// includes reports whether a report covers a register of a server
+func (selection reportSelection) includes(id string, address uint16, name string) bool {
+	if selection == nil {
+		return true
+	}
+	registers, ok := selection[id]
+	if !ok {
+		return false
+	}
+	if registers == nil {
+		return true
+	}
+	for _, register := range registers {
+		if register == name {
+			return true
+		}
+		if addr, err := strconv.ParseUint(register, 10, 16); err == nil && uint16(addr) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// reportCollector samples configured registers of the selected servers over
+// a report period
+type reportCollector struct {
+	mu        sync.Mutex
+	start     time.Time
+	selection reportSelection
+	stats     map[string]map[uint16]*registerStats
+}
+
+func newReportCollector(selection reportSelection) *reportCollector {
+	return &reportCollector{
+		start:     time.Now(),
+		selection: selection,
+		stats:     make(map[string]map[uint16]*registerStats),
+	}
+}
+
+var (
+	// collector is the running report collector, nil when scheduled reports are disabled
+	collector *reportCollector
+	// reportRegisters is the registers reports cover (-report-registers), nil for all
+	reportRegisters reportSelection
+)
+
+// sample records the current value of every configured register
+func (c *reportCollector) sample() {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, server := range serverList {
+		if !c.selection.includesServer(server.ID) {
+			continue
+		}
+		server.mu.Lock()
+		stats, ok := c.stats[server.ID]
+		if !ok {
+			stats = make(map[uint16]*registerStats)
+			c.stats[server.ID] = stats
+		}
+		for _, row := range server.registerData() {
+			addr := row["Address"].(uint16)
+			if _, configured := server.registerMap[addr]; !configured || !c.selection.includes(server.ID, addr, row["Name"].(string)) {
+				continue
+			}
+			stat, ok := stats[addr]
+			if !ok {
+				stat = &registerStats{Address: addr}
+				stats[addr] = stat
+			}
+			stat.Name = row["Name"].(string)
+			stat.Last = row["Value"]
+
+			// A float register holding NaN or an infinity, such as a sensor
+			// reporting a fault, would make the statistics meaningless
+			value, numeric := toFloat(row["Value"])
+			if !numeric || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			if stat.Count == 0 || value < stat.Min {
+				stat.Min = value
+			}
+			if stat.Count == 0 || value > stat.Max {
+				stat.Max = value
+			}
+			stat.Sum += value
+			stat.Count++
+		}
+		server.mu.Unlock()
+	}
+}
+
+// data returns the report for the period so far
+func (c *reportCollector) data() reportData {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	sort.Slice(serverList, func(i, j int) bool {
+		return serverList[i].ID < serverList[j].ID
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reports := make([]serverReport, 0, len(serverList))
+	for _, server := range serverList {
+		if !c.selection.includesServer(server.ID) {
+			continue
+		}
+		server.mu.Lock()
+		report := serverReport{
+			ID:               server.ID,
+			Address:          server.Address,
+			Port:             server.Port,
+			ConnectionStatus: server.ConnectionStatus,
+			ConnectionError:  server.ConnectionError,
+			LastDataReceived: server.LastDataReceived,
+		}
+		server.mu.Unlock()
+
+		for _, stat := range c.stats[server.ID] {
+			report.Registers = append(report.Registers, stat)
+		}
+		sort.Slice(report.Registers, func(i, j int) bool {
+			return report.Registers[i].Address < report.Registers[j].Address
+		})
+		reports = append(reports, report)
+	}
+
+	var alarms []Alarm
+	for _, alarm := range alarmsSince(c.start) {
+		if c.selection.includes(alarm.ServerID, alarm.Address, alarm.Name) {
+			alarms = append(alarms, alarm)
+		}
+	}
+	return reportData{Start: c.start, End: time.Now(), Servers: reports, Alarms: alarms}
+}
+
+// renderReport writes a report in the given format, "html" or "pdf"
+func renderReport(w io.Writer, format string, data reportData) error {
+	if format == "pdf" {
+		return writeReportPDF(w, data)
+	}
+	return templates.ExecuteTemplate(w, "report", data)
+}
+
+// writeReportPDF lays a report out on landscape A4 pages, with the same
+// sections as the HTML report
+func writeReportPDF(w io.Writer, data reportData) error {
+	const (
+		margin = 40
+		row    = 12
+	)
+	doc := newPDFDocument(pdfA4LandscapeWidth, pdfA4LandscapeHeight, "Modbus Browser Report "+data.End.Format("2006-01-02 15:04"))
+	doc.addPage()
+	y := float64(pdfA4LandscapeHeight - margin)
+
+	// table draws a header row and rows at the given column positions,
+	// starting a new page, with the header repeated, when one is full
+	table := func(columns []float64, header []string, rows [][]string) {
+		drawHeader := func() {
+			for i, title := range header {
+				doc.text(columns[i], y, 9, pdfHelveticaBold, title)
+			}
+			doc.line(margin, y-3, pdfA4LandscapeWidth-margin, y-3)
+			y -= row + 2
+		}
+		if y-2*row < margin {
+			doc.addPage()
+			y = pdfA4LandscapeHeight - margin
+		}
+		drawHeader()
+		for _, cells := range rows {
+			if y-row < margin {
+				doc.addPage()
+				y = pdfA4LandscapeHeight - margin
+				drawHeader()
+			}
+			for i, cell := range cells {
+				doc.text(columns[i], y, 9, pdfHelvetica, cell)
+			}
+			y -= row
+		}
+		y -= row
+	}
+	heading := func(size float64, s string) {
+		if y-size-3*row < margin {
+			doc.addPage()
+			y = pdfA4LandscapeHeight - margin
+		}
+		doc.text(margin, y, size, pdfHelveticaBold, s)
+		y -= size + 6
+	}
+	paragraph := func(s string) {
+		doc.text(margin, y, 9, pdfHelvetica, s)
+		y -= row + 4
+	}
+
+	heading(16, "Modbus Browser Report")
+	paragraph(fmt.Sprintf("Period: %s to %s | Alarms: %d, %d active",
+		data.Start.Format("2006-01-02 15:04:05"), data.End.Format("2006-01-02 15:04:05"), len(data.Alarms), data.ActiveAlarms()))
+
+	registerColumns := []float64{margin, 100, 360, 480, 560, 640, 720}
+	for _, server := range data.Servers {
+		heading(12, "Server: "+server.ID)
+		status := server.ConnectionStatus
+		if status != "ok" {
+			status = strings.TrimSpace(status + " " + server.ConnectionError)
+		}
+		paragraph(pdfTruncate(fmt.Sprintf("IP: %s | Port: %d | Status: %s | Last Data Received: %s",
+			server.Address, server.Port, status, server.LastDataReceived.Format("2006-01-02 15:04:05")), 150))
+
+		var rows [][]string
+		for _, stat := range server.Registers {
+			minimum, maximum, avg := "-", "-", "-"
+			if stat.Count > 0 {
+				minimum, maximum, avg = fmt.Sprintf("%g", stat.Min), fmt.Sprintf("%g", stat.Max), fmt.Sprintf("%g", stat.Avg())
+			}
+			rows = append(rows, []string{fmt.Sprint(stat.Address), pdfTruncate(stat.Name, 45),
+				pdfTruncate(fmt.Sprint(stat.Last), 20), minimum, maximum, avg, fmt.Sprint(stat.Count)})
+		}
+		table(registerColumns, []string{"Address", "Name", "Value", "Min", "Max", "Avg", "Samples"}, rows)
+	}
+
+	heading(12, "Alarms")
+	if len(data.Alarms) == 0 {
+		paragraph("No alarms in this period.")
+	} else {
+		var rows [][]string
+		for _, alarm := range data.Alarms {
+			cleared := "active"
+			if alarm.Cleared != nil {
+				cleared = alarm.Cleared.Format("2006-01-02 15:04:05")
+			}
+			rows = append(rows, []string{pdfTruncate(alarm.ServerID, 20), pdfTruncate(fmt.Sprintf("%s (%d)", alarm.Name, alarm.Address), 40),
+				alarm.Limit, fmt.Sprintf("%g", alarm.Value), alarm.Raised.Format("2006-01-02 15:04:05"), cleared})
+		}
+		table([]float64{margin, 160, 400, 450, 540, 660}, []string{"Server", "Register", "Limit", "Value", "Raised", "Cleared"}, rows)
+	}
+
+	return doc.write(w)
+}
+
+// reportSchedule is where scheduled reports go: a directory, email
+// recipients or both
+type reportSchedule struct {
+	dir      string  // "" to not store reports
+	mail     *mailer // nil to not email reports
+	formats  []string
+	interval time.Duration
+}
+
+// runReports samples register values and, every interval, stores and emails
+// a report of the period in each of the formats
+func runReports(schedule reportSchedule) {
+	current := newReportCollector(reportRegisters)
+	mu.Lock()
+	collector = current
+	mu.Unlock()
+
+	sampleTicker := time.NewTicker(reportSampleInterval)
+	defer sampleTicker.Stop()
+	reportTicker := time.NewTicker(schedule.interval)
+	defer reportTicker.Stop()
+
+	for {
+		select {
+		case <-sampleTicker.C:
+			current.sample()
+		case <-reportTicker.C:
+			schedule.deliver(current.data())
+
+			// Start a new report period
+			current = newReportCollector(reportRegisters)
+			mu.Lock()
+			collector = current
+			mu.Unlock()
+		}
+	}
+}
+
+// deliver renders a report and writes it to the directory and emails it
+func (schedule reportSchedule) deliver(data reportData) {
+	var attachments []mailAttachment
+	for _, format := range schedule.formats {
+		var buf bytes.Buffer
+		if err := renderReport(&buf, format, data); err != nil {
+			logMessage(ErrorLevel, "Error rendering %s report: %v", format, err)
+			continue
+		}
+		name := fmt.Sprintf("report-%s.%s", data.End.Format("20060102-150405"), format)
+		attachments = append(attachments, mailAttachment{name: name, contentType: reportFormats[format], data: buf.Bytes()})
+
+		if schedule.dir != "" {
+			path := filepath.Join(schedule.dir, name)
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				logMessage(ErrorLevel, "Error writing report: %v", err)
+			} else {
+				logMessage(InfoLevel, "Wrote report %s", path)
+			}
+		}
+	}
+
+	if schedule.mail != nil && len(attachments) > 0 {
+		subject := fmt.Sprintf("Modbus Browser report %s to %s", data.Start.Format("2006-01-02 15:04"), data.End.Format("2006-01-02 15:04"))
+		body := fmt.Sprintf("Report of %d servers for %s to %s.\nAlarms: %d, %d still active.\n",
+			len(data.Servers), data.Start.Format("2006-01-02 15:04:05"), data.End.Format("2006-01-02 15:04:05"), len(data.Alarms), data.ActiveAlarms())
+		if err := schedule.mail.send(subject, body, attachments); err != nil {
+			logMessage(ErrorLevel, "Error emailing report: %v", err)
+		} else {
+			logMessage(InfoLevel, "Emailed report to %s", strings.Join(schedule.mail.to, ", "))
+		}
+	}
+}
+
+// handleReport serves the report for the current period, or a snapshot of
+// current values when scheduled reports are disabled, as HTML or with
+// ?format=pdf as PDF
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "html"
+	}
+	contentType, ok := reportFormats[format]
+	if !ok {
+		handleError(w, r, fmt.Sprintf("Unknown report format %q (supported: html, pdf)", format))
+		return
+	}
+
+	mu.RLock()
+	current := collector
+	mu.RUnlock()
+
+	if current == nil {
+		current = newReportCollector(reportRegisters)
+		current.sample()
+	}
+
+	data := current.data()
+	var buf bytes.Buffer
+	if err := renderReport(&buf, format, data); err != nil {
+		handleError(w, r, fmt.Sprintf("Error rendering report: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if format == "pdf" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"report-%s.pdf\"", data.End.Format("20060102-150405")))
+	}
+	w.Write(buf.Bytes())
+}
+
+// toFloat converts a decoded register value to a float64 for statistics
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case uint16:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReport returns a report with many registers and a cleared and an
+// active alarm
+func testReport() reportData {
+	start := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	cleared := start.Add(2 * time.Hour)
+	data := reportData{
+		Start: start,
+		End:   start.Add(8 * time.Hour),
+		Alarms: []Alarm{
+			{ServerID: "plc-1", Address: 30001, Name: "Tank level", Limit: "high", Value: 98.5, Raised: start.Add(time.Hour), Cleared: &cleared},
+			{ServerID: "plc-2", Address: 30010, Name: "Pressure (bar)", Limit: "low", Value: 0.2, Raised: start.Add(3 * time.Hour)},
+		},
+	}
+	for _, id := range []string{"plc-1", "plc-2"} {
+		server := serverReport{ID: id, Address: "10.0.0.1", Port: 502, ConnectionStatus: "ok", LastDataReceived: data.End}
+		for i := 0; i < 40; i++ {
+			server.Registers = append(server.Registers, &registerStats{
+				Address: uint16(30000 + i), Name: fmt.Sprintf("Register %d", i), Last: uint16(i),
+				Min: 1, Max: float64(i), Sum: float64(i * 10), Count: 10,
+			})
+		}
+		server.Registers = append(server.Registers, &registerStats{Address: 40000, Name: "Mode", Last: "Auto"})
+		data.Servers = append(data.Servers, server)
+	}
+	return data
+}
+
+func TestReportPDF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderReport(&buf, "pdf", testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if pages := checkPDF(t, buf.Bytes()); pages < 2 {
+		t.Errorf("%d pages, want the 80 registers to need more than one", pages)
+	}
+	for _, want := range []string{
+		"(Modbus Browser Report)",
+		"(Period: 2024-03-01 06:00:00 to 2024-03-01 14:00:00 | Alarms: 2, 1 active)",
+		"(Server: plc-2)",
+		"(Register 39)",
+		"(Pressure \\(bar\\) \\(30010\\))",
+		"(2024-03-01 08:00:00)",
+		"(active)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PDF is missing %s", want)
+		}
+	}
+	// Every page with registers of a server starts with the table header
+	if got, want := strings.Count(buf.String(), "(Samples)"), strings.Count(buf.String(), "/Type /Page /Parent")-1; got < want {
+		t.Errorf("register table header drawn %d times, want at least %d", got, want)
+	}
+}
+
+func TestReportHTMLAlarms(t *testing.T) {
+	saved := templates
+	defer func() { templates = saved }()
+	templates = template.Must(template.New("").Parse(reportTemplate))
+
+	var buf bytes.Buffer
+	if err := renderReport(&buf, "html", testReport()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Alarms: 2, 1 active",
+		"<td>Tank level (30001)</td><td>high</td><td>98.5</td><td>2024-03-01 07:00:00</td><td>2024-03-01 08:00:00</td>",
+		`<td>Pressure (bar) (30010)</td><td>low</td><td>0.2</td><td>2024-03-01 09:00:00</td><td><span class="error">active</span></td>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("HTML report is missing %s", want)
+		}
+	}
+
+	buf.Reset()
+	data := testReport()
+	data.Alarms = nil
+	if err := renderReport(&buf, "html", data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "No alarms in this period.") {
+		t.Errorf("HTML report without alarms does not say so")
+	}
+}
+
+func TestParseReportFormats(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"html", "html", true},
+		{"pdf", "pdf", true},
+		{"html, PDF", "html,pdf", true},
+		{"pdf,pdf", "pdf", true},
+		{"docx", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		formats, err := parseReportFormats(tt.s)
+		if (err == nil) != tt.ok || strings.Join(formats, ",") != tt.want {
+			t.Errorf("parseReportFormats(%q) = %v, %v", tt.s, formats, err)
+		}
+	}
+}
+
+func TestParseReportSelection(t *testing.T) {
+	selection, err := parseReportSelection("plc-1, plc-2:40001,plc-2:Tank level,plc-3:40001,plc-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id      string
+		address uint16
+		name    string
+		want    bool
+	}{
+		{"plc-1", 40010, "Speed", true},
+		{"plc-2", 40001, "", true},
+		{"plc-2", 40002, "Tank level", true},
+		{"plc-2", 40003, "Tank", false},
+		{"plc-3", 40005, "", true}, // the whole server wins over a register of it
+		{"plc-4", 40001, "", false},
+	}
+	for _, tt := range tests {
+		if got := selection.includes(tt.id, tt.address, tt.name); got != tt.want {
+			t.Errorf("includes(%s, %d, %q) = %v", tt.id, tt.address, tt.name, got)
+		}
+	}
+	if selection.includesServer("plc-4") {
+		t.Error("plc-4 is included")
+	}
+
+	if selection, err := parseReportSelection(""); err != nil || selection != nil || !selection.includes("any", 1, "") {
+		t.Errorf("empty selection = %v, %v", selection, err)
+	}
+	for _, s := range []string{":40001", "plc-1:", "plc-1,,plc-2"} {
+		if _, err := parseReportSelection(s); err == nil {
+			t.Errorf("parseReportSelection(%q) succeeded", s)
+		}
+	}
+}
+
+func TestReportSample(t *testing.T) {
+	blocks := []RegisterBlock{{StartAddress: 40001, Length: 3, Registers: []RegisterConfig{
+		{Address: 40001, Name: "Flow", Format: "float"},
+		{Address: 40003, Name: "Mode"},
+	}}}
+	for _, id := range []string{"rep-a", "rep-b"} {
+		server := &ModbusServer{ID: id, RegisterBlocks: blocks, registerMap: buildRegisterMap(blocks)}
+		mu.Lock()
+		servers[id] = server
+		mu.Unlock()
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(servers, "rep-a")
+		delete(servers, "rep-b")
+		mu.Unlock()
+	})
+	mu.RLock()
+	server := servers["rep-a"]
+	mu.RUnlock()
+
+	c := newReportCollector(reportSelection{"rep-a": {"Flow"}})
+	// 1.5, NaN, +Inf and -2.5 as big-endian floats
+	for _, words := range [][2]uint16{{0x3FC0, 0}, {0x7FC0, 0}, {0x7F80, 0}, {0xC020, 0}} {
+		server.mu.Lock()
+		server.dataModel.HoldingRegisters[1], server.dataModel.HoldingRegisters[2] = words[0], words[1]
+		server.mu.Unlock()
+		c.sample()
+	}
+
+	data := c.data()
+	if len(data.Servers) != 1 || data.Servers[0].ID != "rep-a" {
+		t.Fatalf("servers = %+v", data.Servers)
+	}
+	registers := data.Servers[0].Registers
+	if len(registers) != 1 || registers[0].Name != "Flow" {
+		t.Fatalf("registers = %+v", registers)
+	}
+	if stat := registers[0]; stat.Count != 2 || stat.Min != -2.5 || stat.Max != 1.5 || stat.Avg() != -0.5 {
+		t.Errorf("stats = %+v, want 2 samples from -2.5 to 1.5", stat)
+	}
+}
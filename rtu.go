@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rustyoz/modbus"
+)
+
+// RTU framing: slave ID, function code, data and a 2 byte CRC, at most 256 bytes
+const (
+	rtuHeaderSize = 3 // slave ID, function code and byte count or exception code
+	rtuCRCSize    = 2
+	maxRTUADU     = 256
+)
+
+func init() {
+	registerProtocol("modbus-rtu-over-tcp", func(address string, port int, options DialOptions) (Device, error) {
+		client, err := NewRTUOverTCPClient(address, port, options.Source, options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+// NewRTUOverTCPClient creates a Modbus client for serial to Ethernet
+// converters that pass RTU frames through a plain TCP stream instead of
+// translating them to Modbus TCP
+func NewRTUOverTCPClient(address string, port int, source string, timeout time.Duration) (*ModbusClient, error) {
+	handler := &rtuOverTCPHandler{RTUClientHandler: modbus.NewRTUClientHandler("")}
+	handler.Address = net.JoinHostPort(address, strconv.Itoa(port))
+	handler.Timeout = timeout
+	handler.SlaveId = defaultUnitID
+	handler.dialer.Timeout = timeout
+	if source != "" {
+		local, err := sourceAddr(source, address)
+		if err != nil {
+			return nil, err
+		}
+		handler.dialer.LocalAddr = local
+	}
+	return newModbusClient(handler, handler.Address)
+}
+
+// rtuOverTCPHandler sends RTU frames over a TCP connection. It uses the
+// library's RTU framing and checksum with a connection of its own, as the
+// library only sends RTU frames over serial ports.
+type rtuOverTCPHandler struct {
+	*modbus.RTUClientHandler
+	dialer net.Dialer
+	mu     sync.Mutex
+	conn   net.Conn
+}
+
+// Connect opens the connection if it is not open
+func (h *rtuOverTCPHandler) Connect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connect()
+}
+
+// connect opens the connection if it is not open. The caller must hold h.mu.
+func (h *rtuOverTCPHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := h.dialer.Dial("tcp", h.Address)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+// Close closes the connection; the next request opens a new one
+func (h *rtuOverTCPHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send sends a request and reads the response. RTU frames carry no length
+// or transaction ID, so after a failed exchange the connection is closed
+// rather than risk reading a late response as the answer to the next request.
+func (h *rtuOverTCPHandler) Send(request []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	response, err := h.exchange(request)
+	if err != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+	return response, err
+}
+
+// exchange writes a request and reads one response frame, whose length
+// follows from its function code and byte count. The caller must hold h.mu.
+func (h *rtuOverTCPHandler) exchange(request []byte) ([]byte, error) {
+	var deadline time.Time
+	if h.Timeout > 0 {
+		deadline = time.Now().Add(h.Timeout)
+	}
+	if err := h.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := h.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var data [maxRTUADU]byte
+	if _, err := io.ReadFull(h.conn, data[:rtuHeaderSize]); err != nil {
+		return nil, err
+	}
+	var length int
+	switch function := data[1]; {
+	case function&0x80 != 0: // exception code instead of a byte count
+		length = rtuHeaderSize + rtuCRCSize
+	case function <= modbus.FuncCodeReadInputRegisters || function == modbus.FuncCodeReadWriteMultipleRegisters:
+		length = rtuHeaderSize + int(data[2]) + rtuCRCSize
+	case function == modbus.FuncCodeWriteSingleCoil || function == modbus.FuncCodeWriteSingleRegister ||
+		function == modbus.FuncCodeWriteMultipleCoils || function == modbus.FuncCodeWriteMultipleRegisters:
+		length = 8 // echo of the address and value or quantity
+	default:
+		return nil, fmt.Errorf("modbus: response function code '%v' is not supported", function)
+	}
+	if length > maxRTUADU {
+		return nil, fmt.Errorf("modbus: length in response '%v' must not be greater than '%v'", length, maxRTUADU)
+	}
+	if _, err := io.ReadFull(h.conn, data[rtuHeaderSize:length]); err != nil {
+		return nil, err
+	}
+	return data[:length], nil
+}
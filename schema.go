@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema checks documents against a JSON Schema (draft 2020-12). Only
+// the keywords the schemas of this repository use are supported: $ref to
+// #/$defs, type, enum, const, properties, required, additionalProperties,
+// propertyNames, items, minimum, maximum, minLength and pattern. Annotations
+// such as description are ignored.
+type jsonSchema struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// schemaIssue is a place where a document does not match its schema
+type schemaIssue struct {
+	Path    string
+	Message string
+	Unknown bool // a property the schema does not allow
+}
+
+// schemaKeywords are the keywords jsonSchema understands or ignores
+var schemaKeywords = map[string]bool{
+	"$schema": true, "$defs": true, "$ref": true, "title": true, "description": true,
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true, "propertyNames": true,
+	"items": true, "minimum": true, "maximum": true, "minLength": true, "pattern": true,
+}
+
+// parseJSONSchema parses a schema, returning an error if it uses keywords
+// that are not supported, as they would silently be ignored
+func parseJSONSchema(data []byte) (*jsonSchema, error) {
+	var root map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+	s := &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compile(root, "#"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile checks the keywords of a schema and its subschemas and compiles
+// their patterns
+func (s *jsonSchema) compile(node interface{}, path string) error {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		if _, isBool := node.(bool); isBool {
+			return nil
+		}
+		return fmt.Errorf("%s: schema must be an object or a boolean", path)
+	}
+	for key, value := range schema {
+		if !schemaKeywords[key] {
+			return fmt.Errorf("%s: unsupported keyword %q", path, key)
+		}
+		var err error
+		switch key {
+		case "$defs", "properties":
+			for name, sub := range value.(map[string]interface{}) {
+				if err = s.compile(sub, path+"/"+key+"/"+name); err != nil {
+					break
+				}
+			}
+		case "additionalProperties", "propertyNames", "items":
+			err = s.compile(value, path+"/"+key)
+		case "$ref":
+			if _, err = s.resolve(value.(string)); err != nil {
+				err = fmt.Errorf("%s: %v", path, err)
+			}
+		case "pattern":
+			pattern := value.(string)
+			s.patterns[pattern], err = regexp.Compile(pattern)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the definition a $ref of the form #/$defs/name points to
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	defs, _ := s.root["$defs"].(map[string]interface{})
+	def, ok := defs[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined $ref %q", ref)
+	}
+	return def, nil
+}
+
+// validate checks a document decoded with UseNumber and returns its issues
+// in document order, the properties of an object by name
+func (s *jsonSchema) validate(doc interface{}) []schemaIssue {
+	var issues []schemaIssue
+	s.check(s.root, doc, "", &issues)
+	return issues
+}
+
+func (s *jsonSchema) check(node interface{}, value interface{}, path string, issues *[]schemaIssue) {
+	fail := func(format string, args ...interface{}) {
+		*issues = append(*issues, schemaIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		if node == false {
+			fail("no value is allowed here")
+		}
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := s.resolve(ref)
+		s.check(def, value, path, issues)
+	}
+	if types, ok := schema["type"]; ok && !schemaTypeMatches(types, value) {
+		fail("expected %s but found %s", schemaTypeList(types), jsonTypeOf(value))
+		return
+	}
+	if values, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		options := make([]string, 0, len(values))
+		for _, v := range values {
+			found = found || jsonEqual(v, value)
+			text, _ := json.Marshal(v)
+			options = append(options, string(text))
+		}
+		if !found {
+			text, _ := json.Marshal(value)
+			fail("%s is not allowed (must be one of %s)", text, strings.Join(options, ", "))
+			return
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		text, _ := json.Marshal(constant)
+		fail("must be %s", text)
+		return
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		if minimum, ok := schema["minimum"].(json.Number); ok {
+			if limit, _ := minimum.Float64(); f < limit {
+				fail("%s must be at least %s", v, minimum)
+			}
+		}
+		if maximum, ok := schema["maximum"].(json.Number); ok {
+			if limit, _ := maximum.Float64(); f > limit {
+				fail("%s must be at most %s", v, maximum)
+			}
+		}
+
+	case string:
+		if minLength, ok := schema["minLength"].(json.Number); ok {
+			if n, _ := minLength.Int64(); int64(utf8.RuneCountInString(v)) < n {
+				if n == 1 {
+					fail("must not be empty")
+				} else {
+					fail("must be at least %d characters long", n)
+				}
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			fail("%q does not match %s", v, pattern)
+		}
+
+	case []interface{}:
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				s.check(items, item, fmt.Sprintf("%s[%d]", path, i), issues)
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := v[name.(string)]; !present {
+					fail("%s is required", name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := name
+			if path != "" {
+				childPath = path + "." + name
+			}
+			if propertyNames, ok := schema["propertyNames"]; ok {
+				s.check(propertyNames, name, childPath, issues)
+			}
+			if property, ok := properties[name]; ok {
+				s.check(property, v[name], childPath, issues)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*issues = append(*issues, schemaIssue{Path: childPath, Message: fmt.Sprintf("unknown field %q is ignored", name), Unknown: true})
+				}
+			case map[string]interface{}:
+				s.check(additional, v[name], childPath, issues)
+			}
+		}
+	}
+}
+
+// jsonTypeOf returns the JSON Schema type of a value decoded with UseNumber.
+// Numbers without a fraction or exponent are integers, as Go decodes only
+// those into integer fields.
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// schemaTypeMatches reports whether a value has one of the types of a
+// schema's type keyword, a name or a list of names
+func schemaTypeMatches(types interface{}, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	matches := func(t interface{}) bool {
+		return t == actual || (t == "number" && actual == "integer")
+	}
+	if list, ok := types.([]interface{}); ok {
+		for _, t := range list {
+			if matches(t) {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(types)
+}
+
+// schemaTypeList describes a type keyword, e.g. "array or null"
+func schemaTypeList(types interface{}) string {
+	list, ok := types.([]interface{})
+	if !ok {
+		return fmt.Sprint(types)
+	}
+	names := make([]string, len(list))
+	for i, t := range list {
+		names[i] = fmt.Sprint(t)
+	}
+	return strings.Join(names, " or ")
+}
+
+// jsonEqual compares two values decoded with UseNumber, numbers by value
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := a.(json.Number); ok {
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		return fx == fy
+	}
+	switch a.(type) {
+	case nil, bool, string:
+		return a == b
+	}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
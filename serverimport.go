@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serverImportRow is a server of an imported CSV server list, with its outcome
+type serverImportRow struct {
+	Line     int    `json:"line"`
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	PollRate int    `json:"pollRate"`
+	Template string `json:"template,omitempty"`
+	Status   string `json:"status"` // "valid", "invalid" or "added"
+	Error    string `json:"error,omitempty"`
+}
+
+// parseServerImport reads CSV lines of "id,address,port,pollRate" with an
+// optional template column and an optional header row
+func parseServerImport(data []byte) ([]*serverImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var rows []*serverImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 4 || len(record) > 5 {
+			return nil, fmt.Errorf("line %d: expected id,address,port,pollRate[,template]", line)
+		}
+		// Skip a header row
+		if len(rows) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "id") {
+			continue
+		}
+
+		row := &serverImportRow{
+			Line:    line,
+			ID:      strings.TrimSpace(record[0]),
+			Address: strings.TrimSpace(record[1]),
+			Status:  "valid",
+		}
+		if len(record) == 5 {
+			row.Template = strings.TrimSpace(record[4])
+		}
+		var errs []string
+		port, err := strconv.Atoi(strings.TrimSpace(record[2]))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid port %q", record[2]))
+		}
+		row.Port = port
+		pollRate, err := strconv.Atoi(strings.TrimSpace(record[3]))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid pollRate %q", record[3]))
+		}
+		row.PollRate = pollRate
+		if len(errs) > 0 {
+			row.Status = "invalid"
+			row.Error = strings.Join(errs, "; ")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// validateServerImport checks the rows of a server import against each other
+// and the servers and templates already loaded, marking the invalid ones
+func validateServerImport(rows []*serverImportRow) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	mapTemplatesMu.RLock()
+	defer mapTemplatesMu.RUnlock()
+
+	valid := true
+	seen := make(map[string]int)
+	for _, row := range rows {
+		var errs []string
+		if row.Error != "" {
+			errs = append(errs, row.Error)
+		}
+		switch {
+		case row.ID == "":
+			errs = append(errs, "id is required")
+		case strings.ContainsAny(row.ID, "/?#"):
+			errs = append(errs, fmt.Sprintf("id %q must not contain '/', '?' or '#'", row.ID))
+		case seen[row.ID] != 0:
+			errs = append(errs, fmt.Sprintf("duplicate server id %q (also on line %d)", row.ID, seen[row.ID]))
+		case servers[row.ID] != nil || addingServers[row.ID]:
+			errs = append(errs, fmt.Sprintf("server %q already exists", row.ID))
+		}
+		if row.ID != "" && seen[row.ID] == 0 {
+			seen[row.ID] = row.Line
+		}
+		if row.Address == "" {
+			errs = append(errs, "address is required")
+		} else if err := checkHost(row.Address); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if row.Port < 1 || row.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("port %d must be between 1 and 65535", row.Port))
+		}
+		if row.PollRate <= 0 {
+			errs = append(errs, fmt.Sprintf("pollRate %d must be greater than 0", row.PollRate))
+		}
+		if row.Template != "" && mapTemplates[row.Template] == nil {
+			errs = append(errs, fmt.Sprintf("unknown template %q", row.Template))
+		}
+
+		if len(errs) > 0 {
+			row.Status = "invalid"
+			row.Error = strings.Join(errs, "; ")
+			valid = false
+		}
+	}
+	return valid
+}
+
+// handleServerImport creates servers from a CSV server list on POST
+// /api/servers/import, uploaded as the "servers" form field or as the request
+// body. The servers are only created if every row is valid; with
+// preview=true the rows are only validated.
+func handleServerImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := readUploadBody(r, "servers")
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	rows, err := parseServerImport(data)
+	if err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid server list: %v", err))
+		return
+	}
+	if len(rows) == 0 {
+		handleError(w, r, "Server list contains no rows")
+		return
+	}
+
+	valid := validateServerImport(rows)
+	preview := r.FormValue("preview") == "true"
+	if !preview && valid {
+		// The rows were checked under a read lock; reserve their IDs under
+		// the write lock, so a server added since cannot be added twice
+		mu.Lock()
+		for _, row := range rows {
+			if servers[row.ID] != nil || addingServers[row.ID] {
+				row.Status = "invalid"
+				row.Error = fmt.Sprintf("server %q already exists", row.ID)
+				valid = false
+			}
+		}
+		if valid {
+			for _, row := range rows {
+				addingServers[row.ID] = true
+			}
+		}
+		mu.Unlock()
+	}
+	if !preview && valid {
+		// Connecting may take until the timeout of each server, so the
+		// servers are started in the background as they are at startup
+		for _, row := range rows {
+			server := &ModbusServer{
+				ID:               row.ID,
+				Address:          row.Address,
+				Port:             row.Port,
+				PollRate:         row.PollRate,
+				Template:         row.Template,
+				registerMap:      make(map[uint16]RegisterConfig),
+				dataModel:        ModbusDataModel{},
+				ConnectionStatus: "error", // default to error until connected
+			}
+			go func() {
+				startServer(server)
+				mu.Lock()
+				delete(addingServers, server.ID)
+				mu.Unlock()
+			}()
+			row.Status = "added"
+		}
+		logMessage(InfoLevel, "Imported %d servers", len(rows))
+	}
+
+	response := map[string]interface{}{
+		"success": valid,
+		"preview": preview,
+		"rows":    rows,
+	}
+	if !valid {
+		response["error"] = "Some rows are invalid; no servers were added"
+	}
+	json.NewEncoder(w).Encode(response)
+}
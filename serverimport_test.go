@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postServerImport(body string) (int, map[string]interface{}) {
+	r := httptest.NewRequest(http.MethodPost, "/api/servers/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleServerImport(w, r)
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestServerImportReserves(t *testing.T) {
+	// Nothing listens on port 1, so connecting fails fast and the servers
+	// keep retrying until they are removed
+	const list = "imp-a,127.0.0.1,1,1000\nimp-b,127.0.0.1,1,1000\n"
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(servers, "imp-a")
+		delete(servers, "imp-b")
+		mu.Unlock()
+	})
+
+	if _, response := postServerImport(list); response["success"] != true {
+		t.Fatalf("import: %v", response)
+	}
+
+	// Until the servers are started, their IDs are reserved
+	_, response := postServerImport("imp-b,127.0.0.1,1,1000\n")
+	rows, _ := response["rows"].([]interface{})
+	if response["success"] != false || len(rows) != 1 || !strings.Contains(rows[0].(map[string]interface{})["error"].(string), "already exists") {
+		t.Errorf("second import: %v", response)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.RLock()
+		started := servers["imp-a"] != nil && servers["imp-b"] != nil && len(addingServers) == 0
+		mu.RUnlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("imported servers were not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
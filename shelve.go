@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxShelveDuration bounds how long notifications can be shelved, so a
+// forgotten shelf does not silence a device for good
+const maxShelveDuration = 7 * 24 * time.Hour
+
+// Shelf suppresses the notifications of a server, or of one of its
+// registers, until it expires, e.g. while the device is under maintenance.
+// Suppressed notifications are still logged.
+type Shelf struct {
+	ServerID   string    `json:"serverId"`
+	Address    *uint16   `json:"address,omitempty"` // nil for all notifications of the server
+	Reason     string    `json:"reason,omitempty"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Suppressed int       `json:"suppressed"` // notifications not sent
+}
+
+// shelfKey identifies a shelf; address is -1 for a whole server
+type shelfKey struct {
+	server  string
+	address int
+}
+
+var (
+	shelvesMu sync.Mutex
+	shelves   = make(map[shelfKey]*Shelf)
+)
+
+// keyOf returns the key of a shelf
+func (s *Shelf) keyOf() shelfKey {
+	if s.Address == nil {
+		return shelfKey{s.ServerID, -1}
+	}
+	return shelfKey{s.ServerID, int(*s.Address)}
+}
+
+// shelved returns the shelf covering an event, if any, and counts the event
+// as suppressed. Expired shelves are removed.
+func shelved(e Event) *Shelf {
+	if e.ServerID == "" {
+		return nil
+	}
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+	keys := []shelfKey{{e.ServerID, -1}}
+	if e.Address != nil {
+		keys = append(keys, shelfKey{e.ServerID, int(*e.Address)})
+	}
+	now := time.Now()
+	for _, key := range keys {
+		shelf, ok := shelves[key]
+		if !ok {
+			continue
+		}
+		if now.After(shelf.Until) {
+			delete(shelves, key)
+			continue
+		}
+		shelf.Suppressed++
+		return shelf
+	}
+	return nil
+}
+
+// serverShelves returns the shelves of a server that have not expired,
+// whole server first
+func serverShelves(id string) []Shelf {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+	now := time.Now()
+	var list []Shelf
+	for key, shelf := range shelves {
+		switch {
+		case key.server != id:
+		case now.After(shelf.Until):
+			delete(shelves, key)
+		default:
+			list = append(list, *shelf)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].keyOf().address < list[j].keyOf().address })
+	return list
+}
+
+// Shelved describes the shelves of a server for its status line, "" if none
+func (s *ModbusServer) Shelved() string {
+	list := serverShelves(s.ID)
+	switch {
+	case len(list) == 0:
+		return ""
+	case list[0].Address == nil:
+		return "until " + list[0].Until.Format("15:04")
+	case len(list) == 1:
+		return fmt.Sprintf("register %d until %s", *list[0].Address, list[0].Until.Format("15:04"))
+	default:
+		return fmt.Sprintf("%d registers", len(list))
+	}
+}
+
+// handleShelve lists, adds or removes the shelves of a server on GET, POST
+// and DELETE /api/servers/{id}/shelve. POST takes a duration such as "2h" and
+// optionally a register address and reason; DELETE takes ?address= to
+// remove the shelf of a register rather than the whole server.
+func handleShelve(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	_, exists := servers[id]
+	mu.RUnlock()
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request struct {
+			Duration string  `json:"duration"`
+			Address  *uint16 `json:"address"`
+			Reason   string  `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 || duration > maxShelveDuration {
+			handleError(w, r, fmt.Sprintf("Invalid duration %q (must be between 0 and %s, e.g. 2h)", request.Duration, maxShelveDuration))
+			return
+		}
+		now := time.Now()
+		shelf := &Shelf{ServerID: id, Address: request.Address, Reason: request.Reason, Since: now, Until: now.Add(duration)}
+		shelvesMu.Lock()
+		shelves[shelf.keyOf()] = shelf
+		shelvesMu.Unlock()
+		logMessage(InfoLevel, "Shelved notifications of %s until %s%s", shelfName(shelf), shelf.Until.Format(time.RFC3339), reasonSuffix(shelf.Reason))
+	case http.MethodDelete:
+		key := shelfKey{id, -1}
+		if s := r.URL.Query().Get("address"); s != "" {
+			addr, err := strconv.ParseUint(s, 10, 16)
+			if err != nil {
+				handleError(w, r, fmt.Sprintf("Invalid address: %q", s))
+				return
+			}
+			key.address = int(addr)
+		}
+		shelvesMu.Lock()
+		shelf, ok := shelves[key]
+		delete(shelves, key)
+		shelvesMu.Unlock()
+		if ok {
+			logMessage(InfoLevel, "Unshelved notifications of %s (%d suppressed)", shelfName(shelf), shelf.Suppressed)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := serverShelves(id)
+	if list == nil {
+		list = []Shelf{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "shelves": list})
+}
+
+// shelfName describes what a shelf covers, for the log
+func shelfName(s *Shelf) string {
+	if s.Address == nil {
+		return "server " + s.ServerID
+	}
+	return fmt.Sprintf("server %s register %d", s.ServerID, *s.Address)
+}
+
+// reasonSuffix formats a shelf reason for the log
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GeneratorConfig makes a register of a simulated server follow a generated
+// signal. Values are scaled to the register's format: rounded to an integer
+// for decimal and hex, stored as a float32 for float and a float64 for
+// double, and on when at least half way between Min and Max for booleans,
+// coils and discrete inputs.
+type GeneratorConfig struct {
+	Type     string  `json:"type"` // "sine", "ramp", "random-walk" or "csv"
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Period   float64 `json:"period,omitempty"`   // seconds per cycle, for sine and ramp
+	Step     float64 `json:"step,omitempty"`     // largest change per read, for random-walk
+	File     string  `json:"file,omitempty"`     // CSV file whose first column is played back, for csv
+	Interval float64 `json:"interval,omitempty"` // seconds per CSV row, default 1
+}
+
+// ReactionConfig makes a simulated device respond to a write of the register
+// it belongs to, the way the real device would: starting a pump by setting
+// its coil can ramp up the flow register, for example
+type ReactionConfig struct {
+	When    string  `json:"when,omitempty"`  // condition on the written value, as in color rules; empty reacts to every write
+	Address uint16  `json:"address"`         // register or coil that changes
+	Value   float64 `json:"value"`           // value it changes to
+	Ramp    float64 `json:"ramp,omitempty"`  // seconds to move there from its current value; 0 to set it at once
+	Delay   float64 `json:"delay,omitempty"` // seconds before the change starts
+}
+
+// simulatorRamp is a reaction in progress
+type simulatorRamp struct {
+	reg        RegisterConfig
+	from, to   float64
+	start, end time.Time
+}
+
+// generatorTypes lists the supported GeneratorConfig types
+var generatorTypes = map[string]bool{
+	"sine":        true,
+	"ramp":        true,
+	"random-walk": true,
+	"csv":         true,
+}
+
+func init() {
+	registerProtocol("simulator", func(address string, port int, options DialOptions) (Device, error) {
+		return &simulatorDevice{
+			start:   time.Now(),
+			walks:   make(map[uint16]float64),
+			samples: make(map[string][]float64),
+			ramps:   make(map[uint16]*simulatorRamp),
+		}, nil
+	})
+}
+
+// serverDevice is implemented by devices that need the configuration of the
+// server they are connected for
+type serverDevice interface {
+	attach(s *ModbusServer)
+}
+
+// simulatorDevice is an in-memory device for demos and testing. Registers
+// with a generator follow it; all others keep the last value written to them
+// or set by a reaction to a write.
+// Like every Device, it is only used while the server's lock is held, so it
+// reads the server's register map directly.
+type simulatorDevice struct {
+	server  *ModbusServer
+	model   ModbusDataModel
+	start   time.Time
+	walks   map[uint16]float64   // current random-walk values by address
+	samples map[string][]float64 // loaded CSV files by path
+	ramps   map[uint16]*simulatorRamp
+}
+
+func (d *simulatorDevice) attach(s *ModbusServer) {
+	d.server = s
+}
+
+// generate updates the generated registers within [first, first+quantity),
+// then those changed by reactions, which take precedence while they last
+func (d *simulatorDevice) generate(first, quantity uint16) error {
+	if d.server == nil {
+		return nil
+	}
+	end := int(first) + int(quantity)
+	elapsed := time.Since(d.start).Seconds()
+	for addr, reg := range d.server.registerMap {
+		if reg.Generator == nil || addr < first || int(addr) >= end {
+			continue
+		}
+		value, err := d.generatorValue(addr, reg.Generator, elapsed)
+		if err != nil {
+			return fmt.Errorf("generator of address %d: %v", addr, err)
+		}
+		g := reg.Generator
+		d.store(reg, value, g.Max > g.Min && value >= g.Min+(g.Max-g.Min)/2)
+	}
+
+	now := time.Now()
+	for addr, ramp := range d.ramps {
+		if addr < first || int(addr) >= end || now.Before(ramp.start) {
+			continue
+		}
+		value := ramp.to
+		if now.Before(ramp.end) {
+			value = ramp.from + (ramp.to-ramp.from)*float64(now.Sub(ramp.start))/float64(ramp.end.Sub(ramp.start))
+		} else {
+			delete(d.ramps, addr)
+		}
+		d.store(ramp.reg, value, math.Round(value) != 0)
+	}
+	return nil
+}
+
+// react starts the reactions to a write of the addresses within
+// [first, first+quantity). A write also ends the reactions still changing
+// the registers it writes.
+func (d *simulatorDevice) react(first, quantity uint16) {
+	if d.server == nil {
+		return
+	}
+	end := int(first) + int(quantity)
+	for addr := range d.ramps {
+		if addr >= first && int(addr) < end {
+			delete(d.ramps, addr)
+		}
+	}
+
+	now := time.Now()
+	for addr, reg := range d.server.registerMap {
+		if len(reg.Reactions) == 0 || addr < first || int(addr) >= end {
+			continue
+		}
+		written, ok := d.current(reg)
+		if !ok {
+			continue
+		}
+		for _, reaction := range reg.Reactions {
+			if reaction.When != "" {
+				op, limit, err := parseColorCondition(reaction.When)
+				if err != nil || !compare(op, written, limit) {
+					continue
+				}
+			}
+			target, ok := d.server.registerMap[reaction.Address]
+			if !ok {
+				target = RegisterConfig{Address: reaction.Address}
+			}
+			from, _ := d.current(target)
+			start := now.Add(time.Duration(reaction.Delay * float64(time.Second)))
+			d.ramps[reaction.Address] = &simulatorRamp{
+				reg:   target,
+				from:  from,
+				to:    reaction.Value,
+				start: start,
+				end:   start.Add(time.Duration(reaction.Ramp * float64(time.Second))),
+			}
+			logMessage(DebugLevel, "Simulator %s: write of %d changes %d to %g", d.server.ID, addr, reaction.Address, reaction.Value)
+		}
+	}
+}
+
+// current returns the value of a register in the data model as a number
+func (d *simulatorDevice) current(reg RegisterConfig) (float64, bool) {
+	addr := reg.Address
+	switch {
+	case addr < 10000:
+		return toFloat(d.model.Coils[addr])
+	case addr < 20000:
+		return toFloat(d.model.DiscreteInputs[addr-10000])
+	}
+	table, offset := d.registerTable(addr)
+	n := min(max(registerWordCount(reg), 1), len(table)-offset)
+	return toFloat(decodeRegister(reg, table[offset:offset+n]))
+}
+
+// registerTable returns the table of the data model holding an input or
+// holding register, and the register's offset in it
+func (d *simulatorDevice) registerTable(addr uint16) ([]uint16, int) {
+	if addr < 40000 {
+		return d.model.InputRegisters[:], int(addr) - 30000
+	}
+	return d.model.HoldingRegisters[:], int(addr) - 40000
+}
+
+// generatorValue returns the value of a generator after elapsed seconds
+func (d *simulatorDevice) generatorValue(addr uint16, g *GeneratorConfig, elapsed float64) (float64, error) {
+	span := g.Max - g.Min
+	switch g.Type {
+	case "sine":
+		return g.Min + span/2*(1+math.Sin(2*math.Pi*elapsed/g.Period)), nil
+	case "ramp":
+		return g.Min + span*math.Mod(elapsed, g.Period)/g.Period, nil
+	case "random-walk":
+		value, ok := d.walks[addr]
+		if !ok {
+			value = g.Min + span/2
+		}
+		value = math.Max(g.Min, math.Min(g.Max, value+(2*rand.Float64()-1)*g.Step))
+		d.walks[addr] = value
+		return value, nil
+	case "csv":
+		samples, err := d.loadSamples(g.File)
+		if err != nil {
+			return 0, err
+		}
+		interval := g.Interval
+		if interval <= 0 {
+			interval = 1
+		}
+		return samples[int(elapsed/interval)%len(samples)], nil
+	default:
+		return 0, fmt.Errorf("unknown generator type %q", g.Type)
+	}
+}
+
+// loadSamples reads the first column of a CSV file, skipping rows that are
+// not numbers such as a header, and caches it
+func (d *simulatorDevice) loadSamples(path string) ([]float64, error) {
+	if samples, ok := d.samples[path]; ok {
+		return samples, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var samples []float64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64); err == nil {
+			samples = append(samples, v)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s contains no values", path)
+	}
+	d.samples[path] = samples
+	return samples, nil
+}
+
+// store writes a value into the data model in the register's format; on is
+// the state of coils, discrete inputs and booleans
+func (d *simulatorDevice) store(reg RegisterConfig, value float64, on bool) {
+	addr := reg.Address
+	switch {
+	case addr < 10000:
+		d.model.Coils[addr] = on
+		return
+	case addr < 20000:
+		d.model.DiscreteInputs[addr-10000] = on
+		return
+	}
+
+	var words []uint16
+	switch reg.Format {
+	case "float":
+		bits := math.Float32bits(float32(value))
+		words = []uint16{uint16(bits >> 16), uint16(bits)}
+	case "double":
+		bits := math.Float64bits(value)
+		words = []uint16{uint16(bits >> 48), uint16(bits >> 32), uint16(bits >> 16), uint16(bits)}
+	case "boolean":
+		words = []uint16{0}
+		if on {
+			words[0] = 1
+		}
+	case "int16", "uint32", "int32", "uint64", "int64", "unix-time":
+		var err error
+		words, err = encodeInteger(reg, strconv.FormatInt(int64(math.Round(value)), 10))
+		if err != nil {
+			words = make([]uint16, registerWordCount(reg)) // out of range of the format
+		}
+	default:
+		words = []uint16{uint16(int64(math.Round(value)))}
+	}
+
+	words = orderWords(reg, words)
+
+	table, offset := d.registerTable(addr)
+	for i, w := range words {
+		if offset+i < len(table) {
+			table[offset+i] = w
+		}
+	}
+}
+
+// checkRange returns an error if a request does not fit in a table of the data model
+func checkRange(address, quantity uint16, size int) error {
+	if quantity == 0 || int(address)+int(quantity) > size {
+		return fmt.Errorf("illegal data address %d+%d", address, quantity)
+	}
+	return nil
+}
+
+func (d *simulatorDevice) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	if err := checkRange(address, quantity, len(d.model.Coils)); err != nil {
+		return nil, err
+	}
+	if err := d.generate(address, quantity); err != nil {
+		return nil, err
+	}
+	return append([]bool(nil), d.model.Coils[address:address+quantity]...), nil
+}
+
+func (d *simulatorDevice) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	if err := checkRange(address, quantity, len(d.model.DiscreteInputs)); err != nil {
+		return nil, err
+	}
+	if err := d.generate(address+10000, quantity); err != nil {
+		return nil, err
+	}
+	return append([]bool(nil), d.model.DiscreteInputs[address:address+quantity]...), nil
+}
+
+func (d *simulatorDevice) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	if err := checkRange(address, quantity, len(d.model.InputRegisters)); err != nil {
+		return nil, err
+	}
+	if err := d.generate(address+30000, quantity); err != nil {
+		return nil, err
+	}
+	return append([]uint16(nil), d.model.InputRegisters[address:address+quantity]...), nil
+}
+
+func (d *simulatorDevice) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	if err := checkRange(address, quantity, len(d.model.HoldingRegisters)); err != nil {
+		return nil, err
+	}
+	if err := d.generate(address+40000, quantity); err != nil {
+		return nil, err
+	}
+	return append([]uint16(nil), d.model.HoldingRegisters[address:address+quantity]...), nil
+}
+
+func (d *simulatorDevice) WriteSingleCoil(address uint16, value bool) error {
+	return d.WriteMultipleCoils(address, []bool{value})
+}
+
+func (d *simulatorDevice) WriteMultipleCoils(address uint16, values []bool) error {
+	if err := checkRange(address, uint16(len(values)), len(d.model.Coils)); err != nil {
+		return err
+	}
+	copy(d.model.Coils[address:], values)
+	d.react(address, uint16(len(values)))
+	return nil
+}
+
+func (d *simulatorDevice) WriteSingleRegister(address uint16, value uint16) error {
+	return d.WriteMultipleRegisters(address, []uint16{value})
+}
+
+func (d *simulatorDevice) WriteMultipleRegisters(address uint16, values []uint16) error {
+	if err := checkRange(address, uint16(len(values)), len(d.model.HoldingRegisters)); err != nil {
+		return err
+	}
+	copy(d.model.HoldingRegisters[address:], values)
+	d.react(address+40000, uint16(len(values)))
+	return nil
+}
+
+// IsConnectionError is always false: a simulator cannot lose its connection
+func (d *simulatorDevice) IsConnectionError(err error) bool {
+	return false
+}
+
+func (d *simulatorDevice) Close() {}
+
+// checkGenerator returns an error if a generator is incomplete or cannot
+// drive the register's format
+func checkGenerator(reg RegisterConfig) error {
+	g := reg.Generator
+	switch {
+	case !generatorTypes[g.Type]:
+		return fmt.Errorf("unknown generator type %q (must be sine, ramp, random-walk or csv)", g.Type)
+	case g.Max < g.Min:
+		return errors.New("generator min must not be greater than max")
+	case (g.Type == "sine" || g.Type == "ramp") && g.Period <= 0:
+		return fmt.Errorf("%s generator requires a period greater than 0", g.Type)
+	case g.Type == "random-walk" && g.Step <= 0:
+		return errors.New("random-walk generator requires a step greater than 0")
+	case g.Type == "csv" && g.File == "":
+		return errors.New("csv generator requires a file")
+	case reg.Format == "string-byte" || reg.Format == "string-word":
+		return fmt.Errorf("generators cannot drive %s registers", reg.Format)
+	}
+	return nil
+}
+
+// checkReaction returns an error if a reaction cannot be carried out
+func checkReaction(reaction ReactionConfig) error {
+	if reaction.When != "" {
+		if _, _, err := parseColorCondition(reaction.When); err != nil {
+			return err
+		}
+	}
+	if _, ok := addressRangeEnd(reaction.Address); !ok {
+		return fmt.Errorf("address %d is not in a valid address range", reaction.Address)
+	}
+	if reaction.Ramp < 0 || reaction.Delay < 0 {
+		return errors.New("ramp and delay must not be negative")
+	}
+	return nil
+}
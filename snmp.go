@@ -0,0 +1,540 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultSNMPBaseOID is the net-snmp "playpen" subtree reserved for local use
+const defaultSNMPBaseOID = "1.3.6.1.4.1.8072.9999.9999"
+
+// maxSNMPBulkVars caps the number of variables returned for a GetBulk request
+const maxSNMPBulkVars = 100
+
+// SNMP versions, PDU types and error statuses (RFC 1157, RFC 3416)
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+
+	snmpGetRequest     = 0xA0
+	snmpGetNextRequest = 0xA1
+	snmpGetResponse    = 0xA2
+	snmpSetRequest     = 0xA3
+	snmpGetBulkRequest = 0xA5
+
+	snmpNoSuchName  = 2
+	snmpGenErr      = 5
+	snmpNotWritable = 17
+)
+
+// BER tags used by the agent
+const (
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berNull           = 0x05
+	berObjectID       = 0x06
+	berSequence       = 0x30
+	berGauge32        = 0x42
+	berCounter64      = 0x46
+	berNoSuchInstance = 0x81
+	berEndOfMibView   = 0x82
+)
+
+// snmpVar is a variable binding: an OID and its BER-encoded value
+type snmpVar struct {
+	oid   []uint32
+	value []byte
+}
+
+// snmpAgent answers SNMP requests for the configured register values
+type snmpAgent struct {
+	conn      *net.UDPConn
+	community string
+	base      []uint32
+}
+
+// startSNMP starts an SNMP agent on the given UDP port. Registers with an OID
+// are exposed at base OID + server OID + register OID.
+func startSNMP(port int, community, baseOID string) error {
+	base, err := parseOID(baseOID)
+	if err != nil {
+		return fmt.Errorf("invalid base OID: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+
+	agent := &snmpAgent{conn: conn, community: community, base: base}
+	go agent.serve()
+	logMessage(InfoLevel, "SNMP agent listening on UDP port %d under %s", port, formatOID(base))
+	return nil
+}
+
+// serve answers requests until the connection is closed
+func (a *snmpAgent) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			logMessage(ErrorLevel, "SNMP agent stopped: %v", err)
+			return
+		}
+		reply, err := a.handle(buf[:n])
+		if err != nil {
+			logMessage(DebugLevel, "Ignoring SNMP request from %s: %v", addr, err)
+			continue
+		}
+		if _, err := a.conn.WriteToUDP(reply, addr); err != nil {
+			logMessage(ErrorLevel, "Error sending SNMP response to %s: %v", addr, err)
+		}
+	}
+}
+
+// handle decodes a request and returns the encoded response
+func (a *snmpAgent) handle(packet []byte) ([]byte, error) {
+	tag, message, _, err := berRead(packet)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed message")
+	}
+
+	tag, field, message, err := berRead(message)
+	if err != nil || tag != berInteger {
+		return nil, errors.New("malformed version")
+	}
+	version, err := berParseInt(field)
+	if err != nil || (version != snmpVersion1 && version != snmpVersion2c) {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	tag, community, message, err := berRead(message)
+	if err != nil || tag != berOctetString {
+		return nil, errors.New("malformed community")
+	}
+	if subtle.ConstantTimeCompare(community, []byte(a.community)) != 1 {
+		return nil, errors.New("wrong community")
+	}
+
+	pduType, pdu, _, err := berRead(message)
+	if err != nil {
+		return nil, errors.New("malformed PDU")
+	}
+	var fields [3]int64
+	for i := range fields {
+		tag, field, pdu, err = berRead(pdu)
+		if err != nil || tag != berInteger {
+			return nil, errors.New("malformed PDU header")
+		}
+		if fields[i], err = berParseInt(field); err != nil {
+			return nil, err
+		}
+	}
+	requestID := fields[0]
+
+	tag, list, _, err := berRead(pdu)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed variable bindings")
+	}
+	var requested []snmpVar
+	for len(list) > 0 {
+		var binding, oid []byte
+		if tag, binding, list, err = berRead(list); err != nil || tag != berSequence {
+			return nil, errors.New("malformed variable binding")
+		}
+		if tag, oid, _, err = berRead(binding); err != nil || tag != berObjectID {
+			return nil, errors.New("malformed OID")
+		}
+		parsed, err := berParseOID(oid)
+		if err != nil {
+			return nil, err
+		}
+		requested = append(requested, snmpVar{oid: parsed, value: berTLV(berNull, nil)})
+	}
+
+	var errorStatus, errorIndex int64
+	var vars []snmpVar
+	switch pduType {
+	case snmpGetRequest, snmpGetNextRequest:
+		mib := collectSNMPVars(a.base)
+		for i, req := range requested {
+			var v snmpVar
+			var found bool
+			if pduType == snmpGetRequest {
+				v, found = mibGet(mib, req.oid)
+			} else {
+				v, found = mibNext(mib, req.oid)
+			}
+			if !found {
+				if version == snmpVersion1 {
+					errorStatus, errorIndex, vars = snmpNoSuchName, int64(i+1), requested
+					break
+				}
+				v = snmpVar{oid: req.oid, value: berTLV(berNoSuchInstance, nil)}
+				if pduType == snmpGetNextRequest {
+					v.value = berTLV(berEndOfMibView, nil)
+				}
+			}
+			vars = append(vars, v)
+		}
+	case snmpGetBulkRequest:
+		if version == snmpVersion1 {
+			return nil, errors.New("GetBulk is not supported in SNMPv1")
+		}
+		vars = mibBulk(collectSNMPVars(a.base), requested, int(fields[1]), int(fields[2]))
+	case snmpSetRequest:
+		// Values are read-only; writes go through the web UI
+		errorStatus, errorIndex, vars = snmpNotWritable, 1, requested
+		if version == snmpVersion1 {
+			errorStatus = snmpNoSuchName
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PDU type 0x%02X", pduType)
+	}
+
+	reply := encodeSNMPResponse(version, a.community, requestID, errorStatus, errorIndex, vars)
+	if len(reply) > 65507 {
+		reply = encodeSNMPResponse(version, a.community, requestID, snmpGenErr, 0, requested)
+	}
+	return reply, nil
+}
+
+// encodeSNMPResponse builds a GetResponse message
+func encodeSNMPResponse(version int64, community string, requestID, errorStatus, errorIndex int64, vars []snmpVar) []byte {
+	var list []byte
+	for _, v := range vars {
+		list = append(list, berTLV(berSequence, append(berTLV(berObjectID, berEncodeOID(v.oid)), v.value...))...)
+	}
+
+	var pdu []byte
+	pdu = append(pdu, berInt(berInteger, requestID)...)
+	pdu = append(pdu, berInt(berInteger, errorStatus)...)
+	pdu = append(pdu, berInt(berInteger, errorIndex)...)
+	pdu = append(pdu, berTLV(berSequence, list)...)
+
+	var message []byte
+	message = append(message, berInt(berInteger, version)...)
+	message = append(message, berTLV(berOctetString, []byte(community))...)
+	message = append(message, berTLV(snmpGetResponse, pdu)...)
+	return berTLV(berSequence, message)
+}
+
+// collectSNMPVars returns the exposed register values of all connected
+// servers, sorted by OID. Servers that are not receiving data are left out so
+// that the NMS sees their values disappear rather than go stale.
+func collectSNMPVars(base []uint32) []snmpVar {
+	mu.RLock()
+	serverList := make([]*ModbusServer, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, server)
+	}
+	mu.RUnlock()
+
+	var mib []snmpVar
+	for _, server := range serverList {
+		server.mu.Lock()
+		if server.state() != "ok" {
+			server.mu.Unlock()
+			continue
+		}
+		prefix := append([]uint32{}, base...)
+		if server.OID != "" {
+			serverOID, err := parseOID(server.OID)
+			if err != nil {
+				logMessage(ErrorLevel, "Server %s has invalid OID %q: %v", server.ID, server.OID, err)
+				server.mu.Unlock()
+				continue
+			}
+			prefix = append(prefix, serverOID...)
+		}
+		for _, row := range server.registerData() {
+			regConfig, configured := server.registerMap[row["Address"].(uint16)]
+			if !configured || regConfig.OID == "" {
+				continue
+			}
+			regOID, err := parseOID(regConfig.OID)
+			if err != nil {
+				logMessage(ErrorLevel, "Register %d of server %s has invalid OID %q: %v", regConfig.Address, server.ID, regConfig.OID, err)
+				continue
+			}
+			mib = append(mib, snmpVar{
+				oid:   append(append([]uint32{}, prefix...), regOID...),
+				value: encodeSNMPValue(row["Value"]),
+			})
+		}
+		server.mu.Unlock()
+	}
+
+	sort.SliceStable(mib, func(i, j int) bool {
+		return compareOID(mib[i].oid, mib[j].oid) < 0
+	})
+	// Duplicate OIDs would make walks loop; keep the first
+	unique := mib[:0]
+	for _, v := range mib {
+		if len(unique) == 0 || compareOID(unique[len(unique)-1].oid, v.oid) != 0 {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// encodeSNMPValue maps a register value to an SNMP type. SNMP has no floating
+// point type, so floats are sent as strings.
+func encodeSNMPValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return berInt(berInteger, 1)
+		}
+		return berInt(berInteger, 0)
+	case uint16:
+		return berUint(berGauge32, uint64(v))
+	case uint32:
+		return berUint(berGauge32, uint64(v))
+	case uint64:
+		if v <= math.MaxUint32 {
+			return berUint(berGauge32, v)
+		}
+		return berUint(berCounter64, v)
+	case int16:
+		return berInt(berInteger, int64(v))
+	case int32:
+		return berInt(berInteger, int64(v))
+	case int64:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return berInt(berInteger, v)
+		}
+		return berTLV(berOctetString, []byte(strconv.FormatInt(v, 10)))
+	case float32:
+		return berTLV(berOctetString, []byte(strconv.FormatFloat(float64(v), 'g', -1, 32)))
+	case float64:
+		return berTLV(berOctetString, []byte(strconv.FormatFloat(v, 'g', -1, 64)))
+	default:
+		return berTLV(berOctetString, []byte(fmt.Sprint(v)))
+	}
+}
+
+// mibGet returns the variable with exactly the given OID
+func mibGet(mib []snmpVar, oid []uint32) (snmpVar, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return compareOID(mib[i].oid, oid) >= 0 })
+	if i < len(mib) && compareOID(mib[i].oid, oid) == 0 {
+		return mib[i], true
+	}
+	return snmpVar{}, false
+}
+
+// mibNext returns the first variable after the given OID
+func mibNext(mib []snmpVar, oid []uint32) (snmpVar, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return compareOID(mib[i].oid, oid) > 0 })
+	if i < len(mib) {
+		return mib[i], true
+	}
+	return snmpVar{}, false
+}
+
+// mibBulk answers a GetBulk request: one GetNext for each of the first
+// nonRepeaters OIDs, then up to maxRepetitions successive GetNexts for the rest
+func mibBulk(mib []snmpVar, requested []snmpVar, nonRepeaters, maxRepetitions int) []snmpVar {
+	nonRepeaters = min(max(nonRepeaters, 0), len(requested))
+	maxRepetitions = max(maxRepetitions, 0)
+
+	var vars []snmpVar
+	next := func(oid []uint32) snmpVar {
+		if v, found := mibNext(mib, oid); found {
+			return v
+		}
+		return snmpVar{oid: oid, value: berTLV(berEndOfMibView, nil)}
+	}
+
+	for _, req := range requested[:nonRepeaters] {
+		vars = append(vars, next(req.oid))
+	}
+	repeaters := requested[nonRepeaters:]
+	cursors := make([][]uint32, len(repeaters))
+	for i, req := range repeaters {
+		cursors[i] = req.oid
+	}
+	for r := 0; r < maxRepetitions && len(repeaters) > 0 && len(vars)+len(repeaters) <= maxSNMPBulkVars; r++ {
+		ended := true
+		for i := range cursors {
+			v := next(cursors[i])
+			cursors[i] = v.oid
+			vars = append(vars, v)
+			ended = ended && v.value[0] == berEndOfMibView
+		}
+		if ended {
+			break
+		}
+	}
+	return vars
+}
+
+// parseOID parses a dotted OID such as "1.3.6.1" or ".1.2"
+func parseOID(s string) ([]uint32, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), ".")
+	if s == "" {
+		return nil, errors.New("OID is empty")
+	}
+	var oid []uint32
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID component %q", part)
+		}
+		oid = append(oid, uint32(n))
+	}
+	return oid, nil
+}
+
+// formatOID returns the dotted form of an OID
+func formatOID(oid []uint32) string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareOID orders OIDs lexicographically
+func compareOID(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// berRead splits the first TLV off b, returning its tag, value and the remaining bytes
+func berRead(b []byte) (tag byte, value []byte, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	tag = b[0]
+	length := int(b[1])
+	b = b[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 3 || len(b) < n {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, c := range b[:n] {
+			length = length<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if len(b) < length {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// berTLV encodes a tag, length and value
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xFF:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xFFFF:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+// berInt encodes a signed integer in the fewest two's complement bytes
+func berInt(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for (v > 0x7F || v < -0x80) && len(b) < 8 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// berUint encodes an unsigned integer, with a leading zero byte if the top bit is set
+func berUint(tag byte, v uint64) []byte {
+	b := []byte{byte(v)}
+	for v > 0xFF {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// berParseInt decodes a two's complement integer
+func berParseInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errors.New("invalid BER integer")
+	}
+	v := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// berEncodeOID encodes OID components, combining the first two as 40*x+y
+func berEncodeOID(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	var out []byte
+	components := append([]uint32{oid[0]*40 + oid[1]}, oid[2:]...)
+	for _, n := range components {
+		chunk := []byte{byte(n & 0x7F)}
+		for n >>= 7; n > 0; n >>= 7 {
+			chunk = append([]byte{byte(n&0x7F) | 0x80}, chunk...)
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+// berParseOID decodes an encoded OID
+func berParseOID(b []byte) ([]uint32, error) {
+	var components []uint32
+	var n uint64
+	for i, c := range b {
+		n = n<<7 | uint64(c&0x7F)
+		if n > math.MaxUint32 {
+			return nil, errors.New("OID component too large")
+		}
+		if c&0x80 == 0 {
+			components = append(components, uint32(n))
+			n = 0
+		} else if i == len(b)-1 {
+			return nil, errors.New("truncated OID")
+		}
+	}
+	if len(components) == 0 {
+		return nil, errors.New("empty OID")
+	}
+
+	first := components[0]
+	var oid []uint32
+	switch {
+	case first < 40:
+		oid = []uint32{0, first}
+	case first < 80:
+		oid = []uint32{1, first - 40}
+	default:
+		oid = []uint32{2, first - 80}
+	}
+	return append(oid, components[1:]...), nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBERReadRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 0x7F, 0x80, 0xFF, 0x100, 0xFFFF, 0x10000} {
+		value := bytes.Repeat([]byte{0xAB}, n)
+		encoded := append(berTLV(berOctetString, value), 0x01, 0x02)
+
+		tag, got, rest, err := berRead(encoded)
+		if err != nil {
+			t.Fatalf("length %d: %v", n, err)
+		}
+		if tag != berOctetString || !bytes.Equal(got, value) || !bytes.Equal(rest, []byte{0x01, 0x02}) {
+			t.Errorf("length %d: got tag 0x%02X, %d value bytes, rest % X", n, tag, len(got), rest)
+		}
+	}
+}
+
+func TestBERReadMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"empty", nil, "truncated BER value"},
+		{"tag only", []byte{berInteger}, "truncated BER value"},
+		{"short value", []byte{berInteger, 0x02, 0x01}, "truncated BER value"},
+		{"indefinite length", []byte{berSequence, 0x80, 0x00, 0x00}, "invalid BER length"},
+		{"length of length too big", []byte{berOctetString, 0x84, 0x00, 0x00, 0x00, 0x01, 0x00}, "invalid BER length"},
+		{"missing length bytes", []byte{berOctetString, 0x82, 0x01}, "invalid BER length"},
+		{"long form past the end", []byte{berOctetString, 0x82, 0x01, 0x00, 0xAA}, "truncated BER value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := berRead(tt.input)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("berRead(% X) error = %v, want %q", tt.input, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7F}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xFF}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xFF, 0x7F}},
+		{1<<63 - 1, []byte{0x02, 0x08, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{-1 << 63, []byte{0x02, 0x08, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		encoded := berInt(berInteger, tt.v)
+		if !bytes.Equal(encoded, tt.want) {
+			t.Errorf("berInt(%d) = % X, want % X", tt.v, encoded, tt.want)
+		}
+		_, value, _, err := berRead(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := berParseInt(value); err != nil || got != tt.v {
+			t.Errorf("berParseInt(% X) = %d, %v, want %d", value, got, err, tt.v)
+		}
+	}
+
+	for _, b := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := berParseInt(b); err == nil {
+			t.Errorf("berParseInt(% X) accepted an invalid length", b)
+		}
+	}
+}
+
+func TestBERUint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{berGauge32, 0x01, 0x00}},
+		{0x7F, []byte{berGauge32, 0x01, 0x7F}},
+		{0x80, []byte{berGauge32, 0x02, 0x00, 0x80}},
+		{0xFFFFFFFF, []byte{berGauge32, 0x05, 0x00, 0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+	for _, tt := range tests {
+		if got := berUint(berGauge32, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("berUint(%d) = % X, want % X", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid     string
+		encoded []byte
+	}{
+		{"1.3.6.1.2.1.1.1.0", []byte{0x2B, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}},
+		{"1.3.6.1.4.1.8072.9999.9999", []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0xBF, 0x08, 0xCE, 0x0F, 0xCE, 0x0F}},
+		{"2.999.3", []byte{0x88, 0x37, 0x03}},
+		{"1.3.4294967295", []byte{0x2B, 0x8F, 0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, tt := range tests {
+		oid, err := parseOID(tt.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := berEncodeOID(oid)
+		if !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("berEncodeOID(%s) = % X, want % X", tt.oid, encoded, tt.encoded)
+		}
+		decoded, err := berParseOID(encoded)
+		if err != nil || formatOID(decoded) != tt.oid {
+			t.Errorf("berParseOID(% X) = %s, %v, want %s", encoded, formatOID(decoded), err, tt.oid)
+		}
+	}
+
+	malformed := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"empty", nil},
+		{"truncated component", []byte{0x2B, 0x86}},
+		{"component too large", []byte{0x2B, 0x90, 0x80, 0x80, 0x80, 0x00}},
+	}
+	for _, tt := range malformed {
+		if oid, err := berParseOID(tt.encoded); err == nil {
+			t.Errorf("%s: berParseOID(% X) = %s, want an error", tt.name, tt.encoded, formatOID(oid))
+		}
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []uint32
+		ok   bool
+	}{
+		{"1.3.6.1", []uint32{1, 3, 6, 1}, true},
+		{".1.2", []uint32{1, 2}, true},
+		{" 1.2 ", []uint32{1, 2}, true},
+		{"", nil, false},
+		{"1..2", nil, false},
+		{"1.x", nil, false},
+		{"1.4294967296", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseOID(tt.s)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOID(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestCompareOID(t *testing.T) {
+	tests := []struct {
+		a, b []uint32
+		want int
+	}{
+		{[]uint32{1, 3}, []uint32{1, 3}, 0},
+		{[]uint32{1, 3}, []uint32{1, 4}, -1},
+		{[]uint32{1, 10}, []uint32{1, 9}, 1},
+		{[]uint32{1, 3}, []uint32{1, 3, 0}, -1},
+		{[]uint32{1, 3, 0}, []uint32{1, 3}, 1},
+	}
+	for _, tt := range tests {
+		got := compareOID(tt.a, tt.b)
+		if (got < 0) != (tt.want < 0) || (got > 0) != (tt.want > 0) {
+			t.Errorf("compareOID(%v, %v) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// snmpRequest encodes a request PDU for the given OIDs
+func snmpRequest(version int64, community string, pduType byte, requestID int64, oids ...string) []byte {
+	var list []byte
+	for _, s := range oids {
+		list = append(list, berTLV(berSequence, append(berTLV(berObjectID, berEncodeOID(mustOID(s))), berTLV(berNull, nil)...))...)
+	}
+	var pdu []byte
+	pdu = append(pdu, berInt(berInteger, requestID)...)
+	pdu = append(pdu, berInt(berInteger, 0)...)
+	pdu = append(pdu, berInt(berInteger, 0)...)
+	pdu = append(pdu, berTLV(berSequence, list)...)
+
+	var message []byte
+	message = append(message, berInt(berInteger, version)...)
+	message = append(message, berTLV(berOctetString, []byte(community))...)
+	message = append(message, berTLV(pduType, pdu)...)
+	return berTLV(berSequence, message)
+}
+
+func TestSNMPHandle(t *testing.T) {
+	base, _ := parseOID(defaultSNMPBaseOID)
+	agent := &snmpAgent{community: "public", base: base}
+	missing := defaultSNMPBaseOID + ".1.1"
+
+	tests := []struct {
+		name    string
+		request []byte
+		want    []byte
+		err     string
+	}{
+		{
+			name:    "v2c get of a missing OID",
+			request: snmpRequest(snmpVersion2c, "public", snmpGetRequest, 7, missing),
+			want:    encodeSNMPResponse(snmpVersion2c, "public", 7, 0, 0, []snmpVar{{oid: mustOID(missing), value: berTLV(berNoSuchInstance, nil)}}),
+		},
+		{
+			name:    "v2c get-next past the end",
+			request: snmpRequest(snmpVersion2c, "public", snmpGetNextRequest, 8, missing),
+			want:    encodeSNMPResponse(snmpVersion2c, "public", 8, 0, 0, []snmpVar{{oid: mustOID(missing), value: berTLV(berEndOfMibView, nil)}}),
+		},
+		{
+			name:    "v1 get of a missing OID",
+			request: snmpRequest(snmpVersion1, "public", snmpGetRequest, 9, missing),
+			want:    encodeSNMPResponse(snmpVersion1, "public", 9, snmpNoSuchName, 1, []snmpVar{{oid: mustOID(missing), value: berTLV(berNull, nil)}}),
+		},
+		{
+			name:    "v2c set",
+			request: snmpRequest(snmpVersion2c, "public", snmpSetRequest, 10, missing),
+			want:    encodeSNMPResponse(snmpVersion2c, "public", 10, snmpNotWritable, 1, []snmpVar{{oid: mustOID(missing), value: berTLV(berNull, nil)}}),
+		},
+		{
+			name:    "wrong community",
+			request: snmpRequest(snmpVersion2c, "private", snmpGetRequest, 11, missing),
+			err:     "wrong community",
+		},
+		{
+			name:    "unsupported version",
+			request: snmpRequest(3, "public", snmpGetRequest, 12, missing),
+			err:     "unsupported version 3",
+		},
+		{
+			name:    "v1 get-bulk",
+			request: snmpRequest(snmpVersion1, "public", snmpGetBulkRequest, 13, missing),
+			err:     "GetBulk is not supported in SNMPv1",
+		},
+		{
+			name:    "truncated message",
+			request: snmpRequest(snmpVersion2c, "public", snmpGetRequest, 14, missing)[:20],
+			err:     "malformed message",
+		},
+		{
+			name:    "not a sequence",
+			request: berInt(berInteger, 1),
+			err:     "malformed message",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := agent.handle(tt.request)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(reply, tt.want) {
+				t.Errorf("reply = % X, want % X", reply, tt.want)
+			}
+		})
+	}
+}
+
+func mustOID(s string) []uint32 {
+	oid, err := parseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
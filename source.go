@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rustyoz/modbus"
+)
+
+// Modbus TCP framing: a 7 byte MBAP header and at most 260 bytes in all
+const (
+	mbapHeaderSize = 7
+	maxTCPADU      = 260
+)
+
+// sourceAddr returns the local address to connect to target from. source is
+// an IP address, or the name of a network interface whose first address of
+// the same family as target is used, so that a laptop keeps working when
+// DHCP gives the interface a new address.
+func sourceAddr(source, target string) (*net.TCPAddr, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("source address %q is neither an IP address nor a network interface", source)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", source, err)
+	}
+
+	// Hostnames are assumed to resolve to IPv4. A link-local address is
+	// preferred for link-local targets, and avoided for others.
+	ipv6, linkLocal := false, false
+	if ip, err := netip.ParseAddr(hostAddress(target)); err == nil {
+		ipv6 = !ip.Unmap().Is4()
+		linkLocal = ip.IsLinkLocalUnicast()
+	}
+	var local *net.TCPAddr
+	for _, addr := range addrs {
+		network, ok := addr.(*net.IPNet)
+		if !ok || (network.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if local != nil && network.IP.IsLinkLocalUnicast() != linkLocal {
+			continue
+		}
+		local = &net.TCPAddr{IP: network.IP}
+		if network.IP.IsLinkLocalUnicast() {
+			local.Zone = iface.Name
+		}
+		if network.IP.IsLinkLocalUnicast() == linkLocal {
+			return local, nil
+		}
+	}
+	if local != nil {
+		return local, nil
+	}
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", source, family)
+}
+
+// sourceHandler is a Modbus TCP handler that connects from a chosen local
+// address. It uses the library's framing with a connection of its own, as
+// the library's handler always lets the operating system pick the address.
+type sourceHandler struct {
+	*modbus.TCPClientHandler
+	dialer net.Dialer
+	mu     sync.Mutex
+	conn   net.Conn
+}
+
+// Connect opens the connection if it is not open
+func (h *sourceHandler) Connect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connect()
+}
+
+// connect opens the connection if it is not open. The caller must hold h.mu.
+func (h *sourceHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := h.dialer.Dial("tcp", h.Address)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+// Close closes the connection; the next request opens a new one
+func (h *sourceHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send sends a request and reads the response, with the same checks and
+// error messages as the library's handler
+func (h *sourceHandler) Send(request []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if h.Timeout > 0 {
+		deadline = time.Now().Add(h.Timeout)
+	}
+	if err := h.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := h.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var data [maxTCPADU]byte
+	if _, err := io.ReadFull(h.conn, data[:mbapHeaderSize]); err != nil {
+		return nil, err
+	}
+	// The length field counts the unit ID, the last byte of the header
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length <= 0 {
+		return nil, fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
+	}
+	if length > maxTCPADU-mbapHeaderSize+1 {
+		return nil, fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, maxTCPADU-mbapHeaderSize+1)
+	}
+	length += mbapHeaderSize - 1
+	if _, err := io.ReadFull(h.conn, data[mbapHeaderSize:length]); err != nil {
+		return nil, err
+	}
+	return data[:length], nil
+}
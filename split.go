@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rustyoz/modbus"
+)
+
+// BlockRange is a part of a register block found by splitting it
+type BlockRange struct {
+	StartAddress uint16 `json:"startAddress"`
+	Length       uint16 `json:"length"`
+	Readable     bool   `json:"readable"` // false if the device answers reads of it with Illegal Data Address
+}
+
+// isIllegalAddress reports whether err is an Illegal Data Address exception,
+// which a device answers when a read covers an address it does not have
+func isIllegalAddress(err error) bool {
+	var modbusErr *modbus.ModbusError
+	return errors.As(err, &modbusErr) && modbusErr.ExceptionCode == modbus.ExceptionCodeIllegalDataAddress
+}
+
+// findReadableRanges bisects a block into the ranges the device can read and
+// those it answers with Illegal Data Address, in address order. Any other
+// error ends the search, as it says nothing about the addresses. The caller
+// must hold s.mu.
+func (s *ModbusServer) findReadableRanges(block RegisterBlock) ([]BlockRange, error) {
+	if s.client == nil {
+		return nil, fmt.Errorf("server %s is not connected", s.ID)
+	}
+
+	var ranges []BlockRange
+	add := func(start, length uint16, readable bool) {
+		if n := len(ranges); n > 0 && ranges[n-1].Readable == readable {
+			ranges[n-1].Length += length
+			return
+		}
+		ranges = append(ranges, BlockRange{StartAddress: start, Length: length, Readable: readable})
+	}
+	var probe func(start, length uint16) error
+	probe = func(start, length uint16) error {
+		_, err := readAddresses(s.client, start, length)
+		switch {
+		case err == nil:
+			add(start, length, true)
+			return nil
+		case !isIllegalAddress(err):
+			return fmt.Errorf("reading %d+%d: %v", start, length, err)
+		case length == 1:
+			add(start, length, false)
+			return nil
+		}
+		half := length / 2
+		if err := probe(start, half); err != nil {
+			return err
+		}
+		return probe(start+half, length-half)
+	}
+	if err := probe(block.StartAddress, block.Length); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// proposeSplit bisects a block that failed with Illegal Data Address and
+// keeps the ranges found in its status until a user applies them. The
+// caller must hold s.mu.
+func (s *ModbusServer) proposeSplit(block RegisterBlock) ([]BlockRange, error) {
+	ranges, err := s.findReadableRanges(block)
+	if status, ok := s.blockStatus[block.StartAddress]; ok {
+		status.splitProbed = true
+		status.Split = nil
+		if err == nil && (len(ranges) > 1 || !ranges[0].Readable) {
+			status.Split = ranges
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// checkAutoSplit proposes a split of a block whose read failed with Illegal
+// Data Address, once until the block is read or changed, if the server has
+// AutoSplit. The caller must hold s.mu.
+func (s *ModbusServer) checkAutoSplit(block RegisterBlock, readErr error) {
+	status, ok := s.blockStatus[block.StartAddress]
+	if !ok {
+		return
+	}
+	if readErr == nil {
+		status.splitProbed = false
+		status.Split = nil
+		return
+	}
+	if !s.AutoSplit || status.splitProbed || !isIllegalAddress(readErr) {
+		return
+	}
+	ranges, err := s.proposeSplit(block)
+	if err != nil {
+		logMessage(InfoLevel, "Server %s: could not split block %d+%d: %v", s.ID, block.StartAddress, block.Length, err)
+		return
+	}
+	if status.Split == nil {
+		return
+	}
+	message := fmt.Sprintf("Block %d+%d of server %s has unreadable addresses; splitting it into %s awaits confirmation", block.StartAddress, block.Length, s.ID, describeRanges(ranges))
+	logMessage(InfoLevel, "%s", message)
+	events.publish(Event{Type: "block-split", ServerID: s.ID, Address: &block.StartAddress, Message: message})
+}
+
+// describeRanges lists ranges as "40000+10, 40010+5 (unreadable)"
+func describeRanges(ranges []BlockRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("%d+%d", r.StartAddress, r.Length)
+		if !r.Readable {
+			parts[i] += " (unreadable)"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applySplit replaces a block with one block per range, in its place in the
+// poll order. Unreadable ranges become disabled blocks, so the registers in
+// them are kept in the configuration. The caller must hold s.mu.
+func (s *ModbusServer) applySplit(start uint16, ranges []BlockRange) error {
+	index := -1
+	for i, block := range s.RegisterBlocks {
+		if block.StartAddress == start {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("no block of server %s starts at %d", s.ID, start)
+	}
+	block := s.RegisterBlocks[index]
+
+	blocks := make([]RegisterBlock, 0, len(ranges))
+	for _, r := range ranges {
+		part := RegisterBlock{
+			StartAddress: r.StartAddress,
+			Length:       r.Length,
+			Disabled:     block.Disabled || !r.Readable,
+			UnitID:       block.UnitID,
+		}
+		for _, reg := range block.Registers {
+			if reg.Address >= r.StartAddress && int(reg.Address) < int(r.StartAddress)+int(r.Length) {
+				part.Registers = append(part.Registers, reg)
+			}
+		}
+		blocks = append(blocks, part)
+	}
+	s.RegisterBlocks = append(s.RegisterBlocks[:index:index], append(blocks, s.RegisterBlocks[index+1:]...)...)
+	delete(s.blockStatus, start)
+	for _, part := range blocks {
+		if part.Disabled {
+			s.markBlockDisabled(part)
+		}
+	}
+	return nil
+}
+
+// handleSplit bisects a register block on GET /api/servers/{id}/split?start=40000
+// and returns the readable and unreadable ranges found, which are kept as the
+// block's proposed split. POST or PUT {"startAddress": 40000} applies the
+// proposed split, as the user's confirmation of it.
+func handleSplit(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		start, err := strconv.ParseUint(r.URL.Query().Get("start"), 10, 16)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid start: %q", r.URL.Query().Get("start")))
+			return
+		}
+		server.mu.Lock()
+		var block *RegisterBlock
+		for i := range server.RegisterBlocks {
+			if server.RegisterBlocks[i].StartAddress == uint16(start) {
+				block = &server.RegisterBlocks[i]
+			}
+		}
+		if block == nil {
+			server.mu.Unlock()
+			handleError(w, r, fmt.Sprintf("No block of server %s starts at %d", id, start))
+			return
+		}
+		if server.blockStatus == nil {
+			server.blockStatus = make(map[uint16]*BlockStatus)
+		}
+		if _, ok := server.blockStatus[block.StartAddress]; !ok {
+			server.blockStatus[block.StartAddress] = &BlockStatus{StartAddress: block.StartAddress, Length: block.Length, Status: "pending"}
+		}
+		ranges, err := server.proposeSplit(*block)
+		proposed := server.blockStatus[block.StartAddress].Split != nil
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"ranges":   ranges,
+			"proposed": proposed, // false if the whole block is readable
+		})
+
+	case http.MethodPost, http.MethodPut:
+		var request struct {
+			StartAddress uint16 `json:"startAddress"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		server.mu.Lock()
+		var ranges []BlockRange
+		if status, ok := server.blockStatus[request.StartAddress]; ok {
+			ranges = status.Split
+		}
+		err := errors.New("no split is proposed for this block; find its readable ranges first")
+		if ranges != nil {
+			err = server.applySplit(request.StartAddress, ranges)
+		}
+		blocks := server.BlockStatuses()
+		server.mu.Unlock()
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Block %d: %v", request.StartAddress, err))
+			return
+		}
+		logMessage(InfoLevel, "Split block %d of server %s into %s", request.StartAddress, id, describeRanges(ranges))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"blocks":  blocks,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// standbyState is the replication and takeover state of a hot standby instance
+type standbyState struct {
+	mu           sync.Mutex
+	primary      string // base URL of the primary instance
+	role         string // "standby" or "active"
+	lastContact  time.Time
+	lastError    string
+	configSynced time.Time
+	servers      int       // servers in the replicated configuration
+	takenOver    time.Time // zero while on standby
+	timeout      time.Duration
+	config       []byte          // configuration last read from the primary
+	replica      *historyStore   // history replicated from the primary, nil if not replicated
+	historySince int64           // unix milliseconds of the newest replicated sample
+	started      []*ModbusServer // servers started on takeover
+}
+
+// standby is set when this instance is a hot standby (-standby-of)
+var standby *standbyState
+
+// runStandby replicates the configuration, and the history if retention is
+// not 0, of the primary instance at baseURL every interval. When the primary
+// cannot be reached for longer than timeout, this instance takes over polling
+// its servers, and hands them back once the primary answers again.
+func runStandby(baseURL string, interval, timeout, retention time.Duration) {
+	s := &standbyState{
+		primary:     strings.TrimSuffix(baseURL, "/"),
+		role:        "standby",
+		lastContact: time.Now(),
+		timeout:     timeout,
+	}
+	if retention > 0 {
+		s.replica = &historyStore{retention: retention, series: make(map[historyKey]*historySeries)}
+	}
+	mu.Lock()
+	standby = s
+	mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		s.check()
+	}
+}
+
+// check replicates from the primary and takes over or hands back polling
+// depending on whether it answered
+func (s *standbyState) check() {
+	config, count, err := s.fetchConfig()
+	if err == nil && s.replica != nil {
+		if err := s.fetchHistory(); err != nil {
+			logMessage(DebugLevel, "Standby: error replicating history from %s: %v", s.primary, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if err != nil {
+		s.lastError = err.Error()
+		logMessage(DebugLevel, "Standby: primary %s unreachable: %v", s.primary, err)
+		if s.role == "standby" && now.Sub(s.lastContact) > s.timeout {
+			s.takeOver()
+		}
+		return
+	}
+
+	s.lastContact = now
+	s.lastError = ""
+	s.config = config
+	s.configSynced = now
+	s.servers = count
+
+	// A primary that came back without servers, e.g. restarted without its
+	// configuration, is not given the polling back
+	if s.role == "active" {
+		if count == 0 {
+			s.lastError = "primary is reachable but has no servers; keeping its servers on this instance"
+			return
+		}
+		s.handBack()
+	}
+}
+
+// fetchConfig reads the configuration of the primary and returns it with its
+// number of servers
+func (s *standbyState) fetchConfig() ([]byte, int, error) {
+	resp, err := remoteClient.Get(s.primary + "/api/config")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("GET /api/config: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	var config ConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, 0, fmt.Errorf("invalid configuration: %v", err)
+	}
+	return data, len(config.Servers), nil
+}
+
+// fetchHistory adds the samples the primary took since the last fetch to the
+// replicated history
+func (s *standbyState) fetchHistory() error {
+	s.mu.Lock()
+	since := s.historySince
+	s.mu.Unlock()
+
+	query := ""
+	if since != 0 {
+		query = "?since=" + url.QueryEscape(time.UnixMilli(since).UTC().Format(time.RFC3339Nano))
+	}
+	resp, err := remoteClient.Get(s.primary + "/api/history/samples" + query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool            `json:"success"`
+		Error   string          `json:"error"`
+		Series  []HistorySeries `json:"series"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%s", result.Error)
+	}
+
+	s.replica.mu.Lock()
+	for _, series := range result.Series {
+		key := historyKey{series.Server, series.Address}
+		for _, sample := range series.Samples {
+			s.replica.add(key, series.Name, historySample{time.UnixMilli(int64(sample[0])), sample[1]})
+			since = max(since, int64(sample[0]))
+		}
+	}
+	s.replica.mu.Unlock()
+
+	s.mu.Lock()
+	s.historySince = since
+	s.mu.Unlock()
+	return nil
+}
+
+// takeOver starts polling the servers of the replicated configuration, with
+// the replicated history as their starting point, and their mirror rules.
+// Export jobs are left to the primary. The caller must hold s.mu.
+func (s *standbyState) takeOver() {
+	if s.config == nil {
+		s.lastError = "primary unreachable before its configuration could be replicated; nothing to take over"
+		return
+	}
+	var config ConfigFile
+	if err := json.Unmarshal(s.config, &config); err != nil {
+		s.lastError = fmt.Sprintf("invalid replicated configuration: %v", err)
+		return
+	}
+
+	mapTemplatesMu.Lock()
+	for name, template := range config.Templates {
+		mapTemplates[name] = template
+	}
+	mapTemplatesMu.Unlock()
+	startMirrors(config.Mirrors)
+	startHooks(config.Hooks, false) // commands are not taken over the network
+
+	if s.replica != nil {
+		mu.RLock()
+		h := history
+		mu.RUnlock()
+		if h != nil {
+			s.replica.mu.RLock()
+			h.mu.Lock()
+			for key, series := range s.replica.series {
+				if _, exists := h.series[key]; !exists {
+					h.series[key] = &historySeries{name: series.name, samples: append([]historySample(nil), series.samples...), next: series.next}
+				}
+			}
+			h.mu.Unlock()
+			s.replica.mu.RUnlock()
+		}
+	}
+
+	s.started = nil
+	for _, server := range config.Servers {
+		removeServer(server.ID)
+		server.registerMap = buildRegisterMap(server.RegisterBlocks)
+		server.dataModel = ModbusDataModel{}
+		server.ConnectionStatus = "error" // default to error until connected
+		server.ConnectionError = ""
+		server.LastDataReceived = time.Time{}
+		startServer(server)
+		s.started = append(s.started, server)
+	}
+
+	now := time.Now()
+	s.role = "active"
+	s.takenOver = now
+	message := fmt.Sprintf("Primary %s unreachable for %s; this standby took over polling %d servers",
+		s.primary, now.Sub(s.lastContact).Round(time.Second), len(s.started))
+	logMessage(ErrorLevel, "%s", message)
+	events.publish(Event{Type: "standby-takeover", Message: message})
+}
+
+// handBack stops polling the servers started on takeover, now that the
+// primary answers again. The caller must hold s.mu.
+func (s *standbyState) handBack() {
+	for _, server := range s.started {
+		mu.Lock()
+		if servers[server.ID] == server {
+			delete(servers, server.ID)
+		}
+		mu.Unlock()
+		server.mu.Lock()
+		if server.client != nil {
+			server.client.Close()
+			server.client = nil
+		}
+		server.mu.Unlock()
+	}
+
+	message := fmt.Sprintf("Primary %s is back; this standby handed back polling of %d servers", s.primary, len(s.started))
+	s.started = nil
+	s.role = "standby"
+	s.takenOver = time.Time{}
+	logMessage(ErrorLevel, "%s", message)
+	events.publish(Event{Type: "standby-resumed", Message: message})
+}
+
+// removeServer stops polling a server and removes it, if it exists
+func removeServer(id string) {
+	mu.Lock()
+	server, exists := servers[id]
+	delete(servers, id)
+	mu.Unlock()
+	if !exists {
+		return
+	}
+	server.mu.Lock()
+	if server.client != nil {
+		server.client.Close()
+		server.client = nil
+	}
+	server.mu.Unlock()
+}
+
+// handleStandby returns the replication state of a hot standby on GET /api/standby
+func handleStandby(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	s := standby
+	mu.RUnlock()
+	if s == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"enabled": false,
+		})
+		return
+	}
+
+	s.mu.Lock()
+	response := map[string]interface{}{
+		"success":      true,
+		"enabled":      true,
+		"primary":      s.primary,
+		"role":         s.role,
+		"lastContact":  s.lastContact,
+		"configSynced": s.configSynced,
+		"servers":      s.servers,
+	}
+	if s.lastError != "" {
+		response["lastError"] = s.lastError
+	}
+	if !s.takenOver.IsZero() {
+		response["takenOver"] = s.takenOver
+	}
+	s.mu.Unlock()
+	if s.replica != nil {
+		s.replica.mu.RLock()
+		response["historySeries"] = len(s.replica.series)
+		s.replica.mu.RUnlock()
+	}
+	json.NewEncoder(w).Encode(response)
+}
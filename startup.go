@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// issueList formats configuration issues one per line, with their line
+// numbers, for the log
+func issueList(issues []ConfigIssue) string {
+	lines := make([]string, len(issues))
+	for i, issue := range issues {
+		switch {
+		case issue.Line > 0:
+			lines[i] = fmt.Sprintf("  line %d: %s: %s", issue.Line, issue.Path, issue.Message)
+		case issue.Path != "":
+			lines[i] = fmt.Sprintf("  %s: %s", issue.Path, issue.Message)
+		default:
+			lines[i] = "  " + issue.Message
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadStartupConfig applies the configuration file given with -config, as an
+// upload to /api/config/upload would, so that a headless instance polls its
+// servers from the start. ${NAME} placeholders are resolved from the file and
+// the environment. The file is checked like /api/config/validate does, and
+// is not applied at all if it has errors. Servers connect in the background,
+// so devices that do not answer do not hold up the others or the web UI.
+func loadStartupConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	data, errs := substituteVariables(data, nil, true)
+	var warnings []ConfigIssue
+	if len(errs) == 0 {
+		errs, warnings = validateConfig(data)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s is not valid:\n%s", path, issueList(errs))
+	}
+	if len(warnings) > 0 {
+		log.Printf("Warnings for %s:\n%s", path, issueList(warnings))
+	}
+
+	data, _, err = migrateConfig(data)
+	if err != nil {
+		return err
+	}
+	var config ConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	startConfig(config, nil)
+	log.Printf("Loaded %s: polling %d servers", path, len(config.Servers))
+	return nil
+}
+
+// startConfig applies a configuration read at startup and starts polling its
+// servers. prepare, if not nil, is called for each server before it starts.
+func startConfig(config ConfigFile, prepare func(server *ModbusServer)) {
+	// Register map templates must be in place before the servers connect
+	mapTemplatesMu.Lock()
+	for name, template := range config.Templates {
+		mapTemplates[name] = template
+	}
+	mapTemplatesMu.Unlock()
+	startExportJobs(config.Exports, true)
+	startMirrors(config.Mirrors)
+	startHooks(config.Hooks, true)
+
+	for _, server := range config.Servers {
+		server.registerMap = buildRegisterMap(server.RegisterBlocks)
+		server.dataModel = ModbusDataModel{}
+		server.ConnectionStatus = "error" // default to error until connected
+		if prepare != nil {
+			prepare(server)
+		}
+		go startServer(server)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// staticFS holds the files served under /static/ and the index page: the
+// embedded ones, with those of -static-dir laid over them
+var staticFS fs.FS = staticFiles
+
+// overlayFS serves the files of dir in place of those under static/ in base,
+// and falls back to base for files dir does not have
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+// Open opens a file of dir if it has one by that name, or else of base
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if rest, ok := strings.CutPrefix(name, "static/"); ok {
+		f, err := o.dir.Open(rest)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+// setStaticDir lays the files of a directory over the embedded static files,
+// so a logo, custom.css and custom.js can brand the UI
+func setStaticDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	staticFS = overlayFS{dir: os.DirFS(dir), base: staticFiles}
+	return nil
+}
@@ -0,0 +1 @@
+// Replaced by custom.js in the -static-dir directory to extend the UI
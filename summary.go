@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throughputWindow is the period poll throughput is averaged over
+const throughputWindow = 10
+
+// staleAfterPolls is the number of poll periods without data after which a connected server is stale
+const staleAfterPolls = 3
+
+// summaryTemplate renders the fleet status bar
+const summaryTemplate = `
+{{define "summary"}}
+<div class="d-flex flex-wrap align-items-center gap-2">
+	<strong class="me-2">{{.Total}} {{t "servers"}}</strong>
+	<button class="btn btn-sm {{if eq .Filter ""}}btn-dark{{else}}btn-outline-dark{{end}}" onclick="filterServers('')">{{t "All"}}</button>
+	<button class="btn btn-sm {{if eq .Filter "ok"}}btn-success{{else}}btn-outline-success{{end}}" onclick="filterServers('ok')">{{t "Connected"}}: {{.Connected}}</button>
+	<button class="btn btn-sm {{if eq .Filter "error"}}btn-danger{{else}}btn-outline-danger{{end}}" onclick="filterServers('error')">{{t "Error"}}: {{.Error}}</button>
+	<button class="btn btn-sm {{if eq .Filter "stale"}}btn-warning{{else}}btn-outline-warning{{end}}" onclick="filterServers('stale')">{{t "Stale"}}: {{.Stale}}</button>
+	<button class="btn btn-sm {{if eq .Filter "alarm"}}btn-danger{{else}}btn-outline-danger{{end}}" onclick="filterServers('alarm')">{{t "Alarms"}}: {{.ActiveAlarms}}</button>
+	{{if .Disconnected}}<button class="btn btn-sm {{if eq .Filter "disconnected"}}btn-secondary{{else}}btn-outline-secondary{{end}}" onclick="filterServers('disconnected')">{{t "Disconnected"}}: {{.Disconnected}}</button>{{end}}
+	<small class="text-muted ms-2">{{t "Throughput"}}: {{printf "%.1f" .ReadsPerSecond}} {{t "reads/s"}}, {{printf "%.0f" .RegistersPerSecond}} {{t "registers/s"}}</small>
+	<a class="small ms-2" href="/api/availability/report" target="_blank">{{t "Availability"}}</a>
+</div>
+{{end}}`
+
+// rateCounter counts events in one-second buckets over the last throughputWindow seconds
+type rateCounter struct {
+	mu      sync.Mutex
+	buckets [throughputWindow]struct {
+		second int64
+		reads  int
+		values int
+	}
+}
+
+// pollThroughput counts successful block reads of all servers
+var pollThroughput = &rateCounter{}
+
+// add records a successful read of n values
+func (c *rateCounter) add(n int) {
+	now := time.Now().Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket := &c.buckets[now%throughputWindow]
+	if bucket.second != now {
+		bucket.second = now
+		bucket.reads = 0
+		bucket.values = 0
+	}
+	bucket.reads++
+	bucket.values += n
+}
+
+// rates returns the average reads and values per second over the window,
+// excluding the current, incomplete second
+func (c *rateCounter) rates() (reads, values float64) {
+	now := time.Now().Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, bucket := range c.buckets {
+		if bucket.second < now && bucket.second >= now-throughputWindow {
+			reads += float64(bucket.reads)
+			values += float64(bucket.values)
+		}
+	}
+	return reads / throughputWindow, values / throughputWindow
+}
+
+// FleetSummary is the aggregate status of all servers
+type FleetSummary struct {
+	Total              int     `json:"total"`
+	Connected          int     `json:"connected"`
+	Error              int     `json:"error"`
+	Stale              int     `json:"stale"`
+	Disconnected       int     `json:"disconnected"`
+	ActiveAlarms       int     `json:"activeAlarms"`
+	AlarmServers       int     `json:"alarmServers"` // servers with active alarms
+	ReadsPerSecond     float64 `json:"readsPerSecond"`
+	RegistersPerSecond float64 `json:"registersPerSecond"`
+	Filter             string  `json:"-"`
+}
+
+// state classifies a server as "ok", "error", "stale" (connected but not
+// receiving data, unless polling is paused) or "disconnected" (on purpose).
+// The caller must hold s.mu.
+func (s *ModbusServer) state() string {
+	if s.ConnectionStatus == connectionDisconnected {
+		return connectionDisconnected
+	}
+	if s.ConnectionStatus != "ok" {
+		return "error"
+	}
+	staleAfter := time.Duration(staleAfterPolls*s.PollRate) * time.Millisecond
+	if !s.Paused && len(s.RegisterBlocks) > 0 && time.Since(s.LastDataReceived) > staleAfter {
+		return "stale"
+	}
+	return "ok"
+}
+
+// matchesFilter reports whether a server is in the given state, or has
+// active alarms for "alarm". The caller must hold s.mu.
+func (s *ModbusServer) matchesFilter(filter string) bool {
+	if filter == "alarm" {
+		return len(s.alarms) > 0
+	}
+	return s.state() == filter
+}
+
+// handleSummary serves the aggregate status of all servers
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	summary := FleetSummary{Filter: r.URL.Query().Get("status")}
+
+	mu.RLock()
+	for _, server := range servers {
+		server.mu.Lock()
+		switch server.state() {
+		case "ok":
+			summary.Connected++
+		case "stale":
+			summary.Stale++
+		case connectionDisconnected:
+			summary.Disconnected++
+		default:
+			summary.Error++
+		}
+		summary.ActiveAlarms += len(server.alarms)
+		if len(server.alarms) > 0 {
+			summary.AlarmServers++
+		}
+		server.mu.Unlock()
+		summary.Total++
+	}
+	mu.RUnlock()
+
+	summary.ReadsPerSecond, summary.RegistersPerSecond = pollThroughput.rates()
+
+	if isHtmxRequest(r) {
+		w.Header().Set("Content-Type", "text/html")
+		if err := templatesFor(r).ExecuteTemplate(w, "summary", summary); err != nil {
+			handleError(w, r, fmt.Sprintf("Error executing template: %v", err))
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"summary": summary,
+	})
+}
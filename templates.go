@@ -5,15 +5,20 @@ import (
 	"net/http"
 )
 
-// ServeIndex serves the main index page
+// ServeIndex serves the main index page in the language of the user
 func ServeIndex(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFS(staticFiles, "static/index.html")
+	lang := requestLanguage(r)
+	tmpl, err := template.New("index.html").Funcs(translateFuncs(lang)).ParseFS(staticFS, "static/index.html")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = tmpl.Execute(w, nil)
+	err = tmpl.Execute(w, map[string]interface{}{
+		"Lang":      lang,
+		"Languages": languages(),
+		"Catalog":   catalogURL != "",
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Response timeouts of a server in milliseconds. The default suits slow
+// serial gateways; devices on a fast LAN are declared dead sooner with a
+// shorter one.
+const (
+	defaultTimeout = 10000
+	minTimeout     = 10
+	maxTimeout     = 60000
+)
+
+// timeout returns how long the server waits for a connection or response.
+// The caller must hold s.mu.
+func (s *ModbusServer) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return defaultTimeout * time.Millisecond
+	}
+	return time.Duration(s.Timeout) * time.Millisecond
+}
+
+// checkTimeout returns an error if a server timeout in milliseconds is out of range
+func checkTimeout(timeout int) error {
+	if timeout != 0 && (timeout < minTimeout || timeout > maxTimeout) {
+		return fmt.Errorf("timeout %d ms is out of range (%d to %d ms, or 0 for the default of %d ms)", timeout, minTimeout, maxTimeout, defaultTimeout)
+	}
+	return nil
+}
+
+// timeoutDevice is implemented by devices whose timeout can be changed while
+// they are connected
+type timeoutDevice interface {
+	setTimeout(timeout time.Duration)
+}
+
+// setDeviceTimeout changes the timeout of a connected device, and reports
+// whether its protocol supports it
+func setDeviceTimeout(device Device, timeout time.Duration) bool {
+	d, ok := device.(timeoutDevice)
+	if ok {
+		d.setTimeout(timeout)
+	}
+	return ok
+}
+
+func (d *gatewayDevice) setTimeout(timeout time.Duration) {
+	setDeviceTimeout(d.Device, timeout)
+}
+
+func (d *trackedDevice) setTimeout(timeout time.Duration) {
+	setDeviceTimeout(d.Device, timeout)
+}
+
+// handleTimeout returns the response timeout of a server on GET, and sets it
+// on PUT or POST /api/servers/{id}/timeout with {"timeout": 500} in
+// milliseconds, 0 for the default. The new timeout applies to the next
+// request without reconnecting.
+func handleTimeout(w http.ResponseWriter, r *http.Request, id string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var request struct {
+			Timeout int `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			handleError(w, r, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if err := checkTimeout(request.Timeout); err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		server.mu.Lock()
+		server.Timeout = request.Timeout
+		if server.client != nil {
+			setDeviceTimeout(server.client, server.timeout())
+		}
+		server.mu.Unlock()
+		logMessage(InfoLevel, "Set timeout of server %s to %d ms", id, request.Timeout)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	timeout := server.timeout()
+	server.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"timeout": timeout.Milliseconds(),
+	})
+}
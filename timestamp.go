@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // timezones on hosts without a timezone database, such as Windows
+)
+
+// timestampLayout is how decoded dates and times are shown
+const timestampLayout = "2006-01-02 15:04:05 MST"
+
+// timestampFormats lists the formats decoded to a date and time:
+//
+//   - unix-time: seconds since 1970-01-01 UTC, as a uint32 in two registers
+//   - datetime-bcd: wall clock in three registers of BCD digits, YY MM, DD hh
+//     and mm ss, the year counted from 2000
+//   - datetime-iec: wall clock in the four registers of IEC 60870-5, used by
+//     many power meters: the year from 2000 in bits 0-6, the month in bits
+//     8-11 and the day in bits 0-4, the hour in bits 8-12 and the minute in
+//     bits 0-5, and the milliseconds of the minute
+//   - datetime-words: wall clock in six registers, one per field: year,
+//     month, day, hour, minute and second
+//
+// Wall clock formats carry no timezone and are read in the timezone of the
+// server, in which all of them are also shown.
+var timestampFormats = map[string]bool{
+	"unix-time":      true,
+	"datetime-bcd":   true,
+	"datetime-iec":   true,
+	"datetime-words": true,
+}
+
+// timestamp is a decoded date and time, which prints in timestampLayout
+type timestamp struct {
+	time.Time
+}
+
+func (t timestamp) String() string {
+	return t.Format(timestampLayout)
+}
+
+// decodeTimestamp decodes the big-endian words of a register in a timestamp
+// format. Wall clock formats are decoded in UTC, to be placed in the
+// timezone of the server by inLocation. The value is "N/A" if words are
+// missing and "invalid" if they do not hold a date, such as a clock that was
+// never set.
+func decodeTimestamp(format string, words []uint16) interface{} {
+	if len(words) < registerWordCount(RegisterConfig{Format: format}) {
+		return "N/A"
+	}
+	var year, month, day, hour, minute, second, nsec int
+	switch format {
+	case "unix-time":
+		return timestamp{time.Unix(int64(uint32(words[0])<<16|uint32(words[1])), 0).UTC()}
+	case "datetime-bcd":
+		var digits [6]int
+		for i, word := range words[:3] {
+			hi, okHi := fromBCD(byte(word >> 8))
+			lo, okLo := fromBCD(byte(word))
+			if !okHi || !okLo {
+				return "invalid"
+			}
+			digits[2*i], digits[2*i+1] = hi, lo
+		}
+		year, month, day, hour, minute, second = 2000+digits[0], digits[1], digits[2], digits[3], digits[4], digits[5]
+	case "datetime-iec":
+		year = 2000 + int(words[0]&0x7F)
+		month, day = int(words[1]>>8&0x0F), int(words[1]&0x1F)
+		hour, minute = int(words[2]>>8&0x1F), int(words[2]&0x3F)
+		if words[3] >= 60000 {
+			return "invalid"
+		}
+		second, nsec = int(words[3]/1000), int(words[3]%1000)*int(time.Millisecond)
+	case "datetime-words":
+		year, month, day = int(words[0]), int(words[1]), int(words[2])
+		hour, minute, second = int(words[3]), int(words[4]), int(words[5])
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nsec, time.UTC)
+	// time.Date normalizes fields out of range, e.g. the 31st of April to
+	// the 1st of May; such fields are not a date
+	if t.Month() != time.Month(month) || t.Day() != day || t.Hour() != hour || t.Minute() != minute || t.Second() != second {
+		return "invalid"
+	}
+	return timestamp{t}
+}
+
+// fromBCD returns the value of a byte of two BCD digits
+func fromBCD(b byte) (int, bool) {
+	if b>>4 > 9 || b&0x0F > 9 {
+		return 0, false
+	}
+	return int(b>>4)*10 + int(b&0x0F), true
+}
+
+// inLocation places a value decoded by decodeRegister in a timezone: the
+// instant of a Unix time is shown in it and the wall clock of the other
+// timestamp formats is read in it. Other values are returned unchanged.
+func inLocation(reg RegisterConfig, value interface{}, loc *time.Location) interface{} {
+	t, ok := value.(timestamp)
+	if !ok {
+		return value
+	}
+	if reg.Format == "unix-time" {
+		return timestamp{t.In(loc)}
+	}
+	return timestamp{time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)}
+}
+
+// locations caches the timezones loaded by loadLocation
+var locations sync.Map
+
+// loadLocation returns the timezone of an IANA name such as
+// "Europe/Berlin", or the local timezone of the host if the name is empty
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q (must be an IANA name such as Europe/Berlin or UTC)", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// location returns the timezone dates and times of the server are shown in;
+// the local timezone of the host if it has none or an unknown one
+func (s *ModbusServer) location() *time.Location {
+	loc, err := loadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// encodeTimestamp converts a date and time to the big-endian words of a
+// register in a timestamp format. A Unix time takes seconds or a time with
+// its offset, e.g. 2024-05-01T12:00:00+02:00; the wall clock formats take
+// the time as the device shows it, e.g. 2024-05-01 12:00:00.
+func encodeTimestamp(format, text string) ([]uint16, error) {
+	if format == "unix-time" {
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return encodeInteger(RegisterConfig{Format: "uint32"}, text)
+		}
+		if t.Unix() < 0 || t.Unix() > 1<<32-1 {
+			return nil, fmt.Errorf("%s is out of range of a unix-time", text)
+		}
+		return []uint16{uint16(t.Unix() >> 16), uint16(t.Unix())}, nil
+	}
+
+	t, err := time.Parse(time.DateTime, strings.Replace(text, "T", " ", 1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q (must be a date and time such as 2024-05-01 12:00:00)", format, text)
+	}
+	year := t.Year() - 2000
+	switch format {
+	case "datetime-bcd":
+		if year < 0 || year > 99 {
+			return nil, fmt.Errorf("year %d is out of range of %s (2000 to 2099)", t.Year(), format)
+		}
+		return []uint16{
+			toBCD(year)<<8 | toBCD(int(t.Month())),
+			toBCD(t.Day())<<8 | toBCD(t.Hour()),
+			toBCD(t.Minute())<<8 | toBCD(t.Second()),
+		}, nil
+	case "datetime-iec":
+		if year < 0 || year > 127 {
+			return nil, fmt.Errorf("year %d is out of range of %s (2000 to 2127)", t.Year(), format)
+		}
+		return []uint16{
+			uint16(year),
+			uint16(t.Month())<<8 | uint16(t.Day()),
+			uint16(t.Hour())<<8 | uint16(t.Minute()),
+			uint16(t.Second() * 1000),
+		}, nil
+	default: // datetime-words
+		return []uint16{uint16(t.Year()), uint16(t.Month()), uint16(t.Day()), uint16(t.Hour()), uint16(t.Minute()), uint16(t.Second())}, nil
+	}
+}
+
+// toBCD returns the two BCD digits of a number from 0 to 99
+func toBCD(n int) uint16 {
+	return uint16(n/10<<4 | n%10)
+}
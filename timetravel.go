@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxSampleAge is how old the last sample before a point in time may be for
+// its value to count as the value at that time. Older samples mean the server
+// was not receiving data, and the value is unknown.
+const maxSampleAge = 5 * historySampleInterval
+
+// at returns the last sample taken at or before t, and when the value last
+// changed before it, zero if it did not change while kept
+func (s *historySeries) at(t time.Time) (historySample, time.Time, bool) {
+	var last historySample
+	var changed time.Time
+	found := false
+	s.each(func(sample historySample) {
+		if sample.t.After(t) {
+			return
+		}
+		if found && sample.v != last.v {
+			changed = sample.t
+		}
+		last, found = sample, true
+	})
+	return last, changed, found && t.Sub(last.t) <= maxSampleAge
+}
+
+// checkTime returns an error if the history has no samples from t, because
+// it lies in the future or before the retention
+func (h *historyStore) checkTime(t time.Time) error {
+	now := time.Now()
+	switch {
+	case t.After(now):
+		return fmt.Errorf("time %s is in the future", t.Format(time.RFC3339))
+	case now.Sub(t) > h.retention:
+		return fmt.Errorf("time %s is older than the history kept (-history-retention %s)", t.Format(time.RFC3339), h.retention)
+	}
+	return nil
+}
+
+// historicalData replaces the values of the register rows of a server with
+// those the historian recorded at t, colored by the server's registers. Registers without a sample, because
+// they are not configured, not numeric or were not being read at the time,
+// get no value and the quality "missing". The raw words are not kept, so
+// they are left out.
+func (h *historyStore) historicalData(serverID string, registers map[uint16]RegisterConfig, data []map[string]interface{}, t time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, row := range data {
+		row["Raw"], row["Hex"] = nil, nil
+		addr := row["Address"].(uint16)
+		series, ok := h.series[historyKey{serverID, addr}]
+		var sample historySample
+		var changed time.Time
+		if ok {
+			sample, changed, ok = series.at(t)
+		}
+		if !ok {
+			row["Value"], row["Quality"], row["LastChange"] = nil, "missing", time.Time{}
+			setColor(row, nil)
+			setState(row, nil)
+			setBits(row, RegisterConfig{})
+			continue
+		}
+
+		// Keep the type of the live value, so booleans stay booleans
+		switch row["Value"].(type) {
+		case bool:
+			row["Value"] = sample.v != 0
+		case uint16:
+			row["Value"] = uint16(sample.v)
+		case int16:
+			row["Value"] = int16(sample.v)
+		case uint32:
+			row["Value"] = uint32(sample.v)
+		case int32:
+			row["Value"] = int32(sample.v)
+		case uint64:
+			row["Value"] = uint64(sample.v)
+		case int64:
+			row["Value"] = int64(sample.v)
+		case float32:
+			row["Value"] = float32(sample.v)
+		default:
+			row["Value"] = sample.v
+		}
+		row["Quality"] = "good"
+		expectedMin, expectedMax := row["ExpectedMin"].(*float64), row["ExpectedMax"].(*float64)
+		if (expectedMin != nil && sample.v < *expectedMin) || (expectedMax != nil && sample.v > *expectedMax) {
+			row["Quality"] = "suspect"
+		}
+		row["LastChange"] = changed
+		setColor(row, registers[addr].Colors)
+		setState(row, registers[addr].Enum)
+		setBits(row, registers[addr])
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rustyoz/modbus"
+)
+
+// defaultUnitID is the unit (slave) ID requests are sent to, unless the
+// block holding their addresses names another
+const defaultUnitID = 1
+
+// unitIDDevice is implemented by devices whose requests carry a unit ID that
+// can be changed while they are connected
+type unitIDDevice interface {
+	setUnitID(id byte)
+}
+
+// setUnitID changes the unit ID of the next requests
+func (c *ModbusClient) setUnitID(id byte) {
+	switch h := c.handler.(type) {
+	case *modbus.TCPClientHandler:
+		h.SlaveId = id
+	case *sourceHandler:
+		h.mu.Lock()
+		h.SlaveId = id
+		h.mu.Unlock()
+	case *rtuOverTCPHandler:
+		h.mu.Lock()
+		h.SlaveId = id
+		h.mu.Unlock()
+	}
+}
+
+// unitID returns the unit ID of a block
+func (b RegisterBlock) unitID() byte {
+	if b.UnitID != nil {
+		return *b.UnitID
+	}
+	return defaultUnitID
+}
+
+// addressUnit returns the unit ID of the block holding an address. The
+// caller must hold s.mu.
+func (s *ModbusServer) addressUnit(addr uint16) byte {
+	for _, block := range s.RegisterBlocks {
+		if addr >= block.StartAddress && int(addr) < int(block.StartAddress)+int(block.Length) {
+			return block.unitID()
+		}
+	}
+	return defaultUnitID
+}
+
+// requestUnit returns the unit ID of a request for quantity addresses from
+// first, and an error if they belong to blocks of different units. The
+// caller must hold s.mu.
+func (s *ModbusServer) requestUnit(first uint16, quantity int) (byte, error) {
+	id := s.addressUnit(first)
+	for _, block := range s.RegisterBlocks {
+		overlaps := int(block.StartAddress) < int(first)+quantity && int(block.StartAddress)+int(block.Length) > int(first)
+		if overlaps && block.unitID() != id {
+			return 0, fmt.Errorf("addresses %d to %d belong to blocks of unit IDs %d and %d and cannot be accessed in one request", first, int(first)+quantity-1, id, block.unitID())
+		}
+	}
+	return id, nil
+}
+
+// unitDevice sends each request to the unit ID of the register block
+// holding its addresses, for bridges that reach several devices under one
+// IP address. Like every Device, it is only used while the server's lock is
+// held, so it reads the server's blocks directly.
+type unitDevice struct {
+	Device
+	server *ModbusServer
+}
+
+// use sets the unit ID of a request for quantity addresses from first, an
+// address of the register model
+func (d *unitDevice) use(first uint16, quantity int) error {
+	id, err := d.server.requestUnit(first, quantity)
+	if err != nil {
+		return err
+	}
+	d.Device.(unitIDDevice).setUnitID(id)
+	return nil
+}
+
+func (d *unitDevice) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	if err := d.use(address, int(quantity)); err != nil {
+		return nil, err
+	}
+	return d.Device.ReadCoils(address, quantity)
+}
+
+func (d *unitDevice) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	if err := d.use(address+10000, int(quantity)); err != nil {
+		return nil, err
+	}
+	return d.Device.ReadDiscreteInputs(address, quantity)
+}
+
+func (d *unitDevice) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	if err := d.use(address+30000, int(quantity)); err != nil {
+		return nil, err
+	}
+	return d.Device.ReadInputRegisters(address, quantity)
+}
+
+func (d *unitDevice) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	if err := d.use(address+40000, int(quantity)); err != nil {
+		return nil, err
+	}
+	return d.Device.ReadHoldingRegisters(address, quantity)
+}
+
+func (d *unitDevice) WriteSingleCoil(address uint16, value bool) error {
+	if err := d.use(address, 1); err != nil {
+		return err
+	}
+	return d.Device.WriteSingleCoil(address, value)
+}
+
+func (d *unitDevice) WriteMultipleCoils(address uint16, values []bool) error {
+	if err := d.use(address, len(values)); err != nil {
+		return err
+	}
+	return d.Device.WriteMultipleCoils(address, values)
+}
+
+func (d *unitDevice) WriteSingleRegister(address uint16, value uint16) error {
+	if err := d.use(address+40000, 1); err != nil {
+		return err
+	}
+	return d.Device.WriteSingleRegister(address, value)
+}
+
+func (d *unitDevice) WriteMultipleRegisters(address uint16, values []uint16) error {
+	if err := d.use(address+40000, len(values)); err != nil {
+		return err
+	}
+	return d.Device.WriteMultipleRegisters(address, values)
+}
+
+// readDeviceIdentification asks the server's own unit, not that of a block
+func (d *unitDevice) readDeviceIdentification() (*DeviceIdentification, error) {
+	d.Device.(unitIDDevice).setUnitID(defaultUnitID)
+	return readDeviceIdentification(d.Device)
+}
+
+func (d *unitDevice) setTimeout(timeout time.Duration) {
+	setDeviceTimeout(d.Device, timeout)
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// ConfigIssue describes a problem found while validating a configuration file
+type ConfigIssue struct {
+	Line    int    `json:"line,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// configValidator collects issues for a single configuration file
+type configValidator struct {
+	data     []byte
+	offsets  map[string]int64  // JSON path -> offset of its value
+	oids     map[string]string // SNMP OID below the base -> JSON path of the register using it
+	errors   []ConfigIssue
+	warnings []ConfigIssue
+}
+
+// configSchema is the JSON Schema of configuration files, served on GET
+// /api/config/schema
+//
+//go:embed schema/config.schema.json
+var configSchemaData []byte
+
+var configSchema = mustParseJSONSchema(configSchemaData)
+
+func mustParseJSONSchema(data []byte) *jsonSchema {
+	schema, err := parseJSONSchema(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded schema: %v", err))
+	}
+	return schema
+}
+
+// validateConfig checks a configuration file for syntax errors, then checks
+// it against configSchema after migrating it to the current version, and
+// finally applies the semantic rules the schema cannot express. Fields the
+// schema does not know are ignored when the file is loaded, so they are
+// reported as warnings.
+func validateConfig(data []byte) (errs []ConfigIssue, warnings []ConfigIssue) {
+	v := &configValidator{
+		data:    data,
+		offsets: make(map[string]int64),
+		oids:    make(map[string]string),
+	}
+
+	// Syntax, recording the line of every value
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := v.walk(dec, ""); err != nil {
+		v.addJSONError(err)
+		return v.errors, v.warnings
+	}
+
+	// Upgrade older formats, reporting deprecated content
+	migrated, migrationWarnings, err := migrateConfig(data)
+	if err != nil {
+		v.errors = append(v.errors, ConfigIssue{Path: "schemaVersion", Line: v.lineOf("schemaVersion"), Message: err.Error()})
+		return v.errors, v.warnings
+	}
+	for _, warning := range migrationWarnings {
+		v.warn(warning.Path, warning.Message)
+	}
+
+	// Structure
+	var doc interface{}
+	dec = json.NewDecoder(bytes.NewReader(migrated))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		v.addJSONError(err)
+		return v.errors, v.warnings
+	}
+	for _, issue := range configSchema.validate(doc) {
+		if issue.Unknown {
+			v.warn(issue.Path, issue.Message)
+		} else {
+			v.fail(issue.Path, issue.Message)
+		}
+	}
+	if len(v.errors) > 0 {
+		return v.errors, v.warnings
+	}
+
+	var config ConfigFile
+	if err := json.Unmarshal(migrated, &config); err != nil {
+		v.addJSONError(err)
+		return v.errors, v.warnings
+	}
+
+	// Semantics
+	v.checkServers(config.Servers)
+	v.checkTemplates(config.Templates)
+	v.checkExports(config.Exports)
+	v.checkMirrors(config.Mirrors)
+	v.checkHooks(config.Hooks)
+	if err := checkVariables(config.Variables); err != nil {
+		v.fail("variables", err.Error())
+	}
+
+	return v.errors, v.warnings
+}
+
+// walk records the offset of every value in the document, so that issues
+// can be reported with their line
+func (v *configValidator) walk(dec *json.Decoder, path string) error {
+	v.offsets[path] = v.skipSpace(dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			childPath := keyTok.(string)
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			if err := v.walk(dec, childPath); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := v.walk(dec, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+
+	return nil
+}
+
+// checkServers applies the semantic rules to the decoded servers
+func (v *configValidator) checkServers(serverList []*ModbusServer) {
+	if len(serverList) == 0 {
+		v.warn("servers", "configuration contains no servers")
+		return
+	}
+
+	seen := make(map[string]string)
+	for i, server := range serverList {
+		path := fmt.Sprintf("servers[%d]", i)
+		if server == nil {
+			v.fail(path, "server must be an object")
+			continue
+		}
+
+		switch {
+		case server.ID == "":
+			v.fail(path, "id is required")
+		case strings.ContainsAny(server.ID, "/?#"):
+			v.fail(path+".id", fmt.Sprintf("id %q must not contain '/', '?' or '#'", server.ID))
+		case seen[server.ID] != "":
+			v.fail(path+".id", fmt.Sprintf("duplicate server id %q (also used by %s)", server.ID, seen[server.ID]))
+		default:
+			seen[server.ID] = path
+		}
+
+		if server.Address == "" {
+			v.fail(path, "address is required")
+		} else if err := checkHost(server.Address); err != nil {
+			v.fail(path+".address", err.Error())
+		}
+		if server.BackupAddress != "" {
+			if err := checkHost(server.BackupAddress); err != nil {
+				v.fail(path+".backupAddress", err.Error())
+			}
+		}
+		if server.Port < 1 || server.Port > 65535 {
+			v.fail(path+".port", fmt.Sprintf("port %d must be between 1 and 65535", server.Port))
+		}
+		if server.PollRate <= 0 {
+			v.fail(path+".pollRate", fmt.Sprintf("pollRate %d must be greater than 0", server.PollRate))
+		}
+
+		if err := checkProtocol(server.Protocol); err != nil {
+			v.fail(path+".protocol", err.Error())
+		}
+		if err := checkColumns(server.Columns); err != nil {
+			v.fail(path+".columns", err.Error())
+		}
+		if server.BackupPort < 0 || server.BackupPort > 65535 {
+			v.fail(path+".backupPort", fmt.Sprintf("backupPort %d must be between 1 and 65535", server.BackupPort))
+		}
+		if server.BackupPort != 0 && server.BackupAddress == "" {
+			v.fail(path+".backupPort", "backupPort requires a backupAddress")
+		}
+		if server.SourceAddress != "" {
+			if _, err := sourceAddr(server.SourceAddress, server.Address); err != nil {
+				v.fail(path+".sourceAddress", err.Error())
+			}
+		}
+		if err := checkTimeout(server.Timeout); err != nil {
+			v.fail(path+".timeout", err.Error())
+		}
+		if err := checkChecklist(server.Checklist); err != nil {
+			v.fail(path+".checklist", err.Error())
+		}
+		if server.MaxBlockGap > 125 {
+			v.fail(path+".maxBlockGap", fmt.Sprintf("maxBlockGap %d must not be greater than 125", server.MaxBlockGap))
+		}
+		if err := checkConnectionMode(server.Connection); err != nil {
+			v.fail(path+".connection", err.Error())
+		}
+		if _, err := loadLocation(server.Timezone); err != nil {
+			v.fail(path+".timezone", err.Error())
+		}
+		if err := checkWritePolicySetting(server.WritePolicy); err != nil {
+			v.fail(path+".writePolicy", err.Error())
+		}
+
+		v.checkBlocks(path, server.RegisterBlocks)
+		if server.Protocol == "simulator" {
+			for i, block := range server.RegisterBlocks {
+				if block.UnitID != nil {
+					v.warn(fmt.Sprintf("%s.registerBlocks[%d].unitId", path, i), "the simulator has a single unit, so unitId is ignored")
+				}
+			}
+		}
+		v.checkOIDs(path, server)
+	}
+}
+
+// checkOIDs checks the SNMP OIDs of a server and its registers are valid and unique
+func (v *configValidator) checkOIDs(serverPath string, server *ModbusServer) {
+	var prefix []uint32
+	if server.OID != "" {
+		oid, err := parseOID(server.OID)
+		if err != nil {
+			v.fail(serverPath+".oid", err.Error())
+			return
+		}
+		prefix = oid
+	}
+
+	for i, block := range server.RegisterBlocks {
+		for j, reg := range block.Registers {
+			if reg.OID == "" {
+				continue
+			}
+			regPath := fmt.Sprintf("%s.registerBlocks[%d].registers[%d]", serverPath, i, j)
+			oid, err := parseOID(reg.OID)
+			if err != nil {
+				v.fail(regPath+".oid", err.Error())
+				continue
+			}
+			full := formatOID(append(append([]uint32{}, prefix...), oid...))
+			if other, used := v.oids[full]; used {
+				v.fail(regPath+".oid", fmt.Sprintf("OID %s is also used by %s", full, other))
+				continue
+			}
+			v.oids[full] = regPath
+		}
+	}
+}
+
+// checkBlocks applies the semantic rules to the register blocks of a server
+func (v *configValidator) checkBlocks(serverPath string, blocks []RegisterBlock) {
+	for i, block := range blocks {
+		path := fmt.Sprintf("%s.registerBlocks[%d]", serverPath, i)
+		end := int(block.StartAddress) + int(block.Length)
+
+		rangeEnd, ok := addressRangeEnd(block.StartAddress)
+		if !ok {
+			v.fail(path+".startAddress", fmt.Sprintf("startAddress %d is not in a valid address range", block.StartAddress))
+		} else if end-1 > int(rangeEnd) {
+			v.fail(path+".length", fmt.Sprintf("block %d-%d crosses the end of its address range at %d", block.StartAddress, end-1, rangeEnd))
+		}
+		if limit := maxBlockLength(block.StartAddress); block.Length < 1 || block.Length > limit {
+			v.fail(path+".length", fmt.Sprintf("length %d must be between 1 and %d", block.Length, limit))
+		}
+
+		for j, other := range blocks[:i] {
+			if int(block.StartAddress) < int(other.StartAddress)+int(other.Length) && int(other.StartAddress) < end {
+				v.warn(path, fmt.Sprintf("block overlaps registerBlocks[%d]", j))
+			}
+		}
+
+		for j, reg := range block.Registers {
+			regPath := fmt.Sprintf("%s.registers[%d]", path, j)
+			if reg.Address < block.StartAddress || int(reg.Address) >= end {
+				v.fail(regPath+".address", fmt.Sprintf("register address %d is outside its block %d-%d", reg.Address, block.StartAddress, end-1))
+			} else if words := registerWordCount(reg); int(reg.Address)+words > end {
+				v.warn(regPath, fmt.Sprintf("format %q needs %d registers and runs past the end of its block", reg.Format, words))
+			}
+			if reg.Format != "" && !registerFormats[reg.Format] {
+				v.fail(regPath+".format", fmt.Sprintf("unknown format %q", reg.Format))
+			}
+			if reg.ByteOrder != "" && !byteOrders[reg.ByteOrder] {
+				v.fail(regPath+".byteOrder", fmt.Sprintf("unknown byte order %q (must be ABCD, CDAB, BADC or DCBA)", reg.ByteOrder))
+			}
+			if (reg.Format == "string-byte" || reg.Format == "string-word") && reg.StringLength < 1 {
+				v.fail(regPath+".stringLength", fmt.Sprintf("format %q requires a stringLength greater than 0", reg.Format))
+			}
+			if reg.ExpectedMin != nil && reg.ExpectedMax != nil && *reg.ExpectedMin > *reg.ExpectedMax {
+				v.fail(regPath, "expectedMin must not be greater than expectedMax")
+			}
+			if reg.AlarmLow != nil && reg.AlarmHigh != nil && *reg.AlarmLow > *reg.AlarmHigh {
+				v.fail(regPath, "alarmLow must not be greater than alarmHigh")
+			}
+			if (reg.AlarmLow != nil || reg.AlarmHigh != nil) && reg.Address < 30000 {
+				v.fail(regPath, "alarm limits need an input or holding register")
+			}
+			if err := checkFilter(reg); err != nil {
+				v.fail(regPath, err.Error())
+			}
+			if reg.ExpectedUpdate < 0 {
+				v.fail(regPath+".expectedUpdate", "expectedUpdate must not be negative")
+			}
+			if err := checkPulse(reg, reg.Pulse); err != nil {
+				v.fail(regPath+".pulse", err.Error())
+			}
+			if err := checkColorRules(reg.Colors); err != nil {
+				v.fail(regPath+".colors", err.Error())
+			}
+			if err := checkEnum(reg); err != nil {
+				v.fail(regPath+".enum", err.Error())
+			}
+			if err := checkBits(reg); err != nil {
+				v.fail(regPath+".bits", err.Error())
+			}
+			if reg.URL != "" {
+				if u, err := url.Parse(reg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					v.fail(regPath+".url", fmt.Sprintf("url %q must be an http or https URL", reg.URL))
+				}
+			}
+			if reg.Generator != nil {
+				if err := checkGenerator(reg); err != nil {
+					v.fail(regPath+".generator", err.Error())
+				}
+			}
+			for k, reaction := range reg.Reactions {
+				if err := checkReaction(reaction); err != nil {
+					v.fail(fmt.Sprintf("%s.reactions[%d]", regPath, k), err.Error())
+				}
+			}
+			if len(reg.Reactions) > 0 && !(reg.Address < 10000 || reg.Address >= 40000) {
+				v.warn(regPath+".reactions", "only writes of coils and holding registers trigger reactions")
+			}
+			if reg.Parameter && !(reg.Address < 10000 || (reg.Address >= 40000 && reg.Address < 50000)) {
+				v.warn(regPath+".parameter", "only coils and holding registers can be restored as parameters")
+			}
+		}
+	}
+}
+
+// fail records an error for a JSON path
+func (v *configValidator) fail(path, message string) {
+	v.errors = append(v.errors, ConfigIssue{Line: v.lineOf(path), Path: path, Message: message})
+}
+
+// warn records a warning for a JSON path
+func (v *configValidator) warn(path, message string) {
+	v.warnings = append(v.warnings, ConfigIssue{Line: v.lineOf(path), Path: path, Message: message})
+}
+
+// addJSONError records a decoding error, with its line if known
+func (v *configValidator) addJSONError(err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		v.errors = append(v.errors, ConfigIssue{Line: v.lineAt(syntaxErr.Offset), Message: syntaxErr.Error()})
+	case errors.As(err, &typeErr):
+		v.errors = append(v.errors, ConfigIssue{
+			Line:    v.lineAt(typeErr.Offset),
+			Path:    typeErr.Field,
+			Message: fmt.Sprintf("expected %s but found %s", typeErr.Type, typeErr.Value),
+		})
+	default:
+		v.errors = append(v.errors, ConfigIssue{Message: err.Error()})
+	}
+}
+
+// lineOf returns the line of the closest recorded ancestor of path
+func (v *configValidator) lineOf(path string) int {
+	for {
+		if offset, ok := v.offsets[path]; ok {
+			return v.lineAt(offset)
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return 0
+		}
+		path = path[:i]
+	}
+}
+
+// lineAt converts a byte offset to a 1-based line number
+func (v *configValidator) lineAt(offset int64) int {
+	if offset > int64(len(v.data)) {
+		offset = int64(len(v.data))
+	}
+	return bytes.Count(v.data[:offset], []byte("\n")) + 1
+}
+
+// skipSpace advances offset past whitespace and separators to the start of the next token
+func (v *configValidator) skipSpace(offset int64) int64 {
+	for offset < int64(len(v.data)) && strings.IndexByte(" \t\r\n,:", v.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// jsonFields maps the JSON field names of a struct type to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// addressRangeEnd returns the last address of the data model range containing addr
+func addressRangeEnd(addr uint16) (uint16, bool) {
+	switch {
+	case addr < 10000: // Coils
+		return 9999, true
+	case addr < 20000: // Discrete Inputs
+		return 19999, true
+	case addr >= 30000 && addr < 40000: // Input Registers
+		return 39999, true
+	case addr >= 40000 && addr < 50000: // Holding Registers
+		return 49999, true
+	default:
+		return 0, false
+	}
+}
+
+// maxBlockLength returns the largest number of values a single read can return
+// from the table of addr: 2000 for coils and discrete inputs, 125 for registers
+func maxBlockLength(addr uint16) uint16 {
+	if addr < 20000 {
+		return 2000
+	}
+	return 125
+}
+
+// registerWordCount returns the number of consecutive registers a register's format consumes
+func registerWordCount(reg RegisterConfig) int {
+	switch reg.Format {
+	case "float", "uint32", "int32", "unix-time":
+		return 2
+	case "uint64", "int64", "double", "datetime-iec":
+		return 4
+	case "datetime-bcd":
+		return 3
+	case "datetime-words":
+		return 6
+	case "string-byte":
+		return (reg.StringLength + 1) / 2
+	case "string-word":
+		return reg.StringLength
+	default:
+		return 1
+	}
+}
+
+// handleConfigValidate checks an uploaded configuration file without applying it
+func handleConfigValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := readUploadBody(r, "config")
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	data, errs := substituteVariables(data, uploadVariables(r), false)
+	var warnings []ConfigIssue
+	if len(errs) == 0 {
+		errs, warnings = validateConfig(data)
+	}
+	if errs == nil {
+		errs = []ConfigIssue{}
+	}
+	if warnings == nil {
+		warnings = []ConfigIssue{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"valid":    len(errs) == 0,
+		"errors":   errs,
+		"warnings": warnings,
+	})
+}
+
+// handleConfigSchema serves the JSON Schema of configuration files on GET
+// /api/config/schema
+func handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(configSchemaData)
+}
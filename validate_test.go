@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkSchemaFields checks that a schema has a property for every JSON field
+// of a Go type and no others, recursing into nested structs
+func checkSchemaFields(t *testing.T, node interface{}, typ reflect.Type, path string) {
+	t.Helper()
+	schema, _ := node.(map[string]interface{})
+	if ref, ok := schema["$ref"].(string); ok {
+		def, err := configSchema.resolve(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		schema = def.(map[string]interface{})
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		if typ == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		fields := jsonFields(typ)
+		for name, fieldType := range fields {
+			property, ok := properties[name]
+			if !ok {
+				t.Errorf("%s: field %q of %s is missing from the schema", path, name, typ.Name())
+				continue
+			}
+			checkSchemaFields(t, property, fieldType, path+"."+name)
+		}
+		for name := range properties {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s: schema property %q is not a field of %s", path, name, typ.Name())
+			}
+		}
+	case reflect.Slice:
+		checkSchemaFields(t, schema["items"], typ.Elem(), path+"[]")
+	case reflect.Map:
+		checkSchemaFields(t, schema["additionalProperties"], typ.Elem(), path+"{}")
+	}
+}
+
+func TestConfigSchemaFields(t *testing.T) {
+	checkSchemaFields(t, configSchema.root, reflect.TypeOf(ConfigFile{}), "")
+}
+
+func TestConfigSchemaEnums(t *testing.T) {
+	defs := configSchema.root["$defs"].(map[string]interface{})
+	property := func(def string, names ...string) map[string]interface{} {
+		node := defs[def].(map[string]interface{})
+		for _, name := range names {
+			node = node["properties"].(map[string]interface{})[name].(map[string]interface{})
+		}
+		return node
+	}
+	tests := []struct {
+		name   string
+		schema map[string]interface{}
+		values map[string]bool
+	}{
+		{"format", property("register", "format"), registerFormats},
+		{"byteOrder", property("register", "byteOrder"), byteOrders},
+		{"filter", property("register", "filter"), registerFilters},
+		{"generator type", property("register", "generator", "type"), generatorTypes},
+		{"writePolicy", property("server", "writePolicy"), writePolicies},
+		{"connection", property("server", "connection"), connectionModes},
+		{"hook event", property("hook", "event"), hookEvents},
+	}
+	for _, tt := range tests {
+		var got, want []string
+		for _, value := range tt.schema["enum"].([]interface{}) {
+			if value != "" {
+				got = append(got, value.(string))
+			}
+		}
+		for value := range tt.values {
+			want = append(want, value)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: schema allows %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestParseJSONSchemaUnsupported(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "object", "oneOf": [{"type": "string"}]}`,
+		`{"properties": {"a": {"$ref": "#/$defs/missing"}}}`,
+		`{"items": {"$ref": "other.json"}}`,
+		`{"pattern": "("}`,
+	} {
+		if _, err := parseJSONSchema([]byte(schema)); err == nil {
+			t.Errorf("schema %s was accepted", schema)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		path    string // of the first error, or "" if the file is valid
+		message string
+		line    int
+	}{
+		{"valid", `{"schemaVersion": 1, "servers": [
+  {"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 1000,
+   "registerBlocks": [{"startAddress": 40001, "length": 2,
+     "registers": [{"address": 40001, "name": "Speed", "format": "float", "alarmHigh": 1500}]}]}]}`,
+			"", "", 0},
+		{"wrong type", `{"schemaVersion": 1, "servers": [
+  {"id": "plc", "address": "10.0.0.5",
+   "port": "502", "pollRate": 1000}]}`,
+			"servers[0].port", "expected integer but found string", 3},
+		{"fraction for an integer", `{"schemaVersion": 1, "servers": [{"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 2.5}]}`,
+			"servers[0].pollRate", "expected integer but found number", 1},
+		{"unknown format", `{"schemaVersion": 1, "servers": [{"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 1000,
+   "registerBlocks": [{"startAddress": 40001, "length": 1, "registers": [{"address": 40001, "format": "real"}]}]}]}`,
+			"servers[0].registerBlocks[0].registers[0].format", `"real" is not allowed`, 2},
+		{"missing id", `{"schemaVersion": 1, "servers": [{"address": "10.0.0.5", "port": 502, "pollRate": 1000}]}`,
+			"servers[0]", "id is required", 1},
+		{"out of range", `{"schemaVersion": 1, "servers": [{"id": "plc", "address": "10.0.0.5", "port": 70000, "pollRate": 1000}]}`,
+			"servers[0].port", "70000 must be at most 65535", 1},
+		{"variable name", `{"schemaVersion": 1, "servers": [], "variables": {"9SITE": "north"}}`,
+			"variables.9SITE", "does not match", 1},
+		{"duplicate id", `{"schemaVersion": 1, "servers": [
+  {"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 1000},
+  {"id": "plc", "address": "10.0.0.6", "port": 502, "pollRate": 1000}]}`,
+			"servers[1].id", "duplicate server id", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, _ := validateConfig([]byte(tt.config))
+			if tt.path == "" {
+				if len(errs) > 0 {
+					t.Fatalf("errors: %+v", errs)
+				}
+				return
+			}
+			if len(errs) == 0 {
+				t.Fatal("no errors")
+			}
+			if errs[0].Path != tt.path || !strings.Contains(errs[0].Message, tt.message) || errs[0].Line != tt.line {
+				t.Errorf("error = %+v, want %s: %s on line %d", errs[0], tt.path, tt.message, tt.line)
+			}
+		})
+	}
+}
+
+func TestValidateConfigUnknownField(t *testing.T) {
+	config := `{"schemaVersion": 1, "servers": [
+  {"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 1000,
+   "pollrate": 500}]}`
+	errs, warnings := validateConfig([]byte(config))
+	if len(errs) > 0 {
+		t.Fatalf("errors: %+v", errs)
+	}
+	if len(warnings) != 1 || warnings[0].Path != "servers[0].pollrate" || warnings[0].Line != 3 {
+		t.Errorf("warnings = %+v", warnings)
+	}
+}
+
+// TestValidateDownloadedConfig checks that a configuration as written by
+// /api/config passes its own schema
+func TestValidateDownloadedConfig(t *testing.T) {
+	low, high := 0.0, 100.0
+	unit := uint8(2)
+	config := ConfigFile{
+		SchemaVersion: currentConfigVersion,
+		Servers: []*ModbusServer{{
+			ID: "plc", Address: "10.0.0.5", Port: 502, PollRate: 1000,
+			ConnectionStatus: "ok", LastDataReceived: time.Now(),
+			RegisterBlocks: []RegisterBlock{{StartAddress: 40001, Length: 2, UnitID: &unit, Registers: []RegisterConfig{
+				{Address: 40001, Name: "Level", Format: "decimal", AlarmLow: &low, AlarmHigh: &high,
+					Colors: []ColorRule{{When: "> 90", Color: "red"}}, Enum: map[string]string{"0": "Empty"}},
+				{Address: 40002, Name: "Mode", Format: "bitfield", Bits: []BitConfig{{Bit: 0, Name: "Auto"}}},
+			}}},
+			Checklist: []ChecklistItem{{Text: "Wired", Done: true}},
+		}, {
+			ID: "empty", Address: "10.0.0.6", Port: 502, PollRate: 1000,
+		}},
+		Hooks: []HookConfig{{Name: "notify", Event: "reconnected", URL: "https://example.com/"}},
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, warnings := validateConfig(data)
+	if len(errs) > 0 || len(warnings) > 0 {
+		t.Errorf("errors %+v, warnings %+v", errs, warnings)
+	}
+}
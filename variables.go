@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderPattern matches a ${NAME} placeholder in a configuration value
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// variableNamePattern matches a valid variable name
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// uploadVariables returns the variables given with a configuration upload as
+// var.NAME form fields or query parameters
+func uploadVariables(r *http.Request) map[string]string {
+	r.ParseForm()
+	variables := make(map[string]string)
+	for key, values := range r.Form {
+		if name, ok := strings.CutPrefix(key, "var."); ok && len(values) > 0 {
+			variables[name] = values[0]
+		}
+	}
+	return variables
+}
+
+// variableSubstitution replaces the placeholders of a configuration file in
+// place, so that line numbers of later issues still match the file
+type variableSubstitution struct {
+	data   []byte
+	doc    *configValidator // for the line numbers of issues
+	lookup func(name string) (string, bool)
+	edits  []variableEdit
+	issues []ConfigIssue
+}
+
+// variableEdit replaces data[start:end] with text
+type variableEdit struct {
+	start, end int64
+	text       []byte
+}
+
+// substituteVariables resolves the ${NAME} placeholders in the string values
+// of a configuration file. A name is looked up in the variables given with
+// the upload, then in the file's "variables" section, then, for the trusted
+// file given with -config only, in the environment. Uploads never see the
+// environment, which holds credentials such as AWS_SECRET_ACCESS_KEY. A value made up of placeholders only may be used for a number
+// or boolean field, e.g. "port": "${PORT}". Placeholders that cannot be
+// resolved are returned as issues; a file that is not valid JSON is returned
+// unchanged for the usual syntax checks.
+func substituteVariables(data []byte, overrides map[string]string, environment bool) ([]byte, []ConfigIssue) {
+	var section struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.Unmarshal(data, &section); err != nil {
+		return data, nil
+	}
+
+	s := &variableSubstitution{
+		data: data,
+		doc:  &configValidator{data: data},
+		lookup: func(name string) (string, bool) {
+			if value, ok := overrides[name]; ok {
+				return value, true
+			}
+			if value, ok := section.Variables[name]; ok {
+				return value, true
+			}
+			if environment {
+				return os.LookupEnv(name)
+			}
+			return "", false
+		},
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := s.walk(dec, "", reflect.TypeOf(ConfigFile{})); err != nil {
+		return data, nil
+	}
+	if len(s.issues) > 0 || len(s.edits) == 0 {
+		return data, s.issues
+	}
+
+	var out bytes.Buffer
+	var last int64
+	for _, edit := range s.edits {
+		out.Write(data[last:edit.start])
+		out.Write(edit.text)
+		last = edit.end
+	}
+	out.Write(data[last:])
+	return out.Bytes(), nil
+}
+
+// walk substitutes the placeholders of the next JSON value, which is decoded
+// into type t
+func (s *variableSubstitution) walk(dec *json.Decoder, path string, t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Variables are not substituted within the section defining them
+	if path == "variables" {
+		var skipped json.RawMessage
+		return dec.Decode(&skipped)
+	}
+
+	start := s.doc.skipSpace(dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			var childType reflect.Type
+			if fields != nil {
+				childType = fields[key]
+			} else if t != nil && t.Kind() == reflect.Map {
+				childType = t.Elem()
+			}
+			if err := s.walk(dec, childPath, childType); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+
+	case json.Delim('['):
+		var elemType reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elemType = t.Elem()
+		}
+		for i := 0; dec.More(); i++ {
+			if err := s.walk(dec, fmt.Sprintf("%s[%d]", path, i), elemType); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+
+	if value, ok := tok.(string); ok && placeholderPattern.MatchString(value) {
+		s.substitute(path, value, t, start, dec.InputOffset())
+	}
+	return nil
+}
+
+// substitute records the edit resolving the placeholders of a string value
+// found at data[start:end]
+func (s *variableSubstitution) substitute(path, value string, t reflect.Type, start, end int64) {
+	var missing []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if !variableNamePattern.MatchString(name) {
+			missing = append(missing, fmt.Sprintf("invalid variable name %q", name))
+			return placeholder
+		}
+		resolved, ok := s.lookup(name)
+		if !ok {
+			missing = append(missing, fmt.Sprintf("undefined variable %s", name))
+		}
+		return resolved
+	})
+	if len(missing) > 0 {
+		s.issues = append(s.issues, ConfigIssue{Path: path, Line: s.doc.lineAt(start), Message: strings.Join(missing, "; ")})
+		return
+	}
+
+	var text []byte
+	kind := reflect.String
+	if t != nil {
+		kind = t.Kind()
+	}
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(expanded, 64); err != nil || !json.Valid([]byte(expanded)) {
+			s.issues = append(s.issues, ConfigIssue{Path: path, Line: s.doc.lineAt(start),
+				Message: fmt.Sprintf("%s resolves to %q, which is not a number", value, expanded)})
+			return
+		}
+		text = []byte(expanded)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(expanded)
+		if err != nil {
+			s.issues = append(s.issues, ConfigIssue{Path: path, Line: s.doc.lineAt(start),
+				Message: fmt.Sprintf("%s resolves to %q, which is not true or false", value, expanded)})
+			return
+		}
+		text = []byte(strconv.FormatBool(b))
+	default:
+		text, _ = json.Marshal(expanded)
+	}
+	s.edits = append(s.edits, variableEdit{start: start, end: end, text: text})
+}
+
+// checkVariables returns an error if the variables section has an invalid name
+func checkVariables(variables map[string]string) error {
+	for name := range variables {
+		if !variableNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q (letters, digits and underscores, not starting with a digit)", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is the release of this build, set when building with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// features lists the optional features and whether they are enabled on this
+// instance, set from the command line options at startup
+var features map[string]bool
+
+// buildInfo describes how the binary was built: the Go version and, when
+// built from a git checkout, the commit, its time and whether the tree had
+// uncommitted changes
+type buildInfo struct {
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// readBuildInfo returns the build information embedded by the Go toolchain
+func readBuildInfo() buildInfo {
+	info := buildInfo{GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// versionBanner returns the name and version shown at startup and in the
+// usage message, with the short commit if known
+func versionBanner() string {
+	banner := "Modbus Browser " + version
+	if revision := readBuildInfo().Revision; revision != "" {
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		banner += " (" + revision + ")"
+	}
+	return banner
+}
+
+// handleVersion returns the version, build information and enabled features
+// on GET /api/version, so fleet management scripts can check what is
+// deployed on each instance
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"version":  version,
+		"build":    readBuildInfo(),
+		"features": features,
+	})
+}
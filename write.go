@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// WriteRequest is the body of POST /api/servers/{id}/write. The value is
+// encoded according to the format configured for the register: a number or
+// hex string for decimal and hex, a number for float, a string for the
+// string formats and true/false or 1/0 for coils and booleans.
+type WriteRequest struct {
+	Address uint16      `json:"address"`
+	Value   interface{} `json:"value"`
+	Pulse   float64     `json:"pulse,omitempty"` // seconds to hold a coil on before switching it off, overriding the register's pulse
+	WriteAuthorization
+}
+
+// WritePlan is a validated write: the register it targets, the raw words it
+// sets and the Modbus request PDU that carries them
+type WritePlan struct {
+	Address      uint16   `json:"address"`
+	Name         string   `json:"name,omitempty"`
+	Format       string   `json:"format"`
+	FunctionCode byte     `json:"functionCode"`
+	Values       []uint16 `json:"values"`             // raw words; coils are a single 0 or 1
+	PDU          string   `json:"pdu"`                // hex bytes of the request, without the MBAP header
+	Critical     bool     `json:"critical,omitempty"` // subject to the server's write policy
+	Pulse        float64  `json:"pulse,omitempty"`    // seconds until the coil is switched off again
+}
+
+// handleWrite writes a single register or coil on POST /api/servers/{id}/write,
+// or with ?dryRun=true only validates it and returns the bytes that would be sent
+func handleWrite(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	var req WriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, fmt.Sprintf("Invalid write request: %v", err))
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	plan, err := planWrite(server, req)
+	if err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"dryRun":  dryRun,
+		"write":   plan,
+	}
+	if !dryRun {
+		if server.client == nil {
+			handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+			return
+		}
+		if err := checkWritePolicy(server, []WriteChange{{Address: plan.Address, Values: plan.Values}}, req.WriteAuthorization); err != nil {
+			handleWritePolicyError(w, r, err)
+			return
+		}
+		previous, _ := readParameter(server.client, plan.Address, len(plan.Values))
+		if plan.Pulse > 0 {
+			err := pulseWrite(server, plan)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			// The pulse leaves the register off
+			op := recordWrite(id, fmt.Sprintf("api pulse %gs", plan.Pulse), []WriteChange{{Address: plan.Address, Previous: previous, Values: make([]uint16, len(plan.Values))}}, 0)
+			response["writeId"] = op.ID
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		if err := writeParameter(server.client, ParameterValue{Address: plan.Address, Values: plan.Values}); err != nil {
+			handleError(w, r, fmt.Sprintf("Error writing address %d: %v", plan.Address, err))
+			return
+		}
+		op := recordWrite(id, "api", []WriteChange{{Address: plan.Address, Previous: previous, Values: plan.Values}}, 0)
+		response["writeId"] = op.ID
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// planWrite validates a write against the server's configuration and encodes
+// its value. The caller must hold the server's lock.
+func planWrite(server *ModbusServer, req WriteRequest) (*WritePlan, error) {
+	addr := req.Address
+	isCoil := addr < 10000
+	if !isCoil && (addr < 40000 || addr >= 50000) {
+		return nil, fmt.Errorf("address %d is not a coil or holding register", addr)
+	}
+
+	reg, hasConfig := server.registerMap[addr]
+	if !hasConfig {
+		reg = RegisterConfig{Address: addr}
+	}
+	// A pulse switches on, so it needs no value
+	if req.Value == nil && req.Pulse > 0 {
+		req.Value = true
+	}
+	if req.Value == nil {
+		return nil, errors.New("write requires a value")
+	}
+	if err := checkPulse(reg, req.Pulse); err != nil {
+		return nil, fmt.Errorf("address %d: %v", addr, err)
+	}
+	if isCoil {
+		reg.Format = "boolean"
+	} else if reg.Format == "" {
+		reg.Format = "decimal"
+	}
+	plan := &WritePlan{Address: addr, Name: reg.Name, Format: reg.Format}
+
+	values, number, err := encodeWriteValue(reg, isCoil, req.Value)
+	if err != nil {
+		return nil, fmt.Errorf("address %d: %v", addr, err)
+	}
+	plan.Values = values
+
+	// Asking for a pulse, or writing on to a register configured with one,
+	// writes a pulse; writing off to it is a plain write
+	switch {
+	case req.Pulse > 0 && values[0] != 1:
+		return nil, fmt.Errorf("address %d: a pulse switches on and then off, so its value must be on", addr)
+	case req.Pulse > 0:
+		plan.Pulse = req.Pulse
+	case reg.Pulse > 0 && values[0] == 1:
+		plan.Pulse = reg.Pulse
+	}
+	plan.Critical = len(server.criticalAddresses([]WriteChange{{Address: addr, Values: values}})) > 0
+
+	// The whole value must lie in one configured block, as it is polled
+	var block *RegisterBlock
+	for i := range server.RegisterBlocks {
+		b := &server.RegisterBlocks[i]
+		if addr >= b.StartAddress && int(addr) < int(b.StartAddress)+int(b.Length) {
+			block = b
+			break
+		}
+	}
+	if block == nil {
+		return nil, fmt.Errorf("address %d is not in a configured register block of server %s", addr, server.ID)
+	}
+	if int(addr)+len(values) > int(block.StartAddress)+int(block.Length) {
+		return nil, fmt.Errorf("%s value at address %d needs %d registers and runs past the end of block %d+%d",
+			reg.Format, addr, len(values), block.StartAddress, block.Length)
+	}
+	if len(values) > maxWriteRegisters {
+		return nil, fmt.Errorf("value at address %d needs %d registers, more than the maximum of %d per write", addr, len(values), maxWriteRegisters)
+	}
+
+	if number != nil {
+		if reg.ExpectedMin != nil && *number < *reg.ExpectedMin {
+			return nil, fmt.Errorf("value %g is below the expected minimum %g of address %d", *number, *reg.ExpectedMin, addr)
+		}
+		if reg.ExpectedMax != nil && *number > *reg.ExpectedMax {
+			return nil, fmt.Errorf("value %g is above the expected maximum %g of address %d", *number, *reg.ExpectedMax, addr)
+		}
+	}
+
+	var pdu []byte
+	switch {
+	case isCoil:
+		plan.FunctionCode = 0x05
+		pdu = []byte{0x05, byte(addr >> 8), byte(addr), 0x00, 0x00}
+		if values[0] == 1 {
+			pdu[3] = 0xFF
+		}
+	case len(values) == 1:
+		offset := addr - 40000
+		plan.FunctionCode = 0x06
+		pdu = []byte{0x06, byte(offset >> 8), byte(offset), byte(values[0] >> 8), byte(values[0])}
+	default:
+		offset := addr - 40000
+		plan.FunctionCode = 0x10
+		pdu = []byte{0x10, byte(offset >> 8), byte(offset), byte(len(values) >> 8), byte(len(values)), byte(2 * len(values))}
+		for _, v := range values {
+			pdu = append(pdu, byte(v>>8), byte(v))
+		}
+	}
+	hex := make([]string, len(pdu))
+	for i, b := range pdu {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	plan.PDU = strings.Join(hex, " ")
+	return plan, nil
+}
+
+// encodeWriteValue converts a value to the raw words of a register in the
+// given format and byte order, mirroring how registerData decodes them. For
+// numeric formats it also returns the value as a number for the range check.
+func encodeWriteValue(reg RegisterConfig, isCoil bool, value interface{}) ([]uint16, *float64, error) {
+	bigEndian := reg
+	bigEndian.ByteOrder = ""
+	words, number, err := encodeValue(bigEndian, isCoil, value)
+	if err != nil || isCoil {
+		return words, number, err
+	}
+	return orderWords(reg, words), number, nil
+}
+
+// encodeValue converts a value to the big-endian words of a register
+func encodeValue(reg RegisterConfig, isCoil bool, value interface{}) ([]uint16, *float64, error) {
+	text := fmt.Sprint(value)
+	if f, ok := value.(float64); ok {
+		text = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	if isCoil || reg.Format == "boolean" {
+		on, err := parseCoilValue(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		if on {
+			return []uint16{1}, nil, nil
+		}
+		return []uint16{0}, nil, nil
+	}
+
+	if timestampFormats[reg.Format] {
+		words, err := encodeTimestamp(reg.Format, text)
+		return words, nil, err
+	}
+
+	switch reg.Format {
+	case "float":
+		f, err := strconv.ParseFloat(text, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid float value %q", text)
+		}
+		bits := math.Float32bits(float32(f))
+		return []uint16{uint16(bits >> 16), uint16(bits)}, &f, nil
+	case "double":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid double value %q", text)
+		}
+		bits := math.Float64bits(f)
+		return []uint16{uint16(bits >> 48), uint16(bits >> 32), uint16(bits >> 16), uint16(bits)}, &f, nil
+	case "int16", "uint32", "int32", "uint64", "int64":
+		words, err := encodeInteger(reg, text)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, _ := toFloat(decodeRegister(reg, words))
+		return words, &f, nil
+	case "string-byte":
+		s, ok := value.(string)
+		if !ok {
+			return nil, nil, errors.New("string-byte registers take a string value")
+		}
+		if len(s) > reg.StringLength {
+			return nil, nil, fmt.Errorf("string of %d bytes exceeds the length of %d", len(s), reg.StringLength)
+		}
+		bytes := make([]byte, 2*registerWordCount(reg))
+		copy(bytes, s)
+		words := make([]uint16, len(bytes)/2)
+		for i := range words {
+			words[i] = uint16(bytes[2*i])<<8 | uint16(bytes[2*i+1])
+		}
+		return words, nil, nil
+	case "string-word":
+		s, ok := value.(string)
+		if !ok {
+			return nil, nil, errors.New("string-word registers take a string value")
+		}
+		if n := utf8.RuneCountInString(s); n > reg.StringLength {
+			return nil, nil, fmt.Errorf("string of %d characters exceeds the length of %d", n, reg.StringLength)
+		}
+		words := make([]uint16, reg.StringLength)
+		i := 0
+		for _, c := range s {
+			if c > 0xFFFF {
+				return nil, nil, fmt.Errorf("character %q does not fit in a register", c)
+			}
+			words[i] = uint16(c)
+			i++
+		}
+		return words, nil, nil
+	default: // decimal and hex
+		v, err := parseRegisterValue(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		f := float64(v)
+		return []uint16{v}, &f, nil
+	}
+}
+
+// encodeInteger converts a number to the words of a register in an integer
+// format, most significant word first
+func encodeInteger(reg RegisterConfig, text string) ([]uint16, error) {
+	n := registerWordCount(reg)
+	var bits uint64
+	if strings.HasPrefix(reg.Format, "int") {
+		v, err := strconv.ParseInt(text, 10, 16*n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", reg.Format, text)
+		}
+		bits = uint64(v)
+	} else {
+		v, err := strconv.ParseUint(text, 10, 16*n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", reg.Format, text)
+		}
+		bits = v
+	}
+	words := make([]uint16, n)
+	for i := range words {
+		words[n-1-i] = uint16(bits >> (16 * i))
+	}
+	return words, nil
+}
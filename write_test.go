@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestPlanWriteStringByte checks that a string-byte write covers exactly the
+// registers of its length and leaves the register after it alone
+func TestPlanWriteStringByte(t *testing.T) {
+	blocks := []RegisterBlock{{
+		StartAddress: 40001,
+		Length:       3,
+		Registers: []RegisterConfig{
+			{Address: 40001, Name: "Tag", Format: "string-byte", StringLength: 4},
+			{Address: 40003, Name: "Setpoint", Format: "decimal", Critical: true},
+		},
+	}}
+	server := &ModbusServer{ID: "plc", RegisterBlocks: blocks, registerMap: buildRegisterMap(blocks)}
+
+	plan, err := planWrite(server, WriteRequest{Address: 40001, Value: "AB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x4142, 0}; len(plan.Values) != len(want) || plan.Values[0] != want[0] || plan.Values[1] != want[1] {
+		t.Errorf("values = %#04x, want %#04x", plan.Values, want)
+	}
+	if plan.Critical {
+		t.Error("write of the string touches the critical register after it")
+	}
+	if _, err := planWrite(server, WriteRequest{Address: 40001, Value: "ABCDE"}); err == nil {
+		t.Error("string longer than its length was accepted")
+	}
+
+	if got := decodeRegister(blocks[0].Registers[0], plan.Values); got != "AB" {
+		t.Errorf("decoded %q, want %q", got, "AB")
+	}
+}
+
+func TestRegisterWordCountString(t *testing.T) {
+	tests := []struct {
+		format string
+		length int
+		words  int
+	}{
+		{"string-byte", 1, 1},
+		{"string-byte", 4, 2},
+		{"string-byte", 5, 3},
+		{"string-word", 4, 4},
+	}
+	for _, tt := range tests {
+		if got := registerWordCount(RegisterConfig{Format: tt.format, StringLength: tt.length}); got != tt.words {
+			t.Errorf("%s of length %d: %d words, want %d", tt.format, tt.length, got, tt.words)
+		}
+	}
+
+	// The padding byte of an odd length is not part of the text
+	reg := RegisterConfig{Format: "string-byte", StringLength: 3}
+	if got := decodeRegister(reg, []uint16{0x4142, 0x4358}); got != "ABC" {
+		t.Errorf("decoded %q, want %q", got, "ABC")
+	}
+}
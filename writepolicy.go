@@ -0,0 +1,431 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Write policies for registers tagged critical
+const (
+	writePolicyNone     = "none"
+	writePolicyConfirm  = "confirm"  // the write must repeat the server ID
+	writePolicyApproval = "approval" // the write must carry a token issued by a second operator
+)
+
+// writePolicies lists the supported write policies
+var writePolicies = map[string]bool{
+	writePolicyNone:     true,
+	writePolicyConfirm:  true,
+	writePolicyApproval: true,
+}
+
+// defaultWritePolicy applies to servers that do not set their own (-write-policy)
+var defaultWritePolicy = writePolicyNone
+
+// approvalLifetime is how long an approval token can be used after it is issued
+const approvalLifetime = 15 * time.Minute
+
+// WriteAuthorization is the confirmation sent with a write to critical registers
+type WriteAuthorization struct {
+	Confirm       string `json:"confirm,omitempty"`       // the server ID, typed by the operator
+	ApprovalToken string `json:"approvalToken,omitempty"` // from an approved WriteApproval
+}
+
+// writeAuthorization reads the confirmation of a write from form or query values
+func writeAuthorization(r *http.Request) WriteAuthorization {
+	return WriteAuthorization{
+		Confirm:       r.FormValue("confirm"),
+		ApprovalToken: r.FormValue("approvalToken"),
+	}
+}
+
+// WriteApproval is a request by one operator to write critical registers,
+// which a second operator approves to issue a single-use token
+type WriteApproval struct {
+	ID        int       `json:"id"`
+	ServerID  string    `json:"serverId"`
+	Addresses []uint16  `json:"addresses"` // critical registers the token covers
+	Reason    string    `json:"reason,omitempty"`
+	Requested time.Time `json:"requested"`
+	Status    string    `json:"status"` // "pending", "approved", "used" or "expired"
+	Approved  time.Time `json:"approved"`
+	// Approver who approved the request, if approvers are configured
+	ApprovedBy string `json:"approvedBy,omitempty"`
+	// Approver who made the request, or "session:" and the session ID of the
+	// browser, to keep operators from approving their own requests
+	requester string
+	token     string
+}
+
+// Approver is an operator allowed to approve writes, identified by a bearer
+// token (-approvers)
+type Approver struct {
+	Name  string
+	Token string
+}
+
+// approvers are the operators allowed to approve writes. The approval
+// policy requires them: without them any browser session could approve,
+// the requester's own included.
+var approvers []Approver
+
+// parseApprovers parses a comma-separated list of approvers given as
+// "name=token"
+func parseApprovers(s string) ([]Approver, error) {
+	var list []Approver
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, token, found := strings.Cut(item, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid approver %q (must be name=token)", item)
+		}
+		if len(token) < 16 {
+			return nil, fmt.Errorf("token of approver %s must be at least 16 characters", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate approver name %q", name)
+		}
+		seen[name] = true
+		list = append(list, Approver{Name: name, Token: token})
+	}
+	return list, nil
+}
+
+// requestApprover returns the name of the approver whose bearer token a
+// request carries, or "" if it carries none that matches
+func requestApprover(r *http.Request) string {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return ""
+	}
+	name := ""
+	for _, approver := range approvers {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(approver.Token)) == 1 {
+			name = approver.Name
+		}
+	}
+	return name
+}
+
+var (
+	approvalsMu    sync.Mutex
+	approvals      = make(map[string][]*WriteApproval) // per server
+	nextApprovalID = 1
+)
+
+// writePolicyError is returned when a write to critical registers lacks the
+// confirmation its server's policy requires
+type writePolicyError struct {
+	policy    string
+	addresses []uint16
+	reason    string
+}
+
+func (e *writePolicyError) Error() string {
+	return fmt.Sprintf("%s (critical registers: %s)", e.reason, joinAddresses(e.addresses))
+}
+
+// joinAddresses formats addresses as a comma separated list
+func joinAddresses(addresses []uint16) string {
+	parts := make([]string, len(addresses))
+	for i, addr := range addresses {
+		parts[i] = strconv.Itoa(int(addr))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkWritePolicySetting returns an error if a server or the default
+// cannot use a write policy: one that is not known, or approval without
+// approvers
+func checkWritePolicySetting(policy string) error {
+	if policy != "" && !writePolicies[policy] {
+		return fmt.Errorf("unknown write policy %q (must be none, confirm or approval)", policy)
+	}
+	if policy == writePolicyApproval && len(approvers) == 0 {
+		return errors.New("the approval write policy requires approvers (-approvers)")
+	}
+	return nil
+}
+
+// effectiveWritePolicy returns the server's write policy or the global default
+func (s *ModbusServer) effectiveWritePolicy() string {
+	if s.WritePolicy != "" {
+		return s.WritePolicy
+	}
+	return defaultWritePolicy
+}
+
+// criticalAddresses returns the critical registers overlapped by the changes,
+// sorted. The caller must hold the server's lock.
+func (s *ModbusServer) criticalAddresses(changes []WriteChange) []uint16 {
+	var critical []uint16
+	for _, reg := range s.registerMap {
+		if !reg.Critical {
+			continue
+		}
+		regEnd := int(reg.Address) + registerWordCount(reg)
+		for _, change := range changes {
+			end := int(change.Address) + max(len(change.Values), 1)
+			if int(change.Address) < regEnd && end > int(reg.Address) {
+				critical = append(critical, reg.Address)
+				break
+			}
+		}
+	}
+	sort.Slice(critical, func(i, j int) bool { return critical[i] < critical[j] })
+	return critical
+}
+
+// checkWritePolicy returns a *writePolicyError if the changes touch critical
+// registers without the confirmation the server's policy requires. A valid
+// approval token is used up. The caller must hold the server's lock.
+func checkWritePolicy(s *ModbusServer, changes []WriteChange, auth WriteAuthorization) error {
+	policy := s.effectiveWritePolicy()
+	if policy == writePolicyNone {
+		return nil
+	}
+	critical := s.criticalAddresses(changes)
+	if len(critical) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case writePolicyConfirm:
+		if auth.Confirm == s.ID {
+			return nil
+		}
+		return &writePolicyError{policy, critical, fmt.Sprintf("Writing critical registers requires confirmation: type the server ID %q", s.ID)}
+	case writePolicyApproval:
+		if len(approvers) == 0 {
+			return &writePolicyError{policy, critical, "Writing critical registers requires approval, but no approvers are configured (-approvers)"}
+		}
+		if auth.ApprovalToken == "" {
+			return &writePolicyError{policy, critical, "Writing critical registers requires an approval token from a second operator"}
+		}
+		approvalsMu.Lock()
+		defer approvalsMu.Unlock()
+		for _, approval := range approvals[s.ID] {
+			if approval.token == "" || subtle.ConstantTimeCompare([]byte(approval.token), []byte(auth.ApprovalToken)) != 1 {
+				continue
+			}
+			expireApproval(approval)
+			if approval.Status != "approved" {
+				return &writePolicyError{policy, critical, fmt.Sprintf("Approval #%d is %s", approval.ID, approval.Status)}
+			}
+			for _, addr := range critical {
+				if !containsAddress(approval.Addresses, addr) {
+					return &writePolicyError{policy, critical, fmt.Sprintf("Approval #%d does not cover address %d", approval.ID, addr)}
+				}
+			}
+			approval.Status = "used"
+			logMessage(InfoLevel, "Approval #%d used for a write to server %s", approval.ID, s.ID)
+			return nil
+		}
+		return &writePolicyError{policy, critical, "Unknown approval token"}
+	}
+	return fmt.Errorf("unknown write policy %q", policy)
+}
+
+// containsAddress reports whether addr is in addresses
+func containsAddress(addresses []uint16, addr uint16) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// expireApproval marks an approval expired once its token is too old.
+// The caller must hold approvalsMu.
+func expireApproval(approval *WriteApproval) {
+	if approval.Status == "approved" && time.Since(approval.Approved) > approvalLifetime {
+		approval.Status = "expired"
+	}
+}
+
+// handleWritePolicyError writes err, adding the policy it failed so that
+// clients can ask the operator for the missing confirmation
+func handleWritePolicyError(w http.ResponseWriter, r *http.Request, err error) {
+	policyErr, ok := err.(*writePolicyError)
+	if !ok || isHtmxRequest(r) {
+		handleError(w, r, err.Error())
+		return
+	}
+	logMessage(ErrorLevel, "%s", err)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           false,
+		"error":             err.Error(),
+		"writePolicy":       policyErr.policy,
+		"criticalAddresses": policyErr.addresses,
+	})
+}
+
+// handleApprovals lists and requests write approvals on GET and POST
+// /api/servers/{id}/approvals, and approves one on POST
+// /api/servers/{id}/approvals/{approvalId}/approve
+func handleApprovals(w http.ResponseWriter, r *http.Request, id, path string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	// Who is asking: an approver, by their token, or else the browser session
+	identity := ""
+	approver := requestApprover(r)
+	if approver != "" {
+		identity = "approver:" + approver
+	} else {
+		session, err := sessionID(w, r)
+		if err != nil {
+			handleError(w, r, fmt.Sprintf("Error creating session: %v", err))
+			return
+		}
+		identity = "session:" + session
+	}
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			approvalsMu.Lock()
+			list := make([]WriteApproval, 0, len(approvals[id]))
+			for i := len(approvals[id]) - 1; i >= 0; i-- {
+				expireApproval(approvals[id][i])
+				list = append(list, *approvals[id][i])
+			}
+			approvalsMu.Unlock()
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":   true,
+				"approvals": list,
+			})
+		case http.MethodPost:
+			var req struct {
+				Addresses []uint16 `json:"addresses"`
+				Reason    string   `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				handleError(w, r, fmt.Sprintf("Invalid approval request: %v", err))
+				return
+			}
+
+			server.mu.Lock()
+			changes := make([]WriteChange, len(req.Addresses))
+			for i, addr := range req.Addresses {
+				changes[i] = WriteChange{Address: addr}
+			}
+			critical := server.criticalAddresses(changes)
+			server.mu.Unlock()
+			if len(critical) == 0 {
+				handleError(w, r, fmt.Sprintf("None of the addresses are critical registers of server %s", id))
+				return
+			}
+
+			approvalsMu.Lock()
+			approval := &WriteApproval{
+				ID:        nextApprovalID,
+				ServerID:  id,
+				Addresses: critical,
+				Reason:    req.Reason,
+				Requested: time.Now(),
+				Status:    "pending",
+				requester: identity,
+			}
+			nextApprovalID++
+			history := append(approvals[id], approval)
+			if len(history) > maxWriteHistory {
+				history = history[len(history)-maxWriteHistory:]
+			}
+			approvals[id] = history
+			approvalsMu.Unlock()
+
+			logMessage(InfoLevel, "Approval #%d requested for writes to server %s: %s", approval.ID, id, joinAddresses(critical))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":  true,
+				"approval": approval,
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	approvalID, action, _ := strings.Cut(path, "/")
+	n, err := strconv.Atoi(approvalID)
+	if err != nil || action != "approve" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only approvers can approve, so one person cannot approve their own
+	// request from a second browser session
+	if approver == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":          false,
+			"error":            "Approving requires the bearer token of an approver",
+			"approverRequired": true,
+		})
+		return
+	}
+
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	var approval *WriteApproval
+	for _, a := range approvals[id] {
+		if a.ID == n {
+			approval = a
+		}
+	}
+	switch {
+	case approval == nil:
+		handleError(w, r, fmt.Sprintf("Approval #%d not found for server %s", n, id))
+		return
+	case approval.Status != "pending":
+		handleError(w, r, fmt.Sprintf("Approval #%d is already %s", n, approval.Status))
+		return
+	case approval.requester == identity:
+		handleError(w, r, fmt.Sprintf("Approval #%d must be approved by a different operator than the one who requested it", n))
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		handleError(w, r, fmt.Sprintf("Error creating approval token: %v", err))
+		return
+	}
+	approval.token = hex.EncodeToString(b)
+	approval.Status = "approved"
+	approval.Approved = time.Now()
+	approval.ApprovedBy = approver
+	logMessage(InfoLevel, "Approval #%d for writes to server %s approved by %s", approval.ID, id, approver)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"approval": approval,
+		"token":    approval.token,
+	})
+}
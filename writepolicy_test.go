@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withApprovers sets the approvers for a test
+func withApprovers(t *testing.T, list []Approver) {
+	saved := approvers
+	approvers = list
+	t.Cleanup(func() { approvers = saved })
+}
+
+func TestWritePolicySettingNeedsApprovers(t *testing.T) {
+	withApprovers(t, nil)
+	if err := checkWritePolicySetting(writePolicyApproval); err == nil || !strings.Contains(err.Error(), "-approvers") {
+		t.Errorf("approval without approvers: %v", err)
+	}
+	for _, policy := range []string{"", writePolicyNone, writePolicyConfirm} {
+		if err := checkWritePolicySetting(policy); err != nil {
+			t.Errorf("%q: %v", policy, err)
+		}
+	}
+	if err := checkWritePolicySetting("two-person"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+
+	config := `{"schemaVersion": 1, "servers": [{"id": "plc", "address": "10.0.0.5", "port": 502, "pollRate": 1000, "writePolicy": "approval"}]}`
+	if errs, _ := validateConfig([]byte(config)); len(errs) != 1 || errs[0].Path != "servers[0].writePolicy" {
+		t.Errorf("errors = %+v", errs)
+	}
+
+	withApprovers(t, []Approver{{Name: "anna", Token: "7f3c9e1b2d4a6f80"}})
+	if err := checkWritePolicySetting(writePolicyApproval); err != nil {
+		t.Errorf("approval with approvers: %v", err)
+	}
+}
+
+func TestApprovalWrites(t *testing.T) {
+	blocks := []RegisterBlock{{StartAddress: 40001, Length: 1, Registers: []RegisterConfig{
+		{Address: 40001, Name: "Setpoint", Critical: true},
+	}}}
+	server := &ModbusServer{ID: "approval-plc", WritePolicy: writePolicyApproval, RegisterBlocks: blocks, registerMap: buildRegisterMap(blocks)}
+	changes := []WriteChange{{Address: 40001, Values: []uint16{1}}}
+
+	// Servers restored with the policy but without approvers cannot write
+	withApprovers(t, nil)
+	if err := checkWritePolicy(server, changes, WriteAuthorization{ApprovalToken: "anything"}); err == nil {
+		t.Error("write allowed without approvers")
+	}
+
+	withApprovers(t, []Approver{{Name: "anna", Token: "7f3c9e1b2d4a6f80"}, {Name: "ben", Token: "c81d0e5a93b7f246"}})
+	mu.Lock()
+	servers[server.ID] = server
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(servers, server.ID)
+		mu.Unlock()
+		approvalsMu.Lock()
+		delete(approvals, server.ID)
+		approvalsMu.Unlock()
+	})
+
+	call := func(method, path, body, token string) (int, map[string]interface{}) {
+		r := httptest.NewRequest(method, "/api/servers/"+server.ID+"/approvals/"+path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handleApprovals(w, r, server.ID, path)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	_, response := call(http.MethodPost, "", `{"addresses": [40001]}`, "7f3c9e1b2d4a6f80")
+	approval, _ := response["approval"].(map[string]interface{})
+	if approval == nil {
+		t.Fatalf("request: %v", response)
+	}
+	approve := fmt.Sprintf("%d/approve", int(approval["id"].(float64)))
+
+	// A browser session without an approver token cannot approve
+	if code, response := call(http.MethodPost, approve, "", ""); code != http.StatusUnauthorized || response["approverRequired"] != true {
+		t.Errorf("approve without a token: %d %v", code, response)
+	}
+	_, response = call(http.MethodPost, approve, "", "c81d0e5a93b7f246")
+	token, _ := response["token"].(string)
+	if token == "" {
+		t.Fatalf("approve: %v", response)
+	}
+
+	if err := checkWritePolicy(server, changes, WriteAuthorization{ApprovalToken: token[:len(token)-1]}); err == nil {
+		t.Error("write allowed with a truncated token")
+	}
+	if err := checkWritePolicy(server, changes, WriteAuthorization{ApprovalToken: token}); err != nil {
+		t.Errorf("write with the token: %v", err)
+	}
+	if err := checkWritePolicy(server, changes, WriteAuthorization{ApprovalToken: token}); err == nil {
+		t.Error("token used twice")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWriteHistory is the number of write operations kept per server
+const maxWriteHistory = 100
+
+// WriteChange is a coil or register range changed by a write, with the values
+// it replaced. Values are raw words as in ParameterValue; coils are a single 0 or 1.
+type WriteChange struct {
+	Address  uint16   `json:"address"`
+	Previous []uint16 `json:"previous,omitempty"` // empty if the old value could not be read
+	Values   []uint16 `json:"values"`
+}
+
+// WriteOperation is a recorded write to a server
+type WriteOperation struct {
+	ID         int           `json:"id"`
+	ServerID   string        `json:"serverId"`
+	Time       time.Time     `json:"time"`
+	Source     string        `json:"source"` // "bulk write", "api", "websocket", "parameters", "revert" or "raw write"
+	Changes    []WriteChange `json:"changes"`
+	RevertOf   int           `json:"revertOf,omitempty"`   // operation undone by this one
+	RevertedBy int           `json:"revertedBy,omitempty"` // operation that undid this one
+}
+
+var (
+	writeHistoryMu sync.Mutex
+	writeHistory   = make(map[string][]*WriteOperation) // per server, oldest first
+	nextWriteID    = 1
+)
+
+// recordWrite adds a write operation to the history of a server and logs it
+func recordWrite(serverID, source string, changes []WriteChange, revertOf int) *WriteOperation {
+	writeHistoryMu.Lock()
+	defer writeHistoryMu.Unlock()
+
+	op := &WriteOperation{
+		ID:       nextWriteID,
+		ServerID: serverID,
+		Time:     time.Now(),
+		Source:   source,
+		Changes:  changes,
+		RevertOf: revertOf,
+	}
+	nextWriteID++
+
+	history := append(writeHistory[serverID], op)
+	if len(history) > maxWriteHistory {
+		history = history[len(history)-maxWriteHistory:]
+	}
+	writeHistory[serverID] = history
+
+	for _, change := range changes {
+		logMessage(InfoLevel, "Write #%d to server %s (%s): address %d %v -> %v", op.ID, serverID, source, change.Address, change.Previous, change.Values)
+	}
+	return op
+}
+
+// bulkWriteChanges returns the changes made by the successfully written rows
+func bulkWriteChanges(rows []*bulkWriteRow) []WriteChange {
+	var changes []WriteChange
+	for _, row := range rows {
+		if row.Status != "ok" {
+			continue
+		}
+		value := row.register
+		if row.Address < 10000 && row.coil {
+			value = 1
+		} else if row.Address < 10000 {
+			value = 0
+		}
+		change := WriteChange{Address: row.Address, Values: []uint16{value}}
+		if row.previous != nil {
+			change.Previous = []uint16{*row.previous}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// bulkWriteTargets returns the addresses written by validated rows, for checkWritePolicy
+func bulkWriteTargets(rows []*bulkWriteRow) []WriteChange {
+	targets := make([]WriteChange, len(rows))
+	for i, row := range rows {
+		targets[i] = WriteChange{Address: row.Address, Values: []uint16{row.register}}
+	}
+	return targets
+}
+
+// handleWriteHistory lists the writes to a server on GET /api/servers/{id}/writes
+// and undoes one on POST /api/servers/{id}/writes/{writeId}/revert
+func handleWriteHistory(w http.ResponseWriter, r *http.Request, id, path string) {
+	mu.RLock()
+	server, exists := servers[id]
+	mu.RUnlock()
+
+	if !exists {
+		handleError(w, r, fmt.Sprintf("Server not found: %s", id))
+		return
+	}
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeHistoryMu.Lock()
+		history := writeHistory[id]
+		list := make([]WriteOperation, 0, len(history))
+		for i := len(history) - 1; i >= 0; i-- {
+			list = append(list, *history[i])
+		}
+		writeHistoryMu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"writes":  list,
+		})
+		return
+	}
+
+	writeID, action, _ := strings.Cut(path, "/")
+	opID, err := strconv.Atoi(writeID)
+	if err != nil || action != "revert" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.client == nil {
+		handleError(w, r, fmt.Sprintf("Server %s is not connected", id))
+		return
+	}
+
+	op, err := revertWrite(server, opID, writeAuthorization(r))
+	if err != nil {
+		handleWritePolicyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"write":   op,
+	})
+}
+
+// revertWrite writes the previous values of a recorded operation back to the
+// device, newest change first, and records the revert as a new operation.
+// The caller must hold the server's lock.
+func revertWrite(server *ModbusServer, opID int, auth WriteAuthorization) (*WriteOperation, error) {
+	writeHistoryMu.Lock()
+	var original *WriteOperation
+	for _, op := range writeHistory[server.ID] {
+		if op.ID == opID {
+			original = op
+		}
+	}
+	writeHistoryMu.Unlock()
+
+	switch {
+	case original == nil:
+		return nil, fmt.Errorf("Write #%d not found in the history of server %s", opID, server.ID)
+	case original.RevertedBy != 0:
+		return nil, fmt.Errorf("Write #%d was already reverted by write #%d", opID, original.RevertedBy)
+	}
+	for _, change := range original.Changes {
+		if len(change.Previous) == 0 {
+			return nil, fmt.Errorf("Write #%d cannot be reverted: the previous value of address %d is unknown", opID, change.Address)
+		}
+	}
+	if err := checkWritePolicy(server, original.Changes, auth); err != nil {
+		return nil, err
+	}
+
+	var changes []WriteChange
+	var writeErr error
+	for i := len(original.Changes) - 1; i >= 0; i-- {
+		change := original.Changes[i]
+		current, _ := readParameter(server.client, change.Address, len(change.Previous))
+		if err := writeParameter(server.client, ParameterValue{Address: change.Address, Values: change.Previous}); err != nil {
+			writeErr = fmt.Errorf("Error reverting address %d: %v", change.Address, err)
+			break
+		}
+		changes = append(changes, WriteChange{Address: change.Address, Previous: current, Values: change.Previous})
+	}
+
+	op := recordWrite(server.ID, "revert", changes, opID)
+	if writeErr != nil {
+		return nil, fmt.Errorf("%v (%d of %d changes reverted, recorded as write #%d)", writeErr, len(changes), len(original.Changes), op.ID)
+	}
+
+	writeHistoryMu.Lock()
+	original.RevertedBy = op.ID
+	writeHistoryMu.Unlock()
+	return op, nil
+}
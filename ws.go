@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is appended to the client key to compute the handshake accept value (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage is the largest command message accepted from a client
+const maxWebSocketMessage = 1 << 20
+
+// maxWebSocketCommands is how many commands of a connection run at once;
+// further messages are not read until one of them completes
+const maxWebSocketCommands = 8
+
+// wsOrigins are the origins, besides the instance itself, whose pages may
+// open the WebSocket, from -ws-origins
+var wsOrigins map[string]bool
+
+// parseOrigins parses a comma-separated list of origins such as
+// https://scada.example.com
+func parseOrigins(s string) (map[string]bool, error) {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(s, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q (must be a scheme and host such as https://scada.example.com)", origin)
+		}
+		origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+	return origins, nil
+}
+
+// allowedOrigin reports whether a WebSocket upgrade may be accepted.
+// Browsers do not apply CORS to WebSockets but always send the Origin of the
+// page, so without this check any site an operator visits could write to
+// devices through the operator's browser (cross-site WebSocket hijacking).
+// Clients other than browsers, such as scripts, send no Origin.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || wsOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsConn is a server side WebSocket connection
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// wsCommand is a request sent by a client over the WebSocket. The ID is
+// echoed in the response so clients can correlate concurrent requests.
+type wsCommand struct {
+	ID       string          `json:"id"`
+	Action   string          `json:"action"` // "read", "write", "pause", "resume" or "ping"
+	Server   string          `json:"server"`
+	Address  uint16          `json:"address"`
+	Quantity uint16          `json:"quantity,omitempty"` // for reads, default 1
+	Value    json.RawMessage `json:"value,omitempty"`    // for writes of a single value
+	Values   json.RawMessage `json:"values,omitempty"`   // for writes of consecutive values
+	WriteAuthorization
+}
+
+// wsResponse is the reply to a wsCommand
+type wsResponse struct {
+	Type    string      `json:"type"` // "response"
+	ID      string      `json:"id"`
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// handleWebSocket upgrades the connection, relays events to the client and
+// executes the commands it sends
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if !allowedOrigin(r) {
+		logMessage(ErrorLevel, "WebSocket upgrade from %s refused: origin %s not allowed (see -ws-origins)", r.RemoteAddr, r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		logMessage(ErrorLevel, "WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn, reader: rw.Reader}
+	logMessage(DebugLevel, "WebSocket client connected from %s", r.RemoteAddr)
+
+	// Relay events until the client goes away
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case e := <-ch:
+				ws.writeJSON(map[string]interface{}{"type": "event", "event": e})
+			}
+		}
+	}()
+
+	// Commands in progress, bounded by maxWebSocketCommands
+	running := make(chan struct{}, maxWebSocketCommands)
+	for {
+		message, err := ws.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				logMessage(DebugLevel, "WebSocket client %s: %v", r.RemoteAddr, err)
+			}
+			return
+		}
+
+		var cmd wsCommand
+		if err := json.Unmarshal(message, &cmd); err != nil {
+			ws.writeJSON(wsResponse{Type: "response", Error: fmt.Sprintf("Invalid command: %v", err)})
+			continue
+		}
+		// Commands run concurrently so a slow device does not hold up the
+		// others, up to maxWebSocketCommands at a time
+		running <- struct{}{}
+		go func() {
+			defer func() { <-running }()
+			data, err := executeCommand(cmd)
+			resp := wsResponse{Type: "response", ID: cmd.ID, Success: err == nil, Data: data}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			ws.writeJSON(resp)
+		}()
+	}
+}
+
+// executeCommand carries out a WebSocket command and returns its result
+func executeCommand(cmd wsCommand) (interface{}, error) {
+	if cmd.Action == "ping" {
+		return "pong", nil
+	}
+
+	mu.RLock()
+	server, exists := servers[cmd.Server]
+	mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Server not found: %s", cmd.Server)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	switch cmd.Action {
+	case "pause", "resume":
+		server.Paused = cmd.Action == "pause"
+		logMessage(InfoLevel, "Polling of server %s %sd", server.ID, cmd.Action)
+		return nil, nil
+	case "read":
+		if server.client == nil {
+			return nil, errors.New("server not connected")
+		}
+		return readAddresses(server.client, cmd.Address, max(cmd.Quantity, 1))
+	case "write":
+		if server.client == nil {
+			return nil, errors.New("server not connected")
+		}
+		rows, err := commandWriteRows(cmd)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if row.Status == "invalid" {
+				return rows, fmt.Errorf("value %d: %s", row.Line, row.Error)
+			}
+		}
+		if err := checkWritePolicy(server, bulkWriteTargets(rows), cmd.WriteAuthorization); err != nil {
+			return nil, err
+		}
+		applyBulkWrite(server.client, rows)
+		if changes := bulkWriteChanges(rows); len(changes) > 0 {
+			recordWrite(server.ID, "websocket", changes, 0)
+		}
+		for _, row := range rows {
+			if row.Status == "error" {
+				return rows, fmt.Errorf("address %d: %s", row.Address, row.Error)
+			}
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("unknown action %q", cmd.Action)
+	}
+}
+
+// readAddresses reads quantity values from the device, bypassing the data model
+func readAddresses(client Device, address, quantity uint16) (interface{}, error) {
+	if limit := maxBlockLength(address); quantity > limit {
+		return nil, fmt.Errorf("quantity %d exceeds the maximum of %d", quantity, limit)
+	}
+	rangeEnd, ok := addressRangeEnd(address)
+	if !ok {
+		return nil, fmt.Errorf("address %d is not in a valid address range", address)
+	}
+	if int(address)+int(quantity)-1 > int(rangeEnd) {
+		return nil, fmt.Errorf("read %d+%d crosses the end of its address range at %d", address, quantity, rangeEnd)
+	}
+
+	switch {
+	case address < 10000:
+		return client.ReadCoils(address, quantity)
+	case address < 20000:
+		return client.ReadDiscreteInputs(address-10000, quantity)
+	case address < 40000:
+		return client.ReadInputRegisters(address-30000, quantity)
+	default:
+		return client.ReadHoldingRegisters(address-40000, quantity)
+	}
+}
+
+// commandWriteRows validates the value or values of a write command the same
+// way as the rows of a bulk write file
+func commandWriteRows(cmd wsCommand) ([]*bulkWriteRow, error) {
+	var values []interface{}
+	switch {
+	case len(cmd.Values) > 0:
+		if err := json.Unmarshal(cmd.Values, &values); err != nil {
+			return nil, fmt.Errorf("invalid values: %v", err)
+		}
+	case len(cmd.Value) > 0:
+		var value interface{}
+		if err := json.Unmarshal(cmd.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+		values = []interface{}{value}
+	default:
+		return nil, errors.New("write requires a value or values")
+	}
+
+	rows := make([]*bulkWriteRow, 0, len(values))
+	for i, value := range values {
+		rows = append(rows, validateBulkWriteRow(bulkWriteInput{
+			Line:    i + 1,
+			Address: fmt.Sprint(int(cmd.Address) + i),
+			Value:   fmt.Sprint(value),
+		}))
+	}
+	return rows, nil
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// reassembling fragmented messages. It returns io.EOF once the client closes.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, err
+		}
+		final := header[0]&0x80 != 0
+		opcode := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7F)
+
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if !masked {
+			return nil, errors.New("client frames must be masked")
+		}
+		if length > maxWebSocketMessage || uint64(len(message))+length > maxWebSocketMessage {
+			c.writeFrame(wsClose, []byte{0x03, 0xF1}) // 1009: message too big
+			return nil, errors.New("message too big")
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			message = append(message, payload...)
+			if final {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown opcode 0x%X", opcode)
+		}
+	}
+}
+
+// writeFrame sends a single unmasked frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeJSON sends v as a text message
+func (c *wsConn) writeJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logMessage(ErrorLevel, "Error encoding WebSocket message: %v", err)
+		return
+	}
+	if err := c.writeFrame(wsText, data); err != nil {
+		logMessage(DebugLevel, "Error writing WebSocket message: %v", err)
+	}
+}
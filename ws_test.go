@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// wsFrame encodes a frame, masked with mask unless it is nil
+func wsFrame(final bool, opcode byte, payload []byte, mask []byte) []byte {
+	b := []byte{opcode}
+	if final {
+		b[0] |= 0x80
+	}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xFFFF:
+		b = binary.BigEndian.AppendUint16(append(b, maskBit|126), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, maskBit|127), uint64(n))
+	}
+	if mask == nil {
+		return append(b, payload...)
+	}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+// wsExchange reads one message from input on a server side connection and
+// returns it with the bytes the server sent back and the read error
+func wsExchange(input []byte) (message, output []byte, err error) {
+	server, client := net.Pipe()
+	ws := &wsConn{conn: server, reader: bufio.NewReader(bytes.NewReader(input))}
+
+	var sent bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&sent, client)
+		close(copied)
+	}()
+
+	message, err = ws.readMessage()
+	server.Close()
+	<-copied
+	client.Close()
+	return message, sent.Bytes(), err
+}
+
+func TestWebSocketReadMessage(t *testing.T) {
+	mask := []byte{0x37, 0xFA, 0x21, 0x3D}
+	medium := bytes.Repeat([]byte("m"), 300)
+	large := bytes.Repeat([]byte("l"), 70000)
+	half := bytes.Repeat([]byte("h"), maxWebSocketMessage/2+1)
+	concat := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+
+	tests := []struct {
+		name    string
+		input   []byte
+		message []byte
+		err     string
+		output  []byte
+	}{
+		{
+			name:    "masked text",
+			input:   wsFrame(true, wsText, []byte("Hello"), mask),
+			message: []byte("Hello"),
+		},
+		{
+			name:    "16-bit length",
+			input:   wsFrame(true, wsBinary, medium, mask),
+			message: medium,
+		},
+		{
+			name:    "64-bit length",
+			input:   wsFrame(true, wsText, large, mask),
+			message: large,
+		},
+		{
+			name:    "empty payload",
+			input:   wsFrame(true, wsText, nil, mask),
+			message: []byte{},
+		},
+		{
+			name: "fragmented",
+			input: concat(
+				wsFrame(false, wsText, []byte("Hel"), mask),
+				wsFrame(false, wsContinuation, []byte("lo, "), mask),
+				wsFrame(true, wsContinuation, []byte("world"), mask)),
+			message: []byte("Hello, world"),
+		},
+		{
+			name: "ping between fragments",
+			input: concat(
+				wsFrame(false, wsText, []byte("Hel"), mask),
+				wsFrame(true, wsPing, []byte("are you there"), mask),
+				wsFrame(true, wsContinuation, []byte("lo"), mask)),
+			message: []byte("Hello"),
+			output:  wsFrame(true, wsPong, []byte("are you there"), nil),
+		},
+		{
+			name: "pong ignored",
+			input: concat(
+				wsFrame(true, wsPong, []byte("x"), mask),
+				wsFrame(true, wsText, []byte("after"), mask)),
+			message: []byte("after"),
+		},
+		{
+			name:  "unmasked",
+			input: wsFrame(true, wsText, []byte("Hello"), nil),
+			err:   "client frames must be masked",
+		},
+		{
+			name:   "oversized frame",
+			input:  binary.BigEndian.AppendUint64([]byte{0x80 | wsText, 0x80 | 127}, maxWebSocketMessage+1),
+			err:    "message too big",
+			output: wsFrame(true, wsClose, []byte{0x03, 0xF1}, nil),
+		},
+		{
+			name:   "oversized length with the top bit set",
+			input:  binary.BigEndian.AppendUint64([]byte{0x80 | wsText, 0x80 | 127}, 1<<63),
+			err:    "message too big",
+			output: wsFrame(true, wsClose, []byte{0x03, 0xF1}, nil),
+		},
+		{
+			name: "oversized fragmented message",
+			input: concat(
+				wsFrame(false, wsText, half, mask),
+				wsFrame(true, wsContinuation, half, mask)),
+			err:    "message too big",
+			output: wsFrame(true, wsClose, []byte{0x03, 0xF1}, nil),
+		},
+		{
+			name:   "close",
+			input:  wsFrame(true, wsClose, []byte{0x03, 0xE8}, mask),
+			err:    io.EOF.Error(),
+			output: wsFrame(true, wsClose, []byte{0x03, 0xE8}, nil),
+		},
+		{
+			name:  "unknown opcode",
+			input: wsFrame(true, 0x3, []byte("x"), mask),
+			err:   "unknown opcode 0x3",
+		},
+		{
+			name:  "truncated payload",
+			input: wsFrame(true, wsText, []byte("Hello"), mask)[:8],
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, output, err := wsExchange(tt.input)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("error = %v", err)
+			} else if !bytes.Equal(message, tt.message) {
+				t.Errorf("message = %.40q (%d bytes), want %.40q (%d bytes)", message, len(message), tt.message, len(tt.message))
+			}
+			if !bytes.Equal(output, tt.output) {
+				t.Errorf("sent % X, want % X", output, tt.output)
+			}
+		})
+	}
+}
+
+func TestWebSocketWriteFrame(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		server, client := net.Pipe()
+		ws := &wsConn{conn: server}
+		go func() {
+			ws.writeFrame(wsText, payload)
+			server.Close()
+		}()
+		got, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := wsFrame(true, wsText, payload, nil); !bytes.Equal(got, want) {
+			t.Errorf("%d byte payload: header % X, want % X", n, got[:min(len(got), 10)], want[:min(len(want), 10)])
+		}
+	}
+}
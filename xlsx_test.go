@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestXLSXSheetName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"History", "Sheet1"},
+		{"history", "Sheet1"},
+		{"", "Sheet1"},
+		{"''", "Sheet1"},
+		{"plc-1", "plc-1"},
+		{"a:b\\c/d?e*f[g]h", "a_b_c_d_e_f_g_h"},
+		{"'quoted'", "quoted"},
+		{"tab\there", "tab_here"},
+		{strings.Repeat("x", 40), strings.Repeat("x", 31)},
+		{strings.Repeat("ü", 40), strings.Repeat("ü", 31)},
+		{strings.Repeat("x", 30) + "'y", strings.Repeat("x", 30)},
+	}
+	for _, tt := range tests {
+		if got := xlsxSheetName(tt.name); got != tt.want {
+			t.Errorf("xlsxSheetName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "A"}, {25, "Z"}, {26, "AA"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := xlsxColumn(tt.i); got != tt.want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	rows := [][]interface{}{
+		{"2024-03-01T12:00:00Z", uint16(40001), 1.5, nil},
+		{"<a & b>", int64(-7), math.NaN(), true},
+	}
+	if err := writeXLSX(&buf, "plc/1: [main]", []string{"Time", "Address", "Value", "Flag"}, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(content)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/workbook.xml", "xl/worksheets/sheet1.xml"} {
+		content, ok := parts[name]
+		if !ok {
+			t.Errorf("missing part %s", name)
+			continue
+		}
+		// Every part must be well-formed XML
+		d := xml.NewDecoder(strings.NewReader(content))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+		}
+	}
+
+	if workbook := parts["xl/workbook.xml"]; !strings.Contains(workbook, `<sheet name="plc_1_ _main_" sheetId="1"`) {
+		t.Errorf("workbook does not name the sanitized sheet: %s", workbook)
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" t="inlineStr" s="1"><is><t>Time</t></is></c>`,
+		`<c r="D1" t="inlineStr" s="1"><is><t>Flag</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t>2024-03-01T12:00:00Z</t></is></c>`,
+		`<c r="B2"><v>40001</v></c>`,
+		`<c r="C2"><v>1.5</v></c>`,
+		`<c r="A3" t="inlineStr"><is><t>&lt;a &amp; b&gt;</t></is></c>`,
+		`<c r="B3"><v>-7</v></c>`,
+		`<c r="C3" t="inlineStr"><is><t>NaN</t></is></c>`,
+		`<c r="D3" t="inlineStr"><is><t>true</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("sheet is missing %s", cell)
+		}
+	}
+	if strings.Contains(sheet, `r="D2"`) {
+		t.Errorf("nil value written as a cell")
+	}
+}